@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"guardian/internal/middleware"
+	"guardian/internal/models"
+	"guardian/internal/services"
+	"guardian/utlis/logger"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type APIKeyController struct {
+	apiKeyService services.APIKeyServiceInterface
+	middleware    middleware.Interface
+}
+
+func NewAPIKeyController(apiKeyService services.APIKeyServiceInterface, m middleware.Interface) *APIKeyController {
+	return &APIKeyController{
+		apiKeyService: apiKeyService,
+		middleware:    m,
+	}
+}
+
+func (h *APIKeyController) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.apiKeyService.CreateAPIKey(r.Context(), *userID, middleware.APIKeyFromContext(r.Context()), req)
+	switch {
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidExpiry):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrNotGroupMember), errors.Is(err, services.ErrScopeNotHeld):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Error creating api key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+func (h *APIKeyController) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.apiKeyService.GetAPIKeys(r.Context(), *userID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(keys)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *APIKeyController) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "keyID"))
+	if err != nil {
+		http.Error(w, "Invalid api key id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.apiKeyService.RevokeAPIKey(r.Context(), *userID, keyID)
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAPIKeyController_CreateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	m := new(mocks.MockMiddleware)
+	m.On("GetUserFromContext").Return(mock.Anything, nil)
+	reqBody := models.CreateAPIKeyRequest{Name: "pipeline"}
+
+	t.Run("returns the plaintext key once", func(t *testing.T) {
+		t.Parallel()
+
+		apiKeyService := new(mocks.MockAPIKeyService)
+		controller := NewAPIKeyController(apiKeyService, m)
+		apiKeyService.On("CreateAPIKey", mock.Anything, mock.Anything, reqBody).
+			Return(&models.CreateAPIKeyResponse{Key: "gdn_abc_def"}, nil)
+
+		body, _ := json.Marshal(reqBody)
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/api-keys",
+			bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+
+		controller.CreateAPIKey(rec, req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var resp models.CreateAPIKeyResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		assert.Equal(t, "gdn_abc_def", resp.Key)
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		t.Parallel()
+
+		apiKeyService := new(mocks.MockAPIKeyService)
+		controller := NewAPIKeyController(apiKeyService, m)
+		apiKeyService.On("CreateAPIKey", mock.Anything, mock.Anything, reqBody).Return(nil, services.ErrInvalidScope)
+
+		body, _ := json.Marshal(reqBody)
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/api-keys",
+			bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+
+		controller.CreateAPIKey(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestAPIKeyController_RevokeAPIKey(t *testing.T) {
+	t.Parallel()
+
+	m := new(mocks.MockMiddleware)
+	m.On("GetUserFromContext").Return(mock.Anything, nil)
+	keyID := primitive.NewObjectID()
+
+	apiKeyService := new(mocks.MockAPIKeyService)
+	controller := NewAPIKeyController(apiKeyService, m)
+	apiKeyService.On("RevokeAPIKey", mock.Anything, keyID).Return(services.ErrAPIKeyNotFound)
+
+	routeCtx := chi.NewRouteContext()
+	routeCtx.URLParams.Add("keyID", keyID.Hex())
+	req, _ := http.NewRequestWithContext(context.WithValue(context.Background(), chi.RouteCtxKey, routeCtx),
+		http.MethodDelete, "/api-keys/"+keyID.Hex(), nil)
+	rec := httptest.NewRecorder()
+
+	controller.RevokeAPIKey(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -2,19 +2,31 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"guardian/internal/middleware"
 	"guardian/internal/models"
 	"guardian/internal/services"
 	"guardian/utlis/logger"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AuthController struct {
-	userService services.UserServiceInterface
+	userService  services.UserServiceInterface
+	tokenService services.TokenServiceInterface
 }
 
-func NewAuthController(userService services.UserServiceInterface) *AuthController {
+func NewAuthController(userService services.UserServiceInterface,
+	tokenService services.TokenServiceInterface,
+) *AuthController {
 	return &AuthController{
-		userService: userService,
+		userService:  userService,
+		tokenService: tokenService,
 	}
 }
 
@@ -22,19 +34,58 @@ func (h *AuthController) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.userService.Login(r.Context(), req, clientIP(r))
+	var locked *services.LoginLockedError
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Round(time.Second).Seconds())))
+		http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		return
+	case errors.Is(err, services.ErrUserInactive):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
 		logger.GetLogger().Errorf("Error:%v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	token, err := h.userService.Login(req)
+	err = json.NewEncoder(w).Encode(token)
 	if err != nil {
 		logger.GetLogger().Errorf("Error:%v", err)
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		http.Error(w, "Error encoding token", http.StatusInternalServerError)
 		return
 	}
+}
 
-	err = json.NewEncoder(w).Encode(map[string]string{"token": token})
+func (h *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.tokenService.RefreshTokens(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			logger.GetLogger().Infof("refresh rejected: %v", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(token)
 	if err != nil {
 		logger.GetLogger().Errorf("Error:%v", err)
 		http.Error(w, "Error encoding token", http.StatusInternalServerError)
@@ -42,6 +93,48 @@ func (h *AuthController) Login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Logout revokes the access token of the request and the session behind the given refresh token, or every session
+// of the user when all is set.
+func (h *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.UserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.LogoutRequest
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if jti, exp, ok := middleware.TokenIDFromContext(r.Context()); ok {
+		err = h.tokenService.RevokeAccessToken(r.Context(), jti, exp)
+		if err != nil {
+			logger.GetLogger().Errorf("Error:%v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch {
+	case req.All:
+		err = h.tokenService.RevokeAllRefreshTokens(r.Context(), userID)
+	case req.RefreshToken != "":
+		err = h.tokenService.RevokeRefreshToken(r.Context(), userID, req.RefreshToken)
+	}
+	if err != nil && !errors.Is(err, services.ErrInvalidRefreshToken) {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *AuthController) SignUp(w http.ResponseWriter, r *http.Request) {
 	var req models.SignUpRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -52,18 +145,320 @@ func (h *AuthController) SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.userService.SignUp(req)
-	if err != nil {
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail), errors.Is(err, services.ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
 		logger.GetLogger().Errorf("Error:%v", err)
 		http.Error(w, "Error in sign up", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (h *AuthController) DeleteUser(_ http.ResponseWriter, _ *http.Request) {
+// ActivateUser handles the link sent by email, both after sign up and after an email change.
+func (h *AuthController) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.userService.ActivateUser(r.Context(), token)
+	switch {
+	case errors.Is(err, services.ErrInvalidActivationToken), errors.Is(err, services.ErrActivationTokenNotFound):
+		http.Error(w, services.ErrInvalidActivationToken.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthController) ResendActivation(w http.ResponseWriter, r *http.Request) {
+	var req models.ActivationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.userService.ResendActivation(r.Context(), req.Email)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthController) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.UserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateUserRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.userService.UpdateUser(r.Context(), userID, req)
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.UserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.userService.ChangePassword(r.Context(), userID, req)
+	switch {
+	case errors.Is(err, services.ErrWeakPassword), errors.Is(err, services.ErrPasswordNotSet):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrInvalidCredentials):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LinkExternalIdentity links the identity of an IdP token to the signed in account, for accounts that aren't linked
+// automatically on the IdP's first login because they have a password or a privileged role.
+func (h *AuthController) LinkExternalIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.UserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.LinkExternalIdentityRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := middleware.ParseExternalToken(req.IDToken)
+	if err != nil {
+		http.Error(w, "Invalid identity token", http.StatusBadRequest)
+		return
+	}
+
+	err = h.userService.LinkExternalIdentity(r.Context(), userID, identity)
+	switch {
+	case errors.Is(err, services.ErrMissingSubject):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrExternalIdentityTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRole lets admins change the role of any user of their organization.
+func (h *AuthController) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.userService.SetUserRole(r.Context(), actor, userID, req.Role)
+	switch {
+	case errors.Is(err, services.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrRoleNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserTargetModels lets admins choose the target models a user may be routed to.
+func (h *AuthController) SetUserTargetModels(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var req models.TargetModelsRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.userService.SetUserTargetModels(r.Context(), userID, req.TargetModels)
+	switch {
+	case errors.Is(err, services.ErrModelNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUser lets admins add a user to their organization.
+func (h *AuthController) CreateUser(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.CreateUser(r.Context(), actor, req)
+	switch {
+	case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrRoleNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+// GetUsers lists the users of the admin's organization.
+func (h *AuthController) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.userService.GetUsers(r.Context())
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(users)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
 }
 
-func (h *AuthController) ActivateUser(_ http.ResponseWriter, _ *http.Request) {
+// DeleteUser soft deletes the caller's account and ends all of its sessions.
+func (h *AuthController) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.UserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = h.userService.DeleteUser(r.Context(), userID)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if jti, exp, ok := middleware.TokenIDFromContext(r.Context()); ok {
+		err = h.tokenService.RevokeAccessToken(r.Context(), jti, exp)
+		if err != nil {
+			logger.GetLogger().Errorf("Error:%v", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *AuthController) UpdateUser(_ http.ResponseWriter, _ *http.Request) {
+// clientIP returns the IP of the client. The RealIP middleware has already replaced RemoteAddr with the address
+// forwarded by a trusted proxy when there is one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -5,36 +5,44 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"guardian/internal/middleware"
 	"guardian/internal/mocks"
 	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/services"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
 	ErrLogin  = errors.New("login error")
 	ErrSignup = errors.New("signup error")
+	ErrRevoke = errors.New("revoke error")
 )
 
 func TestAuthController_Login(t *testing.T) {
 	t.Parallel()
 
 	mockService := new(mocks.MockUserService)
-	controller := NewAuthController(mockService)
+	controller := NewAuthController(mockService, new(mocks.MockTokenService))
 
 	reqBody := models.LoginRequest{
 		Email:    "test@test.com",
 		Password: "test",
 	}
-	token := "sample"
+	token := &models.TokenResponse{Token: "sample", RefreshToken: "refresh", ExpiresIn: 900}
 
 	t.Run("successful login", func(t *testing.T) {
 		t.Parallel()
 
-		mockService.On("Login", reqBody).Return(token, nil)
+		mockService.On("Login", reqBody, "").Return(token, nil)
 
 		body, _ := json.Marshal(reqBody)
 		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/login", bytes.NewBuffer(body))
@@ -43,18 +51,18 @@ func TestAuthController_Login(t *testing.T) {
 		controller.Login(rec, req)
 
 		assert.Equal(t, http.StatusOK, rec.Code)
-		var respBody map[string]string
+		var respBody models.TokenResponse
 		_ = json.NewDecoder(rec.Body).Decode(&respBody)
-		assert.Equal(t, token, respBody["token"])
-		mockService.AssertCalled(t, "Login", reqBody)
-		mockService.On("Login", reqBody).Unset()
+		assert.Equal(t, *token, respBody)
+		mockService.AssertCalled(t, "Login", reqBody, "")
+		mockService.On("Login", reqBody, "").Unset()
 	})
 
 	t.Run("login with error", func(t *testing.T) {
 		t.Parallel()
 		mockService := new(mocks.MockUserService)
-		controller := NewAuthController(mockService)
-		mockService.On("Login", reqBody).Return("", ErrLogin)
+		controller := NewAuthController(mockService, new(mocks.MockTokenService))
+		mockService.On("Login", reqBody, "").Return(nil, ErrLogin)
 
 		body, _ := json.Marshal(reqBody)
 		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/login", bytes.NewBuffer(body))
@@ -63,14 +71,32 @@ func TestAuthController_Login(t *testing.T) {
 		controller.Login(rec, req)
 
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
-		mockService.AssertCalled(t, "Login", reqBody)
+		mockService.AssertCalled(t, "Login", reqBody, "")
+	})
+
+	t.Run("locked out", func(t *testing.T) {
+		t.Parallel()
+		mockService := new(mocks.MockUserService)
+		controller := NewAuthController(mockService, new(mocks.MockTokenService))
+		mockService.On("Login", reqBody, "192.0.2.1").Return(nil,
+			&services.LoginLockedError{RetryAfter: 90 * time.Second})
+
+		body, _ := json.Marshal(reqBody)
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/login", bytes.NewBuffer(body))
+		req.RemoteAddr = "192.0.2.1:5555"
+		rec := httptest.NewRecorder()
+
+		controller.Login(rec, req)
+
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "90", rec.Header().Get("Retry-After"))
 	})
 }
 
 func TestAuthController_SignUp(t *testing.T) {
 	t.Parallel()
 	mockService := new(mocks.MockUserService)
-	controller := NewAuthController(mockService)
+	controller := NewAuthController(mockService, new(mocks.MockTokenService))
 
 	reqBody := models.SignUpRequest{
 		Name:     "test",
@@ -96,7 +122,7 @@ func TestAuthController_SignUp(t *testing.T) {
 	t.Run("Signup fails", func(t *testing.T) {
 		t.Parallel()
 		mockService := new(mocks.MockUserService)
-		controller := NewAuthController(mockService)
+		controller := NewAuthController(mockService, new(mocks.MockTokenService))
 		mockService.On("SignUp", reqBody).Return(ErrSignup)
 
 		body, _ := json.Marshal(reqBody)
@@ -109,3 +135,301 @@ func TestAuthController_SignUp(t *testing.T) {
 		mockService.AssertCalled(t, "SignUp", reqBody)
 	})
 }
+
+func TestAuthController_Refresh(t *testing.T) {
+	t.Parallel()
+
+	token := &models.TokenResponse{Token: "access", RefreshToken: "next", ExpiresIn: 900}
+	tests := []struct {
+		name         string
+		body         string
+		token        *models.TokenResponse
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "rotates the refresh token",
+			body:         `{"refresh_token":"current"}`,
+			token:        token,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "reused refresh token",
+			body:         `{"refresh_token":"current"}`,
+			err:          services.ErrRefreshTokenReused,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "invalid refresh token",
+			body:         `{"refresh_token":"current"}`,
+			err:          services.ErrInvalidRefreshToken,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "missing refresh token",
+			body:         `{}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tokenService := new(mocks.MockTokenService)
+			tokenService.On("RefreshTokens", "current").Return(tt.token, tt.err)
+			controller := NewAuthController(new(mocks.MockUserService), tokenService)
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/user/refresh",
+				bytes.NewBufferString(tt.body))
+			rec := httptest.NewRecorder()
+			controller.Refresh(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusOK {
+				var respBody models.TokenResponse
+				_ = json.NewDecoder(rec.Body).Decode(&respBody)
+				assert.Equal(t, *token, respBody)
+			}
+		})
+	}
+}
+
+func TestAuthController_Logout(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	tests := []struct {
+		name         string
+		body         string
+		setup        func(tokenService *mocks.MockTokenService)
+		expectedCode int
+	}{
+		{
+			name: "revokes the given session",
+			body: `{"refresh_token":"current"}`,
+			setup: func(tokenService *mocks.MockTokenService) {
+				tokenService.On("RevokeRefreshToken", userID, "current").Return(nil)
+			},
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name: "revokes every session",
+			body: `{"all":true}`,
+			setup: func(tokenService *mocks.MockTokenService) {
+				tokenService.On("RevokeAllRefreshTokens", userID).Return(nil)
+			},
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name: "store failure",
+			body: `{"all":true}`,
+			setup: func(tokenService *mocks.MockTokenService) {
+				tokenService.On("RevokeAllRefreshTokens", userID).Return(ErrRevoke)
+			},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tokenService := new(mocks.MockTokenService)
+			tt.setup(tokenService)
+			controller := NewAuthController(new(mocks.MockUserService), tokenService)
+
+			ctx := middleware.WithUserID(context.Background(), userID)
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/user/logout", bytes.NewBufferString(tt.body))
+			rec := httptest.NewRecorder()
+			controller.Logout(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			tokenService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthController_ActivateUser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		query        string
+		err          error
+		expectedCode int
+	}{
+		{name: "activates the user", query: "?token=valid", expectedCode: http.StatusNoContent},
+		{name: "missing token", expectedCode: http.StatusBadRequest},
+		{
+			name:         "invalid token",
+			query:        "?token=valid",
+			err:          services.ErrInvalidActivationToken,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "email taken in the meantime",
+			query:        "?token=valid",
+			err:          services.ErrEmailTaken,
+			expectedCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockService := new(mocks.MockUserService)
+			mockService.On("ActivateUser", "valid").Return(tt.err)
+			controller := NewAuthController(mockService, new(mocks.MockTokenService))
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/user/activate"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			controller.ActivateUser(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestAuthController_ChangePassword(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	reqBody := models.ChangePasswordRequest{CurrentPassword: "current-password", NewPassword: "new-password"}
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{name: "changes the password", expectedCode: http.StatusNoContent},
+		{name: "wrong current password", err: services.ErrInvalidCredentials, expectedCode: http.StatusForbidden},
+		{name: "weak new password", err: services.ErrWeakPassword, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockService := new(mocks.MockUserService)
+			mockService.On("ChangePassword", userID, reqBody).Return(tt.err)
+			controller := NewAuthController(mockService, new(mocks.MockTokenService))
+
+			body, _ := json.Marshal(reqBody)
+			ctx := middleware.WithUserID(context.Background(), userID)
+			req := httptest.NewRequestWithContext(ctx, http.MethodPut, "/user/password", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+			controller.ChangePassword(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthController_LinkExternalIdentity(t *testing.T) {
+	t.Parallel()
+
+	mockService := new(mocks.MockUserService)
+	controller := NewAuthController(mockService, new(mocks.MockTokenService))
+
+	body, _ := json.Marshal(models.LinkExternalIdentityRequest{IDToken: "not-a-token"})
+	ctx := middleware.WithUserID(context.Background(), primitive.NewObjectID())
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/user/link-external", bytes.NewBuffer(body))
+	rec := httptest.NewRecorder()
+	controller.LinkExternalIdentity(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	mockService.AssertNotCalled(t, "LinkExternalIdentity", mock.Anything, mock.Anything)
+}
+
+func TestAuthController_DeleteUser(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	mockService := new(mocks.MockUserService)
+	mockService.On("DeleteUser", userID).Return(nil)
+	controller := NewAuthController(mockService, new(mocks.MockTokenService))
+
+	ctx := middleware.WithUserID(context.Background(), userID)
+	req := httptest.NewRequestWithContext(ctx, http.MethodDelete, "/user/delete", nil)
+	rec := httptest.NewRecorder()
+	controller.DeleteUser(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestAuthController_CreateUser(t *testing.T) {
+	t.Parallel()
+
+	actor := models.Actor{UserID: primitive.NewObjectID(), Role: entities.RoleAdmin}
+	reqBody := models.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: "long-password"}
+	tests := []struct {
+		name         string
+		user         *entities.User
+		err          error
+		expectedCode int
+	}{
+		{name: "creates the user", user: &entities.User{ID: primitive.NewObjectID()}, expectedCode: http.StatusCreated},
+		{name: "email taken", err: services.ErrEmailTaken, expectedCode: http.StatusConflict},
+		{name: "role above the admin", err: services.ErrRoleNotAllowed, expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockService := new(mocks.MockUserService)
+			mockService.On("CreateUser", actor, reqBody).Return(tt.user, tt.err)
+			controller := NewAuthController(mockService, new(mocks.MockTokenService))
+
+			body, _ := json.Marshal(reqBody)
+			ctx := middleware.WithRole(middleware.WithUserID(context.Background(), actor.UserID), actor.Role)
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/admin/users", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+			controller.CreateUser(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthController_SetUserTargetModels(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	reqBody := models.TargetModelsRequest{TargetModels: []primitive.ObjectID{primitive.NewObjectID()}}
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{name: "sets the target models", expectedCode: http.StatusNoContent},
+		{name: "unknown target model", err: services.ErrModelNotFound, expectedCode: http.StatusBadRequest},
+		{name: "unknown user", err: services.ErrUserNotFound, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockService := new(mocks.MockUserService)
+			mockService.On("SetUserTargetModels", userID, reqBody.TargetModels).Return(tt.err)
+			controller := NewAuthController(mockService, new(mocks.MockTokenService))
+
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("userID", userID.Hex())
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, routeCtx)
+			body, _ := json.Marshal(reqBody)
+			req := httptest.NewRequestWithContext(ctx, http.MethodPut, "/admin/users/"+userID.Hex()+"/targets",
+				bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+			controller.SetUserTargetModels(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"guardian/configs"
+	"guardian/internal/middleware"
+	"guardian/internal/models"
+	"guardian/internal/services"
+	"guardian/utlis/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/sync/errgroup"
+)
+
+// CheckController runs prompts through the user's tasks without forwarding them, for teams calling the LLMs directly
+// that use Guardian as a moderation API.
+type CheckController struct {
+	promptService services.PromptServiceInterface
+	middleware    middleware.Interface
+}
+
+func NewCheckController(promptService services.PromptServiceInterface, m middleware.Interface) *CheckController {
+	return &CheckController{
+		promptService: promptService,
+		middleware:    m,
+	}
+}
+
+// Check returns the verdict on the prompt, with the result of every task and plugin that judged it.
+func (h *CheckController) Check(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CheckRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || !hasPrompt(req) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	verdict, err := h.check(r.Context(), *userID, req)
+	if err != nil {
+		logger.FromContext(r.Context()).WithError(err).Error("error in processing the prompt")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(verdict)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+// CheckBatch returns the verdicts on up to CHECK_BATCH_MAX_SIZE prompts, in the order of the prompts. The prompts are
+// checked concurrently, and the batch fails as a whole when any of them can't be checked.
+func (h *CheckController) CheckBatch(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CheckBatchRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Prompts) == 0 {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Prompts) > configs.GlobalConfig.CheckBatchMaxSize {
+		http.Error(w, fmt.Sprintf("A batch takes at most %d prompts", configs.GlobalConfig.CheckBatchMaxSize),
+			http.StatusBadRequest)
+		return
+	}
+	for _, prompt := range req.Prompts {
+		if !hasPrompt(prompt) {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := models.CheckBatchResponse{Verdicts: make([]models.Verdict, len(req.Prompts))}
+	g, ctx := errgroup.WithContext(r.Context())
+	if configs.GlobalConfig.PipelineWorkerPoolSize > 0 {
+		g.SetLimit(configs.GlobalConfig.PipelineWorkerPoolSize)
+	}
+	for i, prompt := range req.Prompts {
+		g.Go(func() error {
+			verdict, err := h.check(ctx, *userID, prompt)
+			if err != nil {
+				return err
+			}
+			resp.Verdicts[i] = verdict
+			return nil
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		logger.FromContext(r.Context()).WithError(err).Error("error in processing the prompts")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+func (h *CheckController) check(ctx context.Context, userID primitive.ObjectID, req models.CheckRequest) (
+	models.Verdict, error,
+) {
+	sendReq := models.SendRequest{ChatID: req.ChatID, Chat: req.Chat, Prompt: req.Prompt, Messages: req.Messages}
+	reqBody := pluginRequest(ctx, userID, sendReq, chatRequest(sendReq, nil))
+	return h.promptService.ProcessPrompt(ctx, &reqBody)
+}
+
+// hasPrompt reports whether the request has a prompt for the plugins to judge, either on its own or as the last user
+// message.
+func hasPrompt(req models.CheckRequest) bool {
+	if len(req.Messages) == 0 {
+		return req.Prompt != ""
+	}
+	_, prompt := promptOf(req.Messages)
+	return prompt != "" || req.Prompt != ""
+}
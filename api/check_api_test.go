@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckController_Check(t *testing.T) {
+	t.Parallel()
+
+	m := new(mocks.MockMiddleware)
+	m.On("GetUserFromContext").Return(mock.Anything, nil)
+	verdict := models.Verdict{Allowed: false, RiskScore: 80, Tasks: []models.TaskVerdict{{
+		TaskType: "Moderation",
+		Score:    80,
+		Plugins: []models.PluginVerdict{{
+			Name:           "toxicity",
+			PluginResponse: models.PluginResponse{Status: false, Score: 80, Reason: "insult"},
+		}},
+	}}}
+
+	tests := []struct {
+		name         string
+		body         string
+		err          error
+		expectedCode int
+	}{
+		{name: "returns the full verdict", body: `{"prompt": "you are useless"}`, expectedCode: http.StatusOK},
+		{name: "takes messages", body: `{"messages": [{"role": "user", "content": "you are useless"}]}`,
+			expectedCode: http.StatusOK},
+		{name: "requires a prompt", body: `{"chat": "hello"}`, expectedCode: http.StatusBadRequest},
+		{name: "invalid body", body: `{"prompt":`, expectedCode: http.StatusBadRequest},
+		{name: "pipeline errors", body: `{"prompt": "hi"}`, err: errors.New("no tasks"),
+			expectedCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			promptService := new(mocks.MockPromptService)
+			promptService.On("ProcessPrompt").Return(verdict, tt.err)
+			controller := NewCheckController(promptService, m)
+
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/check",
+				bytes.NewBufferString(tt.body))
+			rec := httptest.NewRecorder()
+
+			controller.Check(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusOK {
+				var resp models.Verdict
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, verdict, resp)
+			}
+		})
+	}
+}
+
+func TestCheckController_CheckBatch(t *testing.T) {
+	configs.GlobalConfig.CheckBatchMaxSize = 2
+	configs.GlobalConfig.PipelineWorkerPoolSize = 2
+
+	m := new(mocks.MockMiddleware)
+	m.On("GetUserFromContext").Return(mock.Anything, nil)
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "returns a verdict per prompt", body: `{"prompts": [{"prompt": "hi"}, {"prompt": "hello"}]}`,
+			expectedCode: http.StatusOK},
+		{name: "empty batch", body: `{"prompts": []}`, expectedCode: http.StatusBadRequest},
+		{name: "batch too large", body: `{"prompts": [{"prompt": "a"}, {"prompt": "b"}, {"prompt": "c"}]}`,
+			expectedCode: http.StatusBadRequest},
+		{name: "prompt missing", body: `{"prompts": [{"prompt": "hi"}, {"chat": "hello"}]}`,
+			expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promptService := new(mocks.MockPromptService)
+			promptService.On("ProcessPrompt").Return(models.Verdict{Allowed: true, RiskScore: 10}, nil)
+			controller := NewCheckController(promptService, m)
+
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/check/batch",
+				bytes.NewBufferString(tt.body))
+			rec := httptest.NewRecorder()
+
+			controller.CheckBatch(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusOK {
+				var resp models.CheckBatchResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				require.Len(t, resp.Verdicts, 2)
+				assert.True(t, resp.Verdicts[1].Allowed)
+				promptService.AssertNumberOfCalls(t, "ProcessPrompt", 2)
+			}
+		})
+	}
+}
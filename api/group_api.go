@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"guardian/internal/middleware"
+	"guardian/internal/models"
+	"guardian/internal/services"
+	"guardian/utlis/logger"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GroupController struct {
+	groupService services.GroupServiceInterface
+}
+
+func NewGroupController(groupService services.GroupServiceInterface) *GroupController {
+	return &GroupController{
+		groupService: groupService,
+	}
+}
+
+func (h *GroupController) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateGroupRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.groupService.CreateGroup(r.Context(), req)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(group)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+func (h *GroupController) GetGroups(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := h.groupService.GetGroups(r.Context(), actor)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(groups)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *GroupController) AddMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	groupID, ok := objectIDParam(w, r, "groupID")
+	if !ok {
+		return
+	}
+
+	var req models.GroupMemberRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.UserID.IsZero() {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.groupService.AddMember(r.Context(), actor, groupID, req.UserID)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GroupController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	groupID, ok := objectIDParam(w, r, "groupID")
+	if !ok {
+		return
+	}
+	userID, ok := objectIDParam(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	err := h.groupService.RemoveMember(r.Context(), actor, groupID, userID)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GroupController) SetTasks(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	groupID, ok := objectIDParam(w, r, "groupID")
+	if !ok {
+		return
+	}
+
+	var req models.GroupTasksRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.groupService.SetTasks(r.Context(), actor, groupID, req.Tasks)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GroupController) SetTargetModels(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	groupID, ok := objectIDParam(w, r, "groupID")
+	if !ok {
+		return
+	}
+
+	var req models.TargetModelsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.groupService.SetTargetModels(r.Context(), actor, groupID, req.TargetModels)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GroupController) SetAdmins(w http.ResponseWriter, r *http.Request) {
+	groupID, ok := objectIDParam(w, r, "groupID")
+	if !ok {
+		return
+	}
+
+	var req models.GroupAdminsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.groupService.SetAdmins(r.Context(), groupID, req.Admins)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func actorFromRequest(w http.ResponseWriter, r *http.Request) (models.Actor, bool) {
+	userID, err := middleware.UserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return models.Actor{}, false
+	}
+	return models.Actor{UserID: userID, Role: middleware.RoleFromContext(r.Context())}, true
+}
+
+func objectIDParam(w http.ResponseWriter, r *http.Request, name string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, name))
+	if err != nil {
+		http.Error(w, "Invalid "+name, http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrGroupNotFound), errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrTaskNotFound), errors.Is(err, services.ErrModelNotFound),
+		errors.Is(err, services.ErrInvalidGroup):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian/internal/middleware"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGroupController_AddMember(t *testing.T) {
+	t.Parallel()
+
+	actor := models.Actor{UserID: primitive.NewObjectID(), Role: entities.RoleGroupAdmin}
+	groupID := primitive.NewObjectID()
+	memberID := primitive.NewObjectID()
+
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{name: "adds the member", expectedCode: http.StatusNoContent},
+		{name: "group of another admin", err: services.ErrForbidden, expectedCode: http.StatusForbidden},
+		{name: "unknown group", err: services.ErrGroupNotFound, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			groupService := new(mocks.MockGroupService)
+			groupService.On("AddMember", actor, groupID, memberID).Return(tt.err)
+			controller := NewGroupController(groupService)
+
+			router := chi.NewRouter()
+			router.Post("/groups/{groupID}/members", controller.AddMember)
+
+			body, _ := json.Marshal(models.GroupMemberRequest{UserID: memberID})
+			ctx := middleware.WithRole(middleware.WithUserID(context.Background(), actor.UserID), actor.Role)
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/groups/"+groupID.Hex()+"/members",
+				bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			groupService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"guardian/guardian_api"
+	"guardian/internal/middleware"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+	"guardian/internal/providers"
+	"guardian/internal/services"
+	"guardian/utlis/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GuardianServer serves the prompt pipeline over gRPC with the services behind SendHandlerController. Calls reach it
+// authenticated and rate limited by middleware.GRPCAuth.
+type GuardianServer struct {
+	guardian_api.UnimplementedGuardianServer
+	promptService      services.PromptServiceInterface
+	targetModelService services.TargetModelServiceInterface
+	targetRouter       services.TargetRouterInterface
+	upstreamService    services.UpstreamServiceInterface
+}
+
+func NewGuardianServer(promptService services.PromptServiceInterface,
+	targetModelService services.TargetModelServiceInterface, targetRouter services.TargetRouterInterface,
+	upstreamService services.UpstreamServiceInterface,
+) *GuardianServer {
+	return &GuardianServer{
+		promptService:      promptService,
+		targetModelService: targetModelService,
+		targetRouter:       targetRouter,
+		upstreamService:    upstreamService,
+	}
+}
+
+func (s *GuardianServer) CheckPrompt(ctx context.Context, req *guardian_api.CheckPromptRequest) (
+	*guardian_api.CheckPromptResponse, error,
+) {
+	userID, err := middleware.UserIDFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	sendReq := models.SendRequest{Prompt: req.GetPrompt(), Chat: req.GetChat(), Messages: chatMessages(req.GetMessages())}
+	reqBody := pluginRequest(ctx, userID, sendReq, chatRequest(sendReq, nil))
+	verdict, err := s.promptService.ProcessPrompt(ctx, &reqBody)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("error in processing the prompt")
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	return verdictResponse(verdict), nil
+}
+
+// relayChunkSize bounds the chunks of a passthrough target model's response relayed to the caller.
+const relayChunkSize = 32 << 10
+
+// Generate streams the verdict, then the completion as it arrives from the target model through the plugins moderating
+// output, then how the completion ended. The stream stops once a plugin flags the output.
+func (s *GuardianServer) Generate(req *guardian_api.GenerateRequest,
+	stream grpc.ServerStreamingServer[guardian_api.GenerateResponse],
+) error {
+	ctx := stream.Context()
+	userID, err := middleware.UserIDFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	sendReq := models.SendRequest{
+		Prompt:    req.GetPrompt(),
+		Chat:      req.GetChat(),
+		Messages:  chatMessages(req.GetMessages()),
+		MaxTokens: int(req.GetMaxTokens()),
+		TargetID:  req.GetTargetId(),
+	}
+	if temperature, ok := req.GetOptionalTemperature().(*guardian_api.GenerateRequest_Temperature); ok {
+		sendReq.Temperature = &temperature.Temperature
+	}
+	raw, err := json.Marshal(sendReq)
+	if err != nil {
+		return status.Error(codes.Internal, "Internal server error")
+	}
+	chatReq := chatRequest(sendReq, raw)
+	reqBody := pluginRequest(ctx, userID, sendReq, chatReq)
+
+	var targetLLM *entities.TargetModel
+	autoTarget := sendReq.TargetID == "" || sendReq.TargetID == models.AutoTarget
+	if !autoTarget {
+		reqBody.TargetID, err = primitive.ObjectIDFromHex(sendReq.TargetID)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "Invalid target_id")
+		}
+		err = s.targetRouter.CheckTarget(ctx, userID, reqBody.TargetID)
+		if errors.Is(err, services.ErrTargetNotAllowed) {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		if err != nil {
+			logger.GetLogger().Errorf("error in checking the target LLM %v", err)
+			return status.Error(codes.Internal, "Internal server error")
+		}
+		targetLLM, err = s.targetModelService.GetTargetModel(ctx, reqBody.TargetID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return status.Error(codes.NotFound, "Target model not found")
+		}
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).Error("error in resolving the target LLM")
+			return status.Error(codes.Internal, "Internal server error")
+		}
+	}
+
+	verdict, err := s.promptService.ProcessPrompt(ctx, &reqBody)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("error in processing the prompt")
+		return status.Error(codes.Internal, "Internal server error")
+	}
+	err = stream.Send(&guardian_api.GenerateResponse{
+		Event: &guardian_api.GenerateResponse_Verdict{Verdict: verdictResponse(verdict)},
+	})
+	if err != nil || !verdict.Allowed {
+		return err
+	}
+
+	if autoTarget {
+		targetLLM, err = s.targetRouter.SelectTarget(ctx, userID, reqBody.Prompt, verdict)
+		if errors.Is(err, services.ErrNoTargetAvailable) {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).Error("error in selecting the target LLM")
+			return status.Error(codes.Internal, "Internal server error")
+		}
+	}
+
+	resp, answeredBy, err := s.upstreamService.Send(ctx, userID, targetLLM, http.MethodPost, http.Header{},
+		chatReq)
+	if errors.Is(err, services.ErrTargetUnavailable) {
+		logger.FromContext(ctx).WithError(err).Error("error in forwarding the prompt")
+		return status.Error(codes.Unavailable, services.ErrTargetUnavailable.Error())
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("error in forwarding the prompt")
+		return status.Error(codes.Internal, "Internal server error")
+	}
+	completion, err := s.relay(ctx, stream, &reqBody, resp, answeredBy)
+	if err != nil {
+		return err
+	}
+	return stream.Send(&guardian_api.GenerateResponse{
+		Event: &guardian_api.GenerateResponse_Completion{Completion: &guardian_api.Completion{
+			TargetId:     answeredBy.ID.Hex(),
+			Model:        completion.Model,
+			FinishReason: completion.FinishReason,
+			InputTokens:  uint32(completion.Usage.InputTokens),
+			OutputTokens: uint32(completion.Usage.OutputTokens),
+		}},
+	})
+}
+
+// relay streams the completion in the target model's response to the caller through the plugins moderating output,
+// and returns the completion. Error responses are logged rather than relayed, as they may reveal details of the target
+// model to the caller.
+func (s *GuardianServer) relay(ctx context.Context, stream grpc.ServerStreamingServer[guardian_api.GenerateResponse],
+	reqBody *models.PluginRequest, resp *http.Response, answeredBy *entities.TargetModel,
+) (*models.ChatResponse, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(resp.Body)
+		logger.GetLogger().Errorf("the target model %s answered %d: %s", answeredBy.ID.Hex(), resp.StatusCode, body)
+		return nil, status.Error(codes.FailedPrecondition, "The target model failed to answer")
+	}
+
+	moderator, err := s.promptService.ModerateOutput(ctx, reqBody)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("error in moderating the output")
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	stop := closeOnAbort(moderator, resp.Body)
+	defer stop()
+
+	completion, err := relayCompletion(resp.Body, answeredBy, func(content string) error {
+		err := moderator.Send(content)
+		if err != nil {
+			return err
+		}
+		return stream.Send(&guardian_api.GenerateResponse{
+			Event: &guardian_api.GenerateResponse_Content{Content: content},
+		})
+	})
+	moderationErr := moderator.Close()
+	if errors.Is(moderationErr, plugins.ErrOutputViolation) {
+		logger.FromContext(ctx).WithError(moderationErr).Warn("the output was blocked")
+		return nil, status.Error(codes.PermissionDenied, "The output was blocked")
+	}
+	if moderationErr != nil {
+		logger.FromContext(ctx).WithError(moderationErr).Error("error in moderating the output")
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("error in relaying the target response")
+		return nil, err
+	}
+	return completion, nil
+}
+
+// relayCompletion passes the completion in the body of a successful response to send. The response of a passthrough
+// target model is the completion itself and is passed on as it arrives, a chunk at a time. Other target models answer
+// in Guardian's format, whose completion is passed on in one piece once the response is complete.
+func relayCompletion(body io.Reader, answeredBy *entities.TargetModel, send func(content string) error) (
+	*models.ChatResponse, error,
+) {
+	if _, ok := providers.ForProvider(answeredBy.Provider).(providers.Passthrough); !ok {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, services.ErrTargetUnavailable.Error())
+		}
+		completion, err := replyOf(data, answeredBy)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Internal server error")
+		}
+		return completion, send(completion.Message.Content)
+	}
+
+	buf := make([]byte, relayChunkSize)
+	var pending int
+	for {
+		n, err := body.Read(buf[pending:])
+		n += pending
+		// A rune split across reads waits for its remaining bytes, as the chunks must be valid UTF-8.
+		complete := n
+		if err == nil {
+			complete = runeBoundary(buf[:n])
+		}
+		if complete > 0 {
+			sendErr := send(string(buf[:complete]))
+			if sendErr != nil {
+				return nil, sendErr
+			}
+		}
+		pending = copy(buf, buf[complete:n])
+		if errors.Is(err, io.EOF) {
+			return &models.ChatResponse{}, nil
+		}
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, services.ErrTargetUnavailable.Error())
+		}
+	}
+}
+
+// runeBoundary returns the length of p without a rune cut off at its end.
+func runeBoundary(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
+
+func chatMessages(messages []*guardian_api.ChatMessage) []models.ChatMessage {
+	if len(messages) == 0 {
+		return nil
+	}
+	chat := make([]models.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		chat = append(chat, models.ChatMessage{Role: message.GetRole(), Content: message.GetContent()})
+	}
+	return chat
+}
+
+func verdictResponse(verdict models.Verdict) *guardian_api.CheckPromptResponse {
+	return &guardian_api.CheckPromptResponse{Allowed: verdict.Allowed, RiskScore: verdict.RiskScore}
+}
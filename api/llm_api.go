@@ -1,32 +1,59 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
 	"guardian/internal/middleware"
 	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+	"guardian/internal/providers"
 	"guardian/internal/services"
 	"guardian/utlis/logger"
-	"io"
-	"net/http"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// TargetModelHeader reports the target model that answered the prompt.
+const TargetModelHeader = "X-Guardian-Target-Model"
+
+// maxRecordedReplySize bounds the replies recorded as chat turns. Longer replies reach the user but aren't recorded.
+const maxRecordedReplySize = 1 << 20
+
 type SendHandlerController struct {
 	promptService      services.PromptServiceInterface
 	targetModelService services.TargetModelServiceInterface
+	targetRouter       services.TargetRouterInterface
+	upstreamService    services.UpstreamServiceInterface
 	middleware         middleware.Interface
 }
 
 func NewSendHandlerController(promptService services.PromptServiceInterface,
-	targetModelService services.TargetModelServiceInterface, m middleware.Interface,
+	targetModelService services.TargetModelServiceInterface, targetRouter services.TargetRouterInterface,
+	upstreamService services.UpstreamServiceInterface, m middleware.Interface,
 ) *SendHandlerController {
 	return &SendHandlerController{
 		promptService:      promptService,
 		targetModelService: targetModelService,
+		targetRouter:       targetRouter,
+		upstreamService:    upstreamService,
 		middleware:         m,
 	}
 }
 
+// SendHandler runs the prompt through the user's tasks and forwards the request to the target model. Requests without
+// a target_id, or with target_id "auto", are routed to a target model chosen after the tasks ran. The target model
+// gets the request in its provider's format, and the response comes back in Guardian's format whatever the provider.
+// The response names the model that answered, which is a fallback model when the target model's endpoints all failed.
+// The response is streamed through the plugins moderating output, and cut short once one of them flags it.
 func (h *SendHandlerController) SendHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := h.middleware.GetUserFromContext(r)
 	if err != nil {
@@ -34,30 +61,57 @@ func (h *SendHandlerController) SendHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var reqBody models.PluginRequest
-	err = json.NewDecoder(r.Body).Decode(&reqBody)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		logger.GetLogger().Errorf("error in sendhandler %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	defer r.Body.Close()
 
-	reqBody.UserID = *userID
-	targetLLM, err := h.targetModelService.GetTargetModel(r.Context(), reqBody.TargetID)
+	var sendReq models.SendRequest
+	err = json.Unmarshal(body, &sendReq)
 	if err != nil {
-		logger.GetLogger().Errorf("error in resolving the target LLM %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 
-	result, err := h.promptService.ProcessPrompt(r.Context(), &reqBody)
+	chatReq := chatRequest(sendReq, body)
+	reqBody := pluginRequest(r.Context(), *userID, sendReq, chatReq)
+
+	var targetLLM *entities.TargetModel
+	autoTarget := sendReq.TargetID == "" || sendReq.TargetID == models.AutoTarget
+	if !autoTarget {
+		reqBody.TargetID, err = primitive.ObjectIDFromHex(sendReq.TargetID)
+		if err != nil {
+			http.Error(w, "Invalid target_id", http.StatusBadRequest)
+			return
+		}
+		err = h.targetRouter.CheckTarget(r.Context(), *userID, reqBody.TargetID)
+		if errors.Is(err, services.ErrTargetNotAllowed) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logger.GetLogger().Errorf("error in checking the target LLM %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		targetLLM, err = h.targetModelService.GetTargetModel(r.Context(), reqBody.TargetID)
+		if err != nil {
+			logger.FromContext(r.Context()).WithError(err).Error("error in resolving the target LLM")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	verdict, err := h.promptService.ProcessPrompt(r.Context(), &reqBody)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	if !result {
-		resp := models.PluginResponse{Status: result}
+	if !verdict.Allowed {
+		resp := models.PluginResponse{Status: false, Score: verdict.RiskScore}
 		w.Header().Set("Content-Type", "application/json")
 		respBody, err := json.Marshal(resp)
 		if err != nil {
@@ -72,48 +126,230 @@ func (h *SendHandlerController) SendHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	newReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetLLM.Address, r.Body)
+	if autoTarget {
+		targetLLM, err = h.targetRouter.SelectTarget(r.Context(), *userID, reqBody.Prompt, verdict)
+		if errors.Is(err, services.ErrNoTargetAvailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			logger.FromContext(r.Context()).WithError(err).Error("error in selecting the target LLM")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp, answeredBy, err := h.upstreamService.Send(r.Context(), *userID, targetLLM, r.Method, r.Header, chatReq)
+	if errors.Is(err, services.ErrTargetUnavailable) {
+		logger.FromContext(r.Context()).WithError(err).Error("error in forwarding the prompt")
+		http.Error(w, services.ErrTargetUnavailable.Error(), http.StatusBadGateway)
+		return
+	}
 	if err != nil {
+		logger.FromContext(r.Context()).WithError(err).Error("error in forwarding the prompt")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	defer r.Body.Close()
-
-	for k, v := range r.Header {
-		newReq.Header[k] = v
-	}
 
-	resp, err := h.promptService.SendPrompt(r.Context(), newReq)
+	moderator, err := h.promptService.ModerateOutput(r.Context(), &reqBody)
 	if err != nil {
+		resp.Body.Close()
+		logger.FromContext(r.Context()).WithError(err).Error("error in moderating the output")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	err = h.returnResponseToUser(w, resp)
+	var reply *cappedBuffer
+	if recordsReply(&reqBody, resp) {
+		reply = &cappedBuffer{limit: maxRecordedReplySize}
+	}
+	w.Header().Set(TargetModelHeader, answeredBy.ID.Hex())
+	err = h.returnResponseToUser(w, resp, reply, moderator)
+	if errors.Is(err, plugins.ErrOutputViolation) {
+		logger.FromContext(r.Context()).WithError(err).Warn("the output was blocked")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		logger.FromContext(r.Context()).WithError(err).Error("error in returning the target response")
 		return
 	}
+	if reply != nil && !reply.truncated {
+		h.recordReply(r.Context(), &reqBody, answeredBy, reply.Bytes())
+	}
 }
 
-func (h *SendHandlerController) returnResponseToUser(w http.ResponseWriter, resp *http.Response) error {
-	w.WriteHeader(resp.StatusCode)
+// recordsReply reports whether the target model's response is recorded as a turn of the prompt's chat. Only
+// successful replies of prompts sent in a chat are. Streamed replies aren't recorded, as they aren't a completion.
+func recordsReply(reqBody *models.PluginRequest, resp *http.Response) bool {
+	if reqBody.ChatID == nil || resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType != "text/event-stream"
+}
+
+// cappedBuffer keeps up to limit bytes of what is written to it and notes whether anything was left out. Writes never
+// fail, so that it can tee a response without interrupting it.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	kept := p
+	if room := b.limit - b.Len(); len(kept) > room {
+		kept = kept[:max(room, 0)]
+		b.truncated = true
+	}
+	b.Buffer.Write(kept)
+	return len(p), nil
+}
+
+// recordReply records the target model's answer as a turn of the prompt's chat.
+func (h *SendHandlerController) recordReply(ctx context.Context, reqBody *models.PluginRequest,
+	answeredBy *entities.TargetModel, body []byte,
+) {
+	completion, err := replyOf(body, answeredBy)
+	if err == nil {
+		err = h.promptService.RecordReply(ctx, reqBody, completion.Message.Content)
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("error in recording the chat turn")
+	}
+}
+
+// moderatedWriter streams what is written to it to the plugins moderating output before passing it on, and fails once
+// they flagged the output. What is passed on is flushed, so that streamed responses reach the user as they arrive.
+type moderatedWriter struct {
+	dst       io.Writer
+	moderator *plugins.OutputModerator
+	flusher   *http.ResponseController
+}
+
+func (m *moderatedWriter) Write(p []byte) (int, error) {
+	err := m.moderator.Send(string(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := m.dst.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_ = m.flusher.Flush()
+	return n, nil
+}
+
+// closeOnAbort closes the target model's response once the moderator is aborted, so that relaying the response stops
+// even while the target model is silent. Calling the returned function stops watching the moderator.
+func closeOnAbort(moderator *plugins.OutputModerator, body io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-moderator.Aborted():
+			_ = body.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// returnResponseToUser copies the target model's response to the user through the moderator, and its body to reply
+// unless it is nil. Copying stops once the moderator is aborted, and the reason is returned.
+func (h *SendHandlerController) returnResponseToUser(w http.ResponseWriter, resp *http.Response,
+	reply *cappedBuffer, moderator *plugins.OutputModerator,
+) error {
+	defer resp.Body.Close()
+	stop := closeOnAbort(moderator, resp.Body)
+	defer stop()
 
+	services.RemoveHopByHopHeaders(resp.Header)
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	w.WriteHeader(resp.StatusCode)
 
-	_, err := io.Copy(w, resp.Body)
+	var dst io.Writer = w
+	if reply != nil {
+		dst = io.MultiWriter(w, reply)
+	}
+	_, err := io.Copy(&moderatedWriter{dst: dst, moderator: moderator, flusher: http.NewResponseController(w)},
+		resp.Body)
+	moderationErr := moderator.Close()
+	if moderationErr != nil {
+		return moderationErr
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write response body: %w", err)
 	}
-	defer resp.Body.Close()
 
 	return nil
 }
 
-// TODO: selectTargetLLM should choose if the user/group let the system choose the appropriate Target LLM.
-// func selectTargetLLM() {
-//}
+// chatRequest turns a send request into the conversation for the target model. The prompt, when there is one, is the
+// last message, after either the messages or the previous conversation.
+func chatRequest(sendReq models.SendRequest, raw []byte) *models.ChatRequest {
+	messages := sendReq.Messages
+	if len(messages) == 0 && sendReq.Chat != "" {
+		messages = []models.ChatMessage{{Role: models.RoleSystem, Content: sendReq.Chat}}
+	}
+	if sendReq.Prompt != "" {
+		messages = append(messages, models.ChatMessage{Role: models.RoleUser, Content: sendReq.Prompt})
+	}
+
+	return &models.ChatRequest{
+		Messages:    messages,
+		MaxTokens:   sendReq.MaxTokens,
+		Temperature: sendReq.Temperature,
+		Raw:         raw,
+	}
+}
+
+// replyOf reads the completion in the target model's response body. Responses of passthrough target models aren't in
+// Guardian's format and are taken as the content of the completion.
+func replyOf(body []byte, answeredBy *entities.TargetModel) (*models.ChatResponse, error) {
+	if _, ok := providers.ForProvider(answeredBy.Provider).(providers.Passthrough); ok {
+		return &models.ChatResponse{Message: models.ChatMessage{Role: models.RoleAssistant, Content: string(body)}},
+			nil
+	}
+	var completion models.ChatResponse
+	err := json.Unmarshal(body, &completion)
+	if err != nil {
+		return nil, err
+	}
+	return &completion, nil
+}
+
+// pluginRequest returns the request the plugins judge the prompt of a send request with.
+func pluginRequest(ctx context.Context, userID primitive.ObjectID, sendReq models.SendRequest,
+	chatReq *models.ChatRequest,
+) models.PluginRequest {
+	reqBody := models.PluginRequest{
+		UserID:    userID,
+		ChatID:    sendReq.ChatID,
+		Chat:      sendReq.Chat,
+		Prompt:    sendReq.Prompt,
+		RequestID: chimiddleware.GetReqID(ctx),
+	}
+	if key := middleware.APIKeyFromContext(ctx); key != nil {
+		reqBody.GroupID = key.GroupID
+	}
+	if len(sendReq.Messages) > 0 {
+		var history []models.ChatMessage
+		history, reqBody.Prompt = promptOf(chatReq.Messages)
+		reqBody.SetHistory(history)
+	}
+	return reqBody
+}
+
+// promptOf returns the conversation before the last user message, and that message, for the plugins to judge.
+func promptOf(messages []models.ChatMessage) ([]models.ChatMessage, string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == models.RoleUser {
+			return messages[:i], messages[i].Content
+		}
+	}
+	return nil, ""
+}
@@ -4,17 +4,29 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"guardian/internal/mocks"
 	"guardian/internal/models"
 	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+	"guardian/internal/services"
+	"guardian/prompt_api"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 var (
@@ -41,7 +53,8 @@ func TestSendHandler(t *testing.T) {
 
 		targetModelService := new(mocks.MockTargetModelService)
 		promptService := new(mocks.MockPromptService)
-		controller := NewSendHandlerController(promptService, targetModelService, m)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, targetModelService, allowAllTargets(), upstreamService, m)
 
 		targetModelService.On("GetTargetModel", mock.Anything, mock.Anything).
 			Return(entities.TargetModel{}, ErrTargetModel)
@@ -61,11 +74,12 @@ func TestSendHandler(t *testing.T) {
 
 		targetModelService := new(mocks.MockTargetModelService)
 		promptService := new(mocks.MockPromptService)
-		controller := NewSendHandlerController(promptService, targetModelService, m)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, targetModelService, allowAllTargets(), upstreamService, m)
 
 		targetModelService.On("GetTargetModel", mock.Anything, mock.Anything).
 			Return(entities.TargetModel{}, nil)
-		promptService.On("ProcessPrompt").Return(false, ErrProcessPrompt)
+		promptService.On("ProcessPrompt").Return(models.Verdict{}, ErrProcessPrompt)
 
 		body, _ := json.Marshal(reqBody)
 		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send", bytes.NewBuffer(body))
@@ -81,12 +95,13 @@ func TestSendHandler(t *testing.T) {
 
 		targetModelService := new(mocks.MockTargetModelService)
 		promptService := new(mocks.MockPromptService)
-		controller := NewSendHandlerController(promptService, targetModelService, m)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, targetModelService, allowAllTargets(), upstreamService, m)
 
 		targetModelService.On("GetTargetModel", mock.Anything, mock.Anything).
 			Return(entities.TargetModel{}, nil)
 
-		promptService.On("ProcessPrompt").Return(false, nil)
+		promptService.On("ProcessPrompt").Return(models.Verdict{}, nil)
 		promptService.On("Do").Return(nil, ErrForwardingPrompt)
 
 		body, _ := json.Marshal(reqBody)
@@ -106,12 +121,14 @@ func TestSendHandler(t *testing.T) {
 
 		targetModelService := new(mocks.MockTargetModelService)
 		promptService := new(mocks.MockPromptService)
-		controller := NewSendHandlerController(promptService, targetModelService, m)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, targetModelService, allowAllTargets(), upstreamService, m)
 
 		targetModelService.On("GetTargetModel", mock.Anything, mock.Anything).
 			Return(entities.TargetModel{}, nil)
-		promptService.On("ProcessPrompt").Return(true, nil)
-		promptService.On("SendPrompt").Return(&http.Response{}, ErrForwardingPrompt)
+		promptService.On("ProcessPrompt").Return(models.Verdict{Allowed: true}, nil)
+		upstreamService.On("Send", mock.Anything, http.MethodPost, mock.Anything).
+			Return(nil, nil, fmt.Errorf("%w: %w", services.ErrTargetUnavailable, ErrForwardingPrompt))
 
 		body, _ := json.Marshal(reqBody)
 		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send", bytes.NewBuffer(body))
@@ -119,6 +136,299 @@ func TestSendHandler(t *testing.T) {
 
 		controller.SendHandler(rec, req)
 
-		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+	})
+
+	t.Run("target model is not allowed", func(t *testing.T) {
+		t.Parallel()
+
+		targetRouter := new(mocks.MockTargetRouter)
+		controller := NewSendHandlerController(new(mocks.MockPromptService), new(mocks.MockTargetModelService),
+			targetRouter, new(mocks.MockUpstreamService), m)
+		targetRouter.On("CheckTarget", mock.Anything, mock.Anything).Return(services.ErrTargetNotAllowed)
+
+		body, _ := json.Marshal(reqBody)
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send", bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+
+		controller.SendHandler(rec, req)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func allowAllTargets() *mocks.MockTargetRouter {
+	targetRouter := new(mocks.MockTargetRouter)
+	targetRouter.On("CheckTarget", mock.Anything, mock.Anything).Return(nil)
+	return targetRouter
+}
+
+func TestSendHandler_AutoTarget(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.ObjectID{}
+	m := new(mocks.MockMiddleware)
+	m.On("GetUserFromContext").Return(mock.Anything, nil)
+	body, _ := json.Marshal(models.SendRequest{Prompt: "Hello", TargetID: models.AutoTarget})
+
+	t.Run("no target available", func(t *testing.T) {
+		t.Parallel()
+
+		promptService := new(mocks.MockPromptService)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, new(mocks.MockTargetModelService), targetRouter,
+			upstreamService, m)
+		verdict := models.Verdict{Allowed: true, RiskScore: 70}
+		promptService.On("ProcessPrompt").Return(verdict, nil)
+		targetRouter.On("SelectTarget", userID, "Hello", verdict).Return(nil, services.ErrNoTargetAvailable)
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send", bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+
+		controller.SendHandler(rec, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("reports the model that answered", func(t *testing.T) {
+		t.Parallel()
+
+		promptService := new(mocks.MockPromptService)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, new(mocks.MockTargetModelService), targetRouter,
+			upstreamService, m)
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Address: "http://target"}
+		verdict := models.Verdict{Allowed: true}
+		promptService.On("ProcessPrompt").Return(verdict, nil)
+		moderateNothing(t, promptService)
+		targetRouter.On("SelectTarget", userID, "Hello", verdict).Return(targetModel, nil)
+		fallback := &entities.TargetModel{ID: primitive.NewObjectID(), Address: "http://fallback"}
+		upstreamService.On("Send", targetModel, http.MethodPost, mock.Anything).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(bytes.NewBufferString(`{"answer":"hi"}`)),
+		}, fallback, nil)
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send", bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+
+		controller.SendHandler(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, fallback.ID.Hex(), rec.Header().Get(TargetModelHeader))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `{"answer":"hi"}`, rec.Body.String())
 	})
+	t.Run("records the reply in the chat", func(t *testing.T) {
+		t.Parallel()
+
+		promptService := new(mocks.MockPromptService)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, new(mocks.MockTargetModelService), targetRouter,
+			upstreamService, m)
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Provider: "openai"}
+		verdict := models.Verdict{Allowed: true}
+		promptService.On("ProcessPrompt").Return(verdict, nil)
+		promptService.On("RecordReply", "Hi there").Return(nil)
+		moderateNothing(t, promptService)
+		targetRouter.On("SelectTarget", userID, "Hello", verdict).Return(targetModel, nil)
+		completion, _ := json.Marshal(models.ChatResponse{
+			Message: models.ChatMessage{Role: models.RoleAssistant, Content: "Hi there"},
+		})
+		upstreamService.On("Send", targetModel, http.MethodPost, mock.Anything).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewReader(completion)),
+		}, targetModel, nil)
+
+		chatID := primitive.NewObjectID()
+		chatBody, _ := json.Marshal(models.SendRequest{ChatID: &chatID, Prompt: "Hello"})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send",
+			bytes.NewBuffer(chatBody))
+		rec := httptest.NewRecorder()
+
+		controller.SendHandler(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, string(completion), rec.Body.String())
+		promptService.AssertCalled(t, "RecordReply", "Hi there")
+	})
+	t.Run("does not record streamed replies", func(t *testing.T) {
+		t.Parallel()
+
+		promptService := new(mocks.MockPromptService)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, new(mocks.MockTargetModelService), targetRouter,
+			upstreamService, m)
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Provider: "openai"}
+		verdict := models.Verdict{Allowed: true}
+		promptService.On("ProcessPrompt").Return(verdict, nil)
+		moderateNothing(t, promptService)
+		targetRouter.On("SelectTarget", userID, "Hello", verdict).Return(targetModel, nil)
+		events := "data: {\"content\":\"Hi\"}\n\ndata: [DONE]\n\n"
+		upstreamService.On("Send", targetModel, http.MethodPost, mock.Anything).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream; charset=utf-8"}},
+			Body:       io.NopCloser(strings.NewReader(events)),
+		}, targetModel, nil)
+
+		chatID := primitive.NewObjectID()
+		chatBody, _ := json.Marshal(models.SendRequest{ChatID: &chatID, Prompt: "Hello"})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send",
+			bytes.NewBuffer(chatBody))
+		rec := httptest.NewRecorder()
+
+		controller.SendHandler(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, events, rec.Body.String())
+		promptService.AssertNotCalled(t, "RecordReply", mock.Anything)
+	})
+	t.Run("stops streaming once the output is flagged", func(t *testing.T) {
+		t.Parallel()
+
+		promptService := new(mocks.MockPromptService)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstreamService := new(mocks.MockUpstreamService)
+		controller := NewSendHandlerController(promptService, new(mocks.MockTargetModelService), targetRouter,
+			upstreamService, m)
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Provider: "openai"}
+		verdict := models.Verdict{Allowed: true}
+		promptService.On("ProcessPrompt").Return(verdict, nil)
+		moderator := moderateWith(t, promptService, "secret")
+		targetRouter.On("SelectTarget", userID, "Hello", verdict).Return(targetModel, nil)
+		upstreamService.On("Send", targetModel, http.MethodPost, mock.Anything).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body: &eventStream{moderator: moderator, events: []string{
+				"data: {\"content\":\"The password is\"}\n\n",
+				"data: {\"content\":\"secret\"}\n\n",
+				"data: {\"content\":\"123\"}\n\n",
+			}},
+		}, targetModel, nil)
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/send", bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+
+		controller.SendHandler(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "The password is")
+		assert.NotContains(t, rec.Body.String(), "123")
+		assert.ErrorIs(t, moderator.Err(), plugins.ErrOutputViolation)
+	})
+}
+
+// eventStream is a streamed response whose events arrive one at a time. With a moderator, the event after the flagged
+// one only arrives once the moderator was aborted, as it would from a target model slower than the plugins.
+type eventStream struct {
+	moderator *plugins.OutputModerator
+	events    []string
+	read      int
+}
+
+func (s *eventStream) Read(p []byte) (int, error) {
+	if s.read == len(s.events) {
+		return 0, io.EOF
+	}
+	if s.moderator != nil && s.read > 0 && strings.Contains(s.events[s.read-1], "secret") {
+		select {
+		case <-s.moderator.Aborted():
+		case <-time.After(5 * time.Second):
+		}
+	}
+	n := copy(p, s.events[s.read])
+	s.read++
+	return n, nil
+}
+
+func (s *eventStream) Close() error {
+	return nil
+}
+
+// flaggingPlugin moderates output, and flags it once a chunk contains the word.
+type flaggingPlugin struct {
+	prompt_api.UnimplementedPromptServiceV2Server
+	word string
+}
+
+func (p *flaggingPlugin) ModerateOutput(stream prompt_api.PromptServiceV2_ModerateOutputServer) error {
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if chunk.GetRequest() != nil {
+			continue
+		}
+		if strings.Contains(chunk.GetContent(), p.word) {
+			return stream.Send(&prompt_api.OutputVerdict{ChunkIndex: chunk.GetIndex(), Reason: "flagged"})
+		}
+		err = stream.Send(&prompt_api.OutputVerdict{Allowed: true, ChunkIndex: chunk.GetIndex()})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// moderateWith makes the prompt service moderate output with a plugin flagging the word, and returns the moderator.
+func moderateWith(t *testing.T, promptService *mocks.MockPromptService, word string) *plugins.OutputModerator {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	prompt_api.RegisterPromptServiceV2Server(server, &flaggingPlugin{word: word})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	subscriber := plugins.OutputSubscriber{
+		Plugin: &entities.Plugin{Name: "flagging", Protocol: entities.Protocol{Type: entities.GRPCProtocol}},
+		Client: plugins.NewPluginGRPCClient(conn),
+	}
+	moderator, err := plugins.NewOutputModerator(context.Background(), []plugins.OutputSubscriber{subscriber},
+		&models.PluginRequest{})
+	require.NoError(t, err)
+	promptService.On("ModerateOutput", mock.Anything).Return(moderator, nil)
+	return moderator
+}
+
+// moderateNothing makes the prompt service let any output through.
+func moderateNothing(t *testing.T, promptService *mocks.MockPromptService) {
+	t.Helper()
+
+	moderator, err := plugins.NewOutputModerator(context.Background(), nil, &models.PluginRequest{})
+	require.NoError(t, err)
+	promptService.On("ModerateOutput", mock.Anything).Return(moderator, nil)
+}
+
+func TestCappedBuffer(t *testing.T) {
+	t.Parallel()
+
+	buffer := &cappedBuffer{limit: 5}
+	n, err := buffer.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.False(t, buffer.truncated)
+
+	n, err = buffer.Write([]byte("defg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.True(t, buffer.truncated)
+	assert.Equal(t, "abcde", buffer.String())
 }
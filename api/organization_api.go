@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"guardian/internal/models"
+	"guardian/internal/services"
+	"guardian/utlis/logger"
+)
+
+type OrganizationController struct {
+	organizationService services.OrganizationServiceInterface
+}
+
+func NewOrganizationController(organizationService services.OrganizationServiceInterface) *OrganizationController {
+	return &OrganizationController{
+		organizationService: organizationService,
+	}
+}
+
+func (h *OrganizationController) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateOrganizationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	organization, err := h.organizationService.CreateOrganization(r.Context(), req)
+	switch {
+	case errors.Is(err, services.ErrInvalidOrganization):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrOrganizationExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(organization)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+func (h *OrganizationController) GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	organizations, err := h.organizationService.GetOrganizations(r.Context())
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(organizations)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
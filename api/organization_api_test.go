@@ -0,0 +1,57 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrganizationController_CreateOrganization(t *testing.T) {
+	t.Parallel()
+
+	reqBody := models.CreateOrganizationRequest{Name: "Risk", Slug: "risk"}
+	tests := []struct {
+		name         string
+		organization *entities.Organization
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "creates the organization",
+			organization: &entities.Organization{ID: primitive.NewObjectID(), Name: "Risk", Slug: "risk"},
+			expectedCode: http.StatusCreated,
+		},
+		{name: "slug in use", err: services.ErrOrganizationExists, expectedCode: http.StatusConflict},
+		{name: "invalid slug", err: services.ErrInvalidOrganization, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			organizationService := new(mocks.MockOrganizationService)
+			organizationService.On("CreateOrganization", reqBody).Return(tt.organization, tt.err)
+			controller := NewOrganizationController(organizationService)
+
+			body, _ := json.Marshal(reqBody)
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/organizations",
+				bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+			controller.CreateOrganization(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			organizationService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"guardian/internal/models"
+	"guardian/internal/plugins"
+	"guardian/internal/services"
+	"guardian/utlis/logger"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+type PluginController struct {
+	pluginService       services.PluginServiceInterface
+	pluginHealthService services.PluginHealthServiceInterface
+}
+
+func NewPluginController(pluginService services.PluginServiceInterface,
+	pluginHealthService services.PluginHealthServiceInterface,
+) *PluginController {
+	return &PluginController{
+		pluginService:       pluginService,
+		pluginHealthService: pluginHealthService,
+	}
+}
+
+// CreateBootstrapToken issues a token plugins register themselves into the admin's organization with.
+func (h *PluginController) CreateBootstrapToken(w http.ResponseWriter, r *http.Request) {
+	token, err := h.pluginService.IssueBootstrapToken(r.Context())
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(token)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+// RegisterPlugin lets a plugin holding a bootstrap token register itself and declare its capabilities.
+func (h *PluginController) RegisterPlugin(w http.ResponseWriter, r *http.Request) {
+	bootstrapToken := jwtauth.TokenFromHeader(r)
+	if bootstrapToken == "" {
+		http.Error(w, services.ErrInvalidBootstrapToken.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req models.RegisterPluginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	registration, err := h.pluginService.RegisterPlugin(r.Context(), bootstrapToken, req)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(registration)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+// SetTaskPlugins assigns plugins to a task. Plugins must have declared support for the task's type.
+func (h *PluginController) SetTaskPlugins(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := objectIDParam(w, r, "taskID")
+	if !ok {
+		return
+	}
+
+	var req models.TaskPluginsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.pluginService.SetTaskPlugins(r.Context(), taskID, req.Plugins)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateBuiltinPlugin adds a plugin running detectors in-process, which is then assigned to tasks like any plugin.
+func (h *PluginController) CreateBuiltinPlugin(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateBuiltinPluginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	plugin, err := h.pluginService.CreateBuiltinPlugin(r.Context(), req)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(plugin)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+// CreateWasmPlugin adds a plugin running a WebAssembly module in-process, which is then assigned to tasks like any
+// plugin.
+func (h *PluginController) CreateWasmPlugin(w http.ResponseWriter, r *http.Request) {
+	// Uploaded modules are base64 encoded, so the request is a third larger than the module.
+	r.Body = http.MaxBytesReader(w, r.Body, 2*plugins.MaxModuleSize)
+	var req models.CreateWasmPluginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	plugin, err := h.pluginService.CreateWasmPlugin(r.Context(), req)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(plugin)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+	}
+}
+
+// GetPluginHealth returns the status and latency of the organization's active plugins, as of their last health check.
+func (h *PluginController) GetPluginHealth(w http.ResponseWriter, r *http.Request) {
+	health, err := h.pluginHealthService.GetPluginHealth(r.Context())
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(health)
+	if err != nil {
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writePluginError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBootstrapToken):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrForbidden), errors.Is(err, services.ErrRegistrationDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidPlugin), errors.Is(err, services.ErrPluginNotFound),
+		errors.Is(err, services.ErrUnsupportedTask):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrPluginNameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrCapabilitiesUnavailable):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		logger.GetLogger().Errorf("Error:%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
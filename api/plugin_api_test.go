@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPluginController_GetPluginHealth(t *testing.T) {
+	t.Parallel()
+
+	health := []models.PluginHealth{
+		{PluginID: primitive.NewObjectID(), Name: "toxicity", Status: models.PluginHealthDegraded, LatencyMS: 5000},
+	}
+	tests := []struct {
+		name         string
+		health       []models.PluginHealth
+		err          error
+		expectedCode int
+	}{
+		{name: "returns the health", health: health, expectedCode: http.StatusOK},
+		{name: "fails to load the plugins", err: errors.New("db down"), expectedCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pluginHealthService := new(mocks.MockPluginHealthService)
+			pluginHealthService.On("GetPluginHealth").Return(tt.health, tt.err)
+			controller := NewPluginController(new(mocks.MockPluginService), pluginHealthService)
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/admin/plugins/health", nil)
+			rec := httptest.NewRecorder()
+			controller.GetPluginHealth(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.err == nil {
+				var body []models.PluginHealth
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+				assert.Equal(t, tt.health[0].Name, body[0].Name)
+				assert.Equal(t, models.PluginHealthDegraded, body[0].Status)
+			}
+			pluginHealthService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPluginController_RegisterPlugin(t *testing.T) {
+	t.Parallel()
+
+	body := models.RegisterPluginRequest{Name: "toxicity", Address: "http://toxicity:8080/check",
+		Protocol: entities.HTTPProtocol, Capabilities: &entities.PluginCapabilities{TaskTypes: []string{"toxicity"}}}
+	registration := &models.RegisterPluginResponse{PluginID: primitive.NewObjectID(), Token: "plugin-token",
+		Auth: entities.PluginAuthHMAC, Capabilities: *body.Capabilities}
+	tests := []struct {
+		name         string
+		token        string
+		registration *models.RegisterPluginResponse
+		err          error
+		expectedCode int
+	}{
+		{name: "registers the plugin", token: "bootstrap", registration: registration, expectedCode: http.StatusOK},
+		{name: "requires a bootstrap token", expectedCode: http.StatusUnauthorized},
+		{name: "rejects invalid bootstrap tokens", token: "expired", err: services.ErrInvalidBootstrapToken,
+			expectedCode: http.StatusUnauthorized},
+		{name: "rejects invalid plugins", token: "bootstrap", err: services.ErrInvalidPlugin,
+			expectedCode: http.StatusBadRequest},
+		{name: "fails to describe the plugin", token: "bootstrap", err: services.ErrCapabilitiesUnavailable,
+			expectedCode: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pluginService := new(mocks.MockPluginService)
+			pluginService.On("RegisterPlugin", tt.token, body).Return(tt.registration, tt.err)
+			controller := NewPluginController(pluginService, new(mocks.MockPluginHealthService))
+
+			reqBody, _ := json.Marshal(body)
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/plugins/register",
+				bytes.NewReader(reqBody))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			controller.RegisterPlugin(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.registration != nil {
+				var response models.RegisterPluginResponse
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, *tt.registration, response)
+			}
+			if tt.token == "" {
+				pluginService.AssertNotCalled(t, "RegisterPlugin", tt.token, body)
+			}
+		})
+	}
+}
+
+func TestPluginController_SetTaskPlugins(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{name: "assigns the plugins", expectedCode: http.StatusNoContent},
+		{name: "task not found", err: services.ErrTaskNotFound, expectedCode: http.StatusNotFound},
+		{name: "unsupported task", err: services.ErrUnsupportedTask, expectedCode: http.StatusBadRequest},
+		{name: "unknown plugin", err: services.ErrPluginNotFound, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			taskID := primitive.NewObjectID()
+			pluginIDs := []primitive.ObjectID{primitive.NewObjectID()}
+			pluginService := new(mocks.MockPluginService)
+			pluginService.On("SetTaskPlugins", taskID, pluginIDs).Return(tt.err)
+			controller := NewPluginController(pluginService, new(mocks.MockPluginHealthService))
+
+			reqBody, _ := json.Marshal(models.TaskPluginsRequest{Plugins: pluginIDs})
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("taskID", taskID.Hex())
+			req := httptest.NewRequestWithContext(context.WithValue(context.Background(), chi.RouteCtxKey, routeCtx),
+				http.MethodPut, "/admin/tasks/"+taskID.Hex()+"/plugins", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			controller.SetTaskPlugins(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			pluginService.AssertExpectations(t)
+		})
+	}
+}
@@ -1,13 +1,23 @@
 package guardian
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"guardian/configs"
 	"guardian/internal/metrics"
 	"guardian/internal/mongodb"
+	"guardian/internal/plugins"
+	"guardian/internal/redis"
+	"guardian/internal/repository"
+	"guardian/internal/secrets"
 	"guardian/internal/server"
+	"guardian/internal/setup"
+	"guardian/internal/tracing"
 	"guardian/utlis/logger"
 
 	"github.com/spf13/cobra"
@@ -26,6 +36,17 @@ func init() {
 	logger.InitLogger()
 
 	configs.GlobalConfig = configs.LoadConfig()
+	loggingConfig := configs.GlobalConfig.Logging
+	err := logger.Configure(loggingConfig.Format, loggingConfig.Level, loggingConfig.SensitiveData)
+	if err != nil {
+		logger.GetLogger().Fatalf("Failed to set up logging: %s", err)
+	}
+	wasm := configs.GlobalConfig.Wasm
+	plugins.DefaultWasm = plugins.NewWasmRuntime(wasm.ModuleDir, wasm.MemoryLimitMB, wasm.Timeout)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing := startTracing()
 
 	metrics.Init()
 	go func() {
@@ -36,17 +57,86 @@ func init() {
 		}
 	}()
 
-	// redis.Init(configs.GlobalConfig.RedisAddr)
+	if configs.GlobalConfig.EnableRateLimiter || configs.GlobalConfig.TokenDenylistStore == "redis" ||
+		configs.GlobalConfig.LoginLockout.Store == "redis" {
+		redis.Init(configs.GlobalConfig.RedisAddr)
+	}
+	loadSecretKeys()
 	// rabbitMQClient := rabbitmq.NewClient(cfg.RabbitMQURI)
 	mongodb.Init()
+	setupDefaultOrganization()
+	encryptSecrets()
+	startPluginHealthChecks()
 
 	// milvus.NewClient(configs.GlobalConfig.MilvusURI)
 
-	startServer()
+	startServer(ctx)
+	// The servers are shut down by now, so the spans of the last requests are flushed too.
+	shutdownTracing()
 
 	logger.GetLogger().Info("Successfully connected to all services")
 }
 
-func startServer() {
-	server.StartServer()
+// startTracing installs the exporter of traces, and returns the function flushing the spans left when Guardian stops.
+func startTracing() func() {
+	cfg := configs.GlobalConfig.Tracing
+	shutdown, err := tracing.Init(context.Background(), cfg.Exporter, cfg.OTLPEndpoint, cfg.SampleRatio)
+	if err != nil {
+		logger.GetLogger().Fatalf("Failed to set up tracing: %s", err)
+	}
+	return func() {
+		err := shutdown(context.Background())
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to flush the traces: %s", err)
+		}
+	}
+}
+
+// setupDefaultOrganization creates the default organization and moves data created before multi-tenancy into it.
+func setupDefaultOrganization() {
+	ctx := context.Background()
+	organization, err := setup.InitializeOrganizationService(mongodb.Database).EnsureDefaultOrganization(ctx)
+	if err != nil {
+		logger.GetLogger().Fatalf("Failed to set up the default organization: %s", err)
+	}
+
+	err = repository.AdoptOrphans(ctx, mongodb.Database, organization.ID)
+	if err != nil {
+		logger.GetLogger().Fatalf("Failed to assign existing data to the default organization: %s", err)
+	}
+}
+
+// loadSecretKeys sets up the keys that encrypt tokens at rest. Deployments that haven't configured SECRET_KEYS and
+// SECRET_ACTIVE_KEY yet keep storing tokens in plaintext.
+func loadSecretKeys() {
+	cfg := configs.GlobalConfig.Secrets
+	if len(cfg.Keys) == 0 && cfg.ActiveKey == "" {
+		logger.GetLogger().Warn("SECRET_KEYS is not set, plugin and target model tokens are stored unencrypted")
+		return
+	}
+	err := secrets.Init(cfg.Keys, cfg.ActiveKey)
+	if err != nil {
+		logger.GetLogger().Fatalf("Failed to load the secret keys: %s", err)
+	}
+}
+
+// encryptSecrets encrypts the tokens stored in plaintext and rewraps the ones encrypted with a retired key.
+func encryptSecrets() {
+	err := repository.EncryptSecrets(context.Background(), mongodb.Database)
+	if err != nil {
+		logger.GetLogger().Fatalf("Failed to encrypt the stored secrets: %s", err)
+	}
+}
+
+// startPluginHealthChecks probes the plugins in the background, unless the health checks are turned off.
+func startPluginHealthChecks() {
+	interval := configs.GlobalConfig.PluginHealth.Interval
+	if interval <= 0 {
+		return
+	}
+	go setup.InitializePluginHealthService(mongodb.Database).Run(context.Background(), interval)
+}
+
+func startServer(ctx context.Context) {
+	server.StartServer(ctx)
 }
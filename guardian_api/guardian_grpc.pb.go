@@ -0,0 +1,177 @@
+// guardian.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.12.4
+// source: protoc/guardian.proto
+
+package guardian_api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Guardian_CheckPrompt_FullMethodName = "/guardian.Guardian/CheckPrompt"
+	Guardian_Generate_FullMethodName    = "/guardian.Guardian/Generate"
+)
+
+// GuardianClient is the client API for Guardian service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Guardian serves the prompt pipeline to gRPC clients. Calls authenticate with the API keys and access tokens of the
+// HTTP API, sent in the authorization or x-api-key metadata.
+type GuardianClient interface {
+	// CheckPrompt runs the prompt through the user's tasks and returns the verdict, without forwarding the prompt.
+	CheckPrompt(ctx context.Context, in *CheckPromptRequest, opts ...grpc.CallOption) (*CheckPromptResponse, error)
+	// Generate checks the prompt and, if it is allowed, forwards it to the target model and streams the completion.
+	// The verdict is always the first response.
+	Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GenerateResponse], error)
+}
+
+type guardianClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGuardianClient(cc grpc.ClientConnInterface) GuardianClient {
+	return &guardianClient{cc}
+}
+
+func (c *guardianClient) CheckPrompt(ctx context.Context, in *CheckPromptRequest, opts ...grpc.CallOption) (*CheckPromptResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckPromptResponse)
+	err := c.cc.Invoke(ctx, Guardian_CheckPrompt_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *guardianClient) Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GenerateResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Guardian_ServiceDesc.Streams[0], Guardian_Generate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GenerateRequest, GenerateResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Guardian_GenerateClient = grpc.ServerStreamingClient[GenerateResponse]
+
+// GuardianServer is the server API for Guardian service.
+// All implementations must embed UnimplementedGuardianServer
+// for forward compatibility.
+//
+// Guardian serves the prompt pipeline to gRPC clients. Calls authenticate with the API keys and access tokens of the
+// HTTP API, sent in the authorization or x-api-key metadata.
+type GuardianServer interface {
+	// CheckPrompt runs the prompt through the user's tasks and returns the verdict, without forwarding the prompt.
+	CheckPrompt(context.Context, *CheckPromptRequest) (*CheckPromptResponse, error)
+	// Generate checks the prompt and, if it is allowed, forwards it to the target model and streams the completion.
+	// The verdict is always the first response.
+	Generate(*GenerateRequest, grpc.ServerStreamingServer[GenerateResponse]) error
+	mustEmbedUnimplementedGuardianServer()
+}
+
+// UnimplementedGuardianServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGuardianServer struct{}
+
+func (UnimplementedGuardianServer) CheckPrompt(context.Context, *CheckPromptRequest) (*CheckPromptResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPrompt not implemented")
+}
+func (UnimplementedGuardianServer) Generate(*GenerateRequest, grpc.ServerStreamingServer[GenerateResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Generate not implemented")
+}
+func (UnimplementedGuardianServer) mustEmbedUnimplementedGuardianServer() {}
+func (UnimplementedGuardianServer) testEmbeddedByValue()                  {}
+
+// UnsafeGuardianServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GuardianServer will
+// result in compilation errors.
+type UnsafeGuardianServer interface {
+	mustEmbedUnimplementedGuardianServer()
+}
+
+func RegisterGuardianServer(s grpc.ServiceRegistrar, srv GuardianServer) {
+	// If the following call pancis, it indicates UnimplementedGuardianServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Guardian_ServiceDesc, srv)
+}
+
+func _Guardian_CheckPrompt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckPromptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuardianServer).CheckPrompt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Guardian_CheckPrompt_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuardianServer).CheckPrompt(ctx, req.(*CheckPromptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Guardian_Generate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GenerateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GuardianServer).Generate(m, &grpc.GenericServerStream[GenerateRequest, GenerateResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Guardian_GenerateServer = grpc.ServerStreamingServer[GenerateResponse]
+
+// Guardian_ServiceDesc is the grpc.ServiceDesc for Guardian service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Guardian_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "guardian.Guardian",
+	HandlerType: (*GuardianServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CheckPrompt",
+			Handler:    _Guardian_CheckPrompt_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Generate",
+			Handler:       _Guardian_Generate_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "protoc/guardian.proto",
+}
@@ -0,0 +1,112 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"guardian/configs"
+	"guardian/utlis/logger"
+)
+
+const (
+	SenderSMTP = "smtp"
+	SenderFile = "file"
+	SenderLog  = "log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSender returns the sender selected by MAIL_SENDER. The file and log senders are meant for local development.
+func NewSender() Sender {
+	cfg := configs.GlobalConfig.Mail
+	switch cfg.Sender {
+	case SenderSMTP:
+		return NewSMTPSender(cfg)
+	case SenderFile:
+		return NewFileSender(cfg.Dir, cfg.From)
+	default:
+		return NewLogSender(cfg.From)
+	}
+}
+
+type SMTPSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPSender(cfg configs.MailConfig) *SMTPSender {
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return &SMTPSender{
+		addr: fmt.Sprintf("%s:%d", cfg.SMTPHost, cfg.SMTPPort),
+		from: cfg.From,
+		auth: auth,
+	}
+}
+
+func (s *SMTPSender) Send(_ context.Context, msg Message) error {
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, format(s.from, msg))
+}
+
+// FileSender writes every message as an .eml file into a directory.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func NewFileSender(dir, from string) *FileSender {
+	return &FileSender{dir: dir, from: from}
+}
+
+func (s *FileSender) Send(_ context.Context, msg Message) error {
+	err := os.MkdirAll(s.dir, 0o750)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(s.dir, name), format(s.from, msg), 0o600)
+}
+
+// LogSender prints messages to the log instead of delivering them. Messages are printed unredacted, so that the links
+// they carry can be followed.
+type LogSender struct {
+	from string
+}
+
+func NewLogSender(from string) *LogSender {
+	return &LogSender{from: from}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	logger.Unredacted(ctx).Infof("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return []byte(b.String())
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"guardian/utlis/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSender(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := NewFileSender(dir, "guardian@example.com")
+
+	err := sender.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hello", Body: "body"})
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	content, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "From: guardian@example.com\r\n")
+	assert.Contains(t, string(content), "To: jane@example.com\r\n")
+	assert.Contains(t, string(content), "Subject: Hello\r\n")
+	assert.Contains(t, string(content), "\r\n\r\nbody")
+}
+
+func TestLogSender(t *testing.T) {
+	var out bytes.Buffer
+	logger.GetLogger().SetOutput(&out)
+	require.NoError(t, logger.Configure(logger.FormatText, "info", false))
+	defer logger.InitLogger()
+
+	link := "http://localhost:8080/user/activate?token=abc123"
+	err := NewLogSender("guardian@example.com").Send(context.Background(),
+		Message{To: "jane@example.com", Subject: "Activate", Body: link})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), link)
+}
@@ -2,11 +2,25 @@ package metrics
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// The verdicts tasks and plugins are counted by.
+const (
+	VerdictAllowed = "allowed"
+	VerdictBlocked = "blocked"
+	VerdictError   = "error"
+)
+
+// unmatchedRoute labels the requests that matched no route, so that scanners don't create a series per path.
+const unmatchedRoute = "unmatched"
+
 var (
 	// Define a new counter metric
 	requestCounter = prometheus.NewCounterVec(
@@ -14,7 +28,7 @@ var (
 			Name: "http_requests_total",
 			Help: "Total number of HTTP requests",
 		},
-		[]string{"method", "handler"},
+		[]string{"method", "handler", "code"},
 	)
 
 	// Define a new histogram metric
@@ -26,12 +40,193 @@ var (
 		},
 		[]string{"method", "handler"},
 	)
+
+	pluginUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "guardian_plugin_up",
+			Help: "Whether the last health check of a plugin succeeded",
+		},
+		[]string{"plugin_id", "plugin"},
+	)
+
+	pluginHealthCheckDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "guardian_plugin_health_check_duration_seconds",
+			Help:    "Duration of plugin health checks in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"plugin_id", "plugin"},
+	)
+
+	taskDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "guardian_task_duration_seconds",
+			Help:    "Duration of running a task on a prompt in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"task"},
+	)
+
+	taskVerdicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "guardian_task_verdicts_total",
+			Help: "Total number of prompts tasks allowed, blocked or failed to judge",
+		},
+		[]string{"task", "verdict"},
+	)
+
+	pluginDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "guardian_plugin_duration_seconds",
+			Help:    "Duration of asking a plugin about a prompt in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"plugin_id", "plugin"},
+	)
+
+	pluginVerdicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "guardian_plugin_verdicts_total",
+			Help: "Total number of prompts plugins allowed, blocked or failed to judge",
+		},
+		[]string{"plugin_id", "plugin", "verdict"},
+	)
+
+	pluginErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "guardian_plugin_errors_total",
+			Help: "Total number of prompts plugins failed to judge, by the type of error",
+		},
+		[]string{"plugin_id", "plugin", "type"},
+	)
+
+	pipelineQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "guardian_pipeline_queue_depth",
+			Help: "Number of tasks waiting for a pipeline worker",
+		},
+	)
+
+	targetModelDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "guardian_target_model_duration_seconds",
+			Help:    "Duration of target model requests in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target_model_id", "target_model"},
+	)
+
+	targetModelResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "guardian_target_model_responses_total",
+			Help: "Total number of target model requests by the status code they were answered with",
+		},
+		[]string{"target_model_id", "target_model", "code"},
+	)
+
+	rateLimitRejections = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "guardian_rate_limit_rejections_total",
+			Help: "Total number of requests rejected by the rate limiter",
+		},
+	)
 )
 
 func Init() {
 	// Register the metrics with Prometheus
 	prometheus.MustRegister(requestCounter)
 	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(pluginUp)
+	prometheus.MustRegister(pluginHealthCheckDuration)
+	prometheus.MustRegister(taskDuration)
+	prometheus.MustRegister(taskVerdicts)
+	prometheus.MustRegister(pluginDuration)
+	prometheus.MustRegister(pluginVerdicts)
+	prometheus.MustRegister(pluginErrors)
+	prometheus.MustRegister(pipelineQueueDepth)
+	prometheus.MustRegister(targetModelDuration)
+	prometheus.MustRegister(targetModelResponses)
+	prometheus.MustRegister(rateLimitRejections)
+}
+
+// Middleware counts and times the requests by the route they matched, which keeps the path parameters out of the
+// labels. It must wrap the router for the route to be known once the request was served.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		handler := unmatchedRoute
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			handler = routeContext.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requestCounter.WithLabelValues(r.Method, handler, strconv.Itoa(status)).Inc()
+		requestDuration.WithLabelValues(r.Method, handler).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObservePluginHealth records the outcome of a plugin health check.
+func ObservePluginHealth(pluginID, plugin string, healthy bool, latency time.Duration) {
+	up := 0.0
+	if healthy {
+		up = 1
+	}
+	pluginUp.WithLabelValues(pluginID, plugin).Set(up)
+	pluginHealthCheckDuration.WithLabelValues(pluginID, plugin).Observe(latency.Seconds())
+}
+
+// ForgetPlugin drops the series of a plugin that is no longer checked.
+func ForgetPlugin(pluginID, plugin string) {
+	pluginUp.DeleteLabelValues(pluginID, plugin)
+	pluginHealthCheckDuration.DeleteLabelValues(pluginID, plugin)
+	pluginDuration.DeleteLabelValues(pluginID, plugin)
+	pluginVerdicts.DeletePartialMatch(prometheus.Labels{"plugin_id": pluginID})
+	pluginErrors.DeletePartialMatch(prometheus.Labels{"plugin_id": pluginID})
+}
+
+// ObserveTask records the verdict of a task on a prompt and how long the task took to reach it.
+func ObserveTask(task, verdict string, latency time.Duration) {
+	taskVerdicts.WithLabelValues(task, verdict).Inc()
+	taskDuration.WithLabelValues(task).Observe(latency.Seconds())
+}
+
+// ObservePlugin records the verdict of a plugin on a prompt and how long the plugin took to answer.
+func ObservePlugin(pluginID, plugin, verdict string, latency time.Duration) {
+	pluginVerdicts.WithLabelValues(pluginID, plugin, verdict).Inc()
+	pluginDuration.WithLabelValues(pluginID, plugin).Observe(latency.Seconds())
+}
+
+// ObservePluginError records a prompt the plugin failed to judge. Plugins that weren't asked, e.g. degraded ones,
+// count too.
+func ObservePluginError(pluginID, plugin, errorType string) {
+	pluginVerdicts.WithLabelValues(pluginID, plugin, VerdictError).Inc()
+	pluginErrors.WithLabelValues(pluginID, plugin, errorType).Inc()
+}
+
+// AddQueuedTasks changes the number of tasks waiting for a pipeline worker by delta.
+func AddQueuedTasks(delta int) {
+	pipelineQueueDepth.Add(float64(delta))
+}
+
+// ObserveTargetModel records the status code a target model answered with, 0 when it didn't answer, and how long it
+// took to answer.
+func ObserveTargetModel(targetModelID, targetModel string, status int, latency time.Duration) {
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	targetModelResponses.WithLabelValues(targetModelID, targetModel, code).Inc()
+	targetModelDuration.WithLabelValues(targetModelID, targetModel).Observe(latency.Seconds())
+}
+
+// CountRateLimitRejection records a request the rate limiter rejected.
+func CountRateLimitRejection() {
+	rateLimitRejections.Inc()
 }
 
 // Handler for exposing the metrics
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/plugins/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Post("/send", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/plugins/1", "/plugins/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/send", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/.env", nil))
+
+	assert.InDelta(t, 2, testutil.ToFloat64(requestCounter.WithLabelValues(http.MethodGet, "/plugins/{id}", "404")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(requestCounter.WithLabelValues(http.MethodPost, "/send", "200")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(requestCounter.WithLabelValues(http.MethodGet, unmatchedRoute, "404")), 0)
+	assert.Equal(t, 3, testutil.CollectAndCount(requestDuration))
+}
+
+func TestForgetPlugin(t *testing.T) {
+	t.Parallel()
+
+	ObservePlugin("p1", "toxicity", VerdictAllowed, time.Millisecond)
+	ObservePluginError("p1", "toxicity", "timeout")
+	ObservePlugin("p2", "pii", VerdictBlocked, time.Millisecond)
+
+	ForgetPlugin("p1", "toxicity")
+
+	assert.Equal(t, 1, testutil.CollectAndCount(pluginVerdicts))
+	assert.Equal(t, 0, testutil.CollectAndCount(pluginErrors))
+	assert.Equal(t, 1, testutil.CollectAndCount(pluginDuration))
+}
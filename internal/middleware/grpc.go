@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCAuth runs gRPC calls through the HTTP middleware, so that they are authenticated and rate limited like HTTP
+// requests. The metadata of a call becomes the headers of a request, and the call goes on with the context the
+// middleware left the request with. Calls to the public services, e.g. health checks, skip the middleware.
+type GRPCAuth struct {
+	chain  func(http.Handler) http.Handler
+	public []string
+}
+
+func NewGRPCAuth(publicServices []string, middlewares ...func(http.Handler) http.Handler) *GRPCAuth {
+	return &GRPCAuth{
+		chain: func(next http.Handler) http.Handler {
+			for i := len(middlewares) - 1; i >= 0; i-- {
+				next = middlewares[i](next)
+			}
+			return next
+		},
+		public: publicServices,
+	}
+}
+
+func (a *GRPCAuth) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *GRPCAuth) Stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := a.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: stream, ctx: ctx})
+}
+
+func (a *GRPCAuth) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	for _, service := range a.public {
+		if strings.HasPrefix(fullMethod, "/"+service+"/") {
+			return ctx, nil
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fullMethod, http.NoBody)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+
+	var authorized context.Context
+	w := &statusRecorder{header: make(http.Header), status: http.StatusOK}
+	a.chain(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		authorized = r.Context()
+	})).ServeHTTP(w, r)
+	if authorized == nil {
+		return nil, status.Error(grpcCode(w.status), http.StatusText(w.status))
+	}
+	return authorized, nil
+}
+
+// RequireMethodScopes only lets API keys holding one of the scopes of the gRPC method called through. GRPCAuth turns
+// calls into requests to the full name of their method, which scopes is keyed by. API keys can't call the methods
+// missing from scopes.
+func RequireMethodScopes(scopes map[string][]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			RequireScope(scopes[r.URL.Path]...)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// statusRecorder keeps the status the middleware rejected a request with.
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (w *statusRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"guardian/internal/mocks"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCAuth(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	rawKey := "gdn_0123456789ab_secret"
+	key := &entities.APIKey{UserID: userID, Scopes: []string{entities.ScopeSend},
+		Tenant: entities.Tenant{OrganizationID: primitive.NewObjectID()}}
+	throttle := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Throttle") != "" {
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		md           metadata.MD
+		expectedCode codes.Code
+	}{
+		{name: "authenticated", method: "/guardian.Guardian/CheckPrompt",
+			md: metadata.Pairs("x-api-key", rawKey), expectedCode: codes.OK},
+		{name: "bearer token", method: "/guardian.Guardian/CheckPrompt",
+			md: metadata.Pairs("authorization", "Bearer "+rawKey), expectedCode: codes.OK},
+		{name: "unauthenticated", method: "/guardian.Guardian/CheckPrompt",
+			md: metadata.MD{}, expectedCode: codes.Unauthenticated},
+		{name: "rate limited", method: "/guardian.Guardian/CheckPrompt",
+			md: metadata.Pairs("x-api-key", rawKey, "x-throttle", "1"), expectedCode: codes.ResourceExhausted},
+		{name: "public services", method: "/grpc.health.v1.Health/Check",
+			md: metadata.MD{}, expectedCode: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			apiKeyService := new(mocks.MockAPIKeyService)
+			apiKeyService.On("Authenticate", rawKey).Return(key, nil)
+			m := NewMiddleware(new(mocks.MockUserService), apiKeyService, new(mocks.MockTokenService))
+			auth := NewGRPCAuth([]string{"grpc.health.v1.Health"}, m.Authenticate, throttle,
+				RequireScope(entities.ScopeSend))
+
+			var got primitive.ObjectID
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			_, err := auth.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, _ interface{}) (interface{}, error) {
+					got, _ = UserIDFromContext(ctx)
+					return nil, nil
+				})
+
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+			if tt.expectedCode == codes.OK && tt.md.Len() > 0 {
+				require.Equal(t, userID, got)
+			}
+		})
+	}
+}
+
+func TestGRPCAuth_MethodScopes(t *testing.T) {
+	t.Parallel()
+
+	rawKey := "gdn_0123456789ab_secret"
+	checkOnly := &entities.APIKey{UserID: primitive.NewObjectID(), Scopes: []string{entities.ScopeCheck},
+		Tenant: entities.Tenant{OrganizationID: primitive.NewObjectID()}}
+	apiKeyService := new(mocks.MockAPIKeyService)
+	apiKeyService.On("Authenticate", rawKey).Return(checkOnly, nil)
+	m := NewMiddleware(new(mocks.MockUserService), apiKeyService, new(mocks.MockTokenService))
+	auth := NewGRPCAuth(nil, m.Authenticate, RequireMethodScopes(map[string][]string{
+		"/guardian.Guardian/CheckPrompt": {entities.ScopeCheck, entities.ScopeSend},
+		"/guardian.Guardian/Generate":    {entities.ScopeSend},
+	}))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", rawKey))
+
+	t.Run("check-only keys check prompts", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := auth.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/guardian.Guardian/CheckPrompt"},
+			func(context.Context, interface{}) (interface{}, error) {
+				return nil, nil
+			})
+		assert.NoError(t, err)
+	})
+
+	t.Run("check-only keys can't generate", func(t *testing.T) {
+		t.Parallel()
+
+		called := false
+		err := auth.Stream(nil, &authorizedStream{ctx: ctx}, &grpc.StreamServerInfo{
+			FullMethod: "/guardian.Guardian/Generate",
+		}, func(interface{}, grpc.ServerStream) error {
+			called = true
+			return nil
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.False(t, called)
+	})
+
+	t.Run("unknown methods are closed to API keys", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := auth.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/guardian.Guardian/Unknown"},
+			func(context.Context, interface{}) (interface{}, error) {
+				return nil, nil
+			})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
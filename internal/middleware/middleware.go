@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,98 +9,411 @@ import (
 	"time"
 
 	"guardian/configs"
+	"guardian/internal/models"
 	"guardian/internal/models/entities"
+	"guardian/internal/services"
+	"guardian/internal/tenant"
 	"guardian/utlis/logger"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	ErrJWKSNotConfigured = errors.New("JWKS is not configured")
+	ErrMissingUserID     = errors.New("user id is missing from the request context")
+	ErrMissingOrgID      = errors.New("organization id is missing from the token")
+)
+
+type contextKey string
+
+const (
+	userIDKey contextKey = "user_id"
+	roleKey   contextKey = "role"
+	apiKeyKey contextKey = "api_key"
+
+	apiKeyHeader       = "X-API-Key"
+	organizationHeader = "X-Organization-ID"
+)
+
 type Interface interface {
 	GetUserFromContext(r *http.Request) (*primitive.ObjectID, error)
 }
 
 type Middleware struct {
+	userService   services.UserServiceInterface
+	apiKeyService services.APIKeyServiceInterface
+	tokenService  services.TokenServiceInterface
+	userStatus    *userStatusCache
+}
+
+func NewMiddleware(userService services.UserServiceInterface, apiKeyService services.APIKeyServiceInterface,
+	tokenService services.TokenServiceInterface,
+) *Middleware {
+	return &Middleware{
+		userService:   userService,
+		apiKeyService: apiKeyService,
+		tokenService:  tokenService,
+		userStatus:    newUserStatusCache(configs.GlobalConfig.UserStatusCacheTTL),
+	}
+}
+
+// WithUserID stores the authenticated user's ID in the context.
+func WithUserID(ctx context.Context, userID primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// WithRole stores the role the request acts with in the context.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// RoleFromContext returns the role the request acts with. Guardian tokens issued before roles existed act as users.
+func RoleFromContext(ctx context.Context) string {
+	if role, ok := ctx.Value(roleKey).(string); ok {
+		return role
+	}
+
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		return ""
+	}
+	if role, ok := claims["role"].(string); ok && role != "" {
+		return role
+	}
+	return entities.RoleUser
+}
 
+// APIKeyFromContext returns the API key the request was authenticated with, or nil for token-based requests.
+func APIKeyFromContext(ctx context.Context) *entities.APIKey {
+	key, _ := ctx.Value(apiKeyKey).(*entities.APIKey)
+	return key
 }
 
-func NewMiddleware() *Middleware{
-	return &Middleware{}
+// TokenIDFromContext returns the ID and expiry of the Guardian access token the request was authenticated with.
+func TokenIDFromContext(ctx context.Context) (string, time.Time, bool) {
+	token, _, err := jwtauth.FromContext(ctx)
+	if err != nil || token == nil || token.JwtID() == "" {
+		return "", time.Time{}, false
+	}
+	return token.JwtID(), token.Expiration(), true
+}
+
+// UserIDFromContext returns the authenticated user's ID regardless of how the request was authenticated.
+func UserIDFromContext(ctx context.Context) (primitive.ObjectID, error) {
+	if userID, ok := ctx.Value(userIDKey).(primitive.ObjectID); ok {
+		return userID, nil
+	}
+
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("could not retrieve user claims: %w", err)
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return primitive.NilObjectID, ErrMissingUserID
+	}
+	return primitive.ObjectIDFromHex(userIDStr)
+}
+
+// organizationFromClaims reads the organization a Guardian access token was issued for. Requests are never served
+// without one, so tokens issued before organizations existed have to be refreshed.
+func organizationFromClaims(ctx context.Context) (primitive.ObjectID, error) {
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	organizationIDStr, _ := claims["org_id"].(string)
+	organizationID, err := primitive.ObjectIDFromHex(organizationIDStr)
+	if err != nil || organizationID.IsZero() {
+		return primitive.NilObjectID, ErrMissingOrgID
+	}
+	return organizationID, nil
 }
 
-func VerifyJWT(protected http.Handler) http.Handler {
+// Authenticate accepts Guardian API keys, external IdP tokens (when enabled) and Guardian's own JWTs.
+func (m *Middleware) Authenticate(protected http.Handler) http.Handler {
+	verifyJWT := m.VerifyJWT(protected)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawKey, ok := extractAPIKey(r)
+		if !ok {
+			verifyJWT.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := m.apiKeyService.Authenticate(r.Context(), rawKey)
+		if err != nil {
+			logger.GetLogger().Infof("api key rejected: %v", err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if key.OrganizationID.IsZero() {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		// API keys are non-interactive credentials and act with the service role whoever issued them.
+		ctx := tenant.WithOrganization(r.Context(), key.OrganizationID)
+		ctx = WithRole(WithUserID(ctx, key.UserID), entities.RoleService)
+		protected.ServeHTTP(w, r.WithContext(context.WithValue(ctx, apiKeyKey, key)))
+	})
+}
+
+// RequireScope only lets API keys holding one of the scopes through. Token-based requests act with the full rights of
+// their user and are not restricted.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := APIKeyFromContext(r.Context())
+			if key == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, scope := range scopes {
+				if key.HasScope(scope) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+}
+
+// RequireRole only lets requests acting with one of the roles through.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := RoleFromContext(r.Context())
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+}
+
+// ActAsOrganization lets platform admins administer another organization by naming it in the X-Organization-ID
+// header. Everyone else is bound to the organization of their credentials.
+func ActAsOrganization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(organizationHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if RoleFromContext(r.Context()) != entities.RolePlatformAdmin {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		organizationID, err := primitive.ObjectIDFromHex(header)
+		if err != nil {
+			http.Error(w, "Invalid "+organizationHeader, http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.WithOrganization(r.Context(), organizationID)))
+	})
+}
+
+// RejectAPIKeys restricts routes to interactive, token-based sessions, e.g. account management.
+func RejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if APIKeyFromContext(r.Context()) != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireActiveUser rejects requests of users that have not been activated yet or have been deleted, which covers
+// access tokens and API keys issued before the status changed. Statuses are cached for USER_STATUS_CACHE_TTL, and the
+// requests let through log with the ID of their user.
+func (m *Middleware) RequireActiveUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := UserIDFromContext(r.Context())
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		status, ok := m.userStatus.get(userID)
+		if !ok {
+			user, err := m.userService.GetUser(r.Context(), userID)
+			if err != nil {
+				logger.GetLogger().Infof("user %s rejected: %v", userID.Hex(), err)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			status = user.Status
+			m.userStatus.set(userID, status)
+		}
+		if status != entities.UserStatusActive {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		ctx := logger.WithFields(r.Context(), logrus.Fields{"user_id": userID.Hex()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (m *Middleware) VerifyJWT(protected http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if configs.GlobalConfig.EnableExternalAuth {
-			err := VerifyExternalJWT(r)
+			user, err := m.VerifyExternalJWT(r)
+			if err == nil && user.OrganizationID.IsZero() {
+				err = ErrMissingOrgID
+			}
 			if err == nil {
-				protected.ServeHTTP(w, r)
+				ctx := tenant.WithOrganization(r.Context(), user.OrganizationID)
+				ctx = WithRole(WithUserID(ctx, user.ID), user.GetRole())
+				protected.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
+			logger.GetLogger().Debugf("external token rejected: %v", err)
 		}
 
 		jwtVerifier := jwtauth.Verifier(configs.GlobalConfig.TokenAuth)
 		jwtAuthenticator := jwtauth.Authenticator(configs.GlobalConfig.TokenAuth)
 
-		jwtVerifier(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			jwtAuthenticator(protected).ServeHTTP(w, r)
-		})).ServeHTTP(w, r)
+		jwtVerifier(jwtAuthenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := UserIDFromContext(r.Context())
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			organizationID, err := organizationFromClaims(r.Context())
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if jti, _, ok := TokenIDFromContext(r.Context()); ok {
+				revoked, err := m.tokenService.IsAccessTokenRevoked(r.Context(), jti)
+				if err != nil {
+					logger.GetLogger().Errorf("error in checking token revocation: %v", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+					return
+				}
+			}
+
+			ctx := tenant.WithOrganization(r.Context(), organizationID)
+			protected.ServeHTTP(w, r.WithContext(WithUserID(ctx, userID)))
+		}))).ServeHTTP(w, r)
 	})
 }
 
-func VerifyExternalJWT(r *http.Request) error {
+// VerifyExternalJWT validates a token issued by the configured IdP and returns the Guardian user it maps to,
+// provisioning that user on first login.
+func (m *Middleware) VerifyExternalJWT(r *http.Request) (*entities.User, error) {
+	if configs.GlobalConfig.Jwk == nil {
+		return nil, ErrJWKSNotConfigured
+	}
+
 	tokenStr, err := extractToken(r)
 	if err != nil {
-		return fmt.Errorf("JWT token not provided: %v", err)
+		return nil, fmt.Errorf("JWT token not provided: %w", err)
 	}
 
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return configs.GlobalConfig.Jwk.Keyfunc(token)
-	})
+	identity, err := ParseExternalToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := m.userService.ProvisionExternalUser(r.Context(), identity)
+	if err != nil {
+		return nil, fmt.Errorf("unable to register the user: %w", err)
+	}
+
+	return user, nil
+}
 
+// ParseExternalToken validates a token issued by the configured IdP and returns the identity it carries.
+func ParseExternalToken(tokenStr string) (models.ExternalIdentity, error) {
+	if configs.GlobalConfig.Jwk == nil {
+		return models.ExternalIdentity{}, ErrJWKSNotConfigured
+	}
+
+	token, err := jwt.Parse(tokenStr, configs.GlobalConfig.Jwk.Keyfunc)
 	if err != nil || !token.Valid {
-		return fmt.Errorf("invalid token: %v", err)
+		return models.ExternalIdentity{}, fmt.Errorf("invalid token: %w", err)
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !validateClaims(claims) {
-		return fmt.Errorf("invalid claims")
+		return models.ExternalIdentity{}, errors.New("invalid claims")
 	}
 
-	userIDStr := claims["user_id"].(string)
-	_, err = checkUserInDB(userIDStr)
-	if err != nil {
-		_, err = createUserInDB(userIDStr)
-		if err != nil {
-			return fmt.Errorf("unable to register the user: %v", err)
-		}
-	}
-
-	return nil
+	return identityFromClaims(claims, configs.GlobalConfig.ExternalClaims)
 }
 
 func validateClaims(claims jwt.MapClaims) bool {
-	if claims["iss"] != configs.GlobalConfig.ExternalJwtIssuer {
+	if !claims.VerifyIssuer(configs.GlobalConfig.ExternalJwtIssuer, true) {
 		return false
 	}
 
-	if claims["aud"] != configs.GlobalConfig.ExternalJwtAudience {
-		return false
+	return claims.VerifyAudience(configs.GlobalConfig.ExternalJwtAudience, true)
+}
+
+// identityFromClaims maps the configured claims of an external token to an ExternalIdentity.
+func identityFromClaims(claims jwt.MapClaims, mapping configs.ExternalClaims) (models.ExternalIdentity, error) {
+	subject, _ := claims[mapping.Subject].(string)
+	if subject == "" {
+		return models.ExternalIdentity{}, fmt.Errorf("claim %q is missing", mapping.Subject)
 	}
 
-	if claims["exp"] != nil && claims["exp"].(float64) < float64(time.Now().Unix()) {
-		return false
+	identity := models.ExternalIdentity{Subject: subject}
+	identity.Email, _ = claims[mapping.Email].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims[mapping.Name].(string)
+	if mapping.Organization != "" {
+		identity.Organization, _ = claims[mapping.Organization].(string)
+	}
+
+	if mapping.Groups == "" {
+		return identity, nil
+	}
+	switch groups := claims[mapping.Groups].(type) {
+	case []interface{}:
+		identity.Groups = make([]string, 0, len(groups))
+		for _, group := range groups {
+			if name, ok := group.(string); ok && name != "" {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(strings.ReplaceAll(groups, ",", " "))
 	}
 
-	return true
+	return identity, nil
 }
 
-func extractToken(r *http.Request) (string, error) {
-	adminAuthHeader := r.Header.Get("X-Guardian-Authorization")
-	if adminAuthHeader != "" {
-		return adminAuthHeader, nil
+// extractAPIKey reads an API key from the X-API-Key header or from a bearer Authorization header.
+func extractAPIKey(r *http.Request) (string, bool) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key, true
 	}
 
+	token, err := extractToken(r)
+	if err != nil || !services.IsAPIKey(token) {
+		return "", false
+	}
+	return token, true
+}
+
+func extractToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return "", errors.New("authorization header missing")
@@ -113,32 +427,10 @@ func extractToken(r *http.Request) (string, error) {
 	return parts[1], nil
 }
 
-func checkUserInDB(userID string) (*entities.User, error) {
-	return nil, nil
-}
-
-func createUserInDB(userID string) (*entities.User, error) {
-	return nil, nil
-}
-
-func ParseRequestJWT(r *http.Request) (jwt.MapClaims, error) {
-	_, claims, err := jwtauth.FromContext(r.Context())
-	if err != nil {
-		return nil, fmt.Errorf("could not retrieve user claims: %v", err)
-	}
-	return claims, nil
-}
-
 func (m *Middleware) GetUserFromContext(r *http.Request) (*primitive.ObjectID, error) {
-	claims, err := ParseRequestJWT(r)
-	if err != nil {
-		return nil, err
-	}
-
-	userIDStr := claims["user_id"].(string)
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	userID, err := UserIDFromContext(r.Context())
 	if err != nil {
-		logger.GetLogger().Errorf("error in reading the userID from the user's token: %s", userIDStr)
+		logger.GetLogger().Errorf("error in reading the userID from the request: %v", err)
 		return nil, err
 	}
 
@@ -0,0 +1,559 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/services"
+	"guardian/internal/tenant"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testKID = "test-kid"
+
+var ErrProvision = errors.New("provision error")
+
+func setupExternalAuth(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	configs.GlobalConfig = configs.Config{
+		TokenAuth:           jwtauth.New("HS256", []byte("secret"), nil),
+		EnableExternalAuth:  true,
+		ExternalJwtIssuer:   "https://idp.example.com",
+		ExternalJwtAudience: "guardian",
+		ExternalClaims: configs.ExternalClaims{
+			Subject: "sub",
+			Email:   "email",
+			Name:    "name",
+			Groups:  "groups",
+		},
+		Jwk: keyfunc.NewGiven(map[string]keyfunc.GivenKey{
+			testKID: keyfunc.NewGivenRSA(&key.PublicKey),
+		}),
+	}
+	return key
+}
+
+func signExternalToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKID
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestVerifyJWT_ExternalToken(t *testing.T) {
+	key := setupExternalAuth(t)
+	userID := primitive.NewObjectID()
+	organizationID := primitive.NewObjectID()
+	validClaims := jwt.MapClaims{
+		"iss":            "https://idp.example.com",
+		"aud":            []string{"guardian", "other"},
+		"sub":            "idp|123",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane",
+		"groups":         []string{"ml", "security"},
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+
+	t.Run("provisions the user and exposes its ID", func(t *testing.T) {
+		userService := new(mocks.MockUserService)
+		userService.On("ProvisionExternalUser", mock.Anything, models.ExternalIdentity{
+			Subject:       "idp|123",
+			Email:         "jane@example.com",
+			EmailVerified: true,
+			Name:          "Jane",
+			Groups:        []string{"ml", "security"},
+		}).Return(&entities.User{ID: userID, Tenant: entities.Tenant{OrganizationID: organizationID}}, nil)
+		m := NewMiddleware(userService, new(mocks.MockAPIKeyService), new(mocks.MockTokenService))
+
+		var got *primitive.ObjectID
+		var gotOrganization primitive.ObjectID
+		handler := m.VerifyJWT(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			got, _ = m.GetUserFromContext(r)
+			gotOrganization, _ = tenant.FromContext(r.Context())
+		}))
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/send", nil)
+		req.Header.Set("Authorization", "Bearer "+signExternalToken(t, key, validClaims))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, got)
+		assert.Equal(t, userID, *got)
+		assert.Equal(t, organizationID, gotOrganization)
+	})
+
+	t.Run("rejects a token for another audience", func(t *testing.T) {
+		userService := new(mocks.MockUserService)
+		m := NewMiddleware(userService, new(mocks.MockAPIKeyService), new(mocks.MockTokenService))
+		claims := jwt.MapClaims{}
+		for k, v := range validClaims {
+			claims[k] = v
+		}
+		claims["aud"] = "someone-else"
+
+		handler := m.VerifyJWT(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			t.Fatal("protected handler must not be reached")
+		}))
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/send", nil)
+		req.Header.Set("Authorization", "Bearer "+signExternalToken(t, key, claims))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		userService.AssertNotCalled(t, "ProvisionExternalUser", mock.Anything, mock.Anything)
+	})
+
+	t.Run("rejects when provisioning fails", func(t *testing.T) {
+		userService := new(mocks.MockUserService)
+		userService.On("ProvisionExternalUser", mock.Anything, mock.Anything).Return(nil, ErrProvision)
+		m := NewMiddleware(userService, new(mocks.MockAPIKeyService), new(mocks.MockTokenService))
+
+		handler := m.VerifyJWT(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			t.Fatal("protected handler must not be reached")
+		}))
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/send", nil)
+		req.Header.Set("Authorization", "Bearer "+signExternalToken(t, key, validClaims))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestIdentityFromClaims(t *testing.T) {
+	t.Parallel()
+
+	mapping := configs.ExternalClaims{Subject: "oid", Email: "upn", Name: "name", Groups: "roles"}
+
+	t.Run("missing subject", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := identityFromClaims(jwt.MapClaims{"upn": "a@b.c"}, mapping)
+		require.Error(t, err)
+	})
+
+	t.Run("custom claim names and string groups", func(t *testing.T) {
+		t.Parallel()
+
+		identity, err := identityFromClaims(jwt.MapClaims{
+			"oid":   "42",
+			"upn":   "a@b.c",
+			"roles": "admins, readers",
+		}, mapping)
+		require.NoError(t, err)
+		assert.Equal(t, models.ExternalIdentity{
+			Subject: "42",
+			Email:   "a@b.c",
+			Groups:  []string{"admins", "readers"},
+		}, identity)
+	})
+
+	t.Run("absent groups claim leaves groups untouched", func(t *testing.T) {
+		t.Parallel()
+
+		identity, err := identityFromClaims(jwt.MapClaims{"oid": "42"}, mapping)
+		require.NoError(t, err)
+		assert.Nil(t, identity.Groups)
+	})
+}
+
+func TestAuthenticate_APIKey(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	tenantOf := entities.Tenant{OrganizationID: primitive.NewObjectID()}
+	rawKey := "gdn_0123456789ab_secret"
+
+	tests := []struct {
+		name         string
+		header       string
+		value        string
+		key          *entities.APIKey
+		err          error
+		scope        string
+		expectedCode int
+	}{
+		{
+			name:         "valid key in X-API-Key header",
+			header:       "X-API-Key",
+			value:        rawKey,
+			key:          &entities.APIKey{UserID: userID, Scopes: []string{entities.ScopeSend}, Tenant: tenantOf},
+			scope:        entities.ScopeSend,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "valid key as bearer token",
+			header:       "Authorization",
+			value:        "Bearer " + rawKey,
+			key:          &entities.APIKey{UserID: userID, Scopes: []string{entities.ScopeSend}, Tenant: tenantOf},
+			scope:        entities.ScopeSend,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "key without an organization",
+			header:       "X-API-Key",
+			value:        rawKey,
+			key:          &entities.APIKey{UserID: userID, Scopes: []string{entities.ScopeSend}},
+			scope:        entities.ScopeSend,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "key without the required scope",
+			header:       "X-API-Key",
+			value:        rawKey,
+			key:          &entities.APIKey{UserID: userID, Scopes: []string{entities.ScopeSend}, Tenant: tenantOf},
+			scope:        entities.ScopeManageAPIKeys,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "revoked key",
+			header:       "X-API-Key",
+			value:        rawKey,
+			err:          services.ErrAPIKeyRevoked,
+			scope:        entities.ScopeSend,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			apiKeyService := new(mocks.MockAPIKeyService)
+			apiKeyService.On("Authenticate", rawKey).Return(tt.key, tt.err)
+			m := NewMiddleware(new(mocks.MockUserService), apiKeyService, new(mocks.MockTokenService))
+
+			var got *primitive.ObjectID
+			handler := m.Authenticate(RequireScope(tt.scope)(http.HandlerFunc(
+				func(_ http.ResponseWriter, r *http.Request) {
+					got, _ = m.GetUserFromContext(r)
+				})))
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/send", nil)
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusOK {
+				require.NotNil(t, got)
+				assert.Equal(t, userID, *got)
+			}
+		})
+	}
+}
+
+func TestVerifyJWT_RevokedToken(t *testing.T) {
+	configs.GlobalConfig = configs.Config{TokenAuth: jwtauth.New("HS256", []byte("secret"), nil)}
+	userID := primitive.NewObjectID()
+	_, token, err := configs.GlobalConfig.TokenAuth.Encode(map[string]interface{}{
+		"user_id": userID.Hex(),
+		"org_id":  primitive.NewObjectID().Hex(),
+		"jti":     "token-id",
+		"exp":     time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		revoked      bool
+		expectedCode int
+	}{
+		{name: "active token", expectedCode: http.StatusOK},
+		{name: "revoked token", revoked: true, expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenService := new(mocks.MockTokenService)
+			tokenService.On("IsAccessTokenRevoked", "token-id").Return(tt.revoked, nil)
+			m := NewMiddleware(new(mocks.MockUserService), new(mocks.MockAPIKeyService), tokenService)
+
+			handler := m.VerifyJWT(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/send", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestRequireActiveUser(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	tests := []struct {
+		name         string
+		status       int
+		expectedCode int
+	}{
+		{name: "active user", status: entities.UserStatusActive, expectedCode: http.StatusOK},
+		{name: "inactive user", status: entities.UserStatusInactive, expectedCode: http.StatusForbidden},
+		{name: "deleted user", status: entities.UserStatusDeleted, expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			userService := new(mocks.MockUserService)
+			userService.On("GetUser", userID).Return(&entities.User{ID: userID, Status: tt.status}, nil)
+			m := NewMiddleware(userService, new(mocks.MockAPIKeyService), new(mocks.MockTokenService))
+
+			handler := m.RequireActiveUser(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+			req := httptest.NewRequestWithContext(WithUserID(context.Background(), userID), http.MethodPost, "/send",
+				nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestRequireActiveUser_CachesStatus(t *testing.T) {
+	t.Parallel()
+
+	userID := primitive.NewObjectID()
+	userService := new(mocks.MockUserService)
+	userService.On("GetUser", userID).Return(&entities.User{ID: userID, Status: entities.UserStatusActive}, nil).Once()
+	m := NewMiddleware(userService, new(mocks.MockAPIKeyService), new(mocks.MockTokenService))
+	m.userStatus = newUserStatusCache(time.Minute)
+
+	handler := m.RequireActiveUser(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	for range 3 {
+		req := httptest.NewRequestWithContext(WithUserID(context.Background(), userID), http.MethodPost, "/send", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	userService.AssertNumberOfCalls(t, "GetUser", 1)
+}
+
+func TestRequireRole(t *testing.T) {
+	configs.GlobalConfig = configs.Config{TokenAuth: jwtauth.New("HS256", []byte("secret"), nil)}
+	userID := primitive.NewObjectID()
+	organizationID := primitive.NewObjectID()
+	encode := func(claims map[string]interface{}) string {
+		claims["org_id"] = organizationID.Hex()
+		_, token, err := configs.GlobalConfig.TokenAuth.Encode(claims)
+		require.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name         string
+		header       string
+		value        string
+		expectedCode int
+	}{
+		{
+			name:         "admin token",
+			header:       "Authorization",
+			value:        "Bearer " + encode(map[string]interface{}{"user_id": userID.Hex(), "role": entities.RoleAdmin}),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "user token",
+			header:       "Authorization",
+			value:        "Bearer " + encode(map[string]interface{}{"user_id": userID.Hex(), "role": entities.RoleUser}),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "token without a role acts as user",
+			header:       "Authorization",
+			value:        "Bearer " + encode(map[string]interface{}{"user_id": userID.Hex()}),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "api keys act as service",
+			header:       "X-API-Key",
+			value:        "gdn_0123456789ab_secret",
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiKeyService := new(mocks.MockAPIKeyService)
+			apiKeyService.On("Authenticate", tt.value).Return(&entities.APIKey{
+				UserID: userID, Tenant: entities.Tenant{OrganizationID: organizationID},
+			}, nil)
+			m := NewMiddleware(new(mocks.MockUserService), apiKeyService, new(mocks.MockTokenService))
+
+			handler := m.Authenticate(RequireRole(entities.RoleAdmin)(http.HandlerFunc(
+				func(_ http.ResponseWriter, _ *http.Request) {})))
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/groups", nil)
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestVerifyJWT_Organization(t *testing.T) {
+	configs.GlobalConfig = configs.Config{TokenAuth: jwtauth.New("HS256", []byte("secret"), nil)}
+	userID := primitive.NewObjectID()
+	organizationID := primitive.NewObjectID()
+	encode := func(claims map[string]interface{}) string {
+		_, token, err := configs.GlobalConfig.TokenAuth.Encode(claims)
+		require.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name         string
+		token        string
+		expectedCode int
+	}{
+		{
+			name:         "scopes the request to the token's organization",
+			token:        encode(map[string]interface{}{"user_id": userID.Hex(), "org_id": organizationID.Hex()}),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "token without an organization",
+			token:        encode(map[string]interface{}{"user_id": userID.Hex()}),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "malformed organization",
+			token:        encode(map[string]interface{}{"user_id": userID.Hex(), "org_id": "acme"}),
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMiddleware(new(mocks.MockUserService), new(mocks.MockAPIKeyService), new(mocks.MockTokenService))
+
+			var got primitive.ObjectID
+			handler := m.VerifyJWT(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got, _ = tenant.FromContext(r.Context())
+			}))
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/groups", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, organizationID, got)
+			}
+		})
+	}
+}
+
+func TestActAsOrganization(t *testing.T) {
+	t.Parallel()
+
+	home := primitive.NewObjectID()
+	other := primitive.NewObjectID()
+	tests := []struct {
+		name         string
+		role         string
+		header       string
+		expected     primitive.ObjectID
+		expectedCode int
+	}{
+		{name: "no header keeps the own organization", role: entities.RoleAdmin, expected: home,
+			expectedCode: http.StatusOK},
+		{name: "platform admin switches organization", role: entities.RolePlatformAdmin, header: other.Hex(),
+			expected: other, expectedCode: http.StatusOK},
+		{name: "admin may not switch organization", role: entities.RoleAdmin, header: other.Hex(),
+			expectedCode: http.StatusForbidden},
+		{name: "malformed organization", role: entities.RolePlatformAdmin, header: "acme",
+			expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got primitive.ObjectID
+			handler := ActAsOrganization(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got, _ = tenant.FromContext(r.Context())
+			}))
+			ctx := WithRole(tenant.WithOrganization(context.Background(), home), tt.role)
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/admin/users", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Organization-ID", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestForwardedIP(t *testing.T) {
+	t.Parallel()
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{name: "untrusted peer", remoteAddr: "203.0.113.7:4000", forwarded: "198.51.100.1"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:4000", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "spoofed hops before the client", remoteAddr: "10.0.0.2:4000",
+			forwarded: "192.0.2.9, 198.51.100.1, 10.0.0.3", expected: "198.51.100.1"},
+		{name: "real ip header", remoteAddr: "10.0.0.2:4000", realIP: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "malformed hop", remoteAddr: "10.0.0.2:4000", forwarded: "not-an-ip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.expected, forwardedIP(req, []*net.IPNet{proxies}))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"guardian/configs"
+)
+
+// RealIP replaces RemoteAddr with the client address forwarded by one of the TRUSTED_PROXIES. The forwarding headers
+// of any other peer are ignored, as clients can set them to whatever they like.
+func RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := forwardedIP(r, configs.GlobalConfig.TrustedProxies); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedIP returns the client address of a request relayed by trusted proxies. X-Forwarded-For is read from the
+// right, as every proxy appends the address it received the request from, and the first untrusted address is the
+// client.
+func forwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(peer), trusted) {
+		return ""
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if !isTrustedProxy(ip, trusted) {
+				return ip.String()
+			}
+		}
+		return ""
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userStatusCache remembers the status of recently seen users, so that RequireActiveUser doesn't look the user up
+// on every request. A status change, e.g. a deletion, takes up to the TTL to apply.
+type userStatusCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[primitive.ObjectID]userStatusEntry
+	lastSweep time.Time
+}
+
+type userStatusEntry struct {
+	status    int
+	expiresAt time.Time
+}
+
+// newUserStatusCache returns a cache keeping statuses for ttl, or nil, which caches nothing, for a ttl of zero.
+func newUserStatusCache(ttl time.Duration) *userStatusCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &userStatusCache{
+		ttl:     ttl,
+		entries: make(map[primitive.ObjectID]userStatusEntry),
+	}
+}
+
+func (c *userStatusCache) get(userID primitive.ObjectID) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return 0, false
+	}
+	return entry.status, true
+}
+
+func (c *userStatusCache) set(userID primitive.ObjectID, status int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > c.ttl {
+		for id, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[userID] = userStatusEntry{status: status, expiresAt: now.Add(c.ttl)}
+}
@@ -0,0 +1,76 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockAPIKeyRepo struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyRepo) CreateAPIKey(_ context.Context, key entities.APIKey) (primitive.ObjectID, error) {
+	args := m.Called(key)
+	return args.Get(0).(primitive.ObjectID), args.Error(1)
+}
+
+func (m *MockAPIKeyRepo) GetAPIKeyByPrefix(_ context.Context, prefix string) (*entities.APIKey, error) {
+	args := m.Called(prefix)
+	if key, ok := args.Get(0).(*entities.APIKey); ok {
+		return key, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAPIKeyRepo) GetAPIKeysByUser(_ context.Context, userID primitive.ObjectID) ([]entities.APIKey, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]entities.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepo) RevokeAPIKey(_ context.Context, keyID, userID primitive.ObjectID, _ time.Time) (int64,
+	error,
+) {
+	args := m.Called(keyID, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockAPIKeyRepo) TouchAPIKey(_ context.Context, keyID primitive.ObjectID, _ time.Time) error {
+	return m.Called(keyID).Error(0)
+}
+
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) CreateAPIKey(_ context.Context, userID primitive.ObjectID, caller *entities.APIKey,
+	req models.CreateAPIKeyRequest,
+) (*models.CreateAPIKeyResponse, error) {
+	args := m.Called(userID, caller, req)
+	if resp, ok := args.Get(0).(*models.CreateAPIKeyResponse); ok {
+		return resp, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetAPIKeys(_ context.Context, userID primitive.ObjectID) ([]entities.APIKey, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]entities.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RevokeAPIKey(_ context.Context, userID, keyID primitive.ObjectID) error {
+	return m.Called(userID, keyID).Error(0)
+}
+
+func (m *MockAPIKeyService) Authenticate(_ context.Context, rawKey string) (*entities.APIKey, error) {
+	args := m.Called(rawKey)
+	if key, ok := args.Get(0).(*entities.APIKey); ok {
+		return key, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
@@ -0,0 +1,29 @@
+package mocks
+
+import (
+	"context"
+
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockChatHistoryRepo struct {
+	mock.Mock
+}
+
+func (m *MockChatHistoryRepo) AddTurn(_ context.Context, turn entities.ChatTurn) error {
+	args := m.Called(turn)
+	return args.Error(0)
+}
+
+func (m *MockChatHistoryRepo) GetLastTurns(_ context.Context, userID, chatID primitive.ObjectID, n int) (
+	[]entities.ChatTurn, error,
+) {
+	args := m.Called(userID, chatID, n)
+	if turns, ok := args.Get(0).([]entities.ChatTurn); ok {
+		return turns, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
@@ -0,0 +1,155 @@
+package mocks
+
+import (
+	"context"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockGroupRepo struct {
+	mock.Mock
+}
+
+func (m *MockGroupRepo) GetGroup(_ context.Context, groupID primitive.ObjectID) (*entities.Group, error) {
+	args := m.Called(groupID)
+	if group, ok := args.Get(0).(*entities.Group); ok {
+		return group, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockGroupRepo) GetGroupsByIDs(_ context.Context, groupIDs []primitive.ObjectID) ([]entities.Group, error) {
+	args := m.Called(groupIDs)
+	return args.Get(0).([]entities.Group), args.Error(1)
+}
+
+func (m *MockGroupRepo) GetGroupsByAdmin(_ context.Context, userID primitive.ObjectID) ([]entities.Group, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]entities.Group), args.Error(1)
+}
+
+func (m *MockGroupRepo) GetAllGroups(_ context.Context) ([]entities.Group, error) {
+	args := m.Called()
+	return args.Get(0).([]entities.Group), args.Error(1)
+}
+
+func (m *MockGroupRepo) CreateGroup(_ context.Context, group entities.Group) (entities.Group, error) {
+	args := m.Called(group)
+	return args.Get(0).(entities.Group), args.Error(1)
+}
+
+func (m *MockGroupRepo) SetGroupTasks(_ context.Context, groupID primitive.ObjectID,
+	taskIDs []primitive.ObjectID,
+) error {
+	return m.Called(groupID, taskIDs).Error(0)
+}
+
+func (m *MockGroupRepo) SetGroupTargetModels(_ context.Context, groupID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) error {
+	return m.Called(groupID, modelIDs).Error(0)
+}
+
+func (m *MockGroupRepo) SetGroupAdmins(_ context.Context, groupID primitive.ObjectID,
+	admins []primitive.ObjectID,
+) error {
+	return m.Called(groupID, admins).Error(0)
+}
+
+type MockUserRepo struct {
+	mock.Mock
+}
+
+func (m *MockUserRepo) GetUser(_ context.Context, userID primitive.ObjectID) (*entities.User, error) {
+	args := m.Called(userID)
+	if user, ok := args.Get(0).(*entities.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserRepo) SetUserRole(_ context.Context, userID primitive.ObjectID, role string) (int64, error) {
+	args := m.Called(userID, role)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepo) AddUserToGroup(_ context.Context, userID primitive.ObjectID,
+	group entities.Group,
+) (int64, error) {
+	args := m.Called(userID, group)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepo) RemoveUserFromGroup(_ context.Context, userID, groupID primitive.ObjectID) (int64, error) {
+	args := m.Called(userID, groupID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MockTaskRepo struct {
+	mock.Mock
+}
+
+func (m *MockTaskRepo) GetTasks(_ context.Context, taskIDs []primitive.ObjectID) ([]entities.Task, error) {
+	args := m.Called(taskIDs)
+	return args.Get(0).([]entities.Task), args.Error(1)
+}
+
+func (m *MockTaskRepo) GetTask(_ context.Context, taskID primitive.ObjectID) (entities.Task, error) {
+	args := m.Called(taskID)
+	return args.Get(0).(entities.Task), args.Error(1)
+}
+
+func (m *MockTaskRepo) SetTaskPlugins(_ context.Context, taskID primitive.ObjectID,
+	pluginIDs []primitive.ObjectID,
+) error {
+	return m.Called(taskID, pluginIDs).Error(0)
+}
+
+type MockGroupService struct {
+	mock.Mock
+}
+
+func (m *MockGroupService) CreateGroup(_ context.Context, req models.CreateGroupRequest) (*entities.Group, error) {
+	args := m.Called(req)
+	if group, ok := args.Get(0).(*entities.Group); ok {
+		return group, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockGroupService) GetGroups(_ context.Context, actor models.Actor) ([]entities.Group, error) {
+	args := m.Called(actor)
+	return args.Get(0).([]entities.Group), args.Error(1)
+}
+
+func (m *MockGroupService) AddMember(_ context.Context, actor models.Actor, groupID, userID primitive.ObjectID) error {
+	return m.Called(actor, groupID, userID).Error(0)
+}
+
+func (m *MockGroupService) RemoveMember(_ context.Context, actor models.Actor, groupID,
+	userID primitive.ObjectID,
+) error {
+	return m.Called(actor, groupID, userID).Error(0)
+}
+
+func (m *MockGroupService) SetTasks(_ context.Context, actor models.Actor, groupID primitive.ObjectID,
+	taskIDs []primitive.ObjectID,
+) error {
+	return m.Called(actor, groupID, taskIDs).Error(0)
+}
+
+func (m *MockGroupService) SetTargetModels(_ context.Context, actor models.Actor, groupID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) error {
+	return m.Called(actor, groupID, modelIDs).Error(0)
+}
+
+func (m *MockGroupService) SetAdmins(_ context.Context, groupID primitive.ObjectID,
+	admins []primitive.ObjectID,
+) error {
+	return m.Called(groupID, admins).Error(0)
+}
@@ -3,6 +3,7 @@ package mocks
 import (
 	"context"
 	"guardian/internal/models"
+	"guardian/internal/models/entities"
 	"net/http"
 
 	"github.com/stretchr/testify/mock"
@@ -17,7 +18,13 @@ func (m *MockClient) Do(req *http.Request) (*http.Response, error) {
 	return args.Get(0).(*http.Response), args.Error(1)
 }
 
-func (m *MockClient) Forward(ctx context.Context, req *models.PluginRequest) (*models.PluginResponse, error) {
-	args := m.Called(ctx, req)
+func (m *MockClient) Forward(ctx context.Context, plugin *entities.Plugin,
+	req *models.PluginRequest) (*models.PluginResponse, error) {
+	args := m.Called(ctx, plugin, req)
 	return args.Get(0).(*models.PluginResponse), args.Error(1)
-}
\ No newline at end of file
+}
+
+func (m *MockClient) Check(ctx context.Context, plugin *entities.Plugin) error {
+	args := m.Called(ctx, plugin)
+	return args.Error(0)
+}
@@ -0,0 +1,40 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockLoginAttemptStore struct {
+	mock.Mock
+}
+
+func (m *MockLoginAttemptStore) RegisterFailure(_ context.Context, key string, window time.Duration) (int64, error) {
+	args := m.Called(key, window)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockLoginAttemptStore) Lock(_ context.Context, key string, until time.Time) error {
+	return m.Called(key, until).Error(0)
+}
+
+func (m *MockLoginAttemptStore) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	args := m.Called(key)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockLoginAttemptStore) Reset(_ context.Context, key string) error {
+	return m.Called(key).Error(0)
+}
+
+type MockAuditRepo struct {
+	mock.Mock
+}
+
+func (m *MockAuditRepo) RecordEvent(_ context.Context, event entities.AuditEvent) error {
+	return m.Called(event).Error(0)
+}
@@ -0,0 +1,75 @@
+package mocks
+
+import (
+	"context"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockOrganizationRepo struct {
+	mock.Mock
+}
+
+func (m *MockOrganizationRepo) CreateOrganization(_ context.Context, organization entities.Organization) (
+	*entities.Organization, error,
+) {
+	args := m.Called(organization)
+	if created, ok := args.Get(0).(*entities.Organization); ok {
+		return created, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrganizationRepo) GetOrganization(_ context.Context, organizationID primitive.ObjectID) (
+	*entities.Organization, error,
+) {
+	args := m.Called(organizationID)
+	if organization, ok := args.Get(0).(*entities.Organization); ok {
+		return organization, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrganizationRepo) GetOrganizationBySlug(_ context.Context, slug string) (*entities.Organization, error) {
+	args := m.Called(slug)
+	if organization, ok := args.Get(0).(*entities.Organization); ok {
+		return organization, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrganizationRepo) GetOrganizations(_ context.Context) ([]entities.Organization, error) {
+	args := m.Called()
+	return args.Get(0).([]entities.Organization), args.Error(1)
+}
+
+type MockOrganizationService struct {
+	mock.Mock
+}
+
+func (m *MockOrganizationService) CreateOrganization(_ context.Context, req models.CreateOrganizationRequest) (
+	*entities.Organization, error,
+) {
+	args := m.Called(req)
+	if organization, ok := args.Get(0).(*entities.Organization); ok {
+		return organization, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockOrganizationService) GetOrganizations(_ context.Context) ([]entities.Organization, error) {
+	args := m.Called()
+	return args.Get(0).([]entities.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationService) EnsureDefaultOrganization(_ context.Context) (*entities.Organization, error) {
+	args := m.Called()
+	if organization, ok := args.Get(0).(*entities.Organization); ok {
+		return organization, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
@@ -0,0 +1,26 @@
+package mocks
+
+import (
+	"context"
+
+	"guardian/internal/models"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockPluginHealthService struct {
+	mock.Mock
+}
+
+func (m *MockPluginHealthService) CheckPlugins(_ context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockPluginHealthService) GetPluginHealth(_ context.Context) ([]models.PluginHealth, error) {
+	args := m.Called()
+	if health, ok := args.Get(0).([]models.PluginHealth); ok {
+		return health, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
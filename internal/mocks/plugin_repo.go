@@ -0,0 +1,69 @@
+package mocks
+
+import (
+	"context"
+
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockPluginRepo struct {
+	mock.Mock
+}
+
+func (m *MockPluginRepo) GetPluginsByTask(_ context.Context, task entities.Task) ([]entities.Plugin, error) {
+	args := m.Called(task)
+	if plugins, ok := args.Get(0).([]entities.Plugin); ok {
+		return plugins, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockPluginRepo) GetPlugins(_ context.Context, pluginIDs []primitive.ObjectID) ([]entities.Plugin, error) {
+	args := m.Called(pluginIDs)
+	if plugins, ok := args.Get(0).([]entities.Plugin); ok {
+		return plugins, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockPluginRepo) GetPlugin(_ context.Context, pluginID primitive.ObjectID) (entities.Plugin, error) {
+	args := m.Called(pluginID)
+	if plugin, ok := args.Get(0).(entities.Plugin); ok {
+		return plugin, args.Error(1)
+	}
+	return entities.Plugin{}, args.Error(1)
+}
+
+func (m *MockPluginRepo) GetActivePlugins(_ context.Context) ([]entities.Plugin, error) {
+	args := m.Called()
+	if plugins, ok := args.Get(0).([]entities.Plugin); ok {
+		return plugins, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockPluginRepo) GetPluginByName(_ context.Context, name string) (entities.Plugin, error) {
+	args := m.Called(name)
+	if plugin, ok := args.Get(0).(entities.Plugin); ok {
+		return plugin, args.Error(1)
+	}
+	return entities.Plugin{}, args.Error(1)
+}
+
+func (m *MockPluginRepo) CreatePlugin(_ context.Context, plugin entities.Plugin) (interface{}, error) {
+	args := m.Called(plugin)
+	return args.Get(0), args.Error(1)
+}
+
+func (m *MockPluginRepo) DeletePlugin(_ context.Context, pluginID primitive.ObjectID) (int64, error) {
+	args := m.Called(pluginID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockPluginRepo) UpdatePlugin(_ context.Context, plugin entities.Plugin) (int64, error) {
+	args := m.Called(plugin)
+	return args.Get(0).(int64), args.Error(1)
+}
@@ -2,9 +2,11 @@ package mocks
 
 import (
 	"context"
+	"guardian/internal/models"
 	"guardian/internal/models/entities"
 
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type MockPluginService struct {
@@ -18,3 +20,47 @@ func (m *MockPluginService) GetPluginsByTask(context context.Context, task entit
     }
     return []entities.Plugin{}, args.Error(1)
 }
+
+func (m *MockPluginService) IssueBootstrapToken(_ context.Context) (*models.BootstrapTokenResponse, error) {
+	args := m.Called()
+	if token, ok := args.Get(0).(*models.BootstrapTokenResponse); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockPluginService) RegisterPlugin(_ context.Context, bootstrapToken string,
+	req models.RegisterPluginRequest,
+) (*models.RegisterPluginResponse, error) {
+	args := m.Called(bootstrapToken, req)
+	if registration, ok := args.Get(0).(*models.RegisterPluginResponse); ok {
+		return registration, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockPluginService) SetTaskPlugins(_ context.Context, taskID primitive.ObjectID,
+	pluginIDs []primitive.ObjectID,
+) error {
+	return m.Called(taskID, pluginIDs).Error(0)
+}
+
+func (m *MockPluginService) CreateBuiltinPlugin(_ context.Context, req models.CreateBuiltinPluginRequest) (
+	*entities.Plugin, error,
+) {
+	args := m.Called(req)
+	if plugin, ok := args.Get(0).(*entities.Plugin); ok {
+		return plugin, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockPluginService) CreateWasmPlugin(_ context.Context, req models.CreateWasmPluginRequest) (
+	*entities.Plugin, error,
+) {
+	args := m.Called(req)
+	if plugin, ok := args.Get(0).(*entities.Plugin); ok {
+		return plugin, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"github.com/stretchr/testify/mock"
 	"guardian/internal/models"
-	"net/http"
+	"guardian/internal/plugins"
 )
 
 type MockPromptService struct {
@@ -12,12 +12,20 @@ type MockPromptService struct {
 }
 
 
-func (p *MockPromptService) ProcessPrompt(_ context.Context, _ *models.PluginRequest) (bool, error) {
+func (p *MockPromptService) ProcessPrompt(_ context.Context, _ *models.PluginRequest) (models.Verdict, error) {
 	args := p.Called()
-	return args.Bool(0), args.Error(1)
+	return args.Get(0).(models.Verdict), args.Error(1)
 }
 
-func (p *MockPromptService) SendPrompt(ctx context.Context, newReq *http.Request) (*http.Response, error) {
-	args := p.Called()
-	return args.Get(0).(*http.Response), args.Error(1)
-}
\ No newline at end of file
+func (p *MockPromptService) ModerateOutput(_ context.Context, reqBody *models.PluginRequest) (
+	*plugins.OutputModerator, error) {
+	args := p.Called(reqBody)
+	if moderator, ok := args.Get(0).(*plugins.OutputModerator); ok {
+		return moderator, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (p *MockPromptService) RecordReply(_ context.Context, _ *models.PluginRequest, content string) error {
+	args := p.Called(content)
+	return args.Error(0)
+}
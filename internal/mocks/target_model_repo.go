@@ -15,6 +15,9 @@ func (m *MockTargetModelRepo) GetModels(_ context.Context, modelIDs []primitive.
 	error,
 ) {
 	args := m.Called(modelIDs)
+	if targetModels, ok := args.Get(0).([]entities.TargetModel); ok {
+		return targetModels, args.Error(1)
+	}
 	return []entities.TargetModel{}, args.Error(1)
 }
 
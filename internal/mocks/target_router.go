@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"context"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockTargetRouter struct {
+	mock.Mock
+}
+
+func (m *MockTargetRouter) SelectTarget(_ context.Context, userID primitive.ObjectID, prompt string,
+	verdict models.Verdict,
+) (*entities.TargetModel, error) {
+	args := m.Called(userID, prompt, verdict)
+	if targetModel, ok := args.Get(0).(*entities.TargetModel); ok {
+		return targetModel, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockTargetRouter) CheckTarget(_ context.Context, userID primitive.ObjectID, modelID primitive.ObjectID) error {
+	args := m.Called(userID, modelID)
+	return args.Error(0)
+}
+
+func (m *MockTargetRouter) FilterAllowed(_ context.Context, userID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) ([]primitive.ObjectID, error) {
+	args := m.Called(userID, modelIDs)
+	allowed, _ := args.Get(0).([]primitive.ObjectID)
+	return allowed, args.Error(1)
+}
@@ -0,0 +1,106 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockTokenService struct {
+	mock.Mock
+}
+
+func (m *MockTokenService) IssueTokens(_ context.Context, user *entities.User) (*models.TokenResponse, error) {
+	args := m.Called(user)
+	if token, ok := args.Get(0).(*models.TokenResponse); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockTokenService) RefreshTokens(_ context.Context, refreshToken string) (*models.TokenResponse, error) {
+	args := m.Called(refreshToken)
+	if token, ok := args.Get(0).(*models.TokenResponse); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockTokenService) RevokeAccessToken(_ context.Context, jti string, _ time.Time) error {
+	return m.Called(jti).Error(0)
+}
+
+func (m *MockTokenService) RevokeRefreshToken(_ context.Context, userID primitive.ObjectID,
+	refreshToken string,
+) error {
+	return m.Called(userID, refreshToken).Error(0)
+}
+
+func (m *MockTokenService) RevokeAllRefreshTokens(_ context.Context, userID primitive.ObjectID) error {
+	return m.Called(userID).Error(0)
+}
+
+func (m *MockTokenService) IsAccessTokenRevoked(_ context.Context, jti string) (bool, error) {
+	args := m.Called(jti)
+	return args.Bool(0), args.Error(1)
+}
+
+type MockRefreshTokenRepo struct {
+	mock.Mock
+}
+
+func (m *MockRefreshTokenRepo) CreateRefreshToken(_ context.Context, token entities.RefreshToken) error {
+	return m.Called(token).Error(0)
+}
+
+func (m *MockRefreshTokenRepo) GetRefreshTokenByHash(_ context.Context, hash string) (*entities.RefreshToken,
+	error,
+) {
+	args := m.Called(hash)
+	if token, ok := args.Get(0).(*entities.RefreshToken); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockRefreshTokenRepo) MarkRefreshTokenUsed(_ context.Context, tokenID primitive.ObjectID,
+	_ time.Time,
+) (int64, error) {
+	args := m.Called(tokenID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRefreshTokenRepo) RevokeRefreshTokenFamily(_ context.Context, familyID primitive.ObjectID,
+	_ time.Time,
+) error {
+	return m.Called(familyID).Error(0)
+}
+
+func (m *MockRefreshTokenRepo) RevokeUserRefreshTokens(_ context.Context, userID primitive.ObjectID,
+	_ time.Time,
+) error {
+	return m.Called(userID).Error(0)
+}
+
+type MockTokenDenylist struct {
+	mock.Mock
+}
+
+func (m *MockTokenDenylist) RevokeToken(_ context.Context, jti string, _ time.Time) error {
+	return m.Called(jti).Error(0)
+}
+
+func (m *MockTokenDenylist) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	args := m.Called(jti)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTokenDenylist) ConsumeToken(_ context.Context, jti string, _ time.Time) (bool, error) {
+	args := m.Called(jti)
+	return args.Bool(0), args.Error(1)
+}
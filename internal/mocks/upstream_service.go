@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"context"
+	"net/http"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MockUpstreamService struct {
+	mock.Mock
+}
+
+func (m *MockUpstreamService) Send(_ context.Context, _ primitive.ObjectID, targetModel *entities.TargetModel,
+	method string, _ http.Header, chatReq *models.ChatRequest,
+) (*http.Response, *entities.TargetModel, error) {
+	args := m.Called(targetModel, method, chatReq)
+	resp, _ := args.Get(0).(*http.Response)
+	answeredBy, _ := args.Get(1).(*entities.TargetModel)
+	return resp, answeredBy, args.Error(2)
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"guardian/internal/models"
 	"guardian/internal/models/entities"
 
@@ -12,24 +14,102 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) Login(req models.LoginRequest) (string, error) {
-	args := m.Called(req)
-	return args.String(0), args.Error(1)
+func (m *MockUserService) Login(_ context.Context, req models.LoginRequest, clientIP string) (*models.TokenResponse,
+	error,
+) {
+	args := m.Called(req, clientIP)
+	if token, ok := args.Get(0).(*models.TokenResponse); ok {
+		return token, args.Error(1)
+	}
+	return nil, args.Error(1)
 }
 
 func (m *MockUserService) SignUp(req models.SignUpRequest) error {
 	return m.Called(req).Error(0)
 }
 
-func (m *MockUserService) GetUserTasksByID(userID primitive.ObjectID) ([]entities.Task, error) {
+func (m *MockUserService) GetUserTasksByID(_ context.Context, userID primitive.ObjectID) ([]entities.Task, error) {
 	args := m.Called(userID)
 	return args.Get(0).([]entities.Task), args.Error(1)
 }
 
-func (m *MockUserService) GetUser(_ primitive.ObjectID) (*entities.User, error) {
-	return nil, nil
+func (m *MockUserService) GetGroupTasksByID(_ context.Context, groupID primitive.ObjectID) ([]entities.Task, error) {
+	args := m.Called(groupID)
+	return args.Get(0).([]entities.Task), args.Error(1)
+}
+
+func (m *MockUserService) GetUser(_ context.Context, id primitive.ObjectID) (*entities.User, error) {
+	args := m.Called(id)
+	if user, ok := args.Get(0).(*entities.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) GetUsers(_ context.Context) ([]entities.User, error) {
+	args := m.Called()
+	if users, ok := args.Get(0).([]entities.User); ok {
+		return users, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) CreateUser(_ context.Context, actor models.Actor, req models.CreateUserRequest) (
+	*entities.User, error,
+) {
+	args := m.Called(actor, req)
+	if user, ok := args.Get(0).(*entities.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) ActivateUser(_ context.Context, token string) error {
+	return m.Called(token).Error(0)
+}
+
+func (m *MockUserService) ResendActivation(_ context.Context, email string) error {
+	return m.Called(email).Error(0)
+}
+
+func (m *MockUserService) UpdateUser(_ context.Context, userID primitive.ObjectID, req models.UpdateUserRequest) error {
+	return m.Called(userID, req).Error(0)
+}
+
+func (m *MockUserService) ChangePassword(_ context.Context, userID primitive.ObjectID,
+	req models.ChangePasswordRequest,
+) error {
+	return m.Called(userID, req).Error(0)
+}
+
+func (m *MockUserService) SetUserRole(_ context.Context, actor models.Actor, userID primitive.ObjectID,
+	role string,
+) error {
+	return m.Called(actor, userID, role).Error(0)
+}
+
+func (m *MockUserService) SetUserTargetModels(_ context.Context, userID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) error {
+	return m.Called(userID, modelIDs).Error(0)
+}
+
+func (m *MockUserService) DeleteUser(_ context.Context, userID primitive.ObjectID) error {
+	return m.Called(userID).Error(0)
+}
+
+func (m *MockUserService) ProvisionExternalUser(ctx context.Context, identity models.ExternalIdentity) (*entities.User,
+	error,
+) {
+	args := m.Called(ctx, identity)
+	if user, ok := args.Get(0).(*entities.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
 }
 
-func (m *MockUserService) ActivateUser(_ models.SignUpRequest) error {
-	return nil
+func (m *MockUserService) LinkExternalIdentity(_ context.Context, userID primitive.ObjectID,
+	identity models.ExternalIdentity,
+) error {
+	return m.Called(userID, identity).Error(0)
 }
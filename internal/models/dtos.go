@@ -1,6 +1,11 @@
 package models
 
 import (
+	"strings"
+	"time"
+
+	"guardian/internal/models/entities"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -15,24 +20,327 @@ type SignUpRequest struct {
 	Password string `json:"password"`
 }
 
-// SendRequest represents a request to send a prompt.
+type ActivationRequest struct {
+	Email string `json:"email"`
+}
+
+// UpdateUserRequest changes the caller's profile. Omitted fields are left untouched; a new email only takes effect
+// once it has been verified.
+type UpdateUserRequest struct {
+	Name  *string `json:"name,omitempty"`
+	Email *string `json:"email,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// LinkExternalIdentityRequest carries a token of the IdP, whose identity is linked to the signed in account.
+type LinkExternalIdentityRequest struct {
+	IDToken string `json:"id_token"`
+}
+
+// TokenResponse carries a short-lived access token and the refresh token to renew it.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutRequest optionally names the refresh token to revoke alongside the access token, or all of the user's
+// sessions.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+	All          bool   `json:"all,omitempty"`
+}
+
+// Actor identifies who performs an operation and with which role.
+type Actor struct {
+	UserID primitive.ObjectID
+	Role   string
+}
+
+type CreateGroupRequest struct {
+	Name   string               `json:"name"`
+	Admins []primitive.ObjectID `json:"admins,omitempty"`
+}
+
+type GroupMemberRequest struct {
+	UserID primitive.ObjectID `json:"user_id"`
+}
+
+type GroupTasksRequest struct {
+	Tasks []primitive.ObjectID `json:"tasks"`
+}
+
+type TargetModelsRequest struct {
+	TargetModels []primitive.ObjectID `json:"target_models"`
+}
+
+type GroupAdminsRequest struct {
+	Admins []primitive.ObjectID `json:"admins"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// CreateUserRequest lets an admin add a user to its organization. The user still has to verify the email address.
+type CreateUserRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"`
+}
+
+// CreateAPIKeyRequest represents a request to issue an API key for the caller or one of its groups.
+type CreateAPIKeyRequest struct {
+	Name      string              `json:"name"`
+	GroupID   *primitive.ObjectID `json:"group_id,omitempty"`
+	Scopes    []string            `json:"scopes"`
+	ExpiresAt *time.Time          `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse carries the plaintext key, which is only ever returned once.
+type CreateAPIKeyResponse struct {
+	Key    string          `json:"key"`
+	APIKey entities.APIKey `json:"api_key"`
+}
+
+// ExternalIdentity represents a user identity asserted by an external IdP token.
+type ExternalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+	Organization  string
+}
+
+// AutoTarget lets Guardian choose the target model of a SendRequest. So does leaving TargetID empty.
+const AutoTarget = "auto"
+
+// SendRequest represents a request to send a prompt. Clients either send a prompt, with the previous conversation as
+// chat, or the whole conversation as messages.
 type SendRequest struct {
+	ChatID      *primitive.ObjectID `json:"chat_id,omitempty"`
+	Chat        string              `json:"chat,omitempty"`
+	Prompt      string              `json:"prompt"`
+	Messages    []ChatMessage       `json:"messages,omitempty"`
+	MaxTokens   int                 `json:"max_tokens,omitempty"`
+	Temperature *float64            `json:"temperature,omitempty"`
+	TargetID    string              `json:"target_id,omitempty"`
+}
+
+// ChatMessage is a message of a conversation with a target model.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ChatRequest is the provider independent request that provider adapters translate for their target models.
+type ChatRequest struct {
+	Messages    []ChatMessage
+	MaxTokens   int
+	Temperature *float64
+	// Raw is the request as the client sent it, for target models that take it as is.
+	Raw []byte
+}
+
+// ChatResponse is the provider independent response that provider adapters translate target model responses into.
+type ChatResponse struct {
+	Model        string      `json:"model"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason,omitempty"`
+	Usage        TokenUsage  `json:"usage"`
+}
+
+type TokenUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// PluginRequest represents a request sending to the referee plugins. Chat is the History flattened into "role: content"
+// lines, for plugins that predate History.
+type PluginRequest struct {
+	UserID         primitive.ObjectID  `json:"user_id"`
+	GroupID        *primitive.ObjectID `json:"-"`
+	ChatID         *primitive.ObjectID `json:"chat_id,omitempty"`
+	Chat           string              `json:"chat,omitempty"`
+	History        []ChatMessage       `json:"history,omitempty"`
+	Address        string              `json:"address,omitempty"`
+	Prompt         string              `json:"prompt"`
+	TargetID       primitive.ObjectID  `json:"target_id"`
+	RequestID      string              `json:"request_id,omitempty"`
+	OrganizationID primitive.ObjectID  `json:"organization_id,omitempty"`
+	TaskType       string              `json:"task_type,omitempty"`
+}
+
+// SetHistory sets the conversation before the prompt, and Chat from it.
+func (r *PluginRequest) SetHistory(history []ChatMessage) {
+	chat := make([]string, 0, len(history))
+	for _, message := range history {
+		chat = append(chat, message.Role+": "+message.Content)
+	}
+	r.History = history
+	r.Chat = strings.Join(chat, "\n")
+}
+
+// Verdict is the outcome of the task pipeline for a prompt. The pipeline stops at the first task to block the prompt,
+// so Tasks only holds the verdicts of the tasks that ran.
+type Verdict struct {
+	Allowed bool `json:"allowed"`
+	// RiskScore is the highest score the plugins gave the prompt.
+	RiskScore uint32        `json:"risk_score"`
+	Tasks     []TaskVerdict `json:"tasks"`
+}
+
+// TaskVerdict is the verdict of a task, which blocks the prompt when one of its plugins does or fails to answer.
+type TaskVerdict struct {
+	TaskType string          `json:"task_type"`
+	Allowed  bool            `json:"allowed"`
+	Score    uint32          `json:"score,omitempty"`
+	Failed   bool            `json:"failed,omitempty"`
+	Plugins  []PluginVerdict `json:"plugins,omitempty"`
+}
+
+// PluginVerdict is what a plugin answered about the prompt.
+type PluginVerdict struct {
+	PluginID primitive.ObjectID `json:"plugin_id"`
+	Name     string             `json:"name"`
+	PluginResponse
+}
+
+// TaskResult represents the result of task in the task pipeline
+type TaskResult struct {
+	TaskType string
+	Success  bool
+	Score    uint32
+	Err      error
+	Plugins  []PluginVerdict
+}
+
+// CheckRequest asks for the verdict on a prompt without forwarding it anywhere. Like SendRequest, it takes either a
+// prompt, with the previous conversation in chat, or the conversation in messages, and may belong to a chat.
+type CheckRequest struct {
 	ChatID   *primitive.ObjectID `json:"chat_id,omitempty"`
+	Chat     string              `json:"chat,omitempty"`
 	Prompt   string              `json:"prompt"`
-	TargetID primitive.ObjectID  `json:"target_id"`
+	Messages []ChatMessage       `json:"messages,omitempty"`
 }
 
-// PluginRequest represents a request sending to the referee plugins
-type PluginRequest struct {
-	UserID   primitive.ObjectID `json:"user_id"`
-	Chat     string             `json:"chat,omitempty"`
-	Address  string             `json:"address,omitempty"`
-	Prompt   string             `json:"prompt"`
-	TargetID primitive.ObjectID `json:"target_id"`
+type CheckBatchRequest struct {
+	Prompts []CheckRequest `json:"prompts"`
+}
+
+// CheckBatchResponse holds a verdict for every prompt, in the order of the prompts.
+type CheckBatchResponse struct {
+	Verdicts []Verdict `json:"verdicts"`
 }
 
-// PluginResponse represents the response from a send operation.
+// PluginResponse represents the response from a send operation. Plugins speaking the v2 protocol explain their
+// verdicts with the violated categories and a reason, and may ask for parts of the prompt to be redacted.
 type PluginResponse struct {
-	Status bool   `json:"status"`
-	Score  uint32 `json:"score,omitempty"`
+	Status        bool            `json:"status"`
+	Score         uint32          `json:"score,omitempty"`
+	Categories    []CategoryScore `json:"categories,omitempty"`
+	Reason        string          `json:"reason,omitempty"`
+	Redactions    []RedactionSpan `json:"redactions,omitempty"`
+	PluginVersion string          `json:"plugin_version,omitempty"`
+}
+
+// CategoryScore is the confidence, between 0 and 1, of a plugin that the prompt violates the category.
+type CategoryScore struct {
+	Name       string  `json:"name"`
+	Confidence float32 `json:"confidence"`
+}
+
+// RedactionSpan marks the bytes [Start, End) of the prompt to be replaced with Replacement. Redactions are only
+// reported for now: the prompt is forwarded to the target model as the user sent it.
+type RedactionSpan struct {
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	Replacement string `json:"replacement"`
+}
+
+// RegisterPluginRequest is sent by a plugin registering itself with a bootstrap token. gRPC plugins that leave out
+// their capabilities are asked for them with the Describe RPC. A plugin registering again under its name proves it
+// owns the name with its current Token.
+type RegisterPluginRequest struct {
+	Name         string                       `json:"name"`
+	Token        string                       `json:"token,omitempty"`
+	Address      string                       `json:"address"`
+	Protocol     string                       `json:"protocol"`
+	Auth         string                       `json:"auth,omitempty"`
+	HealthPath   string                       `json:"health_path,omitempty"`
+	Capabilities *entities.PluginCapabilities `json:"capabilities,omitempty"`
+}
+
+// RegisterPluginResponse hands the plugin the token Guardian authenticates its calls with. The token is rotated every
+// time the plugin registers.
+type RegisterPluginResponse struct {
+	PluginID     primitive.ObjectID          `json:"plugin_id"`
+	Token        string                      `json:"token"`
+	Auth         string                      `json:"auth"`
+	Capabilities entities.PluginCapabilities `json:"capabilities"`
+}
+
+type BootstrapTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateBuiltinPluginRequest adds a plugin running the detectors in-process, which tasks of the types can be assigned.
+type CreateBuiltinPluginRequest struct {
+	Name      string             `json:"name"`
+	TaskTypes []string           `json:"task_types"`
+	Detectors entities.Detectors `json:"detectors"`
+}
+
+// CreateWasmPluginRequest adds a plugin running a WebAssembly module in-process, which tasks of the types can be
+// assigned. The module is either uploaded as code, base64 encoded, or a path relative to PLUGIN_WASM_DIR on Guardian's
+// disk.
+type CreateWasmPluginRequest struct {
+	Name      string   `json:"name"`
+	TaskTypes []string `json:"task_types"`
+	Code      []byte   `json:"code,omitempty"`
+	Path      string   `json:"path,omitempty"`
+}
+
+type TaskPluginsRequest struct {
+	Plugins []primitive.ObjectID `json:"plugins"`
+}
+
+const (
+	PluginHealthUnknown  = "unknown"
+	PluginHealthHealthy  = "healthy"
+	PluginHealthDegraded = "degraded"
+)
+
+// PluginHealth is the outcome of the latest health checks of a plugin. A plugin is degraded once too many checks in a
+// row failed, and healthy again after its next successful check.
+type PluginHealth struct {
+	PluginID            primitive.ObjectID `json:"plugin_id"`
+	Name                string             `json:"name"`
+	Status              string             `json:"status"`
+	LatencyMS           int64              `json:"latency_ms"`
+	ConsecutiveFailures int                `json:"consecutive_failures"`
+	LastError           string             `json:"last_error,omitempty"`
+	LastChecked         time.Time          `json:"last_checked"`
 }
@@ -1,15 +1,58 @@
 package entities
 
 import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Group represents a group of users.
+// Organization represents a tenant. Every other entity belongs to exactly one organization and is only visible
+// within it.
+type Organization struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `json:"name"`
+	Slug      string             `json:"slug"`
+	Status    int                `json:"status"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Tenant is embedded in every entity that belongs to an organization.
+type Tenant struct {
+	OrganizationID primitive.ObjectID `json:"organization_id,omitempty" bson:"organization_id,omitempty"`
+}
+
+func (t *Tenant) GetOrganizationID() primitive.ObjectID {
+	return t.OrganizationID
+}
+
+func (t *Tenant) SetOrganizationID(organizationID primitive.ObjectID) {
+	t.OrganizationID = organizationID
+}
+
+// Group represents a group of users. Its tasks apply to every member and its admins may manage its members and
+// tasks.
 type Group struct {
-	ID     primitive.ObjectID `bson:"_id,omitempty"`
-	Name   string             `json:"name"`
-	Status int                `json:"status"`
-	Tasks  *[]Task            `json:"tasks,omitempty"`
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name         string               `json:"name"`
+	Status       int                  `json:"status"`
+	Tasks        []primitive.ObjectID `json:"tasks,omitempty" bson:"tasks,omitempty"`
+	Admins       []primitive.ObjectID `json:"admins,omitempty" bson:"admins,omitempty"`
+	TargetModels []primitive.ObjectID `json:"target_models,omitempty" bson:"target_models,omitempty"`
+	Tenant       `bson:",inline"`
+}
+
+// HasAdmin reports whether the user administers the group.
+func (g *Group) HasAdmin(userID primitive.ObjectID) bool {
+	for _, admin := range g.Admins {
+		if admin == userID {
+			return true
+		}
+	}
+	return false
 }
 
 type GroupMembers struct {
@@ -18,32 +61,261 @@ type GroupMembers struct {
 	GroupID primitive.ObjectID `bson:"_id"`
 }
 
+const (
+	RolePlatformAdmin = "platform_admin"
+	RoleAdmin         = "admin"
+	RoleGroupAdmin    = "group_admin"
+	RoleUser          = "user"
+	RoleService       = "service"
+)
+
+// IsAdminRole reports whether role administers an organization. Platform admins administer every organization.
+func IsAdminRole(role string) bool {
+	return role == RoleAdmin || role == RolePlatformAdmin
+}
+
+// IsValidRole reports whether role is one of the known roles.
+func IsValidRole(role string) bool {
+	switch role {
+	case RolePlatformAdmin, RoleAdmin, RoleGroupAdmin, RoleUser, RoleService:
+		return true
+	}
+	return false
+}
+
+const (
+	UserStatusInactive = 0
+	UserStatusActive   = 1
+	UserStatusDeleted  = 2
+)
+
 // User represents a user of the system.
 type User struct {
-	ID       primitive.ObjectID   `bson:"_id,omitempty"`
-	Name     string               `json:"name"`
-	Email    string               `json:"email"`
-	Password string               `json:"-"`
-	Status   int                  `json:"status"`
-	Groups   []Group              `json:"groups"`
-	Tasks    []primitive.ObjectID `json:"tasks,omitempty"`
+	ID           primitive.ObjectID   `bson:"_id,omitempty"`
+	Name         string               `json:"name"`
+	Email        string               `json:"email"`
+	Password     string               `json:"-"`
+	Status       int                  `json:"status"`
+	Role         string               `json:"role,omitempty" bson:"role,omitempty"`
+	Groups       []Group              `json:"groups"`
+	Tasks        []primitive.ObjectID `json:"tasks,omitempty"`
+	TargetModels []primitive.ObjectID `json:"target_models,omitempty" bson:"target_models,omitempty"`
+	ExternalID   string               `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	DeletedAt    *time.Time           `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// ActivationNonce identifies the last activation token sent to the user, which is the only one accepted.
+	ActivationNonce string `json:"-" bson:"activation_nonce,omitempty"`
+	Tenant          `bson:",inline"`
+}
+
+// GetRole returns the user's role. Users created before roles existed are regular users.
+func (u *User) GetRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
 }
 
-// Plugin represents a plugin to judge the prompt.
+const (
+	ScopeSend          = "send"
+	ScopeCheck         = "check"
+	ScopeManageAPIKeys = "api_keys"
+)
+
+// APIKey represents a long-lived credential for service-to-service access. Only the hash of the key is stored.
+type APIKey struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Name       string              `json:"name"`
+	Prefix     string              `json:"prefix"`
+	Hash       string              `json:"-"`
+	UserID     primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	GroupID    *primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	Scopes     []string            `json:"scopes"`
+	CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+	ExpiresAt  *time.Time          `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time          `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time          `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Tenant     `bson:",inline"`
+}
+
+// HasScope reports whether the key grants the given scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshToken represents an opaque, single-use refresh token. Tokens rotated from the same login share a family so
+// that a replayed token can revoke the whole chain.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	FamilyID  primitive.ObjectID `bson:"family_id"`
+	Hash      string             `bson:"hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty"`
+	Tenant    `bson:",inline"`
+}
+
+const (
+	AuditAccountLocked = "login.account_locked"
+	AuditIPLocked      = "login.ip_locked"
+)
+
+// AuditEvent records a security relevant event, e.g. a lockout after repeated failed logins.
+type AuditEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type      string             `json:"type"`
+	Subject   string             `json:"subject"`
+	Details   map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	Tenant    `bson:",inline"`
+}
+
+// Plugin represents a plugin to judge the prompt. The health checks of HTTP plugins probe HealthPath on the plugin's
+// host, and gRPC plugins are checked with the standard gRPC health protocol. Plugins that registered themselves
+// declared their Capabilities, which tasks are checked against. Plugins of the builtin protocol run Detectors
+// in-process instead of being called, and so do plugins of the wasm protocol run their Module.
 type Plugin struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	Name     string             `json:"name"`
-	Provider string             `json:"provider"`
-	Address  string             `json:"address"`
-	Status   int                `json:"status"`
-	Token    string             `json:"token,omitempty"`
-	Protocol Protocol           `json:"protocol"`
+	ID           primitive.ObjectID  `bson:"_id,omitempty"`
+	Name         string              `json:"name"`
+	Provider     string              `json:"provider"`
+	Address      string              `json:"address"`
+	Status       int                 `json:"status"`
+	Token        Secret              `json:"token,omitempty" bson:"token,omitempty"`
+	Protocol     Protocol            `json:"protocol"`
+	Auth         PluginAuth          `json:"auth" bson:"auth,omitempty"`
+	HealthPath   string              `json:"health_path,omitempty" bson:"health_path,omitempty"`
+	Capabilities *PluginCapabilities `json:"capabilities,omitempty" bson:"capabilities,omitempty"`
+	Detectors    *Detectors          `json:"detectors,omitempty" bson:"detectors,omitempty"`
+	Module       *WasmModule         `json:"module,omitempty" bson:"module,omitempty"`
+	Tenant       `bson:",inline"`
+}
+
+// WasmModule is the WebAssembly module of a wasm plugin, either kept in the plugin's document as Code or read from
+// Path, relative to PLUGIN_WASM_DIR on Guardian's disk.
+type WasmModule struct {
+	Code []byte `json:"-" bson:"code,omitempty"`
+	Path string `json:"path,omitempty" bson:"path,omitempty"`
+}
+
+// Detectors are the checks a builtin plugin runs on prompts, each of them off unless configured. Blocklist keywords
+// match regardless of case and Patterns are regular expressions. MaxLength counts characters. PII lists the kinds of
+// personal data prompts may not contain, and Secrets rejects prompts containing API keys, tokens or private keys.
+// Scripts are the Unicode scripts, e.g. Latin or Cyrillic, the letters of prompts must be written in.
+type Detectors struct {
+	Blocklist []string `json:"blocklist,omitempty" bson:"blocklist,omitempty"`
+	Patterns  []string `json:"patterns,omitempty" bson:"patterns,omitempty"`
+	MaxLength int      `json:"max_length,omitempty" bson:"max_length,omitempty"`
+	PII       []string `json:"pii,omitempty" bson:"pii,omitempty"`
+	Secrets   bool     `json:"secrets,omitempty" bson:"secrets,omitempty"`
+	Scripts   []string `json:"scripts,omitempty" bson:"scripts,omitempty"`
+}
+
+// Enabled reports whether any detector is configured.
+func (d *Detectors) Enabled() bool {
+	return len(d.Blocklist) > 0 || len(d.Patterns) > 0 || d.MaxLength != 0 || len(d.PII) > 0 || d.Secrets ||
+		len(d.Scripts) > 0
 }
 
 const (
-	GRPCProtocol = "grpc"
-	HTTPProtocol = "http"
+	PIIEmail = "email"
+	PIIPhone = "phone"
+	PIICard  = "card"
+)
+
+// PluginCapabilities are what a plugin declares it can do when it registers. A MaxPromptLength of zero means no limit.
+// gRPC plugins declaring an APIVersion of 2 implement PromptServiceV2, and those that also declare ModeratesOutput
+// are streamed the completions of target models.
+type PluginCapabilities struct {
+	TaskTypes       []string `json:"task_types" bson:"task_types"`
+	ReturnsScore    bool     `json:"returns_score" bson:"returns_score"`
+	Redaction       bool     `json:"redaction" bson:"redaction"`
+	MaxPromptLength int      `json:"max_prompt_length,omitempty" bson:"max_prompt_length,omitempty"`
+	Version         string   `json:"version,omitempty" bson:"version,omitempty"`
+	APIVersion      int      `json:"api_version,omitempty" bson:"api_version,omitempty"`
+	ModeratesOutput bool     `json:"moderates_output,omitempty" bson:"moderates_output,omitempty"`
+}
+
+const (
+	PluginAPIV1 = 1
+	PluginAPIV2 = 2
+)
+
+// Supports reports whether the plugin can judge tasks of the type. Plugins added before capabilities existed haven't
+// declared any and are trusted with every task.
+func (p *Plugin) Supports(taskType string) bool {
+	return p.Capabilities == nil || slices.Contains(p.Capabilities.TaskTypes, taskType)
+}
+
+// AcceptsPrompt reports whether the prompt is within the plugin's MaxPromptLength, counted in characters.
+func (p *Plugin) AcceptsPrompt(prompt string) bool {
+	return p.Capabilities == nil || p.Capabilities.MaxPromptLength == 0 ||
+		utf8.RuneCountInString(prompt) <= p.Capabilities.MaxPromptLength
+}
+
+// APIVersion returns the version of the plugin protocol the plugin speaks, which is 1 unless it declared otherwise.
+func (p *Plugin) APIVersion() int {
+	if p.Capabilities == nil || p.Capabilities.APIVersion < PluginAPIV1 {
+		return PluginAPIV1
+	}
+	return p.Capabilities.APIVersion
+}
+
+// ModeratesOutput reports whether the plugin moderates the output of target models as it streams.
+func (p *Plugin) ModeratesOutput() bool {
+	return p.Protocol.Type == GRPCProtocol && p.APIVersion() >= PluginAPIV2 && p.Capabilities.ModeratesOutput
+}
+
+const PluginActive = 1
+
+// PluginAuth tells plugins how to authenticate Guardian. The bearer type sends the plugin's Token in Header, which
+// defaults to Authorization, as an HTTP header or gRPC metadata. The hmac type signs the body of HTTP requests with the
+// Token along with a timestamp and a nonce, so that plugins can reject replayed requests. The client certificate is
+// presented whenever it is set, so mTLS can be combined with the other types; CACert verifies the plugin's own
+// certificate. The certificates and the key are paths to PEM files relative to the PLUGIN_CERT_DIR directory.
+type PluginAuth struct {
+	Type       string `json:"type,omitempty" bson:"type,omitempty"`
+	Header     string `json:"header,omitempty" bson:"header,omitempty"`
+	ClientCert string `json:"client_cert,omitempty" bson:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty" bson:"client_key,omitempty"`
+	CACert     string `json:"ca_cert,omitempty" bson:"ca_cert,omitempty"`
+	ServerName string `json:"server_name,omitempty" bson:"server_name,omitempty"`
+}
+
+const (
+	PluginAuthBearer = "bearer"
+	PluginAuthHMAC   = "hmac"
+	PluginAuthMTLS   = "mtls"
+)
+
+// GetAuth returns how to authenticate to the plugin. Plugins with a token and no type get the token as a bearer token.
+func (p *Plugin) GetAuth() PluginAuth {
+	auth := p.Auth
+	if auth.Type == "" && p.Token != "" {
+		auth.Type = PluginAuthBearer
+	}
+	if auth.Header == "" {
+		auth.Header = "Authorization"
+	}
+	return auth
+}
+
+// UsesTLS reports whether requests to the plugin need a TLS configuration of their own.
+func (a PluginAuth) UsesTLS() bool {
+	return a.Type == PluginAuthMTLS || a.ClientCert != "" || a.CACert != "" || a.ServerName != ""
+}
+
+const (
+	GRPCProtocol      = "grpc"
+	HTTPProtocol      = "http"
 	WEBSOCKETProtocol = "web_socket"
+	BuiltinProtocol   = "builtin"
+	WasmProtocol      = "wasm"
 )
 
 type Protocol struct {
@@ -51,15 +323,125 @@ type Protocol struct {
 	Type string             `json:"type"`
 }
 
-// TargetModel represents the target model for processing.
+// TargetModel represents the target model for processing. Automatic routing picks the cheapest CostTier among the
+// healthy models that accept the prompt length (MaxPromptLength of zero means no limit), and prefers Conservative
+// models for high-risk prompts.
+//
+// Prompts are balanced across the model's Endpoints with weighted round-robin, or with least-latency balancing, and
+// fall back to the Fallbacks models in order when every endpoint fails.
 type TargetModel struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	Provider string             `json:"provider"`
-	Name     string             `json:"name"`
-	Address  string             `json:"address"`
-	Status   int                `json:"status"`
-	Token    string             `json:"token"`
-	Protocol Protocol           `json:"protocol"`
+	ID              primitive.ObjectID   `bson:"_id,omitempty"`
+	Provider        string               `json:"provider"`
+	Name            string               `json:"name"`
+	Address         string               `json:"address"`
+	Status          int                  `json:"status"`
+	Token           Secret               `json:"token,omitempty" bson:"token,omitempty"`
+	Protocol        Protocol             `json:"protocol"`
+	CostTier        int                  `json:"cost_tier" bson:"cost_tier"`
+	MaxPromptLength int                  `json:"max_prompt_length,omitempty" bson:"max_prompt_length,omitempty"`
+	Conservative    bool                 `json:"conservative" bson:"conservative"`
+	Endpoints       []Endpoint           `json:"endpoints,omitempty" bson:"endpoints,omitempty"`
+	Balancing       string               `json:"balancing,omitempty" bson:"balancing,omitempty"`
+	Fallbacks       []primitive.ObjectID `json:"fallbacks,omitempty" bson:"fallbacks,omitempty"`
+	Auth            TargetAuth           `json:"auth,omitempty" bson:"auth,omitempty"`
+	Tenant          `bson:",inline"`
+}
+
+// Secret is a credential such as a plugin or target model token. It is redacted from JSON and formatted output, and
+// repositories encrypt it at rest.
+type Secret string
+
+const redactedSecret = "[REDACTED]"
+
+// Reveal returns the secret itself.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// IsRedacted reports whether the secret is the placeholder it is redacted to, e.g. in a model a client read from the
+// API and sent back.
+func (s Secret) IsRedacted() bool {
+	return s == redactedSecret
+}
+
+// TargetAuth tells how the model's Token authenticates requests. Name is the header or query parameter for the header
+// and query types. Without a type, the provider's usual scheme applies.
+type TargetAuth struct {
+	Type string `json:"type,omitempty" bson:"type,omitempty"`
+	Name string `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+const (
+	AuthBearer = "bearer"
+	AuthAPIKey = "api_key"
+	AuthHeader = "header"
+	AuthQuery  = "query"
+)
+
+// GetAuth returns how to authenticate to the model, falling back to the provider's scheme.
+func (t *TargetModel) GetAuth() TargetAuth {
+	auth := t.Auth
+	if auth.Type == "" {
+		switch strings.ToLower(t.Provider) {
+		case "azure", "azure_openai":
+			auth.Type = AuthAPIKey
+		case "anthropic":
+			auth = TargetAuth{Type: AuthHeader, Name: "x-api-key"}
+		case "google", "gemini":
+			auth = TargetAuth{Type: AuthQuery, Name: "key"}
+		default:
+			auth.Type = AuthBearer
+		}
+	}
+	if auth.Name == "" {
+		switch auth.Type {
+		case AuthHeader:
+			auth.Name = "X-API-Key"
+		case AuthQuery:
+			auth.Name = "key"
+		}
+	}
+	return auth
+}
+
+// Endpoint is one upstream deployment of a target model. Weights only matter to round-robin balancing and default
+// to 1.
+type Endpoint struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty" bson:"weight,omitempty"`
+}
+
+const (
+	BalancingRoundRobin   = "round_robin"
+	BalancingLeastLatency = "least_latency"
+)
+
+// GetEndpoints returns the model's endpoints. Models created before endpoints existed have their address only.
+func (t *TargetModel) GetEndpoints() []Endpoint {
+	if len(t.Endpoints) > 0 {
+		return t.Endpoints
+	}
+	return []Endpoint{{Address: t.Address, Weight: 1}}
+}
+
+// IsHealthy reports whether the model can receive prompts.
+func (t *TargetModel) IsHealthy() bool {
+	return t.Status == 1
 }
 
 // Usage records token consumption for users.
@@ -71,17 +453,26 @@ type Usage struct {
 	OutputTokenConsumption int                `json:"output_token_consumption"`
 }
 
-// Task represents a task that can be used in the pipeline.
+// Task is a check the prompts of its users go through. Plugins of multi-turn tasks also judge the last turns of the
+// conversation the prompt belongs to, to catch attacks split across messages.
 type Task struct {
-	ID      primitive.ObjectID   `json:"_id"`
-	Type    string               `json:"type"`
-	Status  int                  `json:"status"`
-	Plugins []primitive.ObjectID `json:"plugins,omitempty"`
+	ID        primitive.ObjectID   `json:"_id"`
+	Type      string               `json:"type"`
+	Status    int                  `json:"status"`
+	Plugins   []primitive.ObjectID `json:"plugins,omitempty"`
+	MultiTurn bool                 `json:"multi_turn,omitempty" bson:"multi_turn,omitempty"`
+	Tenant    `bson:",inline"`
 }
 
-// TaskResult represents the result of task in the task pipeline
-type TaskResult struct {
-	TaskType string
-	Success  bool
-	Err      error
+// ChatTurn is a message of a conversation a user had through Guardian, kept to give multi-turn tasks the context of
+// a prompt. Prompts the tasks blocked are kept too, as attacks often probe before they succeed.
+type ChatTurn struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatID    primitive.ObjectID `json:"chat_id" bson:"chat_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Role      string             `json:"role"`
+	Content   string             `json:"content"`
+	Blocked   bool               `json:"blocked,omitempty" bson:"blocked,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	Tenant    `bson:",inline"`
 }
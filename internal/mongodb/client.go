@@ -22,6 +22,9 @@ func Init() *mongo.Database {
 		logger.GetLogger().Fatal(err)
 	}
 	Database = Client.Database(configs.GlobalConfig.PrimaryDBName)
+	if err = EnsureIndexes(context.Background(), Database); err != nil {
+		logger.GetLogger().Fatal(err)
+	}
 	return Database
 }
 
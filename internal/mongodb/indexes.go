@@ -0,0 +1,75 @@
+package mongodb
+
+import (
+	"context"
+
+	"guardian/configs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists the indexes Guardian relies on, keyed by collection name.
+func indexes() map[string][]mongo.IndexModel {
+	names := configs.GlobalConfig.CollectionNames
+	return map[string][]mongo.IndexModel{
+		names.User: {
+			{
+				// Concurrent first logins of the same external identity must not provision two users.
+				Keys: bson.D{{Key: "external_id", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"external_id": bson.M{"$type": "string"}}),
+			},
+			{
+				// Users sign in by email alone, so an email belongs to one user across organizations, regardless of
+				// case. External users without an email are left out.
+				Keys: bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetCollation(&options.Collation{Locale: "en", Strength: 2}).
+					SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+			},
+		},
+		names.RefreshToken: {
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		names.LoginAttempt: {
+			// Attempt counters and lockouts are purged once they expire.
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		names.ChatTurn: chatTurnIndexes(),
+		names.RevokedToken: {
+			{
+				// Denylist entries are only relevant until the token expires.
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+	}
+}
+
+// chatTurnIndexes serve the lookup of the last turns of a user's chat, done for every prompt sent in a chat, and purge
+// turns older than the chat history TTL. A TTL of zero keeps them forever.
+func chatTurnIndexes() []mongo.IndexModel {
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "chat_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	}
+	if ttl := configs.GlobalConfig.ChatHistoryTTL; ttl > 0 {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+		})
+	}
+	return models
+}
+
+// EnsureIndexes creates the indexes Guardian relies on. Creating an existing index is a no-op.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	for collection, models := range indexes() {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
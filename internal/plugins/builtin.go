@@ -0,0 +1,288 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+)
+
+var ErrInvalidDetectors = errors.New("invalid detectors")
+
+const (
+	CategoryBlocklist = "blocklist"
+	CategoryPattern   = "pattern"
+	CategoryLength    = "length"
+	CategoryPII       = "pii"
+	CategorySecrets   = "secrets"
+	CategoryCharset   = "charset"
+
+	builtinBlockScore = 100
+)
+
+// DefaultBuiltin runs the detectors of builtin plugins for the pipeline.
+var DefaultBuiltin = NewBuiltinClient()
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+	// phonePattern matches numbers written in E.164 form, with an area code in parentheses or with separators between
+	// groups of digits, so plain runs of digits such as order numbers aren't reported. Other numbers grouped the same
+	// way, e.g. a date followed by a time, are still reported as phone numbers; IP addresses are left out.
+	phonePattern = regexp.MustCompile(
+		`\+\d{10,15}\b|(?:\+\d{1,3}[\s.-])?(?:\(\d{2,4}\)\s?|\b\d{2,4}[\s.-])\d{2,4}[\s.-]\d{2,4}(?:[\s.-]\d{2,4})?\b`)
+
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+		regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+		regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{40,}\b`),
+		regexp.MustCompile(`\bsk-(?:proj-|ant-)?[A-Za-z0-9_-]{20,}`),
+		regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{20,}\b`),
+		regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+		regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	}
+)
+
+// BuiltinClient runs the detectors of builtin plugins in-process, so cheap checks don't cost a network hop. Prompts
+// that trip a detector are blocked with the detector's category, and the personal data and secrets found in them are
+// marked for redaction.
+type BuiltinClient struct {
+	mu       sync.RWMutex
+	patterns map[string]*regexp.Regexp
+}
+
+func NewBuiltinClient() *BuiltinClient {
+	return &BuiltinClient{patterns: make(map[string]*regexp.Regexp)}
+}
+
+func (b *BuiltinClient) Forward(_ context.Context, plugin *entities.Plugin,
+	reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+	resp := &models.PluginResponse{Status: true}
+	detectors := plugin.Detectors
+	if detectors == nil {
+		return resp, nil
+	}
+	prompt := reqBody.Prompt
+	var reasons []string
+	block := func(category, reason string) {
+		resp.Categories = append(resp.Categories, models.CategoryScore{Name: category, Confidence: 1})
+		reasons = append(reasons, reason)
+	}
+
+	if detectors.MaxLength > 0 && utf8.RuneCountInString(prompt) > detectors.MaxLength {
+		block(CategoryLength, fmt.Sprintf("the prompt is longer than %d characters", detectors.MaxLength))
+	}
+
+	lower := strings.ToLower(prompt)
+	for _, keyword := range detectors.Blocklist {
+		if keyword != "" && strings.Contains(lower, strings.ToLower(keyword)) {
+			block(CategoryBlocklist, "the prompt contains a blocked keyword")
+			break
+		}
+	}
+
+	for _, expr := range detectors.Patterns {
+		pattern, err := b.compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		if pattern.MatchString(prompt) {
+			block(CategoryPattern, "the prompt matches a blocked pattern")
+			break
+		}
+	}
+
+	pii, err := findPII(prompt, detectors.PII)
+	if err != nil {
+		return nil, err
+	}
+	if len(pii) > 0 {
+		block(CategoryPII, "the prompt contains personal data")
+		resp.Redactions = append(resp.Redactions, pii...)
+	}
+
+	if detectors.Secrets {
+		secrets := findSecrets(prompt)
+		if len(secrets) > 0 {
+			block(CategorySecrets, "the prompt contains secrets")
+			resp.Redactions = append(resp.Redactions, secrets...)
+		}
+	}
+
+	if len(detectors.Scripts) > 0 {
+		ok, err := inScripts(prompt, detectors.Scripts)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			block(CategoryCharset, "the prompt is written in a script that isn't allowed")
+		}
+	}
+
+	if len(resp.Categories) > 0 {
+		resp.Status = false
+		resp.Score = builtinBlockScore
+		resp.Reason = strings.Join(reasons, "; ")
+	}
+	return resp, nil
+}
+
+// Check reports builtin plugins with detectors that can't run, e.g. invalid patterns, as unhealthy.
+func (b *BuiltinClient) Check(_ context.Context, plugin *entities.Plugin) error {
+	err := b.Validate(plugin.Detectors)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPluginUnhealthy, err)
+	}
+	return nil
+}
+
+// Validate returns an ErrInvalidDetectors error unless every detector is configured correctly.
+func (b *BuiltinClient) Validate(detectors *entities.Detectors) error {
+	if detectors == nil || !detectors.Enabled() {
+		return fmt.Errorf("%w: no detectors are configured", ErrInvalidDetectors)
+	}
+	if detectors.MaxLength < 0 {
+		return fmt.Errorf("%w: max_length must not be negative", ErrInvalidDetectors)
+	}
+	for _, expr := range detectors.Patterns {
+		_, err := b.compile(expr)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := findPII("", detectors.PII)
+	if err != nil {
+		return err
+	}
+	_, err = inScripts("", detectors.Scripts)
+	return err
+}
+
+// compile returns the compiled pattern, compiling every pattern only once.
+func (b *BuiltinClient) compile(expr string) (*regexp.Regexp, error) {
+	b.mu.RLock()
+	pattern, ok := b.patterns[expr]
+	b.mu.RUnlock()
+	if ok {
+		return pattern, nil
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDetectors, err)
+	}
+	b.mu.Lock()
+	b.patterns[expr] = pattern
+	b.mu.Unlock()
+	return pattern, nil
+}
+
+// findPII returns the spans of the prompt holding the kinds of personal data. Card numbers must pass the Luhn check,
+// and phone numbers within card numbers aren't reported twice.
+func findPII(prompt string, kinds []string) ([]models.RedactionSpan, error) {
+	var spans []models.RedactionSpan
+	for _, kind := range kinds {
+		switch kind {
+		case entities.PIIEmail:
+			spans = appendSpans(spans, prompt, emailPattern, "[EMAIL]", nil)
+		case entities.PIICard:
+			spans = appendSpans(spans, prompt, cardPattern, "[CARD]", func(match string) bool {
+				return luhn(digits(match))
+			})
+		case entities.PIIPhone:
+		default:
+			return nil, fmt.Errorf("%w: unknown kind of personal data %q", ErrInvalidDetectors, kind)
+		}
+	}
+	if slices.Contains(kinds, entities.PIIPhone) {
+		cards := slices.Clone(spans)
+		spans = appendSpans(spans, prompt, phonePattern, "[PHONE]", func(match string) bool {
+			n := len(digits(match))
+			return n >= 10 && n <= 15 && net.ParseIP(match) == nil
+		})
+		spans = slices.DeleteFunc(spans, func(span models.RedactionSpan) bool {
+			return span.Replacement == "[PHONE]" && slices.ContainsFunc(cards, func(card models.RedactionSpan) bool {
+				return span.Start < card.End && card.Start < span.End
+			})
+		})
+	}
+	return spans, nil
+}
+
+func findSecrets(prompt string) []models.RedactionSpan {
+	var spans []models.RedactionSpan
+	for _, pattern := range secretPatterns {
+		spans = appendSpans(spans, prompt, pattern, "[SECRET]", nil)
+	}
+	return spans
+}
+
+func appendSpans(spans []models.RedactionSpan, prompt string, pattern *regexp.Regexp, replacement string,
+	accept func(match string) bool,
+) []models.RedactionSpan {
+	for _, loc := range pattern.FindAllStringIndex(prompt, -1) {
+		if accept == nil || accept(prompt[loc[0]:loc[1]]) {
+			spans = append(spans, models.RedactionSpan{Start: loc[0], End: loc[1], Replacement: replacement})
+		}
+	}
+	return spans
+}
+
+// inScripts reports whether every letter of the prompt is written in one of the Unicode scripts.
+func inScripts(prompt string, scripts []string) (bool, error) {
+	tables := make([]*unicode.RangeTable, 0, len(scripts))
+	for _, script := range scripts {
+		table, ok := unicode.Scripts[script]
+		if !ok {
+			return false, fmt.Errorf("%w: unknown script %q", ErrInvalidDetectors, script)
+		}
+		tables = append(tables, table)
+	}
+
+	for _, r := range prompt {
+		if unicode.IsLetter(r) && !unicode.In(r, tables...) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func digits(s string) []int {
+	var d []int
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			d = append(d, int(r-'0'))
+		}
+	}
+	return d
+}
+
+// luhn reports whether the digits pass the Luhn checksum card numbers carry.
+func luhn(d []int) bool {
+	if len(d) < 13 || len(d) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range d {
+		digit := d[len(d)-1-i]
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
@@ -7,44 +7,88 @@ import (
 	"errors"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"guardian/configs"
 	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/tracing"
 	"guardian/prompt_api"
 	"net/http"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
 	ErrForwardRequest       = errors.New("failed to forward request")
 	ErrPluginResponseFailed = errors.New("failed to receive a response")
+	ErrPromptTooLong        = errors.New("prompt is longer than the plugin accepts")
 )
 
 type PluginClient interface {
-	Forward(ctx context.Context, reqBody *models.PluginRequest) (*models.PluginResponse, error)
+	Forward(ctx context.Context, plugin *entities.Plugin, reqBody *models.PluginRequest) (*models.PluginResponse, error)
+}
+
+// HealthChecker probes whether a plugin is alive.
+type HealthChecker interface {
+	Check(ctx context.Context, plugin *entities.Plugin) error
 }
 
 type HTTPClientInterface interface {
 	Do(req *http.Request) (*http.Response, error)
-	Forward(ctx context.Context, reqBody *models.PluginRequest) (*models.PluginResponse, error)
+	Forward(ctx context.Context, plugin *entities.Plugin, reqBody *models.PluginRequest) (*models.PluginResponse, error)
+	HealthChecker
 }
 
+// HTTPClient calls HTTP plugins. Plugins with a TLS configuration of their own get a client of their own, which is
+// reused across requests until their certificates change.
 type HTTPClient struct {
 	*http.Client
+	mu         sync.Mutex
+	certDir    string
+	tlsClients map[primitive.ObjectID]*tlsClient
+}
+
+// tlsClient is a client along with the fingerprint of the TLS configuration it was built with.
+type tlsClient struct {
+	client      *http.Client
+	fingerprint string
 }
 
+// GRPCClient calls gRPC plugins, with PromptServiceV2 for plugins that declared the v2 protocol and with
+// PromptService otherwise.
 type GRPCClient struct {
-	Client prompt_api.PromptServiceClient
+	Client   prompt_api.PromptServiceClient
+	ClientV2 prompt_api.PromptServiceV2Client
+	Health   grpc_health_v1.HealthClient
 }
 
 func NewHTTPClient(client *http.Client) *HTTPClient {
-	return &HTTPClient{client}
+	return &HTTPClient{
+		Client:     client,
+		certDir:    configs.GlobalConfig.PluginCertDir,
+		tlsClients: make(map[primitive.ObjectID]*tlsClient),
+	}
 }
 
 func NewPluginGRPCClient(client *grpc.ClientConn) *GRPCClient {
 	return &GRPCClient{
-		Client: prompt_api.NewPromptServiceClient(client),
+		Client:   prompt_api.NewPromptServiceClient(client),
+		ClientV2: prompt_api.NewPromptServiceV2Client(client),
+		Health:   grpc_health_v1.NewHealthClient(client),
 	}
 }
 
-func (g *GRPCClient) Forward(ctx context.Context, reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+func (g *GRPCClient) Forward(ctx context.Context, plugin *entities.Plugin,
+	reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+	ctx, err := authenticateGRPC(ctx, plugin)
+	if err != nil {
+		return nil, err
+	}
+	if plugin.APIVersion() >= entities.PluginAPIV2 {
+		return g.evaluate(ctx, reqBody)
+	}
+
 	req := prompt_api.SendPromptRequest{
 		Prompt:   reqBody.Prompt,
 		Chat:     reqBody.Chat,
@@ -67,26 +111,38 @@ func (g *GRPCClient) Forward(ctx context.Context, reqBody *models.PluginRequest)
 	}, nil
 }
 
-func (h *HTTPClient) Forward(ctx context.Context, reqBody *models.PluginRequest) (*models.PluginResponse, error) {
-	marshalledBody, err := json.Marshal(reqBody)
+func (h *HTTPClient) Forward(ctx context.Context, plugin *entities.Plugin,
+	reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+	body := *reqBody
+	body.Address = plugin.Address
+	marshalledBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqBody.Address, bytes.NewBuffer(marshalledBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, plugin.Address, bytes.NewBuffer(marshalledBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+	err = authenticate(req, plugin, marshalledBody)
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := h.Client.Do(req)
+	client, err := h.clientFor(plugin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%w from: %s", ErrPluginResponseFailed, reqBody.Address)
+		return nil, fmt.Errorf("%w from: %s", ErrPluginResponseFailed, plugin.Address)
 	}
 
 	var sendResponse models.PluginResponse
@@ -94,8 +150,59 @@ func (h *HTTPClient) Forward(ctx context.Context, reqBody *models.PluginRequest)
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return &models.PluginResponse{
-		Status: sendResponse.Status,
-		Score:  sendResponse.Score,
+	return &sendResponse, nil
+}
+
+func (h *HTTPClient) clientFor(plugin *entities.Plugin) (*http.Client, error) {
+	if !plugin.Auth.UsesTLS() {
+		return h.Client, nil
+	}
+
+	fingerprint, err := prompt_api.TLSFingerprint(plugin.Auth, h.certDir)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cached, exists := h.tlsClients[plugin.ID]; exists {
+		if cached.fingerprint == fingerprint {
+			return cached.client, nil
+		}
+		// The certificates were rotated: drop the connections made with the old ones.
+		cached.client.CloseIdleConnections()
+		delete(h.tlsClients, plugin.ID)
+	}
+	tlsConfig, err := prompt_api.LoadTLSConfig(plugin.Auth, h.certDir)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Timeout: h.Client.Timeout, Transport: transport}
+	h.tlsClients[plugin.ID] = &tlsClient{client: client, fingerprint: fingerprint}
+	return client, nil
+}
+
+// Describe asks a gRPC plugin for its capabilities.
+func (g *GRPCClient) Describe(ctx context.Context, plugin *entities.Plugin) (*entities.PluginCapabilities, error) {
+	ctx, err := authenticateGRPC(ctx, plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.Client.Describe(ctx, &prompt_api.DescribeRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
+	}
+	return &entities.PluginCapabilities{
+		TaskTypes:       resp.GetTaskTypes(),
+		ReturnsScore:    resp.GetReturnsScore(),
+		Redaction:       resp.GetSupportsRedaction(),
+		MaxPromptLength: int(resp.GetMaxPromptLength()),
+		Version:         resp.GetVersion(),
+		APIVersion:      int(resp.GetApiVersion()),
+		ModeratesOutput: resp.GetModeratesOutput(),
 	}, nil
 }
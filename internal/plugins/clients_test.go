@@ -0,0 +1,228 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/prompt_api"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHTTPClient_Auth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		plugin entities.Plugin
+		// check validates the request the plugin receives.
+		check     func(r *http.Request, body []byte) bool
+		expectErr error
+	}{
+		{
+			name:   "no auth",
+			plugin: entities.Plugin{},
+			check: func(r *http.Request, _ []byte) bool {
+				return r.Header.Get("Authorization") == "" && r.Header.Get(SignatureHeader) == ""
+			},
+		},
+		{
+			name:   "token defaults to bearer",
+			plugin: entities.Plugin{Token: "plugin-token"},
+			check: func(r *http.Request, _ []byte) bool {
+				return r.Header.Get("Authorization") == "Bearer plugin-token"
+			},
+		},
+		{
+			name: "custom header",
+			plugin: entities.Plugin{Token: "plugin-token",
+				Auth: entities.PluginAuth{Type: entities.PluginAuthBearer, Header: "X-Plugin-Token"}},
+			check: func(r *http.Request, _ []byte) bool {
+				return r.Header.Get("X-Plugin-Token") == "plugin-token" && r.Header.Get("Authorization") == ""
+			},
+		},
+		{
+			name:   "hmac",
+			plugin: entities.Plugin{Token: "plugin-token", Auth: entities.PluginAuth{Type: entities.PluginAuthHMAC}},
+			check: func(r *http.Request, body []byte) bool {
+				timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+				if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+					return false
+				}
+				nonce := r.Header.Get(NonceHeader)
+				return nonce != "" && r.Header.Get("Authorization") == "" &&
+					r.Header.Get(SignatureHeader) == Sign("plugin-token", r.Method, r.URL.RequestURI(), timestamp,
+						nonce, body)
+			},
+		},
+		{
+			name:      "bearer without a token",
+			plugin:    entities.Plugin{Auth: entities.PluginAuth{Type: entities.PluginAuthBearer}},
+			expectErr: ErrMissingToken,
+		},
+		{
+			name:      "unsupported",
+			plugin:    entities.Plugin{Token: "plugin-token", Auth: entities.PluginAuth{Type: "basic"}},
+			expectErr: ErrUnsupportedAuth,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil || !tt.check(r, body) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(models.PluginResponse{Status: true, Score: 3})
+			}))
+			defer server.Close()
+
+			tt.plugin.ID = primitive.NewObjectID()
+			tt.plugin.Address = server.URL
+			client := NewHTTPClient(server.Client())
+
+			resp, err := client.Forward(context.Background(), &tt.plugin, &models.PluginRequest{Prompt: "hi"})
+			if tt.expectErr != nil {
+				require.ErrorIs(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, &models.PluginResponse{Status: true, Score: 3}, resp)
+		})
+	}
+}
+
+func TestHTTPClient_TraceContext(t *testing.T) {
+	t.Parallel()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		_ = json.NewEncoder(w).Encode(models.PluginResponse{Status: true})
+	}))
+	defer server.Close()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+	_, err := NewHTTPClient(http.DefaultClient).Forward(ctx, &entities.Plugin{Address: server.URL},
+		&models.PluginRequest{Prompt: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", traceparent)
+}
+
+func TestHTTPClient_CACert(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(models.PluginResponse{Status: true})
+	}))
+	defer server.Close()
+
+	certDir := t.TempDir()
+	writeCA(t, filepath.Join(certDir, "ca.pem"), server.Certificate().Raw, time.Now())
+
+	client := NewHTTPClient(http.DefaultClient)
+	client.certDir = certDir
+	plugin := &entities.Plugin{ID: primitive.NewObjectID(), Address: server.URL}
+	_, err := client.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+	require.ErrorIs(t, err, ErrForwardRequest)
+
+	plugin = &entities.Plugin{
+		ID:      primitive.NewObjectID(),
+		Address: server.URL,
+		Auth:    entities.PluginAuth{CACert: "ca.pem", ServerName: "example.com"},
+	}
+	resp, err := client.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+	require.NoError(t, err)
+	require.True(t, resp.Status)
+
+	plugin.Auth.Type = entities.PluginAuthMTLS
+	plugin.ID = primitive.NewObjectID()
+	_, err = client.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+	require.ErrorIs(t, err, ErrForwardRequest)
+}
+
+func TestHTTPClient_CertificatePaths(t *testing.T) {
+	t.Parallel()
+
+	certDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(certDir, "nested"), 0o700))
+	client := NewHTTPClient(http.DefaultClient)
+	client.certDir = certDir
+
+	for _, path := range []string{"../ca.pem", "/etc/ssl/cert.pem", "nested", "missing.pem"} {
+		plugin := &entities.Plugin{
+			ID:      primitive.NewObjectID(),
+			Address: "https://plugin.example.com",
+			Auth:    entities.PluginAuth{CACert: path},
+		}
+		_, err := client.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+		require.ErrorIs(t, err, prompt_api.ErrInvalidCertificate, path)
+	}
+}
+
+func TestHTTPClient_RotatedCertificates(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(models.PluginResponse{Status: true})
+	}))
+	defer server.Close()
+
+	certDir := t.TempDir()
+	caFile := filepath.Join(certDir, "ca.pem")
+	writeCA(t, caFile, server.Certificate().Raw, time.Now().Add(-time.Hour))
+
+	client := NewHTTPClient(http.DefaultClient)
+	client.certDir = certDir
+	plugin := &entities.Plugin{
+		ID:      primitive.NewObjectID(),
+		Address: server.URL,
+		Auth:    entities.PluginAuth{CACert: "ca.pem", ServerName: "example.com"},
+	}
+	first, err := client.clientFor(plugin)
+	require.NoError(t, err)
+	cached, err := client.clientFor(plugin)
+	require.NoError(t, err)
+	require.Same(t, first, cached)
+
+	writeCA(t, caFile, server.Certificate().Raw, time.Now())
+	rotated, err := client.clientFor(plugin)
+	require.NoError(t, err)
+	require.NotSame(t, first, rotated)
+
+	resp, err := client.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+	require.NoError(t, err)
+	require.True(t, resp.Status)
+}
+
+func writeCA(t *testing.T, path string, certificate []byte, modified time.Time) {
+	t.Helper()
+
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0o600)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(path, modified, modified))
+}
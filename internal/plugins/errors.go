@@ -0,0 +1,44 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The types of errors plugins fail to judge prompts with, as reported by ErrorType.
+const (
+	ErrorTypeDegraded      = "degraded"
+	ErrorTypeTimeout       = "timeout"
+	ErrorTypeUnreachable   = "unreachable"
+	ErrorTypeBadResponse   = "bad_response"
+	ErrorTypeMisconfigured = "misconfigured"
+	ErrorTypeModule        = "module"
+	ErrorTypeOther         = "other"
+)
+
+// ErrorType tells what kind of error a plugin failed with, for the errors to be counted by type.
+func ErrorType(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrPluginDegraded):
+		return ErrorTypeDegraded
+	case errors.Is(err, context.DeadlineExceeded), status.Code(err) == codes.DeadlineExceeded,
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTypeTimeout
+	case errors.Is(err, ErrInvalidModule), errors.Is(err, ErrInvalidDetectors), errors.Is(err, ErrUnsupportedAuth),
+		errors.Is(err, ErrMissingToken):
+		return ErrorTypeMisconfigured
+	case errors.Is(err, ErrModuleFailed):
+		return ErrorTypeModule
+	case errors.Is(err, ErrPluginResponseFailed):
+		return ErrorTypeBadResponse
+	case errors.Is(err, ErrForwardRequest):
+		return ErrorTypeUnreachable
+	default:
+		return ErrorTypeOther
+	}
+}
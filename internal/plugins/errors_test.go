@@ -0,0 +1,44 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		err          error
+		expectedType string
+	}{
+		{name: "degraded", err: fmt.Errorf("%w: toxicity", ErrPluginDegraded), expectedType: ErrorTypeDegraded},
+		{name: "deadline", err: fmt.Errorf("%w: %w", ErrForwardRequest, context.DeadlineExceeded),
+			expectedType: ErrorTypeTimeout},
+		{name: "grpc deadline", err: fmt.Errorf("%w: %w", ErrForwardRequest,
+			status.Error(codes.DeadlineExceeded, "deadline exceeded")), expectedType: ErrorTypeTimeout},
+		{name: "unreachable", err: fmt.Errorf("%w: connection refused", ErrForwardRequest),
+			expectedType: ErrorTypeUnreachable},
+		{name: "bad status", err: fmt.Errorf("%w from: http://plugin", ErrPluginResponseFailed),
+			expectedType: ErrorTypeBadResponse},
+		{name: "invalid module", err: fmt.Errorf("%w: no exports", ErrInvalidModule),
+			expectedType: ErrorTypeMisconfigured},
+		{name: "module trap", err: fmt.Errorf("%w: unreachable", ErrModuleFailed), expectedType: ErrorTypeModule},
+		{name: "anything else", err: errors.New("boom"), expectedType: ErrorTypeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.expectedType, ErrorType(tt.err))
+		})
+	}
+}
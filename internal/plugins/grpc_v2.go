@@ -0,0 +1,70 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+
+	"guardian/internal/models"
+	"guardian/prompt_api"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (g *GRPCClient) evaluate(ctx context.Context, reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+	resp, err := g.ClientV2.Evaluate(ctx, evaluateRequest(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
+	}
+	return pluginResponse(resp), nil
+}
+
+func evaluateRequest(reqBody *models.PluginRequest) *prompt_api.EvaluateRequest {
+	history := make([]*prompt_api.ChatMessage, 0, len(reqBody.History))
+	for _, message := range reqBody.History {
+		history = append(history, &prompt_api.ChatMessage{Role: message.Role, Content: message.Content})
+	}
+
+	return &prompt_api.EvaluateRequest{
+		Prompt:  reqBody.Prompt,
+		History: history,
+		Metadata: &prompt_api.RequestMetadata{
+			RequestId:      reqBody.RequestID,
+			OrganizationId: hexOrEmpty(reqBody.OrganizationID),
+			TaskType:       reqBody.TaskType,
+			UserId:         hexOrEmpty(reqBody.UserID),
+			TargetId:       hexOrEmpty(reqBody.TargetID),
+		},
+	}
+}
+
+func pluginResponse(resp *prompt_api.EvaluateResponse) *models.PluginResponse {
+	response := &models.PluginResponse{
+		Status:        resp.GetAllowed(),
+		Reason:        resp.GetReason(),
+		PluginVersion: resp.GetPluginVersion(),
+	}
+	if respScore, ok := resp.GetOptionalScore().(*prompt_api.EvaluateResponse_Score); ok {
+		response.Score = respScore.Score
+	}
+	for _, category := range resp.GetCategories() {
+		response.Categories = append(response.Categories, models.CategoryScore{
+			Name:       category.GetName(),
+			Confidence: category.GetConfidence(),
+		})
+	}
+	for _, span := range resp.GetRedactions() {
+		response.Redactions = append(response.Redactions, models.RedactionSpan{
+			Start:       int(span.GetStart()),
+			End:         int(span.GetEnd()),
+			Replacement: span.GetReplacement(),
+		})
+	}
+	return response
+}
+
+func hexOrEmpty(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
@@ -0,0 +1,103 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/prompt_api"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+)
+
+type fakePromptClient struct {
+	prompt_api.PromptServiceClient
+	requests []*prompt_api.SendPromptRequest
+}
+
+func (f *fakePromptClient) SendPrompt(_ context.Context, in *prompt_api.SendPromptRequest,
+	_ ...grpc.CallOption) (*prompt_api.SendPromptResponse, error) {
+	f.requests = append(f.requests, in)
+	return &prompt_api.SendPromptResponse{
+		Status:        in.GetPrompt() != "blocked",
+		OptionalScore: &prompt_api.SendPromptResponse_Score{Score: 10},
+	}, nil
+}
+
+type fakePromptClientV2 struct {
+	prompt_api.PromptServiceV2Client
+	requests []*prompt_api.EvaluateRequest
+}
+
+func (f *fakePromptClientV2) Evaluate(_ context.Context, in *prompt_api.EvaluateRequest,
+	_ ...grpc.CallOption) (*prompt_api.EvaluateResponse, error) {
+	f.requests = append(f.requests, in)
+	if in.GetPrompt() != "blocked" {
+		return &prompt_api.EvaluateResponse{Allowed: true, PluginVersion: "2.0.0"}, nil
+	}
+	return &prompt_api.EvaluateResponse{
+		OptionalScore: &prompt_api.EvaluateResponse_Score{Score: 90},
+		Categories:    []*prompt_api.CategoryScore{{Name: "jailbreak", Confidence: 0.9}},
+		Reason:        "asks to ignore the instructions",
+		Redactions:    []*prompt_api.RedactionSpan{{Start: 0, End: 7, Replacement: "[REDACTED]"}},
+		PluginVersion: "2.0.0",
+	}, nil
+}
+
+func TestGRPCClient_Forward(t *testing.T) {
+	t.Parallel()
+
+	organizationID := primitive.NewObjectID()
+	reqBody := &models.PluginRequest{
+		UserID:         primitive.NewObjectID(),
+		Prompt:         "blocked",
+		History:        []models.ChatMessage{{Role: models.RoleUser, Content: "hi"}},
+		RequestID:      "req-1",
+		OrganizationID: organizationID,
+		TaskType:       "jailbreak",
+	}
+
+	t.Run("v1 plugins", func(t *testing.T) {
+		t.Parallel()
+
+		v1, v2 := new(fakePromptClient), new(fakePromptClientV2)
+		client := &GRPCClient{Client: v1, ClientV2: v2}
+
+		resp, err := client.Forward(context.Background(), &entities.Plugin{}, reqBody)
+		require.NoError(t, err)
+		require.Equal(t, &models.PluginResponse{Status: false, Score: 10}, resp)
+		require.Len(t, v1.requests, 1)
+		require.Empty(t, v2.requests)
+	})
+
+	t.Run("v2 plugins", func(t *testing.T) {
+		t.Parallel()
+
+		v1, v2 := new(fakePromptClient), new(fakePromptClientV2)
+		client := &GRPCClient{Client: v1, ClientV2: v2}
+		plugin := &entities.Plugin{Capabilities: &entities.PluginCapabilities{APIVersion: entities.PluginAPIV2}}
+
+		resp, err := client.Forward(context.Background(), plugin, reqBody)
+		require.NoError(t, err)
+		require.Equal(t, &models.PluginResponse{
+			Score:         90,
+			Categories:    []models.CategoryScore{{Name: "jailbreak", Confidence: 0.9}},
+			Reason:        "asks to ignore the instructions",
+			Redactions:    []models.RedactionSpan{{Start: 0, End: 7, Replacement: "[REDACTED]"}},
+			PluginVersion: "2.0.0",
+		}, resp)
+		require.Empty(t, v1.requests)
+		require.Len(t, v2.requests, 1)
+
+		req := v2.requests[0]
+		require.Equal(t, "hi", req.GetHistory()[0].GetContent())
+		require.Equal(t, "req-1", req.GetMetadata().GetRequestId())
+		require.Equal(t, organizationID.Hex(), req.GetMetadata().GetOrganizationId())
+		require.Equal(t, "jailbreak", req.GetMetadata().GetTaskType())
+		require.Equal(t, reqBody.UserID.Hex(), req.GetMetadata().GetUserId())
+		require.Empty(t, req.GetMetadata().GetTargetId())
+	})
+}
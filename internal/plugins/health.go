@@ -0,0 +1,164 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultHealthPath = "/health"
+
+var (
+	ErrPluginUnhealthy = errors.New("plugin is unhealthy")
+	ErrPluginDegraded  = errors.New("plugin is degraded")
+)
+
+// DefaultHealth holds the health of the plugins, as the health checks last saw it.
+var DefaultHealth = NewHealthRegistry()
+
+// HealthRegistry keeps the outcome of the health checks of every plugin.
+type HealthRegistry struct {
+	mu     sync.RWMutex
+	health map[primitive.ObjectID]models.PluginHealth
+}
+
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{health: make(map[primitive.ObjectID]models.PluginHealth)}
+}
+
+// Record stores the outcome of a health check of the plugin. The plugin is degraded once threshold checks in a row
+// failed, and healthy again as soon as a check succeeds.
+func (r *HealthRegistry) Record(plugin *entities.Plugin, latency time.Duration, checkErr error,
+	threshold int) models.PluginHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	health := r.health[plugin.ID]
+	health.PluginID = plugin.ID
+	health.Name = plugin.Name
+	health.LatencyMS = latency.Milliseconds()
+	health.LastChecked = time.Now()
+	if checkErr == nil {
+		health.Status = models.PluginHealthHealthy
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+	} else {
+		health.ConsecutiveFailures++
+		health.LastError = checkErr.Error()
+		if health.ConsecutiveFailures >= max(threshold, 1) {
+			health.Status = models.PluginHealthDegraded
+		} else if health.Status == "" {
+			health.Status = models.PluginHealthUnknown
+		}
+	}
+	r.health[plugin.ID] = health
+	return health
+}
+
+// Get returns the health of the plugin. Plugins that weren't checked yet have an unknown status.
+func (r *HealthRegistry) Get(plugin *entities.Plugin) models.PluginHealth {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	health, exists := r.health[plugin.ID]
+	if !exists {
+		return models.PluginHealth{PluginID: plugin.ID, Name: plugin.Name, Status: models.PluginHealthUnknown}
+	}
+	return health
+}
+
+func (r *HealthRegistry) IsDegraded(pluginID primitive.ObjectID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.health[pluginID].Status == models.PluginHealthDegraded
+}
+
+// Retain forgets the plugins other than the given ones, e.g. the ones deleted or deactivated since the last checks,
+// and returns the forgotten ones.
+func (r *HealthRegistry) Retain(pluginIDs map[primitive.ObjectID]struct{}) []models.PluginHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var forgotten []models.PluginHealth
+	for id, health := range r.health {
+		if _, exists := pluginIDs[id]; !exists {
+			forgotten = append(forgotten, health)
+			delete(r.health, id)
+		}
+	}
+	return forgotten
+}
+
+// Check sends a GET request to the health path of an HTTP plugin, which is healthy if it answers with a 2xx status.
+func (h *HTTPClient) Check(ctx context.Context, plugin *entities.Plugin) error {
+	address, err := healthURL(plugin)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new request: %w", err)
+	}
+	err = authenticate(req, plugin, nil)
+	if err != nil {
+		return err
+	}
+
+	client, err := h.clientFor(plugin)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPluginUnhealthy, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: status %d", ErrPluginUnhealthy, resp.StatusCode)
+	}
+	return nil
+}
+
+// Check asks a gRPC plugin for its overall health with the standard gRPC health protocol.
+func (g *GRPCClient) Check(ctx context.Context, plugin *entities.Plugin) error {
+	ctx, err := authenticateGRPC(ctx, plugin)
+	if err != nil {
+		return err
+	}
+
+	resp, err := g.Health.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPluginUnhealthy, err)
+	}
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%w: %s", ErrPluginUnhealthy, resp.GetStatus())
+	}
+	return nil
+}
+
+func healthURL(plugin *entities.Plugin) (string, error) {
+	address, err := url.Parse(plugin.Address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPluginUnhealthy, err)
+	}
+	address.Path = plugin.HealthPath
+	if address.Path == "" {
+		address.Path = defaultHealthPath
+	}
+	address.RawPath = ""
+	address.RawQuery = ""
+	address.Fragment = ""
+	return address.String(), nil
+}
@@ -0,0 +1,87 @@
+package plugins
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHTTPClient_Check(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		healthPath string
+		status     int
+		expectErr  bool
+	}{
+		{name: "default path", status: http.StatusOK},
+		{name: "custom path", healthPath: "/ready", status: http.StatusNoContent},
+		{name: "unhealthy", status: http.StatusServiceUnavailable, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			expectedPath := tt.healthPath
+			if expectedPath == "" {
+				expectedPath = defaultHealthPath
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != expectedPath ||
+					r.Header.Get("Authorization") != "Bearer plugin-token" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			plugin := &entities.Plugin{
+				ID:         primitive.NewObjectID(),
+				Address:    server.URL + "/check?v=1",
+				Token:      "plugin-token",
+				HealthPath: tt.healthPath,
+			}
+			err := NewHTTPClient(server.Client()).Check(context.Background(), plugin)
+			if tt.expectErr {
+				require.ErrorIs(t, err, ErrPluginUnhealthy)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHealthRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := NewHealthRegistry()
+	plugin := &entities.Plugin{ID: primitive.NewObjectID(), Name: "plugin"}
+	require.Equal(t, models.PluginHealthUnknown, registry.Get(plugin).Status)
+
+	registry.Record(plugin, time.Millisecond, ErrPluginUnhealthy, 2)
+	require.False(t, registry.IsDegraded(plugin.ID))
+	health := registry.Record(plugin, time.Millisecond, ErrPluginUnhealthy, 2)
+	require.Equal(t, models.PluginHealthDegraded, health.Status)
+	require.True(t, registry.IsDegraded(plugin.ID))
+
+	health = registry.Record(plugin, 5*time.Millisecond, nil, 2)
+	require.Equal(t, models.PluginHealthHealthy, health.Status)
+	require.Equal(t, int64(5), health.LatencyMS)
+	require.Empty(t, health.LastError)
+	require.False(t, registry.IsDegraded(plugin.ID))
+
+	forgotten := registry.Retain(map[primitive.ObjectID]struct{}{})
+	require.Len(t, forgotten, 1)
+	require.Equal(t, models.PluginHealthUnknown, registry.Get(plugin).Status)
+}
@@ -0,0 +1,154 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/prompt_api"
+)
+
+var ErrOutputViolation = errors.New("a plugin flagged the output")
+
+// OutputSubscriber is a plugin moderating output, and the client to stream the output to it with.
+type OutputSubscriber struct {
+	Plugin *entities.Plugin
+	Client *GRPCClient
+}
+
+// OutputModerator streams the completion of a target model to the plugins moderating output. Once a plugin flags the
+// output, or a stream fails, the moderator is aborted: every stream is closed and the caller is expected to stop
+// streaming the completion to the user.
+type OutputModerator struct {
+	cancel  context.CancelFunc
+	streams []outputStream
+	index   uint32
+	wg      sync.WaitGroup
+
+	abortOnce sync.Once
+	aborted   chan struct{}
+	err       error
+}
+
+type outputStream struct {
+	plugin *entities.Plugin
+	stream prompt_api.PromptServiceV2_ModerateOutputClient
+}
+
+// ModerateOutput opens a stream to the plugin and sends it the request the completion answers.
+func (g *GRPCClient) ModerateOutput(ctx context.Context, plugin *entities.Plugin, reqBody *models.PluginRequest) (
+	prompt_api.PromptServiceV2_ModerateOutputClient, error,
+) {
+	ctx, err := authenticateGRPC(ctx, plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := g.ClientV2.ModerateOutput(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
+	}
+	err = stream.Send(&prompt_api.OutputChunk{Request: evaluateRequest(reqBody)})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
+	}
+	return stream, nil
+}
+
+// NewOutputModerator opens a stream to every subscriber. The streams live until Close is called or ctx is done.
+func NewOutputModerator(ctx context.Context, subscribers []OutputSubscriber, reqBody *models.PluginRequest) (
+	*OutputModerator, error,
+) {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &OutputModerator{cancel: cancel, aborted: make(chan struct{})}
+
+	for _, subscriber := range subscribers {
+		stream, err := subscriber.Client.ModerateOutput(ctx, subscriber.Plugin, reqBody)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("%s: %w", subscriber.Plugin.Name, err)
+		}
+		m.streams = append(m.streams, outputStream{plugin: subscriber.Plugin, stream: stream})
+	}
+
+	for _, stream := range m.streams {
+		m.wg.Add(1)
+		go m.receive(stream)
+	}
+	return m, nil
+}
+
+// Send streams a chunk of the completion to the plugins. It returns the reason the moderator was aborted, if it was.
+func (m *OutputModerator) Send(chunk string) error {
+	err := m.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range m.streams {
+		err = s.stream.Send(&prompt_api.OutputChunk{Index: m.index, Content: chunk})
+		// The plugin ended the stream when sending returns io.EOF, which the receiver reports on.
+		if err != nil && !errors.Is(err, io.EOF) {
+			m.abort(fmt.Errorf("%w: %s: %w", ErrForwardRequest, s.plugin.Name, err))
+		}
+	}
+	m.index++
+	return m.Err()
+}
+
+// Aborted is closed once a plugin flags the output or a stream fails.
+func (m *OutputModerator) Aborted() <-chan struct{} {
+	return m.aborted
+}
+
+// Err returns why the moderator was aborted, or nil if it wasn't.
+func (m *OutputModerator) Err() error {
+	select {
+	case <-m.aborted:
+		return m.err
+	default:
+		return nil
+	}
+}
+
+// Close tells the plugins the completion is over and waits for their last verdicts.
+func (m *OutputModerator) Close() error {
+	for _, s := range m.streams {
+		_ = s.stream.CloseSend()
+	}
+	m.wg.Wait()
+	m.cancel()
+	return m.Err()
+}
+
+func (m *OutputModerator) receive(s outputStream) {
+	defer m.wg.Done()
+
+	for {
+		verdict, err := s.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			m.abort(fmt.Errorf("%w: %s: %w", ErrPluginResponseFailed, s.plugin.Name, err))
+			return
+		}
+		if !verdict.GetAllowed() {
+			m.abort(fmt.Errorf("%w: %s at chunk %d: %s", ErrOutputViolation, s.plugin.Name, verdict.GetChunkIndex(),
+				verdict.GetReason()))
+			return
+		}
+	}
+}
+
+func (m *OutputModerator) abort(err error) {
+	m.abortOnce.Do(func() {
+		m.err = err
+		close(m.aborted)
+		m.cancel()
+	})
+}
@@ -0,0 +1,141 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/prompt_api"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// moderatingPlugin flags the output once it mentions a secret.
+type moderatingPlugin struct {
+	prompt_api.UnimplementedPromptServiceV2Server
+	mu     sync.Mutex
+	prompt string
+	chunks []string
+}
+
+func (p *moderatingPlugin) ModerateOutput(stream prompt_api.PromptServiceV2_ModerateOutputServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.prompt = first.GetRequest().GetPrompt()
+	p.mu.Unlock()
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.chunks = append(p.chunks, chunk.GetContent())
+		p.mu.Unlock()
+
+		if strings.Contains(chunk.GetContent(), "secret") {
+			return stream.Send(&prompt_api.OutputVerdict{ChunkIndex: chunk.GetIndex(), Reason: "leaks a secret"})
+		}
+		err = stream.Send(&prompt_api.OutputVerdict{Allowed: true, ChunkIndex: chunk.GetIndex()})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func newModeratingPlugin(t *testing.T) (*moderatingPlugin, OutputSubscriber) {
+	t.Helper()
+
+	plugin := new(moderatingPlugin)
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	prompt_api.RegisterPromptServiceV2Server(server, plugin)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return plugin, OutputSubscriber{
+		Plugin: &entities.Plugin{Name: "leaks", Protocol: entities.Protocol{Type: entities.GRPCProtocol},
+			Capabilities: &entities.PluginCapabilities{APIVersion: entities.PluginAPIV2, ModeratesOutput: true}},
+		Client: NewPluginGRPCClient(conn),
+	}
+}
+
+func TestOutputModerator(t *testing.T) {
+	t.Parallel()
+
+	reqBody := &models.PluginRequest{Prompt: "tell me a story"}
+
+	t.Run("streams the output", func(t *testing.T) {
+		t.Parallel()
+
+		plugin, subscriber := newModeratingPlugin(t)
+		moderator, err := NewOutputModerator(context.Background(), []OutputSubscriber{subscriber}, reqBody)
+		require.NoError(t, err)
+
+		require.NoError(t, moderator.Send("Once upon "))
+		require.NoError(t, moderator.Send("a time"))
+		require.NoError(t, moderator.Close())
+
+		plugin.mu.Lock()
+		defer plugin.mu.Unlock()
+		require.Equal(t, "tell me a story", plugin.prompt)
+		require.Equal(t, []string{"Once upon ", "a time"}, plugin.chunks)
+	})
+
+	t.Run("aborts on violations", func(t *testing.T) {
+		t.Parallel()
+
+		_, subscriber := newModeratingPlugin(t)
+		_, other := newModeratingPlugin(t)
+		moderator, err := NewOutputModerator(context.Background(), []OutputSubscriber{subscriber, other}, reqBody)
+		require.NoError(t, err)
+
+		require.NoError(t, moderator.Send("the password is "))
+		_ = moderator.Send("secret")
+		select {
+		case <-moderator.Aborted():
+		case <-time.After(5 * time.Second):
+			t.Fatal("the moderator wasn't aborted")
+		}
+
+		require.ErrorIs(t, moderator.Err(), ErrOutputViolation)
+		require.ErrorContains(t, moderator.Err(), "leaks a secret")
+		require.ErrorIs(t, moderator.Send("123"), ErrOutputViolation)
+		require.ErrorIs(t, moderator.Close(), ErrOutputViolation)
+	})
+
+	t.Run("without subscribers", func(t *testing.T) {
+		t.Parallel()
+
+		moderator, err := NewOutputModerator(context.Background(), nil, reqBody)
+		require.NoError(t, err)
+		require.NoError(t, moderator.Send("anything"))
+		require.NoError(t, moderator.Close())
+	})
+}
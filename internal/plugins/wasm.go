@@ -0,0 +1,230 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+var (
+	ErrInvalidModule = errors.New("invalid wasm module")
+	ErrModuleFailed  = errors.New("wasm module failed")
+)
+
+const wasmPageSize = 64 * 1024
+
+// MaxModuleSize bounds the WebAssembly modules of wasm plugins, whether uploaded or read from disk.
+const MaxModuleSize = 8 << 20
+
+// DefaultWasm runs the modules of wasm plugins for the pipeline, once the runtime is configured.
+var DefaultWasm *WasmRuntime
+
+// WasmRuntime runs the WebAssembly modules of wasm plugins in-process, in a sandbox with WASI but without access to
+// the file system, network or clock beyond what WASI emulates. Every prompt gets a fresh instance of the module, which
+// may use up to the runtime's memory limit and is stopped once the timeout is up. Modules on disk are read from
+// moduleDir, which plugins cannot escape.
+//
+// Modules export their memory, alloc(size i32) i32, which returns where to write a request of size bytes, and
+// evaluate(ptr i32, len i32) i64. evaluate is passed the PluginRequest as JSON and returns where its PluginResponse,
+// as JSON, is in memory: the pointer in the upper 32 bits and the length in the lower 32 bits.
+type WasmRuntime struct {
+	runtime   wazero.Runtime
+	moduleDir string
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	modules map[string]wazero.CompiledModule
+}
+
+func NewWasmRuntime(moduleDir string, memoryLimitMB int, timeout time.Duration) *WasmRuntime {
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memoryLimitMB * 1024 * 1024 / wasmPageSize)).
+		WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	return &WasmRuntime{
+		runtime:   runtime,
+		moduleDir: moduleDir,
+		timeout:   timeout,
+		modules:   make(map[string]wazero.CompiledModule),
+	}
+}
+
+func (w *WasmRuntime) Forward(ctx context.Context, plugin *entities.Plugin,
+	reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+	compiled, err := w.compile(ctx, plugin)
+	if err != nil {
+		return nil, err
+	}
+	req, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	module, err := w.runtime.InstantiateModule(ctx, compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModuleFailed, err)
+	}
+	defer module.Close(context.Background())
+
+	resp, err := evaluate(ctx, module, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrModuleFailed, plugin.Name, err)
+	}
+	var result models.PluginResponse
+	err = json.Unmarshal(resp, &result)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: failed to decode response: %w", ErrModuleFailed, plugin.Name, err)
+	}
+	return &result, nil
+}
+
+// Check reports wasm plugins whose module can't be loaded or doesn't export the functions plugins must as unhealthy.
+func (w *WasmRuntime) Check(ctx context.Context, plugin *entities.Plugin) error {
+	_, err := w.compile(ctx, plugin)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPluginUnhealthy, err)
+	}
+	return nil
+}
+
+// compile returns the compiled module of the plugin. Modules are compiled once and told apart by their code, or the
+// size and modification time of their file, so that a plugin whose module changed gets the new one. Modules are loaded
+// and compiled outside the lock, so a slow module doesn't hold up plugins whose modules are compiled already.
+func (w *WasmRuntime) compile(ctx context.Context, plugin *entities.Plugin) (wazero.CompiledModule, error) {
+	key, load, err := w.moduleSource(plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	compiled, ok := w.modules[key]
+	w.mu.Unlock()
+	if ok {
+		return compiled, nil
+	}
+
+	code, err := load()
+	if err != nil {
+		return nil, err
+	}
+	compiled, err = w.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidModule, err)
+	}
+	exports := compiled.ExportedFunctions()
+	if exports["alloc"] == nil || exports["evaluate"] == nil || len(compiled.ExportedMemories()) == 0 {
+		_ = compiled.Close(ctx)
+		return nil, fmt.Errorf("%w: modules must export memory, alloc and evaluate", ErrInvalidModule)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if existing, ok := w.modules[key]; ok {
+		_ = compiled.Close(ctx)
+		return existing, nil
+	}
+	w.modules[key] = compiled
+	return compiled, nil
+}
+
+func (w *WasmRuntime) moduleSource(plugin *entities.Plugin) (string, func() ([]byte, error), error) {
+	switch {
+	case plugin.Module == nil:
+		return "", nil, fmt.Errorf("%w: %s has no module", ErrInvalidModule, plugin.Name)
+	case len(plugin.Module.Code) > MaxModuleSize:
+		return "", nil, fmt.Errorf("%w: the module is larger than %d bytes", ErrInvalidModule, MaxModuleSize)
+	case len(plugin.Module.Code) > 0:
+		code := plugin.Module.Code
+		return fmt.Sprintf("code:%x", sha256.Sum256(code)), func() ([]byte, error) { return code, nil }, nil
+	case plugin.Module.Path != "":
+		path, info, err := moduleFile(w.moduleDir, plugin.Module.Path)
+		if err != nil {
+			return "", nil, err
+		}
+		key := fmt.Sprintf("path:%s:%d:%d", path, info.ModTime().UnixNano(), info.Size())
+		return key, func() ([]byte, error) { return readModule(path, plugin.Module.Path) }, nil
+	default:
+		return "", nil, fmt.Errorf("%w: %s has no module", ErrInvalidModule, plugin.Name)
+	}
+}
+
+// moduleFile resolves name, a path relative to moduleDir, and checks that it is a regular file.
+func moduleFile(moduleDir, name string) (string, os.FileInfo, error) {
+	if moduleDir == "" {
+		return "", nil, fmt.Errorf("%w: no module directory is configured", ErrInvalidModule)
+	}
+	name = filepath.Clean(name)
+	if !filepath.IsLocal(name) {
+		return "", nil, fmt.Errorf("%w: %s is outside the module directory", ErrInvalidModule, name)
+	}
+	path := filepath.Join(moduleDir, name)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("%w: %s is not a readable file", ErrInvalidModule, name)
+	}
+	if info.Size() > MaxModuleSize {
+		return "", nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalidModule, name, MaxModuleSize)
+	}
+	return path, info, nil
+}
+
+// readModule reads the module at path, which may have grown since it was checked, up to MaxModuleSize bytes.
+func readModule(path, name string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a readable file", ErrInvalidModule, name)
+	}
+	defer file.Close()
+
+	code, err := io.ReadAll(io.LimitReader(file, MaxModuleSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a readable file", ErrInvalidModule, name)
+	}
+	if len(code) > MaxModuleSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalidModule, name, MaxModuleSize)
+	}
+	return code, nil
+}
+
+func evaluate(ctx context.Context, module api.Module, req []byte) ([]byte, error) {
+	results, err := module.ExportedFunction("alloc").Call(ctx, uint64(len(req)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(results[0])
+	if !module.Memory().Write(ptr, req) {
+		return nil, errors.New("alloc returned memory out of range")
+	}
+
+	results, err = module.ExportedFunction("evaluate").Call(ctx, uint64(ptr), uint64(len(req)))
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := module.Memory().Read(uint32(results[0]>>32), uint32(results[0]))
+	if !ok {
+		return nil, errors.New("evaluate returned memory out of range")
+	}
+	return resp, nil
+}
@@ -0,0 +1,142 @@
+package plugins
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verdictModule blocks requests longer than 200 bytes:
+//
+//	(module
+//	  (memory (export "memory") 1)
+//	  (func (export "alloc") (param i32) (result i32) (i32.const 1024))
+//	  (func (export "evaluate") (param i32 i32) (result i64)
+//	    (if (result i64) (i32.gt_u (local.get 1) (i32.const 200))
+//	      (then (i64.const 0x100000003d)) (else (i64.const 0x800000000f))))
+//	  (data (i32.const 16) "{\"status\":false,\"score\":90,\"reason\":\"the prompt is too long\"}")
+//	  (data (i32.const 128) "{\"status\":true}"))
+var verdictModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, 0x03, 0x03, 0x02, 0x00, 0x01, 0x05, 0x03, 0x01, 0x00, 0x01,
+	0x07, 0x1d, 0x03, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c,
+	0x6f, 0x63, 0x00, 0x00, 0x08, 0x65, 0x76, 0x61, 0x6c, 0x75, 0x61, 0x74, 0x65, 0x00, 0x01, 0x0a,
+	0x22, 0x02, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x1a, 0x00, 0x20, 0x01, 0x41, 0xc8, 0x01, 0x4b,
+	0x04, 0x7e, 0x42, 0xbd, 0x80, 0x80, 0x80, 0x80, 0x02, 0x05, 0x42, 0x8f, 0x80, 0x80, 0x80, 0x80,
+	0x10, 0x0b, 0x0b, 0x0b, 0x58, 0x02, 0x00, 0x41, 0x10, 0x0b, 0x3d, 0x7b, 0x22, 0x73, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x22, 0x3a, 0x66, 0x61, 0x6c, 0x73, 0x65, 0x2c, 0x22, 0x73, 0x63, 0x6f, 0x72,
+	0x65, 0x22, 0x3a, 0x39, 0x30, 0x2c, 0x22, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22, 0x3a, 0x22,
+	0x74, 0x68, 0x65, 0x20, 0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x20, 0x69, 0x73, 0x20, 0x74, 0x6f,
+	0x6f, 0x20, 0x6c, 0x6f, 0x6e, 0x67, 0x22, 0x7d, 0x00, 0x41, 0x80, 0x01, 0x0b, 0x0f, 0x7b, 0x22,
+	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x3a, 0x74, 0x72, 0x75, 0x65, 0x7d,
+}
+
+// loopModule never returns from evaluate.
+var loopModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, 0x03, 0x03, 0x02, 0x00, 0x01, 0x05, 0x03, 0x01, 0x00, 0x01,
+	0x07, 0x1d, 0x03, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c,
+	0x6f, 0x63, 0x00, 0x00, 0x08, 0x65, 0x76, 0x61, 0x6c, 0x75, 0x61, 0x74, 0x65, 0x00, 0x01, 0x0a,
+	0x11, 0x02, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x09, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42,
+	0x00, 0x0b,
+}
+
+// largeModule asks for 2 MiB of memory.
+var largeModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, 0x03, 0x03, 0x02, 0x00, 0x01, 0x05, 0x03, 0x01, 0x00, 0x20,
+	0x07, 0x1d, 0x03, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c,
+	0x6f, 0x63, 0x00, 0x00, 0x08, 0x65, 0x76, 0x61, 0x6c, 0x75, 0x61, 0x74, 0x65, 0x00, 0x01, 0x0a,
+	0x0c, 0x02, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x04, 0x00, 0x42, 0x00, 0x0b,
+}
+
+func TestWasmRuntime_Forward(t *testing.T) {
+	t.Parallel()
+
+	runtime := NewWasmRuntime("", 1, 100*time.Millisecond)
+	plugin := &entities.Plugin{Name: "length", Protocol: entities.Protocol{Type: entities.WasmProtocol},
+		Module: &entities.WasmModule{Code: verdictModule}}
+
+	resp, err := runtime.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+	require.NoError(t, err)
+	assert.True(t, resp.Status)
+
+	resp, err = runtime.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: strings.Repeat("a", 300)})
+	require.NoError(t, err)
+	assert.False(t, resp.Status)
+	assert.Equal(t, uint32(90), resp.Score)
+	assert.Equal(t, "the prompt is too long", resp.Reason)
+}
+
+func TestWasmRuntime_ModuleOnDisk(t *testing.T) {
+	t.Parallel()
+
+	moduleDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "length.wasm"), verdictModule, 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(moduleDir, "modules.wasm"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "large.wasm"), make([]byte, MaxModuleSize+1), 0o600))
+	runtime := NewWasmRuntime(moduleDir, 1, time.Second)
+
+	plugin := &entities.Plugin{Name: "length", Module: &entities.WasmModule{Path: "./length.wasm"}}
+	resp, err := runtime.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+	require.NoError(t, err)
+	assert.True(t, resp.Status)
+
+	for _, path := range []string{"missing.wasm", "modules.wasm", "large.wasm", "../length.wasm",
+		filepath.Join(moduleDir, "length.wasm")} {
+		plugin := &entities.Plugin{Name: "length", Module: &entities.WasmModule{Path: path}}
+		_, err := runtime.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+		require.ErrorIs(t, err, ErrInvalidModule, path)
+		assert.NotContains(t, err.Error(), "no such file", path)
+	}
+
+	_, err = NewWasmRuntime("", 1, time.Second).Forward(context.Background(), plugin,
+		&models.PluginRequest{Prompt: "hi"})
+	require.ErrorIs(t, err, ErrInvalidModule)
+}
+
+func TestWasmRuntime_Limits(t *testing.T) {
+	t.Parallel()
+
+	runtime := NewWasmRuntime("", 1, 100*time.Millisecond)
+
+	t.Run("stops modules running past the timeout", func(t *testing.T) {
+		t.Parallel()
+
+		plugin := &entities.Plugin{Name: "loop", Module: &entities.WasmModule{Code: loopModule}}
+		start := time.Now()
+		_, err := runtime.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+		require.ErrorIs(t, err, ErrModuleFailed)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("rejects modules asking for more memory than the limit", func(t *testing.T) {
+		t.Parallel()
+
+		plugin := &entities.Plugin{Name: "large", Module: &entities.WasmModule{Code: largeModule}}
+		_, err := runtime.Forward(context.Background(), plugin, &models.PluginRequest{Prompt: "hi"})
+		require.ErrorIs(t, err, ErrInvalidModule)
+	})
+}
+
+func TestWasmRuntime_Check(t *testing.T) {
+	t.Parallel()
+
+	runtime := NewWasmRuntime("", 1, time.Second)
+	require.NoError(t, runtime.Check(context.Background(), &entities.Plugin{
+		Module: &entities.WasmModule{Code: verdictModule}}))
+	require.ErrorIs(t, runtime.Check(context.Background(), &entities.Plugin{
+		Module: &entities.WasmModule{Code: []byte("not wasm")}}), ErrPluginUnhealthy)
+	require.ErrorIs(t, runtime.Check(context.Background(), &entities.Plugin{
+		Module: &entities.WasmModule{Code: make([]byte, MaxModuleSize+1)}}), ErrInvalidModule)
+	require.ErrorIs(t, runtime.Check(context.Background(), &entities.Plugin{}), ErrPluginUnhealthy)
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+)
+
+var ErrInvalidResponse = errors.New("invalid response from the target model")
+
+// Adapter translates chat requests into a provider's wire format and the provider's responses back, so that clients
+// don't have to know which provider is behind a target model.
+type Adapter interface {
+	NewRequest(ctx context.Context, method, address string, req *models.ChatRequest, model *entities.TargetModel) (
+		*http.Request, error)
+	// ParseResponse normalizes a successful response. Error responses are returned as the provider sent them.
+	ParseResponse(resp *http.Response) (*http.Response, error)
+}
+
+// ForProvider returns the adapter for the provider of a target model. Unknown providers get the request as the client
+// sent it.
+func ForProvider(provider string) Adapter {
+	switch strings.ToLower(provider) {
+	case "openai", "azure", "azure_openai":
+		return OpenAI{}
+	case "anthropic":
+		return Anthropic{}
+	case "ollama":
+		return Ollama{}
+	default:
+		return Passthrough{}
+	}
+}
+
+func newJSONRequest(ctx context.Context, address string, payload interface{}) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// normalize decodes a successful provider response with decode and replaces its body with the chat response.
+func normalize(resp *http.Response, decode func(body []byte) (*models.ChatResponse, error)) (*http.Response, error) {
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	chatResponse, err := decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	normalized, err := json.Marshal(chatResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(normalized))
+	resp.ContentLength = int64(len(normalized))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(normalized)))
+	resp.Header.Del("Content-Encoding")
+	return resp, nil
+}
+
+// splitSystem separates the system messages, which some providers take apart from the conversation.
+func splitSystem(messages []models.ChatMessage) (string, []models.ChatMessage) {
+	var system []string
+	conversation := make([]models.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		if message.Role == models.RoleSystem {
+			system = append(system, message.Content)
+			continue
+		}
+		conversation = append(conversation, message)
+	}
+	return strings.Join(system, "\n\n"), conversation
+}
@@ -0,0 +1,163 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdapters(t *testing.T) {
+	t.Parallel()
+
+	temperature := 0.2
+	chatReq := &models.ChatRequest{
+		Messages: []models.ChatMessage{
+			{Role: models.RoleSystem, Content: "Be brief."},
+			{Role: models.RoleUser, Content: "Hello"},
+		},
+		MaxTokens:   64,
+		Temperature: &temperature,
+		Raw:         []byte(`{"prompt":"Hello"}`),
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		// expected is the request the fake provider must receive.
+		expected string
+		// reply is what the fake provider answers.
+		reply    string
+		response string
+	}{
+		{
+			name:     "openai",
+			provider: "openai",
+			expected: `{"model":"m","messages":[{"role":"system","content":"Be brief."},` +
+				`{"role":"user","content":"Hello"}],"max_tokens":64,"temperature":0.2}`,
+			reply: `{"model":"m-1","choices":[{"message":{"role":"assistant","content":"Hi"},` +
+				`"finish_reason":"stop"}],"usage":{"prompt_tokens":7,"completion_tokens":2}}`,
+			response: `{"model":"m-1","message":{"role":"assistant","content":"Hi"},"finish_reason":"stop",` +
+				`"usage":{"input_tokens":7,"output_tokens":2}}`,
+		},
+		{
+			name:     "anthropic",
+			provider: "anthropic",
+			expected: `{"model":"m","system":"Be brief.","messages":[{"role":"user","content":"Hello"}],` +
+				`"max_tokens":64,"temperature":0.2}`,
+			reply: `{"model":"m-1","role":"assistant","content":[{"type":"text","text":"Hi"}],` +
+				`"stop_reason":"end_turn","usage":{"input_tokens":7,"output_tokens":2}}`,
+			response: `{"model":"m-1","message":{"role":"assistant","content":"Hi"},"finish_reason":"end_turn",` +
+				`"usage":{"input_tokens":7,"output_tokens":2}}`,
+		},
+		{
+			name:     "ollama",
+			provider: "ollama",
+			expected: `{"model":"m","messages":[{"role":"system","content":"Be brief."},` +
+				`{"role":"user","content":"Hello"}],"stream":false,"options":{"temperature":0.2,"num_predict":64}}`,
+			reply: `{"model":"m-1","message":{"role":"assistant","content":"Hi"},"done_reason":"stop",` +
+				`"prompt_eval_count":7,"eval_count":2}`,
+			response: `{"model":"m-1","message":{"role":"assistant","content":"Hi"},"finish_reason":"stop",` +
+				`"usage":{"input_tokens":7,"output_tokens":2}}`,
+		},
+		{
+			name:     "passthrough",
+			provider: "custom",
+			expected: `{"prompt":"Hello"}`,
+			reply:    `{"answer":"Hi"}`,
+			response: `{"answer":"Hi"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil || !jsonEqual(tt.expected, string(body)) {
+					http.Error(w, "unexpected request: "+string(body), http.StatusBadRequest)
+					return
+				}
+				if tt.provider == "anthropic" && r.Header.Get("anthropic-version") == "" {
+					http.Error(w, "missing anthropic-version", http.StatusBadRequest)
+					return
+				}
+				_, _ = w.Write([]byte(tt.reply))
+			}))
+			defer server.Close()
+
+			adapter := ForProvider(tt.provider)
+			req, err := adapter.NewRequest(context.Background(), http.MethodPost, server.URL, chatReq,
+				&entities.TargetModel{Name: "m", Provider: tt.provider})
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			resp, err = adapter.ParseResponse(resp)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			require.JSONEq(t, tt.response, string(body))
+		})
+	}
+}
+
+func TestAdapters_ErrorResponses(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"error":"rate limited"}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	adapter := ForProvider("openai")
+	req, err := adapter.NewRequest(context.Background(), http.MethodPost, server.URL, &models.ChatRequest{},
+		&entities.TargetModel{Name: "m"})
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	resp, err = adapter.ParseResponse(resp)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestAdapters_InvalidResponse(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer server.Close()
+
+	adapter := ForProvider("openai")
+	req, err := adapter.NewRequest(context.Background(), http.MethodPost, server.URL, &models.ChatRequest{},
+		&entities.TargetModel{Name: "m"})
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	_, err = adapter.ParseResponse(resp)
+	require.ErrorIs(t, err, ErrInvalidResponse)
+}
+
+func jsonEqual(expected, actual string) bool {
+	var e, a interface{}
+	if json.Unmarshal([]byte(expected), &e) != nil || json.Unmarshal([]byte(actual), &a) != nil {
+		return false
+	}
+	expectedJSON, _ := json.Marshal(e)
+	actualJSON, _ := json.Marshal(a)
+	return string(expectedJSON) == string(actualJSON)
+}
@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is used when the client doesn't limit the response, as the messages API requires a limit.
+	anthropicMaxTokens = 1024
+)
+
+// Anthropic speaks the messages API of Anthropic.
+type Anthropic struct{}
+
+type anthropicRequest struct {
+	Model       string               `json:"model"`
+	System      string               `json:"system,omitempty"`
+	Messages    []models.ChatMessage `json:"messages"`
+	MaxTokens   int                  `json:"max_tokens"`
+	Temperature *float64             `json:"temperature,omitempty"`
+}
+
+type anthropicResponse struct {
+	Model   string `json:"model"`
+	Role    string `json:"role"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+func (Anthropic) NewRequest(ctx context.Context, _, address string, req *models.ChatRequest,
+	model *entities.TargetModel,
+) (*http.Request, error) {
+	system, messages := splitSystem(req.Messages)
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = anthropicMaxTokens
+	}
+
+	httpReq, err := newJSONRequest(ctx, address, anthropicRequest{
+		Model:       model.Name,
+		System:      system,
+		Messages:    messages,
+		MaxTokens:   maxTokens,
+		Temperature: req.Temperature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	return httpReq, nil
+}
+
+func (Anthropic) ParseResponse(resp *http.Response) (*http.Response, error) {
+	return normalize(resp, func(body []byte) (*models.ChatResponse, error) {
+		var message anthropicResponse
+		err := json.Unmarshal(body, &message)
+		if err != nil {
+			return nil, err
+		}
+
+		var text strings.Builder
+		for _, block := range message.Content {
+			if block.Type == "text" {
+				text.WriteString(block.Text)
+			}
+		}
+		return &models.ChatResponse{
+			Model:        message.Model,
+			Message:      models.ChatMessage{Role: models.RoleAssistant, Content: text.String()},
+			FinishReason: message.StopReason,
+			Usage: models.TokenUsage{
+				InputTokens:  message.Usage.InputTokens,
+				OutputTokens: message.Usage.OutputTokens,
+			},
+		}, nil
+	})
+}
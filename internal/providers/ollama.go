@@ -0,0 +1,65 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+)
+
+// Ollama speaks the chat API of Ollama.
+type Ollama struct{}
+
+type ollamaRequest struct {
+	Model    string               `json:"model"`
+	Messages []models.ChatMessage `json:"messages"`
+	Stream   bool                 `json:"stream"`
+	Options  *ollamaOptions       `json:"options,omitempty"`
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+}
+
+type ollamaResponse struct {
+	Model           string             `json:"model"`
+	Message         models.ChatMessage `json:"message"`
+	DoneReason      string             `json:"done_reason"`
+	PromptEvalCount int                `json:"prompt_eval_count"`
+	EvalCount       int                `json:"eval_count"`
+}
+
+func (Ollama) NewRequest(ctx context.Context, _, address string, req *models.ChatRequest,
+	model *entities.TargetModel,
+) (*http.Request, error) {
+	payload := ollamaRequest{
+		Model:    model.Name,
+		Messages: req.Messages,
+	}
+	if req.Temperature != nil || req.MaxTokens > 0 {
+		payload.Options = &ollamaOptions{Temperature: req.Temperature, NumPredict: req.MaxTokens}
+	}
+	return newJSONRequest(ctx, address, payload)
+}
+
+func (Ollama) ParseResponse(resp *http.Response) (*http.Response, error) {
+	return normalize(resp, func(body []byte) (*models.ChatResponse, error) {
+		var chat ollamaResponse
+		err := json.Unmarshal(body, &chat)
+		if err != nil {
+			return nil, err
+		}
+		return &models.ChatResponse{
+			Model:        chat.Model,
+			Message:      chat.Message,
+			FinishReason: chat.DoneReason,
+			Usage: models.TokenUsage{
+				InputTokens:  chat.PromptEvalCount,
+				OutputTokens: chat.EvalCount,
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+)
+
+// OpenAI speaks the chat completions API of OpenAI and of the providers compatible with it, such as Azure OpenAI.
+type OpenAI struct{}
+
+type openAIRequest struct {
+	Model       string               `json:"model,omitempty"`
+	Messages    []models.ChatMessage `json:"messages"`
+	MaxTokens   int                  `json:"max_tokens,omitempty"`
+	Temperature *float64             `json:"temperature,omitempty"`
+}
+
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      models.ChatMessage `json:"message"`
+		FinishReason string             `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func (OpenAI) NewRequest(ctx context.Context, _, address string, req *models.ChatRequest,
+	model *entities.TargetModel,
+) (*http.Request, error) {
+	return newJSONRequest(ctx, address, openAIRequest{
+		Model:       model.Name,
+		Messages:    req.Messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	})
+}
+
+func (OpenAI) ParseResponse(resp *http.Response) (*http.Response, error) {
+	return normalize(resp, func(body []byte) (*models.ChatResponse, error) {
+		var completion openAIResponse
+		err := json.Unmarshal(body, &completion)
+		if err != nil {
+			return nil, err
+		}
+		if len(completion.Choices) == 0 {
+			return nil, errors.New("no choices")
+		}
+		return &models.ChatResponse{
+			Model:        completion.Model,
+			Message:      completion.Choices[0].Message,
+			FinishReason: completion.Choices[0].FinishReason,
+			Usage: models.TokenUsage{
+				InputTokens:  completion.Usage.PromptTokens,
+				OutputTokens: completion.Usage.CompletionTokens,
+			},
+		}, nil
+	})
+}
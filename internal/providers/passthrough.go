@@ -0,0 +1,23 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+)
+
+// Passthrough forwards the request as the client sent it and returns the response as the target model sent it.
+type Passthrough struct{}
+
+func (Passthrough) NewRequest(ctx context.Context, method, address string, req *models.ChatRequest,
+	_ *entities.TargetModel,
+) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, address, bytes.NewReader(req.Raw))
+}
+
+func (Passthrough) ParseResponse(resp *http.Response) (*http.Response, error) {
+	return resp, nil
+}
@@ -5,8 +5,9 @@ import (
 	"strconv"
 
 	"guardian/configs"
+	"guardian/internal/metrics"
+	"guardian/internal/middleware"
 
-	"github.com/go-chi/jwtauth/v5"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -17,13 +18,12 @@ const (
 func RateLimiterMiddleware(redisClient *redis.Client) func(handler http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			_, claims, _ := jwtauth.FromContext(r.Context())
-			userID, ok := claims["user_id"].(string)
-			if !ok {
+			userID, err := middleware.UserIDFromContext(r.Context())
+			if err != nil {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			redisKey := rateLimitKeyPrefix + userID
+			redisKey := rateLimitKeyPrefix + userID.Hex()
 
 			currentCountStr, err := redisClient.Get(r.Context(), redisKey).Result()
 			if err != nil && err != redis.Nil {
@@ -36,6 +36,7 @@ func RateLimiterMiddleware(redisClient *redis.Client) func(handler http.Handler)
 			}
 
 			if currentCount >= configs.GlobalConfig.RequestLimit {
+				metrics.CountRateLimitRejection()
 				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 				return
 			}
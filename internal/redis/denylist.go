@@ -0,0 +1,43 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const revokedTokenKeyPrefix = "revoked_token:"
+
+// TokenDenylist is the Redis backed token denylist. Keys expire together with the tokens they revoke.
+type TokenDenylist struct {
+	client *redis.Client
+}
+
+func NewTokenDenylist(client *redis.Client) *TokenDenylist {
+	return &TokenDenylist{client: client}
+}
+
+func (d *TokenDenylist) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return d.client.Set(ctx, revokedTokenKeyPrefix+jti, 1, ttl).Err()
+}
+
+func (d *TokenDenylist) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := d.client.Exists(ctx, revokedTokenKeyPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (d *TokenDenylist) ConsumeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return d.client.SetNX(ctx, revokedTokenKeyPrefix+jti, 1, ttl).Result()
+}
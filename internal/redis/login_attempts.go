@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailuresKeyPrefix = "login_failures:"
+	loginLockKeyPrefix     = "login_lock:"
+)
+
+// LoginAttempts is the Redis backed login attempt store. Counters expire with their window and locks with their
+// lockout.
+type LoginAttempts struct {
+	client *redis.Client
+}
+
+func NewLoginAttempts(client *redis.Client) *LoginAttempts {
+	return &LoginAttempts{client: client}
+}
+
+func (l *LoginAttempts) RegisterFailure(ctx context.Context, key string, window time.Duration) (int64, error) {
+	var failures *redis.IntCmd
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, loginFailuresKeyPrefix+key)
+		pipe.Expire(ctx, loginFailuresKeyPrefix+key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return failures.Val(), nil
+}
+
+func (l *LoginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return l.client.Set(ctx, loginLockKeyPrefix+key, until.Unix(), ttl).Err()
+}
+
+func (l *LoginAttempts) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	value, err := l.client.Get(ctx, loginLockKeyPrefix+key).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+func (l *LoginAttempts) Reset(ctx context.Context, key string) error {
+	return l.client.Del(ctx, loginFailuresKeyPrefix+key, loginLockKeyPrefix+key).Err()
+}
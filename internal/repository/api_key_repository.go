@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type APIKeyRepoInterface interface {
+	CreateAPIKey(ctx context.Context, key entities.APIKey) (primitive.ObjectID, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*entities.APIKey, error)
+	GetAPIKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]entities.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID, userID primitive.ObjectID, revokedAt time.Time) (int64, error)
+	TouchAPIKey(ctx context.Context, keyID primitive.ObjectID, usedAt time.Time) error
+}
+
+type APIKeyRepository struct {
+	*MongoBaseRepository[entities.APIKey]
+}
+
+func NewAPIKeyRepository(db *mongo.Database) *APIKeyRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.APIKey)
+	return &APIKeyRepository{
+		MongoBaseRepository: NewMongoBaseRepository[entities.APIKey](collection),
+	}
+}
+
+func (u *APIKeyRepository) CreateAPIKey(ctx context.Context, key entities.APIKey) (primitive.ObjectID, error) {
+	err := assignTenant(ctx, &key)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	result, err := u.collection.InsertOne(ctx, key)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.Errorf("unexpected inserted id: %v", result.InsertedID)
+	}
+	return id, nil
+}
+
+func (u *APIKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*entities.APIKey, error) {
+	var key entities.APIKey
+	filter, err := scope(ctx, bson.M{"prefix": prefix})
+	if err != nil {
+		return nil, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (u *APIKeyRepository) GetAPIKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]entities.APIKey,
+	error,
+) {
+	keys := []entities.APIKey{}
+	filter, err := scope(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Errorf("error in GetAPIKeysByUser: %v", err)
+	}
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return nil, errors.Errorf("error in fetching api keys: %v", err)
+	}
+	return keys, nil
+}
+
+func (u *APIKeyRepository) RevokeAPIKey(ctx context.Context, keyID, userID primitive.ObjectID,
+	revokedAt time.Time,
+) (int64, error) {
+	filter, err := scope(ctx, bson.M{"_id": keyID, "user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": revokedAt}})
+	if err != nil {
+		return -1, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (u *APIKeyRepository) TouchAPIKey(ctx context.Context, keyID primitive.ObjectID, usedAt time.Time) error {
+	return u.Update(ctx, bson.M{"_id": keyID}, bson.M{"$set": bson.M{"last_used_at": usedAt}})
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+	"guardian/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AuditRepoInterface interface {
+	RecordEvent(ctx context.Context, event entities.AuditEvent) error
+}
+
+// AuditRepository keeps the audit trail. Events belong to the organization of the context they are recorded in, or to
+// none when recorded with an unscoped context, e.g. login lockouts.
+type AuditRepository struct {
+	*MongoBaseRepository[entities.AuditEvent]
+}
+
+func NewAuditRepository(db *mongo.Database) *AuditRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.AuditEvent)
+	return &AuditRepository{
+		MongoBaseRepository: NewMongoBaseRepository[entities.AuditEvent](collection),
+	}
+}
+
+func (u *AuditRepository) RecordEvent(ctx context.Context, event entities.AuditEvent) error {
+	if tenant.IsUnscoped(ctx) {
+		_, err := u.collection.InsertOne(ctx, &event)
+		return err
+	}
+	return u.Create(ctx, &event)
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"slices"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ChatHistoryRepoInterface interface {
+	AddTurn(ctx context.Context, turn entities.ChatTurn) error
+	GetLastTurns(ctx context.Context, userID, chatID primitive.ObjectID, n int) ([]entities.ChatTurn, error)
+}
+
+// ChatHistoryRepository keeps the turns of conversations. A conversation belongs to the user who started it, so the
+// turns of a chat are only looked up along with their user. Turns are purged once they are older than the chat history
+// TTL (see mongodb.EnsureIndexes).
+type ChatHistoryRepository struct {
+	*MongoBaseRepository[entities.ChatTurn]
+}
+
+func NewChatHistoryRepository(db *mongo.Database) *ChatHistoryRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.ChatTurn)
+	return &ChatHistoryRepository{
+		MongoBaseRepository: NewMongoBaseRepository[entities.ChatTurn](collection),
+	}
+}
+
+func (u *ChatHistoryRepository) AddTurn(ctx context.Context, turn entities.ChatTurn) error {
+	return u.Create(ctx, &turn)
+}
+
+// GetLastTurns returns the last n turns of the chat, oldest first.
+func (u *ChatHistoryRepository) GetLastTurns(ctx context.Context, userID, chatID primitive.ObjectID, n int) (
+	[]entities.ChatTurn, error,
+) {
+	var turns []entities.ChatTurn
+
+	filter, err := scope(ctx, bson.M{"chat_id": chatID, "user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(n))
+	cursor, err := u.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Errorf("error in GetLastTurns: %v", err)
+	}
+	err = cursor.All(ctx, &turns)
+	if err != nil {
+		return nil, errors.Errorf("error in fetching chat turns: %v", err)
+	}
+	slices.Reverse(turns)
+	return turns, nil
+}
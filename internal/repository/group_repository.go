@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type GroupRepoInterface interface {
+	GetGroup(ctx context.Context, groupID primitive.ObjectID) (*entities.Group, error)
+	GetGroupsByIDs(ctx context.Context, groupIDs []primitive.ObjectID) ([]entities.Group, error)
+	GetGroupsByAdmin(ctx context.Context, userID primitive.ObjectID) ([]entities.Group, error)
+	GetAllGroups(ctx context.Context) ([]entities.Group, error)
+	CreateGroup(ctx context.Context, group entities.Group) (entities.Group, error)
+	SetGroupTasks(ctx context.Context, groupID primitive.ObjectID, taskIDs []primitive.ObjectID) error
+	SetGroupAdmins(ctx context.Context, groupID primitive.ObjectID, admins []primitive.ObjectID) error
+	SetGroupTargetModels(ctx context.Context, groupID primitive.ObjectID, modelIDs []primitive.ObjectID) error
+}
+
+type GroupRepository struct {
+	*MongoBaseRepository[entities.Group]
+}
+
+func NewGroupRepository(db *mongo.Database) *GroupRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.Group)
+	return &GroupRepository{
+		MongoBaseRepository: NewMongoBaseRepository[entities.Group](collection),
+	}
+}
+
+func (u *GroupRepository) GetGroupsByNames(ctx context.Context, names []string) ([]entities.Group, error) {
+	var groups []entities.Group
+
+	filter, err := scope(ctx, bson.M{"name": bson.M{"$in": names}})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Errorf("error in GetGroupsByNames: %v", err)
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return nil, errors.Errorf("error in fetching groups: %v", err)
+	}
+	return groups, nil
+}
+
+func (u *GroupRepository) CreateGroup(ctx context.Context, group entities.Group) (entities.Group, error) {
+	err := assignTenant(ctx, &group)
+	if err != nil {
+		return entities.Group{}, err
+	}
+	result, err := u.collection.InsertOne(ctx, group)
+	if err != nil {
+		return entities.Group{}, err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		group.ID = id
+	}
+	return group, nil
+}
+
+func (u *GroupRepository) GetGroup(ctx context.Context, groupID primitive.ObjectID) (*entities.Group, error) {
+	var group entities.Group
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: groupID}})
+	if err != nil {
+		return nil, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (u *GroupRepository) GetGroupsByIDs(ctx context.Context, groupIDs []primitive.ObjectID) ([]entities.Group,
+	error,
+) {
+	return u.findGroups(ctx, bson.M{"_id": bson.M{"$in": groupIDs}})
+}
+
+func (u *GroupRepository) GetGroupsByAdmin(ctx context.Context, userID primitive.ObjectID) ([]entities.Group, error) {
+	return u.findGroups(ctx, bson.M{"admins": userID})
+}
+
+func (u *GroupRepository) GetAllGroups(ctx context.Context) ([]entities.Group, error) {
+	return u.findGroups(ctx, bson.M{})
+}
+
+func (u *GroupRepository) SetGroupTasks(ctx context.Context, groupID primitive.ObjectID,
+	taskIDs []primitive.ObjectID,
+) error {
+	return u.Update(ctx, bson.M{"_id": groupID}, bson.M{"$set": bson.M{"tasks": taskIDs}})
+}
+
+func (u *GroupRepository) SetGroupAdmins(ctx context.Context, groupID primitive.ObjectID,
+	admins []primitive.ObjectID,
+) error {
+	return u.Update(ctx, bson.M{"_id": groupID}, bson.M{"$set": bson.M{"admins": admins}})
+}
+
+func (u *GroupRepository) SetGroupTargetModels(ctx context.Context, groupID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) error {
+	return u.Update(ctx, bson.M{"_id": groupID}, bson.M{"$set": bson.M{"target_models": modelIDs}})
+}
+
+func (u *GroupRepository) findGroups(ctx context.Context, filter bson.M) ([]entities.Group, error) {
+	groups := []entities.Group{}
+	filter, err := scope(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Errorf("error in finding groups: %v", err)
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return nil, errors.Errorf("error in fetching groups: %v", err)
+	}
+	return groups, nil
+}
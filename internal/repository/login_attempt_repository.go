@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"guardian/configs"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttemptStoreInterface counts failed logins per key, e.g. an account or a client IP, and keeps their lockouts.
+type LoginAttemptStoreInterface interface {
+	RegisterFailure(ctx context.Context, key string, window time.Duration) (int64, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type loginAttempt struct {
+	Key         string    `bson:"_id"`
+	Failures    int64     `bson:"failures"`
+	LockedUntil time.Time `bson:"locked_until,omitempty"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// LoginAttemptRepository is the MongoDB backed login attempt store. Logins are not scoped to an organization, and
+// counters are only relevant until they expire, when the TTL index on expires_at purges them.
+type LoginAttemptRepository struct {
+	*MongoBaseRepository[loginAttempt]
+}
+
+func NewLoginAttemptRepository(db *mongo.Database) *LoginAttemptRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.LoginAttempt)
+	return &LoginAttemptRepository{
+		MongoBaseRepository: NewMongoBaseRepository[loginAttempt](collection),
+	}
+}
+
+// RegisterFailure counts a failed login and returns the failures within the window, which restarts once it has
+// passed without failures.
+func (u *LoginAttemptRepository) RegisterFailure(ctx context.Context, key string, window time.Duration) (int64,
+	error,
+) {
+	now := time.Now().UTC()
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "failures", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$gt", Value: bson.A{"$expires_at", now}}},
+			bson.D{{Key: "$add", Value: bson.A{"$failures", 1}}},
+			1,
+		}}}},
+		{Key: "expires_at", Value: bson.D{{Key: "$max", Value: bson.A{"$locked_until", now.Add(window)}}}},
+	}}}}
+
+	var attempt loginAttempt
+	err := u.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return 0, errors.Errorf("error in counting the failed login: %v", err)
+	}
+	return attempt.Failures, nil
+}
+
+func (u *LoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := u.collection.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"locked_until": until}, "$max": bson.M{"expires_at": until}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (u *LoginAttemptRepository) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var attempt loginAttempt
+	err := u.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&attempt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return attempt.LockedUntil, nil
+}
+
+func (u *LoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	_, err := u.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OrganizationRepoInterface manages the tenants themselves, which are not scoped to an organization.
+type OrganizationRepoInterface interface {
+	CreateOrganization(ctx context.Context, organization entities.Organization) (*entities.Organization, error)
+	GetOrganization(ctx context.Context, organizationID primitive.ObjectID) (*entities.Organization, error)
+	GetOrganizationBySlug(ctx context.Context, slug string) (*entities.Organization, error)
+	GetOrganizations(ctx context.Context) ([]entities.Organization, error)
+}
+
+type OrganizationRepository struct {
+	*MongoBaseRepository[entities.Organization]
+}
+
+func NewOrganizationRepository(db *mongo.Database) *OrganizationRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.Organization)
+	return &OrganizationRepository{
+		MongoBaseRepository: NewMongoBaseRepository[entities.Organization](collection),
+	}
+}
+
+func (u *OrganizationRepository) CreateOrganization(ctx context.Context, organization entities.Organization) (
+	*entities.Organization, error,
+) {
+	result, err := u.collection.InsertOne(ctx, organization)
+	if err != nil {
+		return nil, err
+	}
+	organization.ID = result.InsertedID.(primitive.ObjectID)
+	return &organization, nil
+}
+
+func (u *OrganizationRepository) GetOrganization(ctx context.Context, organizationID primitive.ObjectID) (
+	*entities.Organization, error,
+) {
+	var organization entities.Organization
+	err := u.collection.FindOne(ctx, bson.M{"_id": organizationID}).Decode(&organization)
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+func (u *OrganizationRepository) GetOrganizationBySlug(ctx context.Context, slug string) (
+	*entities.Organization, error,
+) {
+	var organization entities.Organization
+	err := u.collection.FindOne(ctx, bson.M{"slug": slug}).Decode(&organization)
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+func (u *OrganizationRepository) GetOrganizations(ctx context.Context) ([]entities.Organization, error) {
+	var organizations []entities.Organization
+	cursor, err := u.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Errorf("error in finding organizations: %v", err)
+	}
+	err = cursor.All(ctx, &organizations)
+	return organizations, err
+}
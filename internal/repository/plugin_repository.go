@@ -20,6 +20,8 @@ type PluginRepoInterface interface {
 	GetPluginsByTask(ctx context.Context, task entities.Task) ([]entities.Plugin, error)
 	GetPlugins(ctx context.Context, modelIDs []primitive.ObjectID) ([]entities.Plugin, error)
 	GetPlugin(ctx context.Context, modelID primitive.ObjectID) (entities.Plugin, error)
+	GetActivePlugins(ctx context.Context) ([]entities.Plugin, error)
+	GetPluginByName(ctx context.Context, name string) (entities.Plugin, error)
 	CreatePlugin(ctx context.Context, model entities.Plugin) (interface{}, error)
 	DeletePlugin(ctx context.Context, modelID primitive.ObjectID) (int64, error)
 	UpdatePlugin(ctx context.Context, model entities.Plugin) (int64, error)
@@ -35,7 +37,10 @@ func NewPluginRepository(db *mongo.Database) *PluginRepository {
 func (u *PluginRepository) GetPluginsByTask(ctx context.Context, task entities.Task) ([]entities.Plugin, error) {
 	var plugins []entities.Plugin
 
-	filter := bson.M{"_id": bson.M{"$in": task.Plugins}}
+	filter, err := scope(ctx, bson.M{"_id": bson.M{"$in": task.Plugins}})
+	if err != nil {
+		return nil, err
+	}
 	cursor, err := u.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, errors.Errorf("error in GetPlugins: %v", err)
@@ -44,7 +49,7 @@ func (u *PluginRepository) GetPluginsByTask(ctx context.Context, task entities.T
 	if err != nil {
 		return nil, errors.Errorf("error in fetching plugins: %v", err)
 	}
-	return plugins, nil
+	return u.decryptTokens(plugins)
 }
 
 func (u *PluginRepository) GetPlugins(ctx context.Context, modelIDs []primitive.ObjectID) ([]entities.Plugin,
@@ -52,7 +57,10 @@ func (u *PluginRepository) GetPlugins(ctx context.Context, modelIDs []primitive.
 ) {
 	var models []entities.Plugin
 
-	filter := bson.M{"_id": bson.M{"$in": modelIDs}}
+	filter, err := scope(ctx, bson.M{"_id": bson.M{"$in": modelIDs}})
+	if err != nil {
+		return nil, err
+	}
 	cursor, err := u.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, errors.Errorf("error in GetTasks: %v", err)
@@ -61,30 +69,87 @@ func (u *PluginRepository) GetPlugins(ctx context.Context, modelIDs []primitive.
 	if err != nil {
 		return nil, errors.Errorf("error in fetching tasks: %v", err)
 	}
-	return models, nil
+	return u.decryptTokens(models)
+}
+
+func (u *PluginRepository) GetActivePlugins(ctx context.Context) ([]entities.Plugin, error) {
+	var plugins []entities.Plugin
+
+	filter, err := scope(ctx, bson.M{"status": entities.PluginActive})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Errorf("error in GetActivePlugins: %v", err)
+	}
+	err = cursor.All(ctx, &plugins)
+	if err != nil {
+		return nil, errors.Errorf("error in fetching plugins: %v", err)
+	}
+	return u.decryptTokens(plugins)
+}
+
+func (u *PluginRepository) GetPluginByName(ctx context.Context, name string) (entities.Plugin, error) {
+	var plugin entities.Plugin
+	filter, err := scope(ctx, bson.M{"name": name})
+	if err != nil {
+		return entities.Plugin{}, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&plugin)
+	if err != nil {
+		return entities.Plugin{}, err
+	}
+	plugin.Token, err = decryptSecret(plugin.Token, tokenContext(u.collection.Name(), plugin.ID))
+	if err != nil {
+		return entities.Plugin{}, err
+	}
+	return plugin, nil
 }
 
 func (u *PluginRepository) GetPlugin(ctx context.Context, modelID primitive.ObjectID) (entities.Plugin,
 	error,
 ) {
 	var model entities.Plugin
-	cursor, err := u.collection.Find(ctx, bson.D{{"_id", modelID}})
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: modelID}})
+	if err != nil {
+		return entities.Plugin{}, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&model)
 	if err != nil {
 		return entities.Plugin{}, err
 	}
-	err = cursor.All(ctx, &model)
-	return model, err
+	model.Token, err = decryptSecret(model.Token, tokenContext(u.collection.Name(), model.ID))
+	if err != nil {
+		return entities.Plugin{}, err
+	}
+	return model, nil
 }
 
 func (u *PluginRepository) CreatePlugin(ctx context.Context, model entities.Plugin) (interface{}, error) {
-	cursor, err := u.collection.InsertOne(ctx, bson.D{
-		{"name", model.Name},
-		{"status", model.Status},
-		{"address", model.Address},
-		{"provider", model.Provider},
-		{"token", model.Token},
-		{"protocol", model.Protocol},
-	})
+	fields, err := tenantFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id := primitive.NewObjectID()
+	token, err := encryptSecret(model.Token, tokenContext(u.collection.Name(), id))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.InsertOne(ctx, append(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "name", Value: model.Name},
+		{Key: "status", Value: model.Status},
+		{Key: "address", Value: model.Address},
+		{Key: "provider", Value: model.Provider},
+		{Key: "token", Value: token},
+		{Key: "protocol", Value: model.Protocol},
+		{Key: "auth", Value: model.Auth},
+		{Key: "health_path", Value: model.HealthPath},
+		{Key: "capabilities", Value: model.Capabilities},
+		{Key: "detectors", Value: model.Detectors},
+		{Key: "module", Value: model.Module},
+	}, fields...))
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +157,11 @@ func (u *PluginRepository) CreatePlugin(ctx context.Context, model entities.Plug
 }
 
 func (u *PluginRepository) DeletePlugin(ctx context.Context, modelID primitive.ObjectID) (int64, error) {
-	cursor, err := u.collection.DeleteOne(ctx, bson.D{{"_id", modelID}})
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: modelID}})
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.DeleteOne(ctx, filter)
 	if err != nil {
 		return -1, err
 	}
@@ -100,9 +169,32 @@ func (u *PluginRepository) DeletePlugin(ctx context.Context, modelID primitive.O
 }
 
 func (u *PluginRepository) UpdatePlugin(ctx context.Context, model entities.Plugin) (int64, error) {
-	cursor, err := u.collection.UpdateByID(ctx, model.ID, model)
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: model.ID}})
+	if err != nil {
+		return -1, err
+	}
+	// An empty or redacted token is left out of the update and keeps the stored one.
+	if model.Token.IsRedacted() {
+		model.Token = ""
+	}
+	model.Token, err = encryptSecret(model.Token, tokenContext(u.collection.Name(), model.ID))
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": model})
 	if err != nil {
 		return -1, err
 	}
 	return cursor.ModifiedCount, err
 }
+
+func (u *PluginRepository) decryptTokens(plugins []entities.Plugin) ([]entities.Plugin, error) {
+	var err error
+	for i := range plugins {
+		plugins[i].Token, err = decryptSecret(plugins[i].Token, tokenContext(u.collection.Name(), plugins[i].ID))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plugins, nil
+}
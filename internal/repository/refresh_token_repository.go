@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type RefreshTokenRepoInterface interface {
+	CreateRefreshToken(ctx context.Context, token entities.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*entities.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, tokenID primitive.ObjectID, usedAt time.Time) (int64, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID primitive.ObjectID, revokedAt time.Time) error
+	RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID, revokedAt time.Time) error
+}
+
+type RefreshTokenRepository struct {
+	*MongoBaseRepository[entities.RefreshToken]
+}
+
+func NewRefreshTokenRepository(db *mongo.Database) *RefreshTokenRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.RefreshToken)
+	return &RefreshTokenRepository{
+		MongoBaseRepository: NewMongoBaseRepository[entities.RefreshToken](collection),
+	}
+}
+
+func (u *RefreshTokenRepository) CreateRefreshToken(ctx context.Context, token entities.RefreshToken) error {
+	return u.Create(ctx, &token)
+}
+
+func (u *RefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*entities.RefreshToken,
+	error,
+) {
+	return u.GetByFilter(ctx, bson.M{"hash": hash})
+}
+
+// MarkRefreshTokenUsed only succeeds for a token that hasn't been used yet, so concurrent refreshes with the same
+// token can't both win.
+func (u *RefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, tokenID primitive.ObjectID,
+	usedAt time.Time,
+) (int64, error) {
+	filter, err := scope(ctx, bson.M{"_id": tokenID, "used_at": bson.M{"$exists": false}})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": usedAt}})
+	if err != nil {
+		return -1, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (u *RefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID primitive.ObjectID,
+	revokedAt time.Time,
+) error {
+	filter, err := scope(ctx, bson.M{"family_id": familyID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	_, err = u.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": revokedAt}})
+	return err
+}
+
+func (u *RefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID,
+	revokedAt time.Time,
+) error {
+	filter, err := scope(ctx, bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	_, err = u.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": revokedAt}})
+	return err
+}
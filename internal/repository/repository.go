@@ -18,28 +18,48 @@ func NewMongoBaseRepository[T any](collection *mongo.Collection) *MongoBaseRepos
 }
 
 func (r *MongoBaseRepository[T]) Create(ctx context.Context, entity *T) error {
-	_, err := r.collection.InsertOne(ctx, entity)
+	err := assignTenant(ctx, entity)
+	if err != nil {
+		return err
+	}
+	_, err = r.collection.InsertOne(ctx, entity)
 	return err
 }
 
 func (r *MongoBaseRepository[T]) Update(ctx context.Context, filter bson.M, update bson.M) error {
-	_, err := r.collection.UpdateOne(ctx, filter, update)
+	filter, err := scope(ctx, filter)
+	if err != nil {
+		return err
+	}
+	_, err = r.collection.UpdateOne(ctx, filter, update)
 	return err
 }
 
 func (r *MongoBaseRepository[T]) Delete(ctx context.Context, filter bson.M) error {
-	_, err := r.collection.DeleteOne(ctx, filter)
+	filter, err := scope(ctx, filter)
+	if err != nil {
+		return err
+	}
+	_, err = r.collection.DeleteOne(ctx, filter)
 	return err
 }
 
 func (r *MongoBaseRepository[T]) GetByFilter(ctx context.Context, filter bson.M) (*T, error) {
 	var entity T
-	err := r.collection.FindOne(ctx, filter).Decode(&entity)
+	filter, err := scope(ctx, filter)
+	if err != nil {
+		return &entity, err
+	}
+	err = r.collection.FindOne(ctx, filter).Decode(&entity)
 	return &entity, err
 }
 
 func (r *MongoBaseRepository[T]) GetAll(ctx context.Context, filter bson.M) ([]T, error) {
 	var entities []T
+	filter, err := scope(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
 	cursor, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"guardian/configs"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenDenylistInterface keeps the IDs (jti) of access tokens revoked before their expiry. ConsumeToken revokes a
+// single-use token and reports whether it was still unused, atomically, so that it can be redeemed only once.
+type TokenDenylistInterface interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	ConsumeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+type revokedToken struct {
+	JTI       string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// RevokedTokenRepository is the MongoDB backed token denylist. Entries are only relevant until the token expires,
+// so a TTL index on expires_at purges them (see mongodb.EnsureIndexes).
+type RevokedTokenRepository struct {
+	*MongoBaseRepository[revokedToken]
+}
+
+func NewRevokedTokenRepository(db *mongo.Database) *RevokedTokenRepository {
+	collection := db.Collection(configs.GlobalConfig.CollectionNames.RevokedToken)
+	return &RevokedTokenRepository{
+		MongoBaseRepository: NewMongoBaseRepository[revokedToken](collection),
+	}
+}
+
+func (u *RevokedTokenRepository) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := u.collection.UpdateOne(ctx,
+		bson.M{"_id": jti},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (u *RevokedTokenRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	_, err := u.GetByFilter(ctx, bson.M{"_id": jti, "expires_at": bson.M{"$gt": time.Now()}})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (u *RevokedTokenRepository) ConsumeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	_, err := u.collection.InsertOne(ctx, revokedToken{JTI: jti, ExpiresAt: expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
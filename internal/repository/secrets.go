@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+	"guardian/internal/secrets"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// tokenContext binds an encrypted token to the document and field it is stored in, so that a ciphertext copied into
+// another document or collection fails to decrypt.
+func tokenContext(collection string, id primitive.ObjectID) string {
+	return collection + "/" + id.Hex() + "/token"
+}
+
+func encryptSecret(secret entities.Secret, associatedData string) (entities.Secret, error) {
+	encrypted, err := secrets.Default.Encrypt(secret.Reveal(), associatedData)
+	if err != nil {
+		return "", errors.Errorf("error in encrypting the token: %v", err)
+	}
+	return entities.Secret(encrypted), nil
+}
+
+func decryptSecret(secret entities.Secret, associatedData string) (entities.Secret, error) {
+	plaintext, err := secrets.Default.Decrypt(secret.Reveal(), associatedData)
+	if err != nil {
+		return "", errors.Errorf("error in decrypting the token: %v", err)
+	}
+	return entities.Secret(plaintext), nil
+}
+
+// EncryptSecrets encrypts the tokens stored before encryption and rewraps the ones encrypted with a key other than the
+// active one, so that retired keys can be removed.
+func EncryptSecrets(ctx context.Context, db *mongo.Database) error {
+	if secrets.Default == nil {
+		return nil
+	}
+	names := configs.GlobalConfig.CollectionNames
+	for _, name := range []string{names.Plugin, names.TargetModel} {
+		err := rewrapTokens(ctx, db.Collection(name))
+		if err != nil {
+			return errors.Errorf("error in encrypting the tokens of %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func rewrapTokens(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Find(ctx, bson.M{"token": bson.M{"$nin": bson.A{nil, ""}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Token string             `bson:"token"`
+		}
+		err = cursor.Decode(&document)
+		if err != nil {
+			return err
+		}
+		if !secrets.Default.NeedsRewrap(document.Token) {
+			continue
+		}
+
+		associatedData := tokenContext(collection.Name(), document.ID)
+		token, err := decryptSecret(entities.Secret(document.Token), associatedData)
+		if err != nil {
+			return err
+		}
+		token, err = encryptSecret(token, associatedData)
+		if err != nil {
+			return err
+		}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": document.ID}, bson.M{"$set": bson.M{"token": token}})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
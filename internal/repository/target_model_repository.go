@@ -36,7 +36,10 @@ func (u *TargetModelRepository) GetModels(ctx context.Context, modelIDs []primit
 ) {
 	var models []entities.TargetModel
 
-	filter := bson.M{"_id": bson.M{"$in": modelIDs}}
+	filter, err := scope(ctx, bson.M{"_id": bson.M{"$in": modelIDs}})
+	if err != nil {
+		return nil, err
+	}
 	cursor, err := u.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, errors.Errorf("error in GetTasks: %v", err)
@@ -45,6 +48,12 @@ func (u *TargetModelRepository) GetModels(ctx context.Context, modelIDs []primit
 	if err != nil {
 		return nil, errors.Errorf("error in fetching tasks: %v", err)
 	}
+	for i := range models {
+		models[i].Token, err = decryptSecret(models[i].Token, tokenContext(u.collection.Name(), models[i].ID))
+		if err != nil {
+			return nil, err
+		}
+	}
 	return models, nil
 }
 
@@ -52,7 +61,15 @@ func (u *TargetModelRepository) GetModel(ctx context.Context, modelID primitive.
 	error,
 ) {
 	var model entities.TargetModel
-	err := u.collection.FindOne(ctx, bson.D{{"_id", modelID}}).Decode(&model)
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: modelID}})
+	if err != nil {
+		return entities.TargetModel{}, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&model)
+	if err != nil {
+		return entities.TargetModel{}, err
+	}
+	model.Token, err = decryptSecret(model.Token, tokenContext(u.collection.Name(), model.ID))
 	if err != nil {
 		return entities.TargetModel{}, err
 	}
@@ -60,13 +77,30 @@ func (u *TargetModelRepository) GetModel(ctx context.Context, modelID primitive.
 }
 
 func (u *TargetModelRepository) CreateModel(ctx context.Context, model entities.TargetModel) (interface{}, error) {
-	cursor, err := u.collection.InsertOne(ctx, bson.D{
-		{"name", model.Name},
-		{"status", model.Status},
-		{"address", model.Address},
-		{"provider", model.Provider},
-		{"token", model.Token},
-	})
+	fields, err := tenantFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id := primitive.NewObjectID()
+	token, err := encryptSecret(model.Token, tokenContext(u.collection.Name(), id))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.InsertOne(ctx, append(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "name", Value: model.Name},
+		{Key: "status", Value: model.Status},
+		{Key: "address", Value: model.Address},
+		{Key: "provider", Value: model.Provider},
+		{Key: "token", Value: token},
+		{Key: "cost_tier", Value: model.CostTier},
+		{Key: "max_prompt_length", Value: model.MaxPromptLength},
+		{Key: "conservative", Value: model.Conservative},
+		{Key: "endpoints", Value: model.Endpoints},
+		{Key: "balancing", Value: model.Balancing},
+		{Key: "fallbacks", Value: model.Fallbacks},
+		{Key: "auth", Value: model.Auth},
+	}, fields...))
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +108,11 @@ func (u *TargetModelRepository) CreateModel(ctx context.Context, model entities.
 }
 
 func (u *TargetModelRepository) DeleteModel(ctx context.Context, modelID primitive.ObjectID) (int64, error) {
-	cursor, err := u.collection.DeleteOne(ctx, bson.D{{"_id", modelID}})
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: modelID}})
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.DeleteOne(ctx, filter)
 	if err != nil {
 		return -1, err
 	}
@@ -82,7 +120,19 @@ func (u *TargetModelRepository) DeleteModel(ctx context.Context, modelID primiti
 }
 
 func (u *TargetModelRepository) UpdateModel(ctx context.Context, model entities.TargetModel) (int64, error) {
-	cursor, err := u.collection.UpdateByID(ctx, model.ID, model)
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: model.ID}})
+	if err != nil {
+		return -1, err
+	}
+	// An empty or redacted token is left out of the update and keeps the stored one.
+	if model.Token.IsRedacted() {
+		model.Token = ""
+	}
+	model.Token, err = encryptSecret(model.Token, tokenContext(u.collection.Name(), model.ID))
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": model})
 	if err != nil {
 		return -1, err
 	}
@@ -12,6 +12,12 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+type TaskRepoInterface interface {
+	GetTasks(ctx context.Context, taskIDs []primitive.ObjectID) ([]entities.Task, error)
+	GetTask(ctx context.Context, taskID primitive.ObjectID) (entities.Task, error)
+	SetTaskPlugins(ctx context.Context, taskID primitive.ObjectID, pluginIDs []primitive.ObjectID) error
+}
+
 type TaskRepository struct {
 	*MongoBaseRepository[entities.Task]
 }
@@ -26,7 +32,10 @@ func NewTaskRepository(db *mongo.Database) *TaskRepository {
 func (u *TaskRepository) GetTasks(ctx context.Context, taskIDs []primitive.ObjectID) ([]entities.Task, error) {
 	var tasks []entities.Task
 
-	filter := bson.M{"_id": bson.M{"$in": taskIDs}}
+	filter, err := scope(ctx, bson.M{"_id": bson.M{"$in": taskIDs}})
+	if err != nil {
+		return nil, err
+	}
 	cursor, err := u.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, errors.Errorf("error in GetTasks: %v", err)
@@ -40,20 +49,41 @@ func (u *TaskRepository) GetTasks(ctx context.Context, taskIDs []primitive.Objec
 
 func (u *TaskRepository) GetTask(ctx context.Context, taskID primitive.ObjectID) (entities.Task, error) {
 	var task entities.Task
-	cursor, err := u.collection.Find(ctx, bson.D{{"_id", taskID}})
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: taskID}})
+	if err != nil {
+		return entities.Task{}, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&task)
 	if err != nil {
 		return entities.Task{}, err
 	}
-	err = cursor.All(ctx, &task)
-	return task, err
+	return task, nil
+}
+
+func (u *TaskRepository) SetTaskPlugins(ctx context.Context, taskID primitive.ObjectID,
+	pluginIDs []primitive.ObjectID,
+) error {
+	filter, err := scope(ctx, bson.M{"_id": taskID})
+	if err != nil {
+		return err
+	}
+	_, err = u.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"plugins": pluginIDs}})
+	if err != nil {
+		return errors.Errorf("error in setting the plugins of the task: %v", err)
+	}
+	return nil
 }
 
 func (u *TaskRepository) CreateTask(ctx context.Context, task entities.Task) (interface{}, error) {
-	cursor, err := u.collection.InsertOne(ctx, bson.D{
-		{"type", task.Type},
-		{"status", task.Status},
-		{"plugins", task.Plugins},
-	})
+	fields, err := tenantFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.InsertOne(ctx, append(bson.D{
+		{Key: "type", Value: task.Type},
+		{Key: "status", Value: task.Status},
+		{Key: "plugins", Value: task.Plugins},
+	}, fields...))
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +91,11 @@ func (u *TaskRepository) CreateTask(ctx context.Context, task entities.Task) (in
 }
 
 func (u *TaskRepository) DeleteTask(ctx context.Context, taskID primitive.ObjectID) (int64, error) {
-	cursor, err := u.collection.DeleteOne(ctx, bson.D{{"_id", taskID}})
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: taskID}})
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.DeleteOne(ctx, filter)
 	if err != nil {
 		return -1, err
 	}
@@ -69,7 +103,11 @@ func (u *TaskRepository) DeleteTask(ctx context.Context, taskID primitive.Object
 }
 
 func (u *TaskRepository) UpdateTask(ctx context.Context, task entities.Task) (int64, error) {
-	cursor, err := u.collection.UpdateByID(ctx, task.ID, task)
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: task.ID}})
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": task})
 	if err != nil {
 		return -1, err
 	}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+
+	"guardian/configs"
+	"guardian/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const organizationField = "organization_id"
+
+type tenantEntity interface {
+	GetOrganizationID() primitive.ObjectID
+	SetOrganizationID(organizationID primitive.ObjectID)
+}
+
+// organizationOf returns the organization of the context, or NilObjectID for contexts unscoped with
+// tenant.Unscoped. Any other context fails, so that a missing scope never reaches across organizations.
+func organizationOf(ctx context.Context) (primitive.ObjectID, error) {
+	if organizationID, ok := tenant.FromContext(ctx); ok {
+		return organizationID, nil
+	}
+	if tenant.IsUnscoped(ctx) {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.NilObjectID, tenant.ErrNoOrganization
+}
+
+// scope restricts a filter to the organization of the context.
+func scope(ctx context.Context, filter bson.M) (bson.M, error) {
+	organizationID, err := organizationOf(ctx)
+	if err != nil || organizationID.IsZero() {
+		return filter, err
+	}
+
+	scoped := make(bson.M, len(filter)+1)
+	for key, value := range filter {
+		scoped[key] = value
+	}
+	scoped[organizationField] = organizationID
+	return scoped, nil
+}
+
+func scopeD(ctx context.Context, filter bson.D) (bson.D, error) {
+	organizationID, err := organizationOf(ctx)
+	if err != nil || organizationID.IsZero() {
+		return filter, err
+	}
+	return append(filter[:len(filter):len(filter)], bson.E{Key: organizationField, Value: organizationID}), nil
+}
+
+// assignTenant places a new entity in the organization of the context unless it names one explicitly. Entities
+// can't be created outside an organization, not even with an unscoped context.
+func assignTenant(ctx context.Context, entity interface{}) error {
+	scoped, ok := entity.(tenantEntity)
+	if !ok || !scoped.GetOrganizationID().IsZero() {
+		return nil
+	}
+	organizationID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return tenant.ErrNoOrganization
+	}
+	scoped.SetOrganizationID(organizationID)
+	return nil
+}
+
+// tenantFields is the organization field of documents that are inserted field by field.
+func tenantFields(ctx context.Context) (bson.D, error) {
+	organizationID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, tenant.ErrNoOrganization
+	}
+	return bson.D{{Key: organizationField, Value: organizationID}}, nil
+}
+
+// AdoptOrphans moves documents created before multi-tenancy into the given organization.
+func AdoptOrphans(ctx context.Context, db *mongo.Database, organizationID primitive.ObjectID) error {
+	names := configs.GlobalConfig.CollectionNames
+	for _, name := range []string{
+		names.User, names.Task, names.Group, names.TargetModel, names.Plugin, names.APIKey, names.RefreshToken,
+	} {
+		_, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{organizationField: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{organizationField: organizationID}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
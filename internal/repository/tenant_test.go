@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"guardian/internal/models/entities"
+	"guardian/internal/tenant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestScope(t *testing.T) {
+	t.Parallel()
+	organizationID := primitive.NewObjectID()
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		expected    bson.M
+		expectedErr error
+	}{
+		{name: "scoped", ctx: tenant.WithOrganization(context.Background(), organizationID),
+			expected: bson.M{"name": "x", organizationField: organizationID}},
+		{name: "unscoped", ctx: tenant.Unscoped(context.Background()), expected: bson.M{"name": "x"}},
+		{name: "missing organization", ctx: context.Background(), expectedErr: tenant.ErrNoOrganization},
+		{name: "zero organization", ctx: tenant.WithOrganization(context.Background(), primitive.NilObjectID),
+			expectedErr: tenant.ErrNoOrganization},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filter, err := scope(tt.ctx, bson.M{"name": "x"})
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, filter)
+		})
+	}
+}
+
+func TestAssignTenant(t *testing.T) {
+	t.Parallel()
+
+	group := entities.Group{}
+	err := assignTenant(tenant.Unscoped(context.Background()), &group)
+	require.ErrorIs(t, err, tenant.ErrNoOrganization)
+
+	organizationID := primitive.NewObjectID()
+	err = assignTenant(tenant.WithOrganization(context.Background(), organizationID), &group)
+	require.NoError(t, err)
+	assert.Equal(t, organizationID, group.OrganizationID)
+}
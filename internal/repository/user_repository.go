@@ -12,6 +12,13 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+type UserRepoInterface interface {
+	GetUser(ctx context.Context, userID primitive.ObjectID) (*entities.User, error)
+	SetUserRole(ctx context.Context, userID primitive.ObjectID, role string) (int64, error)
+	AddUserToGroup(ctx context.Context, userID primitive.ObjectID, group entities.Group) (int64, error)
+	RemoveUserFromGroup(ctx context.Context, userID, groupID primitive.ObjectID) (int64, error)
+}
+
 type UserRepository struct {
 	*MongoBaseRepository[entities.User]
 }
@@ -25,7 +32,11 @@ func NewUserRepository(db *mongo.Database) *UserRepository {
 
 func (u *UserRepository) GetUser(ctx context.Context, userID primitive.ObjectID) (*entities.User, error) {
 	var user entities.User
-	err := u.collection.FindOne(ctx, bson.D{{"_id", userID}}).Decode(&user)
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: userID}})
+	if err != nil {
+		return nil, err
+	}
+	err = u.collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +44,12 @@ func (u *UserRepository) GetUser(ctx context.Context, userID primitive.ObjectID)
 }
 
 func (u *UserRepository) CreateUser(ctx context.Context, user entities.User) (interface{}, error) {
-	cursor, err := u.collection.InsertOne(ctx, bson.D{{"name", user.Name}, {"status", 1}})
+	fields, err := tenantFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := u.collection.InsertOne(ctx, append(bson.D{{Key: "name", Value: user.Name}, {Key: "status", Value: 1}},
+		fields...))
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +57,11 @@ func (u *UserRepository) CreateUser(ctx context.Context, user entities.User) (in
 }
 
 func (u *UserRepository) DeleteUser(ctx context.Context, userID uuid.UUID) (int64, error) {
-	cursor, err := u.collection.DeleteOne(ctx, bson.D{{"_id", userID}})
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: userID}})
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.DeleteOne(ctx, filter)
 	if err != nil {
 		return -1, err
 	}
@@ -49,9 +69,94 @@ func (u *UserRepository) DeleteUser(ctx context.Context, userID uuid.UUID) (int6
 }
 
 func (u *UserRepository) UpdateUser(ctx context.Context, user entities.User) (int64, error) {
-	cursor, err := u.collection.UpdateByID(ctx, user.ID, user)
+	filter, err := scopeD(ctx, bson.D{{Key: "_id", Value: user.ID}})
+	if err != nil {
+		return -1, err
+	}
+	cursor, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": user})
 	if err != nil {
 		return -1, err
 	}
 	return cursor.ModifiedCount, err
 }
+
+// ActivateUser activates the user and applies the email address if the activation nonce still matches, clearing it
+// so that each activation token works once. It returns the number of activated users.
+func (u *UserRepository) ActivateUser(ctx context.Context, userID primitive.ObjectID, nonce, email string) (int64,
+	error,
+) {
+	filter, err := scope(ctx,
+		bson.M{"_id": userID, "activation_nonce": nonce, "status": bson.M{"$ne": entities.UserStatusDeleted}})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter,
+		bson.M{
+			"$set":   bson.M{"status": entities.UserStatusActive, "email": email},
+			"$unset": bson.M{"activation_nonce": ""},
+		},
+	)
+	if err != nil {
+		return -1, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (u *UserRepository) SetUserRole(ctx context.Context, userID primitive.ObjectID, role string) (int64, error) {
+	filter, err := scope(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return -1, err
+	}
+	return result.MatchedCount, nil
+}
+
+func (u *UserRepository) SetUserTargetModels(ctx context.Context, userID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) (int64, error) {
+	filter, err := scope(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"target_models": modelIDs}})
+	if err != nil {
+		return -1, err
+	}
+	return result.MatchedCount, nil
+}
+
+// AddUserToGroup embeds the group in the user's groups unless the user is a member already.
+func (u *UserRepository) AddUserToGroup(ctx context.Context, userID primitive.ObjectID,
+	group entities.Group,
+) (int64, error) {
+	// Users signed up without groups store null, which $push refuses to append to.
+	err := u.Update(ctx, bson.M{"_id": userID, "groups": nil}, bson.M{"$set": bson.M{"groups": bson.A{}}})
+	if err != nil {
+		return -1, err
+	}
+
+	filter, err := scope(ctx, bson.M{"_id": userID, "groups._id": bson.M{"$ne": group.ID}})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"groups": group}})
+	if err != nil {
+		return -1, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (u *UserRepository) RemoveUserFromGroup(ctx context.Context, userID, groupID primitive.ObjectID) (int64, error) {
+	filter, err := scope(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return -1, err
+	}
+	result, err := u.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"groups": bson.M{"_id": groupID}}})
+	if err != nil {
+		return -1, err
+	}
+	return result.ModifiedCount, nil
+}
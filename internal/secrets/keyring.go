@@ -0,0 +1,204 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	encryptedPrefix = "enc:"
+	// v1 secrets were encrypted without associated data. They are still decrypted, and rewrapped into v2 secrets.
+	versionV1 = "v1"
+	versionV2 = "v2"
+	keySize   = 32
+)
+
+var (
+	ErrNoActiveKey      = errors.New("no active secret key is configured")
+	ErrNoKeys           = errors.New("secret was encrypted but no secret keys are configured")
+	ErrUnknownKey       = errors.New("secret was encrypted with an unknown key")
+	ErrMalformedSecret  = errors.New("malformed encrypted secret")
+	ErrInvalidKeyConfig = errors.New("secret keys must be <key id>:<base64 encoded 32 byte key>")
+)
+
+// Default encrypts the secrets the repositories store. It is set up by Init on start, and stays nil when no keys are
+// configured.
+var Default *Keyring
+
+// Keyring does envelope encryption with AES-GCM: every secret gets its own data key, which is wrapped by the active
+// key. Keys are told apart by their IDs, so keys can be rotated by adding a new active key while keeping the old ones
+// for decryption until every secret has been rewrapped. Secrets are bound to associated data naming where they are
+// stored, so that a secret copied to another record or field fails to decrypt. A nil Keyring leaves secrets in
+// plaintext.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// Init sets up the default keyring from "<key id>:<base64 key>" entries.
+func Init(specs []string, active string) error {
+	keyring, err := ParseKeyring(specs, active)
+	if err != nil {
+		return err
+	}
+	Default = keyring
+	return nil
+}
+
+func ParseKeyring(specs []string, active string) (*Keyring, error) {
+	keys := make(map[string][]byte, len(specs))
+	for _, spec := range specs {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		if !ok || id == "" {
+			return nil, ErrInvalidKeyConfig
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("%w: key %s", ErrInvalidKeyConfig, id)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(keys, active)
+}
+
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, ErrNoActiveKey
+	}
+	return &Keyring{keys: keys, active: active}, nil
+}
+
+// Encrypt returns the secret encrypted with a fresh data key wrapped by the active key, bound to the associated data.
+// Empty secrets stay empty.
+func (k *Keyring) Encrypt(plaintext, associatedData string) (string, error) {
+	if k == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+
+	return k.prefix() + base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt returns the plaintext of an encrypted secret, provided it was encrypted with the same associated data.
+// Secrets stored before encryption are returned as they are.
+func (k *Keyring) Decrypt(secret, associatedData string) (string, error) {
+	if !IsEncrypted(secret) {
+		return secret, nil
+	}
+	if k == nil {
+		return "", ErrNoKeys
+	}
+
+	parts := strings.Split(strings.TrimPrefix(secret, encryptedPrefix), ":")
+	if len(parts) != 4 {
+		return "", ErrMalformedSecret
+	}
+	version, keyID := parts[0], parts[1]
+	var additionalData []byte
+	switch version {
+	case versionV1:
+	case versionV2:
+		additionalData = []byte(associatedData)
+	default:
+		return "", ErrMalformedSecret
+	}
+	key, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformedSecret
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", ErrMalformedSecret
+	}
+
+	dataKey, err := open(key, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRewrap reports whether the secret is in plaintext, wrapped by a key other than the active one or encrypted
+// without associated data.
+func (k *Keyring) NeedsRewrap(secret string) bool {
+	if k == nil || secret == "" {
+		return false
+	}
+	return !strings.HasPrefix(secret, k.prefix())
+}
+
+// prefix starts the secrets the keyring encrypts now.
+func (k *Keyring) prefix() string {
+	return encryptedPrefix + versionV2 + ":" + k.active + ":"
+}
+
+// IsEncrypted reports whether the secret was encrypted by a keyring.
+func IsEncrypted(secret string) bool {
+	return strings.HasPrefix(secret, encryptedPrefix+versionV1+":") ||
+		strings.HasPrefix(secret, encryptedPrefix+versionV2+":")
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformedSecret
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedSecret, err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testAssociatedData = "plugins/6523554a1b2c3d4e5f607182/token"
+
+func newTestKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+
+	specs := make([]string, 0, len(ids))
+	for i, id := range ids {
+		specs = append(specs, id+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, keySize)))
+	}
+	keyring, err := ParseKeyring(specs, active)
+	require.NoError(t, err)
+	return keyring
+}
+
+func TestKeyring_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	keyring := newTestKeyring(t, "k1", "k1")
+
+	encrypted, err := keyring.Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(encrypted))
+	require.NotContains(t, encrypted, "sk-secret")
+	require.False(t, keyring.NeedsRewrap(encrypted))
+
+	again, err := keyring.Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, again)
+
+	plaintext, err := keyring.Decrypt(encrypted, testAssociatedData)
+	require.NoError(t, err)
+	require.Equal(t, "sk-secret", plaintext)
+}
+
+func TestKeyring_AssociatedData(t *testing.T) {
+	t.Parallel()
+
+	keyring := newTestKeyring(t, "k1", "k1")
+	encrypted, err := keyring.Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+
+	_, err = keyring.Decrypt(encrypted, "plugins/6523554a1b2c3d4e5f607183/token")
+	require.ErrorIs(t, err, ErrMalformedSecret)
+	_, err = keyring.Decrypt(encrypted, "target_models/6523554a1b2c3d4e5f607182/token")
+	require.ErrorIs(t, err, ErrMalformedSecret)
+}
+
+func TestKeyring_V1(t *testing.T) {
+	t.Parallel()
+
+	keyring := newTestKeyring(t, "k1", "k1")
+	dataKey := bytes.Repeat([]byte{9}, keySize)
+	ciphertext, err := seal(dataKey, []byte("sk-secret"), nil)
+	require.NoError(t, err)
+	wrappedKey, err := seal(keyring.keys["k1"], dataKey, []byte("k1"))
+	require.NoError(t, err)
+	encrypted := encryptedPrefix + "v1:k1:" + base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext)
+
+	require.True(t, IsEncrypted(encrypted))
+	require.True(t, keyring.NeedsRewrap(encrypted))
+	plaintext, err := keyring.Decrypt(encrypted, testAssociatedData)
+	require.NoError(t, err)
+	require.Equal(t, "sk-secret", plaintext)
+}
+
+func TestKeyring_Empty(t *testing.T) {
+	t.Parallel()
+
+	keyring := newTestKeyring(t, "k1", "k1")
+
+	encrypted, err := keyring.Encrypt("", testAssociatedData)
+	require.NoError(t, err)
+	require.Empty(t, encrypted)
+	require.False(t, keyring.NeedsRewrap(""))
+}
+
+func TestKeyring_Plaintext(t *testing.T) {
+	t.Parallel()
+
+	keyring := newTestKeyring(t, "k1", "k1")
+
+	plaintext, err := keyring.Decrypt("sk-legacy", testAssociatedData)
+	require.NoError(t, err)
+	require.Equal(t, "sk-legacy", plaintext)
+	require.True(t, keyring.NeedsRewrap("sk-legacy"))
+}
+
+func TestKeyring_Nil(t *testing.T) {
+	t.Parallel()
+
+	var keyring *Keyring
+	plaintext, err := keyring.Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+	require.Equal(t, "sk-secret", plaintext)
+	require.False(t, keyring.NeedsRewrap(plaintext))
+
+	encrypted, err := newTestKeyring(t, "k1", "k1").Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+	_, err = keyring.Decrypt(encrypted, testAssociatedData)
+	require.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	t.Parallel()
+
+	old := newTestKeyring(t, "k1", "k1")
+	encrypted, err := old.Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+
+	rotated := newTestKeyring(t, "k2", "k1", "k2")
+	require.True(t, rotated.NeedsRewrap(encrypted))
+	plaintext, err := rotated.Decrypt(encrypted, testAssociatedData)
+	require.NoError(t, err)
+	require.Equal(t, "sk-secret", plaintext)
+
+	rewrapped, err := rotated.Encrypt(plaintext, testAssociatedData)
+	require.NoError(t, err)
+	require.False(t, rotated.NeedsRewrap(rewrapped))
+	require.True(t, strings.HasPrefix(rewrapped, encryptedPrefix+"v2:k2:"))
+
+	retired := newTestKeyring(t, "k2", "k2")
+	_, err = retired.Decrypt(encrypted, testAssociatedData)
+	require.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	t.Parallel()
+
+	keyring := newTestKeyring(t, "k1", "k1")
+	encrypted, err := keyring.Encrypt("sk-secret", testAssociatedData)
+	require.NoError(t, err)
+
+	parts := strings.Split(encrypted, ":")
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[4])
+	require.NoError(t, err)
+	ciphertext[len(ciphertext)-1] ^= 1
+	parts[4] = base64.StdEncoding.EncodeToString(ciphertext)
+
+	_, err = keyring.Decrypt(strings.Join(parts, ":"), testAssociatedData)
+	require.ErrorIs(t, err, ErrMalformedSecret)
+
+	_, err = keyring.Decrypt(encryptedPrefix+"v2:k1:abc", testAssociatedData)
+	require.ErrorIs(t, err, ErrMalformedSecret)
+}
+
+func TestParseKeyring_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		specs    []string
+		active   string
+		expected error
+	}{
+		{name: "no keys", expected: ErrNoActiveKey},
+		{name: "missing id", specs: []string{"c2VjcmV0"}, active: "k1", expected: ErrInvalidKeyConfig},
+		{name: "short key", specs: []string{"k1:c2VjcmV0"}, active: "k1", expected: ErrInvalidKeyConfig},
+		{name: "not base64", specs: []string{"k1:%%%"}, active: "k1", expected: ErrInvalidKeyConfig},
+		{
+			name:     "unknown active key",
+			specs:    []string{"k1:" + base64.StdEncoding.EncodeToString(make([]byte, keySize))},
+			active:   "k2",
+			expected: ErrNoActiveKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseKeyring(tt.specs, tt.active)
+			require.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
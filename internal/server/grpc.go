@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"guardian/configs"
+	"guardian/guardian_api"
+	guardianMiddleware "guardian/internal/middleware"
+	"guardian/internal/models/entities"
+	"guardian/internal/mongodb"
+	"guardian/internal/ratelimit"
+	redisClient "guardian/internal/redis"
+	"guardian/internal/setup"
+	"guardian/internal/tracing"
+	"guardian/utlis/logger"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// newGRPCServer serves Guardian over gRPC. Calls go through the middleware of the /send route, except for the health
+// and reflection services, which are public. API keys need the scopes of the matching HTTP route: CheckPrompt those
+// of /check and Generate those of /send.
+func newGRPCServer() *grpc.Server {
+	authMiddleware := setup.InitializeMiddleware(mongodb.Database)
+	middlewares := []func(http.Handler) http.Handler{
+		middleware.RequestID,
+		tracing.Wrap("auth", authMiddleware.Authenticate),
+		authMiddleware.RequireActiveUser,
+	}
+	if configs.GlobalConfig.EnableRateLimiter {
+		middlewares = append(middlewares, tracing.Wrap("rate limit", ratelimit.RateLimiterMiddleware(redisClient.Client)))
+	}
+	// Checking prompts is what check-only API keys are for, while generating completions sends prompts on.
+	middlewares = append(middlewares, guardianMiddleware.RequireMethodScopes(map[string][]string{
+		guardian_api.Guardian_CheckPrompt_FullMethodName: {entities.ScopeCheck, entities.ScopeSend},
+		guardian_api.Guardian_Generate_FullMethodName:    {entities.ScopeSend},
+	}))
+
+	auth := guardianMiddleware.NewGRPCAuth([]string{
+		grpc_health_v1.Health_ServiceDesc.ServiceName,
+		grpc_reflection_v1.ServerReflection_ServiceDesc.ServiceName,
+		grpc_reflection_v1alpha.ServerReflection_ServiceDesc.ServiceName,
+	}, middlewares...)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, auth.Unary),
+		grpc.ChainStreamInterceptor(tracing.StreamServerInterceptor, auth.Stream))
+
+	guardian_api.RegisterGuardianServer(server, setup.InitializeGuardianServer(mongodb.Database))
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(guardian_api.Guardian_ServiceDesc.ServiceName,
+		grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	return server
+}
+
+func serveGRPC(server *grpc.Server, port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	logger.GetLogger().Infof("gRPC server starting on port %d", port)
+	return server.Serve(listener)
+}
+
+// stopGRPC stops the server once the calls in flight are done, or cancels them when ctx is done first.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
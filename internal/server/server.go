@@ -2,30 +2,42 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"guardian/api"
 	"guardian/configs"
+	"guardian/internal/metrics"
 	guardianMiddleware "guardian/internal/middleware"
+	"guardian/internal/models/entities"
 	"guardian/internal/mongodb"
 	"guardian/internal/ratelimit"
 	redisClient "guardian/internal/redis"
 	"guardian/internal/setup"
+	"guardian/internal/tracing"
 	"guardian/utlis/logger"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	swagger "github.com/swaggo/http-swagger"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
 )
 
-func StartServer() error {
+// shutdownTimeout bounds how long the servers wait for the requests in flight once Guardian is stopped.
+const shutdownTimeout = 30 * time.Second
+
+// StartServer serves Guardian over HTTP, and over gRPC when GRPC_SERVER_PORT is set, until ctx is done. The servers
+// are then shut down, giving the requests in flight up to shutdownTimeout to finish.
+func StartServer(ctx context.Context) error {
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(metrics.Middleware)
+	router.Use(tracing.Middleware)
+	router.Use(guardianMiddleware.RealIP)
+	router.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: logger.GetLogger(), NoColor: true}))
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.Timeout(60 * time.Second))
 
@@ -41,13 +53,32 @@ func StartServer() error {
 		swagger.URL("/swagger/doc.json"),
 	))
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	g, ctx := errgroup.WithContext(ctx)
+	httpServer := &http.Server{Addr: ":8080", Handler: router}
 	g.Go(func() error {
 		logger.GetLogger().Info("Server starting on port 8080")
-		return http.ListenAndServe(":8080", router)
+		err := httpServer.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	})
+	var grpcServer *grpc.Server
+	if configs.GlobalConfig.GRPCServerPort > 0 {
+		grpcServer = newGRPCServer()
+		g.Go(func() error {
+			return serveGRPC(grpcServer, configs.GlobalConfig.GRPCServerPort)
+		})
+	}
+	g.Go(func() error {
+		<-ctx.Done()
+		logger.GetLogger().Info("Server shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if grpcServer != nil {
+			stopGRPC(shutdownCtx, grpcServer)
+		}
+		return httpServer.Shutdown(shutdownCtx)
 	})
 
 	if err := g.Wait(); err != nil {
@@ -65,19 +96,33 @@ func setupRoutes(router *chi.Mux) {
 
 	authController := setup.InitializeAuthController(mongodb.Database)
 	sendController := setup.InitializeSendHandlerController(mongodb.Database)
+	checkController := setup.InitializeCheckController(mongodb.Database)
+	apiKeyController := setup.InitializeAPIKeyController(mongodb.Database)
+	groupController := setup.InitializeGroupController(mongodb.Database)
+	organizationController := setup.InitializeOrganizationController(mongodb.Database)
+	pluginController := setup.InitializePluginController(mongodb.Database)
+	authMiddleware := setup.InitializeMiddleware(mongodb.Database)
 
 	addAuthRoutes(router, authController)
-	setupRateLimiter(router)
+	router.Post("/plugins/register", pluginController.RegisterPlugin)
 
 	router.Group(func(protected chi.Router) {
-		protected.Use(guardianMiddleware.VerifyJWT)
+		protected.Use(tracing.Wrap("auth", authMiddleware.Authenticate))
+		protected.Use(authMiddleware.RequireActiveUser)
+		setupRateLimiter(protected)
 		addProtectedRoutes(protected, authController, sendController)
+		addCheckRoutes(protected, checkController)
+		addAPIKeyRoutes(protected, apiKeyController)
+		addGroupRoutes(protected, groupController)
+		addAdminRoutes(protected, authController, pluginController)
+		addOrganizationRoutes(protected, organizationController)
 	})
 }
 
-func setupRateLimiter(router *chi.Mux) {
+// setupRateLimiter must run after the authentication middleware as the limit is applied per user.
+func setupRateLimiter(router chi.Router) {
 	if configs.GlobalConfig.EnableRateLimiter {
-		router.Use(ratelimit.RateLimiterMiddleware(redisClient.Client))
+		router.Use(tracing.Wrap("rate limit", ratelimit.RateLimiterMiddleware(redisClient.Client)))
 	}
 }
 
@@ -85,15 +130,84 @@ func addAuthRoutes(router *chi.Mux, authController *api.AuthController) {
 	router.Route("/user", func(r chi.Router) {
 		r.Post("/login", authController.Login)
 		r.Post("/sign-up", authController.SignUp)
+		r.Post("/refresh", authController.Refresh)
+		r.Get("/activate", authController.ActivateUser)
+		r.Post("/activate/resend", authController.ResendActivation)
 	})
 }
 
 func addProtectedRoutes(protected chi.Router, authController *api.AuthController,
 	controller *api.SendHandlerController,
 ) {
-	protected.Put("/user/update", authController.UpdateUser)
-	protected.Patch("/user/activate", authController.ActivateUser)
-	protected.Delete("/user/delete", authController.DeleteUser)
+	account := protected.With(guardianMiddleware.RejectAPIKeys)
+	account.Put("/user/update", authController.UpdateUser)
+	account.Put("/user/password", authController.ChangePassword)
+	account.Post("/user/link-external", authController.LinkExternalIdentity)
+	account.Delete("/user/delete", authController.DeleteUser)
+	account.Post("/user/logout", authController.Logout)
+
+	protected.With(guardianMiddleware.RequireScope(entities.ScopeSend)).Post("/send", controller.SendHandler)
+}
+
+// addCheckRoutes lets API keys that may send prompts check them too, while keys only meant for moderation get the check
+// scope alone.
+func addCheckRoutes(protected chi.Router, controller *api.CheckController) {
+	protected.Route("/check", func(r chi.Router) {
+		r.Use(guardianMiddleware.RequireScope(entities.ScopeCheck, entities.ScopeSend))
+		r.Post("/", controller.Check)
+		r.Post("/batch", controller.CheckBatch)
+	})
+}
+
+func addAPIKeyRoutes(protected chi.Router, controller *api.APIKeyController) {
+	protected.Route("/api-keys", func(r chi.Router) {
+		r.Use(guardianMiddleware.RequireScope(entities.ScopeManageAPIKeys))
+		r.Post("/", controller.CreateAPIKey)
+		r.Get("/", controller.GetAPIKeys)
+		r.Delete("/{keyID}", controller.RevokeAPIKey)
+	})
+}
+
+// addGroupRoutes exposes group management to admins and group admins. The group service further limits group admins
+// to the groups they administer.
+func addGroupRoutes(protected chi.Router, controller *api.GroupController) {
+	protected.Route("/groups", func(r chi.Router) {
+		r.Use(guardianMiddleware.RequireRole(entities.RolePlatformAdmin, entities.RoleAdmin, entities.RoleGroupAdmin))
+		r.Use(guardianMiddleware.ActAsOrganization)
+		admins := r.With(guardianMiddleware.RequireRole(entities.RolePlatformAdmin, entities.RoleAdmin))
+		r.Get("/", controller.GetGroups)
+		admins.Post("/", controller.CreateGroup)
+		admins.Put("/{groupID}/admins", controller.SetAdmins)
+		r.Post("/{groupID}/members", controller.AddMember)
+		r.Delete("/{groupID}/members/{userID}", controller.RemoveMember)
+		r.Put("/{groupID}/tasks", controller.SetTasks)
+		r.Put("/{groupID}/targets", controller.SetTargetModels)
+	})
+}
+
+// addAdminRoutes exposes the management of users and plugins to the admins of an organization.
+func addAdminRoutes(protected chi.Router, authController *api.AuthController,
+	pluginController *api.PluginController,
+) {
+	protected.Route("/admin", func(r chi.Router) {
+		r.Use(guardianMiddleware.RequireRole(entities.RolePlatformAdmin, entities.RoleAdmin))
+		r.Use(guardianMiddleware.ActAsOrganization)
+		r.Get("/users", authController.GetUsers)
+		r.Post("/users", authController.CreateUser)
+		r.Put("/users/{userID}/role", authController.SetUserRole)
+		r.Put("/users/{userID}/targets", authController.SetUserTargetModels)
+		r.Get("/plugins/health", pluginController.GetPluginHealth)
+		r.Post("/plugins/bootstrap-tokens", pluginController.CreateBootstrapToken)
+		r.Post("/plugins/builtin", pluginController.CreateBuiltinPlugin)
+		r.Post("/plugins/wasm", pluginController.CreateWasmPlugin)
+		r.Put("/tasks/{taskID}/plugins", pluginController.SetTaskPlugins)
+	})
+}
 
-	protected.Post("/send", controller.SendHandler)
+func addOrganizationRoutes(protected chi.Router, controller *api.OrganizationController) {
+	protected.Route("/organizations", func(r chi.Router) {
+		r.Use(guardianMiddleware.RequireRole(entities.RolePlatformAdmin))
+		r.Get("/", controller.GetOrganizations)
+		r.Post("/", controller.CreateOrganization)
+	})
 }
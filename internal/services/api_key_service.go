@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/repository"
+	"guardian/internal/tenant"
+	"guardian/utlis/logger"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// APIKeyPrefix identifies Guardian API keys, e.g. in secret scanners or the Authorization header.
+	APIKeyPrefix = "gdn_"
+
+	apiKeyPrefixBytes = 6
+	apiKeySecretBytes = 32
+	// apiKeyTouchInterval bounds how often the last-used timestamp of a key gets written.
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrAPIKeyExpired  = errors.New("api key expired")
+	ErrAPIKeyRevoked  = errors.New("api key revoked")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidScope   = errors.New("invalid api key scope")
+	ErrNotGroupMember = errors.New("user is not a member of the group")
+	ErrScopeNotHeld   = errors.New("api key cannot grant more than the calling key holds")
+	ErrInvalidExpiry  = errors.New("invalid api key expiry")
+)
+
+var validScopes = map[string]bool{
+	entities.ScopeSend:          true,
+	entities.ScopeCheck:         true,
+	entities.ScopeManageAPIKeys: true,
+}
+
+type APIKeyServiceInterface interface {
+	CreateAPIKey(ctx context.Context, userID primitive.ObjectID, caller *entities.APIKey,
+		req models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error)
+	GetAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]entities.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error
+	Authenticate(ctx context.Context, rawKey string) (*entities.APIKey, error)
+}
+
+type APIKeyService struct {
+	apiKeyRepo  repository.APIKeyRepoInterface
+	userService UserServiceInterface
+}
+
+func NewAPIKeyService(apiKeyRepo repository.APIKeyRepoInterface, userService UserServiceInterface) *APIKeyService {
+	return &APIKeyService{
+		apiKeyRepo:  apiKeyRepo,
+		userService: userService,
+	}
+}
+
+// CreateAPIKey issues a new key for the user, or for one of its groups, and returns the plaintext key once. caller is
+// the key the request was authenticated with, if any; the new key can't hold more than it does.
+func (a *APIKeyService) CreateAPIKey(ctx context.Context, userID primitive.ObjectID, caller *entities.APIKey,
+	req models.CreateAPIKeyRequest,
+) (*models.CreateAPIKeyResponse, error) {
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{entities.ScopeSend}
+	}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			return nil, errors.Wrap(ErrInvalidScope, scope)
+		}
+		if caller != nil && !caller.HasScope(scope) {
+			return nil, errors.Wrap(ErrScopeNotHeld, scope)
+		}
+	}
+	if caller != nil && caller.GroupID != nil && (req.GroupID == nil || *req.GroupID != *caller.GroupID) {
+		return nil, errors.Wrap(ErrScopeNotHeld, "group")
+	}
+
+	if req.GroupID != nil {
+		user, err := a.userService.GetUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isGroupMember(user, *req.GroupID) {
+			return nil, ErrNotGroupMember
+		}
+	}
+
+	now := time.Now().UTC()
+	expiresAt, err := apiKeyExpiry(now, req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rawKey, prefix, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := entities.APIKey{
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      hashSecret(rawKey),
+		UserID:    userID,
+		GroupID:   req.GroupID,
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	key.ID, err = a.apiKeyRepo.CreateAPIKey(ctx, key)
+	if err != nil {
+		return nil, errors.Errorf("error in creating the api key: %v", err)
+	}
+
+	return &models.CreateAPIKeyResponse{Key: rawKey, APIKey: key}, nil
+}
+
+func (a *APIKeyService) GetAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]entities.APIKey, error) {
+	return a.apiKeyRepo.GetAPIKeysByUser(ctx, userID)
+}
+
+func (a *APIKeyService) RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	revoked, err := a.apiKeyRepo.RevokeAPIKey(ctx, keyID, userID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext key to its stored record, rejecting unknown, revoked and expired keys.
+func (a *APIKeyService) Authenticate(ctx context.Context, rawKey string) (*entities.APIKey, error) {
+	prefix, ok := parseAPIKeyPrefix(rawKey)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// The organization of a key is only known once it has been resolved.
+	key, err := a.apiKeyRepo.GetAPIKeyByPrefix(tenant.Unscoped(ctx), prefix)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(rawKey))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	ctx = tenant.WithOrganization(ctx, key.OrganizationID)
+	if key.GroupID != nil {
+		// Group keys stop working once their owner leaves the group.
+		user, err := a.userService.GetUser(ctx, key.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !isGroupMember(user, *key.GroupID) {
+			return nil, ErrNotGroupMember
+		}
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := a.apiKeyRepo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			logger.GetLogger().Errorf("error in updating last usage of api key %s: %v", key.Prefix, err)
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, nil
+}
+
+// apiKeyExpiry validates the requested expiry, which must lie in the future and, if API_KEY_EXP_TIME is set, within
+// it. Keys without a requested expiry get the longest one allowed.
+func apiKeyExpiry(now time.Time, requested *time.Time) (*time.Time, error) {
+	maxLifetime := configs.GlobalConfig.APIKeyExpirationTime
+	if requested == nil {
+		if maxLifetime <= 0 {
+			return nil, nil
+		}
+		expiresAt := now.Add(maxLifetime)
+		return &expiresAt, nil
+	}
+
+	if !requested.After(now) {
+		return nil, errors.Wrap(ErrInvalidExpiry, "expires_at must be in the future")
+	}
+	if maxLifetime > 0 && requested.After(now.Add(maxLifetime)) {
+		return nil, errors.Wrapf(ErrInvalidExpiry, "expires_at must be within %s", maxLifetime)
+	}
+	expiresAt := requested.UTC()
+	return &expiresAt, nil
+}
+
+// IsAPIKey reports whether the credential looks like a Guardian API key rather than a JWT.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+func generateAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, apiKeyPrefixBytes)
+	secretBytes := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	return APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes), prefix, nil
+}
+
+func parseAPIKeyPrefix(rawKey string) (string, bool) {
+	if !IsAPIKey(rawKey) {
+		return "", false
+	}
+	prefix, secret, found := strings.Cut(strings.TrimPrefix(rawKey, APIKeyPrefix), "_")
+	if !found || len(prefix) != hex.EncodedLen(apiKeyPrefixBytes) || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// hashSecret uses a plain SHA-256 as API keys and refresh tokens carry 256 bits of entropy, so a slow KDF adds nothing
+// but latency.
+func hashSecret(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func isGroupMember(user *entities.User, groupID primitive.ObjectID) bool {
+	for _, group := range user.Groups {
+		if group.ID == groupID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	configs.GlobalConfig.APIKeyExpirationTime = 24 * time.Hour
+	userID := primitive.NewObjectID()
+	groupID := primitive.NewObjectID()
+
+	t.Run("issues a hashed, prefixed key", func(t *testing.T) {
+		apiKeyRepo := new(mocks.MockAPIKeyRepo)
+		service := NewAPIKeyService(apiKeyRepo, new(mocks.MockUserService))
+		keyID := primitive.NewObjectID()
+		apiKeyRepo.On("CreateAPIKey", mock.Anything).Return(keyID, nil)
+
+		resp, err := service.CreateAPIKey(context.Background(), userID, nil, models.CreateAPIKeyRequest{Name: "batch"})
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(resp.Key, APIKeyPrefix+resp.APIKey.Prefix+"_"))
+		assert.Equal(t, keyID, resp.APIKey.ID)
+		assert.Equal(t, hashSecret(resp.Key), resp.APIKey.Hash)
+		assert.NotContains(t, resp.APIKey.Hash, resp.Key)
+		assert.Equal(t, []string{entities.ScopeSend}, resp.APIKey.Scopes)
+		require.NotNil(t, resp.APIKey.ExpiresAt)
+	})
+
+	t.Run("rejects unknown scopes", func(t *testing.T) {
+		service := NewAPIKeyService(new(mocks.MockAPIKeyRepo), new(mocks.MockUserService))
+
+		_, err := service.CreateAPIKey(context.Background(), userID, nil,
+			models.CreateAPIKeyRequest{Scopes: []string{"admin"}})
+		require.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("group keys require membership", func(t *testing.T) {
+		userService := new(mocks.MockUserService)
+		service := NewAPIKeyService(new(mocks.MockAPIKeyRepo), userService)
+		userService.On("GetUser", userID).Return(&entities.User{ID: userID}, nil)
+
+		_, err := service.CreateAPIKey(context.Background(), userID, nil,
+			models.CreateAPIKeyRequest{GroupID: &groupID})
+		require.ErrorIs(t, err, ErrNotGroupMember)
+	})
+
+	t.Run("keys can't grant more than the calling key", func(t *testing.T) {
+		service := NewAPIKeyService(new(mocks.MockAPIKeyRepo), new(mocks.MockUserService))
+		caller := &entities.APIKey{Scopes: []string{entities.ScopeManageAPIKeys}}
+
+		_, err := service.CreateAPIKey(context.Background(), userID, caller,
+			models.CreateAPIKeyRequest{Scopes: []string{entities.ScopeSend}})
+		require.ErrorIs(t, err, ErrScopeNotHeld)
+	})
+
+	t.Run("group keys can't mint keys outside their group", func(t *testing.T) {
+		service := NewAPIKeyService(new(mocks.MockAPIKeyRepo), new(mocks.MockUserService))
+		caller := &entities.APIKey{GroupID: &groupID, Scopes: []string{entities.ScopeSend, entities.ScopeManageAPIKeys}}
+
+		_, err := service.CreateAPIKey(context.Background(), userID, caller, models.CreateAPIKeyRequest{})
+		require.ErrorIs(t, err, ErrScopeNotHeld)
+	})
+
+	t.Run("rejects invalid expiries", func(t *testing.T) {
+		service := NewAPIKeyService(new(mocks.MockAPIKeyRepo), new(mocks.MockUserService))
+		past := time.Now().Add(-time.Minute)
+		tooLate := time.Now().Add(48 * time.Hour)
+
+		for _, expiresAt := range []*time.Time{&past, &tooLate} {
+			_, err := service.CreateAPIKey(context.Background(), userID, nil,
+				models.CreateAPIKeyRequest{ExpiresAt: expiresAt})
+			require.ErrorIs(t, err, ErrInvalidExpiry)
+		}
+	})
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	rawKey, prefix, err := generateAPIKey()
+	require.NoError(t, err)
+	past := time.Now().Add(-time.Hour)
+	userID := primitive.NewObjectID()
+	groupID := primitive.NewObjectID()
+
+	tests := []struct {
+		name        string
+		rawKey      string
+		stored      *entities.APIKey
+		repoErr     error
+		expectedErr error
+	}{
+		{
+			name:   "valid key",
+			rawKey: rawKey,
+			stored: &entities.APIKey{Prefix: prefix, Hash: hashSecret(rawKey)},
+		},
+		{
+			name:        "group key of a former member",
+			rawKey:      rawKey,
+			stored:      &entities.APIKey{Prefix: prefix, Hash: hashSecret(rawKey), UserID: userID, GroupID: &groupID},
+			expectedErr: ErrNotGroupMember,
+		},
+		{
+			name:        "malformed key",
+			rawKey:      "not-a-key",
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "unknown prefix",
+			rawKey:      rawKey,
+			repoErr:     mongo.ErrNoDocuments,
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "wrong secret",
+			rawKey:      rawKey + "x",
+			stored:      &entities.APIKey{Prefix: prefix, Hash: hashSecret(rawKey)},
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "revoked key",
+			rawKey:      rawKey,
+			stored:      &entities.APIKey{Prefix: prefix, Hash: hashSecret(rawKey), RevokedAt: &past},
+			expectedErr: ErrAPIKeyRevoked,
+		},
+		{
+			name:        "expired key",
+			rawKey:      rawKey,
+			stored:      &entities.APIKey{Prefix: prefix, Hash: hashSecret(rawKey), ExpiresAt: &past},
+			expectedErr: ErrAPIKeyExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			apiKeyRepo := new(mocks.MockAPIKeyRepo)
+			userService := new(mocks.MockUserService)
+			service := NewAPIKeyService(apiKeyRepo, userService)
+			userService.On("GetUser", userID).Return(&entities.User{ID: userID}, nil)
+			apiKeyRepo.On("GetAPIKeyByPrefix", prefix).Return(tt.stored, tt.repoErr)
+			apiKeyRepo.On("TouchAPIKey", mock.Anything).Return(nil)
+
+			key, err := service.Authenticate(context.Background(), tt.rawKey)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, key.LastUsedAt)
+			apiKeyRepo.AssertCalled(t, "TouchAPIKey", mock.Anything)
+		})
+	}
+}
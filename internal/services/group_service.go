@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/repository"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrForbidden     = errors.New("not allowed to manage this group")
+	ErrGroupNotFound = errors.New("group not found")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrTaskNotFound  = errors.New("task not found")
+	ErrInvalidGroup  = errors.New("group name is required")
+	ErrModelNotFound = errors.New("target model not found")
+)
+
+type GroupServiceInterface interface {
+	CreateGroup(ctx context.Context, req models.CreateGroupRequest) (*entities.Group, error)
+	GetGroups(ctx context.Context, actor models.Actor) ([]entities.Group, error)
+	AddMember(ctx context.Context, actor models.Actor, groupID, userID primitive.ObjectID) error
+	RemoveMember(ctx context.Context, actor models.Actor, groupID, userID primitive.ObjectID) error
+	SetTasks(ctx context.Context, actor models.Actor, groupID primitive.ObjectID, taskIDs []primitive.ObjectID) error
+	SetTargetModels(ctx context.Context, actor models.Actor, groupID primitive.ObjectID,
+		modelIDs []primitive.ObjectID) error
+	SetAdmins(ctx context.Context, groupID primitive.ObjectID, admins []primitive.ObjectID) error
+}
+
+type GroupService struct {
+	groupRepo       repository.GroupRepoInterface
+	userRepo        repository.UserRepoInterface
+	taskRepo        repository.TaskRepoInterface
+	targetModelRepo repository.TargetModelRepoInterface
+}
+
+func NewGroupService(groupRepo repository.GroupRepoInterface, userRepo repository.UserRepoInterface,
+	taskRepo repository.TaskRepoInterface, targetModelRepo repository.TargetModelRepoInterface,
+) *GroupService {
+	return &GroupService{
+		groupRepo:       groupRepo,
+		userRepo:        userRepo,
+		taskRepo:        taskRepo,
+		targetModelRepo: targetModelRepo,
+	}
+}
+
+func (g *GroupService) CreateGroup(ctx context.Context, req models.CreateGroupRequest) (*entities.Group, error) {
+	if req.Name == "" {
+		return nil, ErrInvalidGroup
+	}
+	err := g.ensureUsersExist(ctx, req.Admins)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := g.groupRepo.CreateGroup(ctx, entities.Group{
+		Name:   req.Name,
+		Status: 1,
+		Admins: req.Admins,
+	})
+	if err != nil {
+		return nil, errors.Errorf("error in creating group: %v", err)
+	}
+	return &group, nil
+}
+
+// GetGroups returns every group to admins and only the administered groups to group admins.
+func (g *GroupService) GetGroups(ctx context.Context, actor models.Actor) ([]entities.Group, error) {
+	if entities.IsAdminRole(actor.Role) {
+		return g.groupRepo.GetAllGroups(ctx)
+	}
+	return g.groupRepo.GetGroupsByAdmin(ctx, actor.UserID)
+}
+
+// AddMember adds the user to the group. Group admins may only add users who are members of one of the groups they
+// administer already, so they can't pull arbitrary users under their group's tasks.
+func (g *GroupService) AddMember(ctx context.Context, actor models.Actor, groupID, userID primitive.ObjectID) error {
+	group, err := g.authorize(ctx, actor, groupID)
+	if err != nil {
+		return err
+	}
+	user, err := g.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if actor.Role == entities.RoleGroupAdmin {
+		err = g.ensureManagedUser(ctx, actor, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = g.userRepo.AddUserToGroup(ctx, userID, membership(*group))
+	if err != nil {
+		return errors.Errorf("error in adding the group member: %v", err)
+	}
+	return nil
+}
+
+func (g *GroupService) RemoveMember(ctx context.Context, actor models.Actor, groupID,
+	userID primitive.ObjectID,
+) error {
+	_, err := g.authorize(ctx, actor, groupID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := g.userRepo.RemoveUserFromGroup(ctx, userID, groupID)
+	if err != nil {
+		return errors.Errorf("error in removing the group member: %v", err)
+	}
+	if removed == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (g *GroupService) SetTasks(ctx context.Context, actor models.Actor, groupID primitive.ObjectID,
+	taskIDs []primitive.ObjectID,
+) error {
+	_, err := g.authorize(ctx, actor, groupID)
+	if err != nil {
+		return err
+	}
+
+	taskIDs = uniqueIDs(taskIDs)
+	if len(taskIDs) > 0 {
+		tasks, err := g.taskRepo.GetTasks(ctx, taskIDs)
+		if err != nil {
+			return err
+		}
+		if len(tasks) != len(taskIDs) {
+			return ErrTaskNotFound
+		}
+	}
+
+	return g.groupRepo.SetGroupTasks(ctx, groupID, taskIDs)
+}
+
+// SetTargetModels sets the target models the group's members may be routed to.
+func (g *GroupService) SetTargetModels(ctx context.Context, actor models.Actor, groupID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) error {
+	_, err := g.authorize(ctx, actor, groupID)
+	if err != nil {
+		return err
+	}
+
+	modelIDs = uniqueIDs(modelIDs)
+	if len(modelIDs) > 0 {
+		targetModels, err := g.targetModelRepo.GetModels(ctx, modelIDs)
+		if err != nil {
+			return err
+		}
+		if len(targetModels) != len(modelIDs) {
+			return ErrModelNotFound
+		}
+	}
+
+	return g.groupRepo.SetGroupTargetModels(ctx, groupID, modelIDs)
+}
+
+func (g *GroupService) SetAdmins(ctx context.Context, groupID primitive.ObjectID, admins []primitive.ObjectID) error {
+	_, err := g.getGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	admins = uniqueIDs(admins)
+	err = g.ensureUsersExist(ctx, admins)
+	if err != nil {
+		return err
+	}
+	return g.groupRepo.SetGroupAdmins(ctx, groupID, admins)
+}
+
+// authorize lets admins manage any group and group admins only the groups they administer.
+func (g *GroupService) authorize(ctx context.Context, actor models.Actor, groupID primitive.ObjectID) (
+	*entities.Group, error,
+) {
+	if !entities.IsAdminRole(actor.Role) && actor.Role != entities.RoleGroupAdmin {
+		return nil, ErrForbidden
+	}
+
+	group, err := g.getGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if actor.Role == entities.RoleGroupAdmin && !group.HasAdmin(actor.UserID) {
+		return nil, ErrForbidden
+	}
+	return group, nil
+}
+
+// ensureManagedUser checks that the user is a member of one of the groups the group admin administers.
+func (g *GroupService) ensureManagedUser(ctx context.Context, actor models.Actor, user *entities.User) error {
+	administered, err := g.groupRepo.GetGroupsByAdmin(ctx, actor.UserID)
+	if err != nil {
+		return err
+	}
+	for _, group := range administered {
+		if isGroupMember(user, group.ID) {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+func (g *GroupService) getGroup(ctx context.Context, groupID primitive.ObjectID) (*entities.Group, error) {
+	group, err := g.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return group, nil
+}
+
+func (g *GroupService) ensureUsersExist(ctx context.Context, userIDs []primitive.ObjectID) error {
+	for _, userID := range userIDs {
+		_, err := g.userRepo.GetUser(ctx, userID)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// membership is the copy of a group embedded in its members. Tasks and admins are always read from the group itself.
+func membership(group entities.Group) entities.Group {
+	return entities.Group{ID: group.ID, Name: group.Name, Status: group.Status}
+}
+
+func uniqueIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestGroupService_AddMember(t *testing.T) {
+	t.Parallel()
+
+	adminID := primitive.NewObjectID()
+	memberID := primitive.NewObjectID()
+	group := &entities.Group{
+		ID:     primitive.NewObjectID(),
+		Name:   "ml",
+		Status: 1,
+		Tasks:  []primitive.ObjectID{primitive.NewObjectID()},
+		Admins: []primitive.ObjectID{adminID},
+	}
+	otherGroup := entities.Group{ID: primitive.NewObjectID(), Name: "nlp", Admins: []primitive.ObjectID{adminID}}
+
+	tests := []struct {
+		name        string
+		actor       models.Actor
+		memberOf    []entities.Group
+		expectedErr error
+	}{
+		{name: "admin manages any group", actor: models.Actor{UserID: primitive.NewObjectID(), Role: entities.RoleAdmin}},
+		{
+			name:     "group admin adds a user of another administered group",
+			actor:    models.Actor{UserID: adminID, Role: entities.RoleGroupAdmin},
+			memberOf: []entities.Group{{ID: otherGroup.ID}},
+		},
+		{
+			name:        "group admin adds a user outside its groups",
+			actor:       models.Actor{UserID: adminID, Role: entities.RoleGroupAdmin},
+			expectedErr: ErrForbidden,
+		},
+		{
+			name:        "group admin of another group",
+			actor:       models.Actor{UserID: primitive.NewObjectID(), Role: entities.RoleGroupAdmin},
+			expectedErr: ErrForbidden,
+		},
+		{
+			name:        "regular user",
+			actor:       models.Actor{UserID: adminID, Role: entities.RoleUser},
+			expectedErr: ErrForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			groupRepo := new(mocks.MockGroupRepo)
+			userRepo := new(mocks.MockUserRepo)
+			service := NewGroupService(groupRepo, userRepo, new(mocks.MockTaskRepo), new(mocks.MockTargetModelRepo))
+			groupRepo.On("GetGroup", group.ID).Return(group, nil)
+			groupRepo.On("GetGroupsByAdmin", adminID).Return([]entities.Group{*group, otherGroup}, nil)
+			userRepo.On("GetUser", memberID).Return(&entities.User{ID: memberID, Groups: tt.memberOf}, nil)
+			userRepo.On("AddUserToGroup", memberID, entities.Group{ID: group.ID, Name: "ml", Status: 1}).
+				Return(int64(1), nil)
+
+			err := service.AddMember(context.Background(), tt.actor, group.ID, memberID)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				userRepo.AssertNotCalled(t, "AddUserToGroup", mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			userRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGroupService_SetTasks(t *testing.T) {
+	t.Parallel()
+
+	admin := models.Actor{UserID: primitive.NewObjectID(), Role: entities.RoleAdmin}
+	groupID := primitive.NewObjectID()
+	taskID := primitive.NewObjectID()
+
+	t.Run("unknown task", func(t *testing.T) {
+		t.Parallel()
+
+		groupRepo := new(mocks.MockGroupRepo)
+		taskRepo := new(mocks.MockTaskRepo)
+		service := NewGroupService(groupRepo, new(mocks.MockUserRepo), taskRepo, new(mocks.MockTargetModelRepo))
+		groupRepo.On("GetGroup", groupID).Return(&entities.Group{ID: groupID}, nil)
+		taskRepo.On("GetTasks", []primitive.ObjectID{taskID}).Return([]entities.Task{}, nil)
+
+		err := service.SetTasks(context.Background(), admin, groupID, []primitive.ObjectID{taskID, taskID})
+		require.ErrorIs(t, err, ErrTaskNotFound)
+		groupRepo.AssertNotCalled(t, "SetGroupTasks", mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown group", func(t *testing.T) {
+		t.Parallel()
+
+		groupRepo := new(mocks.MockGroupRepo)
+		service := NewGroupService(groupRepo, new(mocks.MockUserRepo), new(mocks.MockTaskRepo),
+			new(mocks.MockTargetModelRepo))
+		groupRepo.On("GetGroup", groupID).Return(nil, mongo.ErrNoDocuments)
+
+		err := service.SetTasks(context.Background(), admin, groupID, nil)
+		require.ErrorIs(t, err, ErrGroupNotFound)
+	})
+
+	t.Run("deduplicates tasks", func(t *testing.T) {
+		t.Parallel()
+
+		groupRepo := new(mocks.MockGroupRepo)
+		taskRepo := new(mocks.MockTaskRepo)
+		service := NewGroupService(groupRepo, new(mocks.MockUserRepo), taskRepo, new(mocks.MockTargetModelRepo))
+		groupRepo.On("GetGroup", groupID).Return(&entities.Group{ID: groupID}, nil)
+		taskRepo.On("GetTasks", []primitive.ObjectID{taskID}).Return([]entities.Task{{ID: taskID}}, nil)
+		groupRepo.On("SetGroupTasks", groupID, []primitive.ObjectID{taskID}).Return(nil)
+
+		err := service.SetTasks(context.Background(), admin, groupID, []primitive.ObjectID{taskID, taskID})
+		require.NoError(t, err)
+		groupRepo.AssertExpectations(t)
+	})
+}
+
+func TestGroupService_SetTargetModels(t *testing.T) {
+	t.Parallel()
+
+	admin := models.Actor{UserID: primitive.NewObjectID(), Role: entities.RoleAdmin}
+	groupID := primitive.NewObjectID()
+	modelID := primitive.NewObjectID()
+
+	t.Run("unknown target model", func(t *testing.T) {
+		t.Parallel()
+
+		groupRepo := new(mocks.MockGroupRepo)
+		targetModelRepo := new(mocks.MockTargetModelRepo)
+		service := NewGroupService(groupRepo, new(mocks.MockUserRepo), new(mocks.MockTaskRepo), targetModelRepo)
+		groupRepo.On("GetGroup", groupID).Return(&entities.Group{ID: groupID}, nil)
+		targetModelRepo.On("GetModels", []primitive.ObjectID{modelID}).Return([]entities.TargetModel{}, nil)
+
+		err := service.SetTargetModels(context.Background(), admin, groupID, []primitive.ObjectID{modelID})
+		require.ErrorIs(t, err, ErrModelNotFound)
+		groupRepo.AssertNotCalled(t, "SetGroupTargetModels", mock.Anything, mock.Anything)
+	})
+
+	t.Run("deduplicates target models", func(t *testing.T) {
+		t.Parallel()
+
+		groupRepo := new(mocks.MockGroupRepo)
+		targetModelRepo := new(mocks.MockTargetModelRepo)
+		service := NewGroupService(groupRepo, new(mocks.MockUserRepo), new(mocks.MockTaskRepo), targetModelRepo)
+		groupRepo.On("GetGroup", groupID).Return(&entities.Group{ID: groupID}, nil)
+		targetModelRepo.On("GetModels", []primitive.ObjectID{modelID}).
+			Return([]entities.TargetModel{{ID: modelID}}, nil)
+		groupRepo.On("SetGroupTargetModels", groupID, []primitive.ObjectID{modelID}).Return(nil)
+
+		err := service.SetTargetModels(context.Background(), admin, groupID, []primitive.ObjectID{modelID, modelID})
+		require.NoError(t, err)
+		groupRepo.AssertExpectations(t)
+	})
+}
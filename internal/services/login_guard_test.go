@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLoginLockout() {
+	configs.GlobalConfig.LoginLockout = configs.LoginLockoutConfig{
+		MaxAttempts:      3,
+		MaxAttemptsPerIP: 10,
+		BaseLockout:      30 * time.Second,
+		MaxLockout:       time.Hour,
+		Window:           24 * time.Hour,
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 30*time.Second, lockoutDuration(0, 30*time.Second, time.Hour))
+	assert.Equal(t, 2*time.Minute, lockoutDuration(2, 30*time.Second, time.Hour))
+	assert.Equal(t, time.Hour, lockoutDuration(20, 30*time.Second, time.Hour))
+	assert.Equal(t, time.Hour, lockoutDuration(1000, 30*time.Second, time.Hour))
+}
+
+func TestLoginGuard_CheckLogin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		account     time.Time
+		ip          time.Time
+		expectedErr error
+	}{
+		{name: "not locked"},
+		{name: "lockout has passed", account: time.Now().Add(-time.Minute)},
+		{name: "account locked", account: time.Now().Add(time.Minute), expectedErr: ErrLoginLocked},
+		{name: "ip locked", ip: time.Now().Add(time.Minute), expectedErr: ErrLoginLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := new(mocks.MockLoginAttemptStore)
+			store.On("LockedUntil", "account:jane@example.com").Return(tt.account, nil)
+			store.On("LockedUntil", "ip:192.0.2.1").Return(tt.ip, nil)
+			guard := NewLoginGuard(store, new(mocks.MockAuditRepo))
+
+			err := guard.CheckLogin(context.Background(), " Jane@Example.com", "192.0.2.1")
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedErr)
+			var locked *LoginLockedError
+			require.ErrorAs(t, err, &locked)
+			assert.Greater(t, locked.RetryAfter, time.Duration(0))
+		})
+	}
+}
+
+func TestLoginGuard_RecordFailure(t *testing.T) {
+	setupLoginLockout()
+
+	t.Run("below the allowed attempts", func(t *testing.T) {
+		store := new(mocks.MockLoginAttemptStore)
+		store.On("RegisterFailure", "account:jane@example.com", 24*time.Hour).Return(int64(2), nil)
+		store.On("RegisterFailure", "ip:192.0.2.1", 24*time.Hour).Return(int64(2), nil)
+		auditRepo := new(mocks.MockAuditRepo)
+		guard := NewLoginGuard(store, auditRepo)
+
+		guard.RecordFailure(context.Background(), "jane@example.com", "192.0.2.1")
+		store.AssertNotCalled(t, "Lock", mock.Anything, mock.Anything)
+		auditRepo.AssertNotCalled(t, "RecordEvent", mock.Anything)
+	})
+
+	t.Run("locks the account out and audits it", func(t *testing.T) {
+		store := new(mocks.MockLoginAttemptStore)
+		store.On("RegisterFailure", "account:jane@example.com", 24*time.Hour).Return(int64(4), nil)
+		store.On("RegisterFailure", "ip:192.0.2.1", 24*time.Hour).Return(int64(4), nil)
+		store.On("Lock", "account:jane@example.com", mock.MatchedBy(func(until time.Time) bool {
+			lockout := time.Until(until)
+			return lockout > 55*time.Second && lockout <= time.Minute
+		})).Return(nil)
+		auditRepo := new(mocks.MockAuditRepo)
+		auditRepo.On("RecordEvent", mock.MatchedBy(func(event entities.AuditEvent) bool {
+			return event.Type == entities.AuditAccountLocked && event.Subject == "jane@example.com" &&
+				event.Details["failures"] == "4"
+		})).Return(nil)
+		guard := NewLoginGuard(store, auditRepo)
+
+		guard.RecordFailure(context.Background(), "jane@example.com", "192.0.2.1")
+		store.AssertExpectations(t)
+		auditRepo.AssertExpectations(t)
+	})
+}
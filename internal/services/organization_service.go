@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/repository"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrOrganizationExists   = errors.New("organization slug is already in use")
+	ErrInvalidOrganization  = errors.New("organization needs a name and a slug of lowercase letters, digits and dashes")
+	ErrNoOrganization       = errors.New("request is not scoped to an organization")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type OrganizationServiceInterface interface {
+	CreateOrganization(ctx context.Context, req models.CreateOrganizationRequest) (*entities.Organization, error)
+	GetOrganizations(ctx context.Context) ([]entities.Organization, error)
+	EnsureDefaultOrganization(ctx context.Context) (*entities.Organization, error)
+}
+
+type OrganizationService struct {
+	organizationRepo repository.OrganizationRepoInterface
+}
+
+func NewOrganizationService(organizationRepo repository.OrganizationRepoInterface) *OrganizationService {
+	return &OrganizationService{
+		organizationRepo: organizationRepo,
+	}
+}
+
+func (o *OrganizationService) CreateOrganization(ctx context.Context, req models.CreateOrganizationRequest) (
+	*entities.Organization, error,
+) {
+	if req.Name == "" || !slugPattern.MatchString(req.Slug) {
+		return nil, ErrInvalidOrganization
+	}
+
+	_, err := o.organizationRepo.GetOrganizationBySlug(ctx, req.Slug)
+	switch {
+	case err == nil:
+		return nil, ErrOrganizationExists
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return nil, errors.Errorf("error in finding the organization: %v", err)
+	}
+
+	organization, err := o.organizationRepo.CreateOrganization(ctx, entities.Organization{
+		Name:      req.Name,
+		Slug:      req.Slug,
+		Status:    1,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, errors.Errorf("error in creating the organization: %v", err)
+	}
+	return organization, nil
+}
+
+func (o *OrganizationService) GetOrganizations(ctx context.Context) ([]entities.Organization, error) {
+	return o.organizationRepo.GetOrganizations(ctx)
+}
+
+// EnsureDefaultOrganization returns the organization self sign ups and external users without an organization claim
+// join, creating it on first start.
+func (o *OrganizationService) EnsureDefaultOrganization(ctx context.Context) (*entities.Organization, error) {
+	slug := configs.GlobalConfig.DefaultOrganization
+	organization, err := o.organizationRepo.GetOrganizationBySlug(ctx, slug)
+	if err == nil {
+		return organization, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Errorf("error in finding the default organization: %v", err)
+	}
+
+	return o.CreateOrganization(ctx, models.CreateOrganizationRequest{Name: slug, Slug: slug})
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCreateOrganization(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		req         models.CreateOrganizationRequest
+		existing    *entities.Organization
+		expectedErr error
+	}{
+		{name: "creates the organization", req: models.CreateOrganizationRequest{Name: "Risk", Slug: "risk"}},
+		{
+			name:        "slug in use",
+			req:         models.CreateOrganizationRequest{Name: "Risk", Slug: "risk"},
+			existing:    &entities.Organization{Slug: "risk"},
+			expectedErr: ErrOrganizationExists,
+		},
+		{
+			name:        "invalid slug",
+			req:         models.CreateOrganizationRequest{Name: "Risk", Slug: "Risk Team"},
+			expectedErr: ErrInvalidOrganization,
+		},
+		{
+			name:        "missing name",
+			req:         models.CreateOrganizationRequest{Slug: "risk"},
+			expectedErr: ErrInvalidOrganization,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			organizationRepo := new(mocks.MockOrganizationRepo)
+			if tt.existing != nil {
+				organizationRepo.On("GetOrganizationBySlug", tt.req.Slug).Return(tt.existing, nil)
+			} else {
+				organizationRepo.On("GetOrganizationBySlug", tt.req.Slug).Return(nil, mongo.ErrNoDocuments)
+			}
+			organizationRepo.On("CreateOrganization", mock.Anything).Return(
+				&entities.Organization{ID: primitive.NewObjectID(), Name: tt.req.Name, Slug: tt.req.Slug}, nil)
+			service := NewOrganizationService(organizationRepo)
+
+			organization, err := service.CreateOrganization(context.Background(), tt.req)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				organizationRepo.AssertNotCalled(t, "CreateOrganization", mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.req.Slug, organization.Slug)
+		})
+	}
+}
+
+func TestEnsureDefaultOrganization(t *testing.T) {
+	configs.GlobalConfig.DefaultOrganization = "default"
+	existing := &entities.Organization{ID: primitive.NewObjectID(), Slug: "default"}
+
+	t.Run("returns the existing organization", func(t *testing.T) {
+		organizationRepo := new(mocks.MockOrganizationRepo)
+		organizationRepo.On("GetOrganizationBySlug", "default").Return(existing, nil)
+		service := NewOrganizationService(organizationRepo)
+
+		organization, err := service.EnsureDefaultOrganization(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, existing.ID, organization.ID)
+		organizationRepo.AssertNotCalled(t, "CreateOrganization", mock.Anything)
+	})
+
+	t.Run("creates it on first start", func(t *testing.T) {
+		organizationRepo := new(mocks.MockOrganizationRepo)
+		organizationRepo.On("GetOrganizationBySlug", "default").Return(nil, mongo.ErrNoDocuments)
+		organizationRepo.On("CreateOrganization", mock.MatchedBy(func(organization entities.Organization) bool {
+			return organization.Slug == "default"
+		})).Return(existing, nil)
+		service := NewOrganizationService(organizationRepo)
+
+		organization, err := service.EnsureDefaultOrganization(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, existing.ID, organization.ID)
+		organizationRepo.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/metrics"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+	"guardian/internal/repository"
+	"guardian/internal/tenant"
+	"guardian/utlis/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PluginHealthServiceInterface checks whether the active plugins are alive and reports their health.
+type PluginHealthServiceInterface interface {
+	CheckPlugins(ctx context.Context) error
+	GetPluginHealth(ctx context.Context) ([]models.PluginHealth, error)
+}
+
+type PluginHealthService struct {
+	pluginRepo repository.PluginRepoInterface
+	client     plugins.HTTPClientInterface
+	health     *plugins.HealthRegistry
+}
+
+func NewPluginHealthService(pluginRepo repository.PluginRepoInterface,
+	client plugins.HTTPClientInterface,
+) *PluginHealthService {
+	return &PluginHealthService{
+		pluginRepo: pluginRepo,
+		client:     client,
+		health:     plugins.DefaultHealth,
+	}
+}
+
+// Run checks the plugins every interval until the context is done.
+func (s *PluginHealthService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.CheckPlugins(ctx)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to check the health of the plugins: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckPlugins probes the active plugins of every organization at once. Plugins that fail too many checks in a row are
+// degraded, so that the pipeline fails them right away instead of waiting on their timeouts.
+func (s *PluginHealthService) CheckPlugins(ctx context.Context) error {
+	pluginList, err := s.pluginRepo.GetActivePlugins(tenant.Unscoped(ctx))
+	if err != nil {
+		return err
+	}
+
+	active := make(map[primitive.ObjectID]struct{}, len(pluginList))
+	var wg sync.WaitGroup
+	for i := range pluginList {
+		plugin := &pluginList[i]
+		active[plugin.ID] = struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.checkPlugin(ctx, plugin)
+		}()
+	}
+	wg.Wait()
+
+	for _, health := range s.health.Retain(active) {
+		metrics.ForgetPlugin(health.PluginID.Hex(), health.Name)
+	}
+	return nil
+}
+
+func (s *PluginHealthService) checkPlugin(ctx context.Context, plugin *entities.Plugin) {
+	ctx, cancel := context.WithTimeout(ctx, configs.GlobalConfig.PluginHealth.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.probe(ctx, plugin)
+	latency := time.Since(start)
+
+	wasDegraded := s.health.IsDegraded(plugin.ID)
+	health := s.health.Record(plugin, latency, err, configs.GlobalConfig.PluginHealth.FailureThreshold)
+	metrics.ObservePluginHealth(plugin.ID.Hex(), plugin.Name, err == nil, latency)
+
+	switch {
+	case health.Status == models.PluginHealthDegraded && !wasDegraded:
+		logger.GetLogger().Warnf("Plugin %s is degraded: %v", plugin.Name, err)
+	case health.Status == models.PluginHealthHealthy && wasDegraded:
+		logger.GetLogger().Infof("Plugin %s recovered", plugin.Name)
+	}
+}
+
+func (s *PluginHealthService) probe(ctx context.Context, plugin *entities.Plugin) error {
+	switch plugin.Protocol.Type {
+	case entities.HTTPProtocol:
+		return s.client.Check(ctx, plugin)
+
+	case entities.GRPCProtocol:
+		grpcConn, err := configs.GlobalConfig.GRPCManager.GetClient(*plugin)
+		if err != nil {
+			return fmt.Errorf("%w: %w", plugins.ErrPluginUnhealthy, err)
+		}
+		return plugins.NewPluginGRPCClient(grpcConn).Check(ctx, plugin)
+
+	case entities.BuiltinProtocol:
+		return plugins.DefaultBuiltin.Check(ctx, plugin)
+
+	case entities.WasmProtocol:
+		if plugins.DefaultWasm == nil {
+			return fmt.Errorf("%w: the wasm runtime is unavailable", plugins.ErrPluginUnhealthy)
+		}
+		return plugins.DefaultWasm.Check(ctx, plugin)
+
+	default:
+		return fmt.Errorf("unsupported protocol type: %s", plugin.Protocol.Type)
+	}
+}
+
+// GetPluginHealth returns the health of the active plugins of the organization.
+func (s *PluginHealthService) GetPluginHealth(ctx context.Context) ([]models.PluginHealth, error) {
+	pluginList, err := s.pluginRepo.GetActivePlugins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	health := make([]models.PluginHealth, 0, len(pluginList))
+	for i := range pluginList {
+		health = append(health, s.health.Get(&pluginList[i]))
+	}
+	return health, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestPluginHealthService(pluginRepo *mocks.MockPluginRepo, client *mocks.MockClient) *PluginHealthService {
+	service := NewPluginHealthService(pluginRepo, client)
+	service.health = plugins.NewHealthRegistry()
+	return service
+}
+
+func TestPluginHealthService_CheckPlugins(t *testing.T) {
+	configs.GlobalConfig.PluginHealth = configs.PluginHealthConfig{Timeout: time.Second, FailureThreshold: 2}
+
+	healthy := entities.Plugin{ID: primitive.NewObjectID(), Name: "healthy", Status: entities.PluginActive,
+		Protocol: entities.Protocol{Type: entities.HTTPProtocol}}
+	failing := entities.Plugin{ID: primitive.NewObjectID(), Name: "failing", Status: entities.PluginActive,
+		Protocol: entities.Protocol{Type: entities.HTTPProtocol}}
+
+	pluginRepo := new(mocks.MockPluginRepo)
+	pluginRepo.On("GetActivePlugins").Return([]entities.Plugin{healthy, failing}, nil)
+	client := new(mocks.MockClient)
+	client.On("Check", mock.Anything, &healthy).Return(nil)
+	failingCheck := client.On("Check", mock.Anything, &failing).Return(plugins.ErrPluginUnhealthy)
+	service := newTestPluginHealthService(pluginRepo, client)
+
+	require.NoError(t, service.CheckPlugins(context.Background()))
+	require.Equal(t, models.PluginHealthHealthy, service.health.Get(&healthy).Status)
+	require.Equal(t, models.PluginHealthUnknown, service.health.Get(&failing).Status)
+	require.False(t, service.health.IsDegraded(failing.ID))
+
+	require.NoError(t, service.CheckPlugins(context.Background()))
+	health := service.health.Get(&failing)
+	require.Equal(t, models.PluginHealthDegraded, health.Status)
+	require.Equal(t, 2, health.ConsecutiveFailures)
+	require.Equal(t, plugins.ErrPluginUnhealthy.Error(), health.LastError)
+	require.True(t, service.health.IsDegraded(failing.ID))
+
+	failingCheck.Unset()
+	client.On("Check", mock.Anything, &failing).Return(nil)
+	require.NoError(t, service.CheckPlugins(context.Background()))
+	require.Equal(t, models.PluginHealthHealthy, service.health.Get(&failing).Status)
+	require.Zero(t, service.health.Get(&failing).ConsecutiveFailures)
+}
+
+func TestPluginHealthService_ForgetsInactivePlugins(t *testing.T) {
+	configs.GlobalConfig.PluginHealth = configs.PluginHealthConfig{Timeout: time.Second, FailureThreshold: 1}
+
+	plugin := entities.Plugin{ID: primitive.NewObjectID(), Name: "plugin", Status: entities.PluginActive,
+		Protocol: entities.Protocol{Type: entities.HTTPProtocol}}
+	pluginRepo := new(mocks.MockPluginRepo)
+	activePlugins := pluginRepo.On("GetActivePlugins").Return([]entities.Plugin{plugin}, nil)
+	client := new(mocks.MockClient)
+	client.On("Check", mock.Anything, &plugin).Return(plugins.ErrPluginUnhealthy)
+	service := newTestPluginHealthService(pluginRepo, client)
+
+	require.NoError(t, service.CheckPlugins(context.Background()))
+	require.True(t, service.health.IsDegraded(plugin.ID))
+
+	activePlugins.Unset()
+	pluginRepo.On("GetActivePlugins").Return([]entities.Plugin{}, nil)
+	require.NoError(t, service.CheckPlugins(context.Background()))
+	require.False(t, service.health.IsDegraded(plugin.ID))
+}
+
+func TestPluginHealthService_GetPluginHealth(t *testing.T) {
+	configs.GlobalConfig.PluginHealth = configs.PluginHealthConfig{Timeout: time.Second, FailureThreshold: 1}
+
+	checked := entities.Plugin{ID: primitive.NewObjectID(), Name: "checked"}
+	unchecked := entities.Plugin{ID: primitive.NewObjectID(), Name: "unchecked"}
+	pluginRepo := new(mocks.MockPluginRepo)
+	pluginRepo.On("GetActivePlugins").Return([]entities.Plugin{checked, unchecked}, nil)
+	service := newTestPluginHealthService(pluginRepo, new(mocks.MockClient))
+	service.health.Record(&checked, 12*time.Millisecond, nil, 1)
+
+	health, err := service.GetPluginHealth(context.Background())
+	require.NoError(t, err)
+	require.Len(t, health, 2)
+	require.Equal(t, models.PluginHealthHealthy, health[0].Status)
+	require.Equal(t, int64(12), health[0].LatencyMS)
+	require.Equal(t, models.PluginHealth{PluginID: unchecked.ID, Name: "unchecked",
+		Status: models.PluginHealthUnknown}, health[1])
+}
+
+func TestPromptService_SkipsDegradedPlugins(t *testing.T) {
+	plugin := entities.Plugin{ID: primitive.NewObjectID(), Name: "degraded",
+		Protocol: entities.Protocol{Type: entities.HTTPProtocol}}
+	plugins.DefaultHealth.Record(&plugin, time.Second, plugins.ErrPluginUnhealthy, 1)
+	defer plugins.DefaultHealth.Retain(nil)
+
+	client := new(mocks.MockClient)
+	promptService := NewPromptService(new(mocks.MockUserService), client, new(mocks.MockPluginService),
+		new(mocks.MockChatHistoryRepo))
+
+	verdicts, err := promptService.forwardRequest(context.Background(), []entities.Plugin{plugin},
+		&models.PluginRequest{Prompt: "hi"})
+	require.ErrorIs(t, err, plugins.ErrPluginDegraded)
+	require.Empty(t, verdicts)
+	client.AssertNotCalled(t, "Forward", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -2,21 +2,62 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"time"
 
+	"guardian/configs"
+	"guardian/internal/models"
 	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
 	"guardian/internal/repository"
+	"guardian/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	bootstrapPurpose = "plugin_bootstrap"
+	pluginTokenBytes = 32
+)
+
+var (
+	ErrRegistrationDisabled    = errors.New("plugin registration is disabled")
+	ErrInvalidBootstrapToken   = errors.New("invalid bootstrap token")
+	ErrInvalidPlugin           = errors.New("invalid plugin")
+	ErrPluginNotFound          = errors.New("plugin not found")
+	ErrPluginNameTaken         = errors.New("a plugin with this name is already registered")
+	ErrUnsupportedTask         = errors.New("plugin does not support the task")
+	ErrCapabilitiesUnavailable = errors.New("failed to discover the plugin's capabilities")
 )
 
 type PluginServiceInterface interface {
 	GetPluginsByTask(ctx context.Context, task entities.Task) ([]entities.Plugin, error)
+	IssueBootstrapToken(ctx context.Context) (*models.BootstrapTokenResponse, error)
+	RegisterPlugin(ctx context.Context, bootstrapToken string, req models.RegisterPluginRequest) (
+		*models.RegisterPluginResponse, error)
+	SetTaskPlugins(ctx context.Context, taskID primitive.ObjectID, pluginIDs []primitive.ObjectID) error
+	CreateBuiltinPlugin(ctx context.Context, req models.CreateBuiltinPluginRequest) (*entities.Plugin, error)
+	CreateWasmPlugin(ctx context.Context, req models.CreateWasmPluginRequest) (*entities.Plugin, error)
 }
 
 type PluginService struct {
 	pluginRepo repository.PluginRepoInterface
+	taskRepo   repository.TaskRepoInterface
+	denylist   repository.TokenDenylistInterface
 }
 
-func NewPluginService(pluginRepo repository.PluginRepoInterface) *PluginService {
-	return &PluginService{pluginRepo: pluginRepo}
+func NewPluginService(pluginRepo repository.PluginRepoInterface,
+	taskRepo repository.TaskRepoInterface, denylist repository.TokenDenylistInterface,
+) *PluginService {
+	return &PluginService{pluginRepo: pluginRepo, taskRepo: taskRepo, denylist: denylist}
 }
 
 func (t *PluginService) GetPluginsByTask(ctx context.Context, task entities.Task) ([]entities.Plugin, error) {
@@ -26,3 +67,317 @@ func (t *PluginService) GetPluginsByTask(ctx context.Context, task entities.Task
 	}
 	return plugins, err
 }
+
+// IssueBootstrapToken returns a token a plugin registers itself into the organization with, once, until it expires.
+func (t *PluginService) IssueBootstrapToken(ctx context.Context) (*models.BootstrapTokenResponse, error) {
+	if configs.GlobalConfig.PluginBootstrapKey == "" {
+		return nil, ErrRegistrationDisabled
+	}
+	organizationID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	expiresAt := time.Now().Add(configs.GlobalConfig.PluginBootstrapExpTime)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"org_id":  organizationID.Hex(),
+		"purpose": bootstrapPurpose,
+		"jti":     uuid.NewString(),
+		"exp":     expiresAt.Unix(),
+	})
+	signed, err := token.SignedString([]byte(configs.GlobalConfig.PluginBootstrapKey))
+	if err != nil {
+		return nil, errors.Errorf("error in signing the bootstrap token: %v", err)
+	}
+	return &models.BootstrapTokenResponse{Token: signed, ExpiresAt: expiresAt}, nil
+}
+
+// RegisterPlugin adds the plugin to the organization of the bootstrap token and issues it a token. The bootstrap token
+// is spent on the first registration that gets as far as saving the plugin, so a rejected request doesn't burn it. A
+// plugin registered under the same name is only updated when the request proves to be that plugin with its current
+// token. HTTP plugins sign requests with HMAC and gRPC plugins get bearer tokens unless they ask otherwise. Only the
+// plugin's own settings are taken from the request: TLS settings stay as the admins configured them.
+func (t *PluginService) RegisterPlugin(ctx context.Context, bootstrapToken string, req models.RegisterPluginRequest) (
+	*models.RegisterPluginResponse, error,
+) {
+	if configs.GlobalConfig.PluginBootstrapKey == "" {
+		return nil, ErrRegistrationDisabled
+	}
+	claims, err := parseBootstrapToken(bootstrapToken)
+	if err != nil {
+		return nil, err
+	}
+	ctx = tenant.WithOrganization(ctx, claims.organizationID)
+
+	err = validateRegistration(req)
+	if err != nil {
+		return nil, err
+	}
+
+	plugin, err := t.pluginRepo.GetPluginByName(ctx, req.Name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if exists && !ownsPlugin(plugin, req.Token) {
+		return nil, ErrPluginNameTaken
+	}
+	plugin.Name = req.Name
+	plugin.Address = req.Address
+	plugin.Protocol = entities.Protocol{Type: req.Protocol}
+	plugin.HealthPath = req.HealthPath
+	plugin.Status = entities.PluginActive
+	plugin.Auth.Type = req.Auth
+	if plugin.Auth.Type == "" && req.Protocol == entities.GRPCProtocol {
+		plugin.Auth.Type = entities.PluginAuthBearer
+	} else if plugin.Auth.Type == "" {
+		plugin.Auth.Type = entities.PluginAuthHMAC
+	}
+
+	plugin.Capabilities = req.Capabilities
+	if plugin.Capabilities == nil {
+		plugin.Capabilities, err = describePlugin(ctx, plugin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := generatePluginToken()
+	if err != nil {
+		return nil, err
+	}
+	plugin.Token = entities.Secret(token)
+
+	unused, err := t.denylist.ConsumeToken(ctx, claims.jti, claims.expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !unused {
+		return nil, ErrInvalidBootstrapToken
+	}
+
+	if exists {
+		_, err = t.pluginRepo.UpdatePlugin(ctx, plugin)
+		if err != nil {
+			return nil, err
+		}
+		configs.GlobalConfig.GRPCManager.Remove(plugin.ID)
+	} else {
+		insertedID, err := t.pluginRepo.CreatePlugin(ctx, plugin)
+		if err != nil {
+			return nil, err
+		}
+		plugin.ID, _ = insertedID.(primitive.ObjectID)
+	}
+
+	return &models.RegisterPluginResponse{
+		PluginID:     plugin.ID,
+		Token:        token,
+		Auth:         plugin.Auth.Type,
+		Capabilities: *plugin.Capabilities,
+	}, nil
+}
+
+// CreateBuiltinPlugin adds a builtin plugin to the organization. Builtin plugins run their detectors in-process, so
+// they have no address and aren't issued a token.
+func (t *PluginService) CreateBuiltinPlugin(ctx context.Context, req models.CreateBuiltinPluginRequest) (
+	*entities.Plugin, error,
+) {
+	if req.Name == "" || len(req.TaskTypes) == 0 {
+		return nil, fmt.Errorf("%w: name and task types are required", ErrInvalidPlugin)
+	}
+	err := plugins.DefaultBuiltin.Validate(&req.Detectors)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPlugin, err)
+	}
+
+	return t.createLocalPlugin(ctx, entities.Plugin{
+		Name:     req.Name,
+		Protocol: entities.Protocol{Type: entities.BuiltinProtocol},
+		Capabilities: &entities.PluginCapabilities{
+			TaskTypes:    req.TaskTypes,
+			ReturnsScore: true,
+			Redaction:    true,
+		},
+		Detectors: &req.Detectors,
+	})
+}
+
+// CreateWasmPlugin adds a wasm plugin to the organization, provided its module loads and exports what plugins must.
+// Like builtin plugins, wasm plugins run in-process.
+func (t *PluginService) CreateWasmPlugin(ctx context.Context, req models.CreateWasmPluginRequest) (
+	*entities.Plugin, error,
+) {
+	if req.Name == "" || len(req.TaskTypes) == 0 || (len(req.Code) == 0) == (req.Path == "") {
+		return nil, fmt.Errorf("%w: name, task types and either code or path are required", ErrInvalidPlugin)
+	}
+	plugin := entities.Plugin{
+		Name:         req.Name,
+		Protocol:     entities.Protocol{Type: entities.WasmProtocol},
+		Capabilities: &entities.PluginCapabilities{TaskTypes: req.TaskTypes},
+		Module:       &entities.WasmModule{Code: req.Code, Path: req.Path},
+	}
+	if plugins.DefaultWasm == nil {
+		return nil, fmt.Errorf("%w: the wasm runtime is unavailable", ErrInvalidPlugin)
+	}
+	err := plugins.DefaultWasm.Check(ctx, &plugin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPlugin, err)
+	}
+
+	return t.createLocalPlugin(ctx, plugin)
+}
+
+// createLocalPlugin adds an active plugin that runs in-process, unless another plugin has its name.
+func (t *PluginService) createLocalPlugin(ctx context.Context, plugin entities.Plugin) (*entities.Plugin, error) {
+	_, err := t.pluginRepo.GetPluginByName(ctx, plugin.Name)
+	if err == nil {
+		return nil, fmt.Errorf("%w: a plugin named %s exists", ErrInvalidPlugin, plugin.Name)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	plugin.Status = entities.PluginActive
+	insertedID, err := t.pluginRepo.CreatePlugin(ctx, plugin)
+	if err != nil {
+		return nil, err
+	}
+	plugin.ID, _ = insertedID.(primitive.ObjectID)
+	return &plugin, nil
+}
+
+// SetTaskPlugins assigns plugins to the task, provided they declared support for its type.
+func (t *PluginService) SetTaskPlugins(ctx context.Context, taskID primitive.ObjectID,
+	pluginIDs []primitive.ObjectID,
+) error {
+	task, err := t.taskRepo.GetTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrTaskNotFound
+		}
+		return err
+	}
+
+	pluginIDs = uniqueIDs(pluginIDs)
+	if len(pluginIDs) > 0 {
+		pluginList, err := t.pluginRepo.GetPlugins(ctx, pluginIDs)
+		if err != nil {
+			return err
+		}
+		if len(pluginList) != len(pluginIDs) {
+			return ErrPluginNotFound
+		}
+		for _, plugin := range pluginList {
+			if !plugin.Supports(task.Type) {
+				return fmt.Errorf("%w: %s does not support %s", ErrUnsupportedTask, plugin.Name, task.Type)
+			}
+		}
+	}
+
+	return t.taskRepo.SetTaskPlugins(ctx, taskID, pluginIDs)
+}
+
+// bootstrapClaims are the claims of a valid bootstrap token.
+type bootstrapClaims struct {
+	organizationID primitive.ObjectID
+	jti            string
+	expiresAt      time.Time
+}
+
+func parseBootstrapToken(tokenStr string) (bootstrapClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(configs.GlobalConfig.PluginBootstrapKey), nil
+	})
+	if err != nil || !token.Valid {
+		return bootstrapClaims{}, ErrInvalidBootstrapToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != bootstrapPurpose {
+		return bootstrapClaims{}, ErrInvalidBootstrapToken
+	}
+	organizationIDStr, _ := claims["org_id"].(string)
+	organizationID, err := primitive.ObjectIDFromHex(organizationIDStr)
+	if err != nil || organizationID.IsZero() {
+		return bootstrapClaims{}, ErrInvalidBootstrapToken
+	}
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" || exp == 0 {
+		return bootstrapClaims{}, ErrInvalidBootstrapToken
+	}
+	return bootstrapClaims{
+		organizationID: organizationID,
+		jti:            jti,
+		expiresAt:      time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// ownsPlugin reports whether token is the plugin's current token.
+func ownsPlugin(plugin entities.Plugin, token string) bool {
+	return plugin.Token != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(plugin.Token.Reveal()), []byte(token)) == 1
+}
+
+func validateRegistration(req models.RegisterPluginRequest) error {
+	if req.Name == "" || req.Address == "" {
+		return fmt.Errorf("%w: name and address are required", ErrInvalidPlugin)
+	}
+
+	switch req.Protocol {
+	case entities.HTTPProtocol:
+		address, err := url.Parse(req.Address)
+		if err != nil || (address.Scheme != "http" && address.Scheme != "https") || address.Host == "" {
+			return fmt.Errorf("%w: address must be an http(s) URL", ErrInvalidPlugin)
+		}
+		if req.Capabilities == nil {
+			return fmt.Errorf("%w: HTTP plugins must declare their capabilities", ErrInvalidPlugin)
+		}
+	case entities.GRPCProtocol:
+	default:
+		return fmt.Errorf("%w: unsupported protocol %q", ErrInvalidPlugin, req.Protocol)
+	}
+
+	switch req.Auth {
+	case "", entities.PluginAuthBearer, entities.PluginAuthHMAC:
+	default:
+		return fmt.Errorf("%w: unsupported auth %q", ErrInvalidPlugin, req.Auth)
+	}
+	if req.Auth == entities.PluginAuthHMAC && req.Protocol == entities.GRPCProtocol {
+		return fmt.Errorf("%w: gRPC plugins authenticate with bearer tokens", ErrInvalidPlugin)
+	}
+	return nil
+}
+
+// describePlugin asks a gRPC plugin for its capabilities over a connection of its own, as the plugin doesn't know its
+// token yet.
+func describePlugin(ctx context.Context, plugin entities.Plugin) (*entities.PluginCapabilities, error) {
+	plugin.Token = ""
+	plugin.Auth.Type = ""
+	conn, err := configs.GlobalConfig.GRPCManager.Dial(plugin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCapabilitiesUnavailable, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, configs.GlobalConfig.HttpClientTimeout)
+	defer cancel()
+	capabilities, err := plugins.NewPluginGRPCClient(conn).Describe(ctx, &plugin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCapabilitiesUnavailable, err)
+	}
+	return capabilities, nil
+}
+
+func generatePluginToken() (string, error) {
+	token := make([]byte, pluginTokenBytes)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", errors.Errorf("error in generating the plugin token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+	"guardian/internal/tenant"
+	"guardian/prompt_api"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func setupPluginRegistration(t *testing.T) (context.Context, primitive.ObjectID) {
+	t.Helper()
+
+	configs.GlobalConfig.PluginBootstrapKey = "bootstrap-secret"
+	configs.GlobalConfig.PluginBootstrapExpTime = time.Hour
+	configs.GlobalConfig.GRPCManager = prompt_api.NewClientManager("")
+	organizationID := primitive.NewObjectID()
+	return tenant.WithOrganization(context.Background(), organizationID), organizationID
+}
+
+func TestPluginService_RegisterPlugin(t *testing.T) {
+	ctx, organizationID := setupPluginRegistration(t)
+	capabilities := &entities.PluginCapabilities{TaskTypes: []string{"toxicity"}, ReturnsScore: true, Version: "1.2.0"}
+	req := models.RegisterPluginRequest{
+		Name:         "toxicity",
+		Address:      "http://toxicity:8080/check",
+		Protocol:     entities.HTTPProtocol,
+		Capabilities: capabilities,
+	}
+
+	t.Run("creates the plugin", func(t *testing.T) {
+		pluginRepo := new(mocks.MockPluginRepo)
+		denylist := new(mocks.MockTokenDenylist)
+		service := NewPluginService(pluginRepo, new(mocks.MockTaskRepo), denylist)
+		bootstrap, err := service.IssueBootstrapToken(ctx)
+		require.NoError(t, err)
+
+		pluginID := primitive.NewObjectID()
+		denylist.On("ConsumeToken", mock.Anything).Return(true, nil)
+		pluginRepo.On("GetPluginByName", "toxicity").Return(nil, mongo.ErrNoDocuments)
+		pluginRepo.On("CreatePlugin", mock.MatchedBy(func(plugin entities.Plugin) bool {
+			return plugin.Address == req.Address && plugin.Status == entities.PluginActive &&
+				plugin.Auth.Type == entities.PluginAuthHMAC && plugin.Token != "" && plugin.Capabilities == capabilities
+		})).Return(pluginID, nil)
+
+		registration, err := service.RegisterPlugin(context.Background(), bootstrap.Token, req)
+		require.NoError(t, err)
+		require.Equal(t, pluginID, registration.PluginID)
+		require.Equal(t, entities.PluginAuthHMAC, registration.Auth)
+		require.NotEmpty(t, registration.Token)
+		require.Equal(t, *capabilities, registration.Capabilities)
+		pluginRepo.AssertExpectations(t)
+		denylist.AssertExpectations(t)
+	})
+
+	existing := entities.Plugin{
+		ID:      primitive.NewObjectID(),
+		Name:    "toxicity",
+		Address: "http://old:8080/check",
+		Token:   "old-token",
+		Auth:    entities.PluginAuth{CACert: "ca.pem"},
+		Tenant:  entities.Tenant{OrganizationID: organizationID},
+	}
+
+	t.Run("updates the plugin and rotates its token", func(t *testing.T) {
+		pluginRepo := new(mocks.MockPluginRepo)
+		denylist := new(mocks.MockTokenDenylist)
+		service := NewPluginService(pluginRepo, new(mocks.MockTaskRepo), denylist)
+		bootstrap, err := service.IssueBootstrapToken(ctx)
+		require.NoError(t, err)
+
+		denylist.On("ConsumeToken", mock.Anything).Return(true, nil)
+		pluginRepo.On("GetPluginByName", "toxicity").Return(existing, nil)
+		pluginRepo.On("UpdatePlugin", mock.MatchedBy(func(plugin entities.Plugin) bool {
+			return plugin.ID == existing.ID && plugin.Address == req.Address && plugin.Token != "old-token" &&
+				plugin.Auth.CACert == existing.Auth.CACert
+		})).Return(int64(1), nil)
+
+		reregistration := req
+		reregistration.Token = "old-token"
+		registration, err := service.RegisterPlugin(context.Background(), bootstrap.Token, reregistration)
+		require.NoError(t, err)
+		require.Equal(t, existing.ID, registration.PluginID)
+		pluginRepo.AssertExpectations(t)
+	})
+
+	t.Run("rejects a taken name without the plugin's token", func(t *testing.T) {
+		for _, token := range []string{"", "wrong-token"} {
+			pluginRepo := new(mocks.MockPluginRepo)
+			denylist := new(mocks.MockTokenDenylist)
+			service := NewPluginService(pluginRepo, new(mocks.MockTaskRepo), denylist)
+			bootstrap, err := service.IssueBootstrapToken(ctx)
+			require.NoError(t, err)
+
+			pluginRepo.On("GetPluginByName", "toxicity").Return(existing, nil)
+
+			takeover := req
+			takeover.Token = token
+			_, err = service.RegisterPlugin(context.Background(), bootstrap.Token, takeover)
+			require.ErrorIs(t, err, ErrPluginNameTaken)
+			pluginRepo.AssertNotCalled(t, "UpdatePlugin", mock.Anything)
+			denylist.AssertNotCalled(t, "ConsumeToken", mock.Anything)
+		}
+	})
+
+	t.Run("rejects a spent bootstrap token", func(t *testing.T) {
+		pluginRepo := new(mocks.MockPluginRepo)
+		denylist := new(mocks.MockTokenDenylist)
+		service := NewPluginService(pluginRepo, new(mocks.MockTaskRepo), denylist)
+		bootstrap, err := service.IssueBootstrapToken(ctx)
+		require.NoError(t, err)
+
+		denylist.On("ConsumeToken", mock.Anything).Return(false, nil)
+		pluginRepo.On("GetPluginByName", "toxicity").Return(nil, mongo.ErrNoDocuments)
+
+		_, err = service.RegisterPlugin(context.Background(), bootstrap.Token, req)
+		require.ErrorIs(t, err, ErrInvalidBootstrapToken)
+		pluginRepo.AssertNotCalled(t, "CreatePlugin", mock.Anything)
+	})
+
+	t.Run("keeps the bootstrap token when the plugin can't describe itself", func(t *testing.T) {
+		configs.GlobalConfig.HttpClientTimeout = time.Second
+		pluginRepo := new(mocks.MockPluginRepo)
+		denylist := new(mocks.MockTokenDenylist)
+		service := NewPluginService(pluginRepo, new(mocks.MockTaskRepo), denylist)
+		bootstrap, err := service.IssueBootstrapToken(ctx)
+		require.NoError(t, err)
+
+		pluginRepo.On("GetPluginByName", "toxicity").Return(nil, mongo.ErrNoDocuments)
+
+		unreachable := models.RegisterPluginRequest{Name: "toxicity", Address: "127.0.0.1:1",
+			Protocol: entities.GRPCProtocol}
+		_, err = service.RegisterPlugin(context.Background(), bootstrap.Token, unreachable)
+		require.ErrorIs(t, err, ErrCapabilitiesUnavailable)
+		denylist.AssertNotCalled(t, "ConsumeToken", mock.Anything)
+	})
+
+	t.Run("rejects invalid bootstrap tokens", func(t *testing.T) {
+		service := NewPluginService(new(mocks.MockPluginRepo), new(mocks.MockTaskRepo), new(mocks.MockTokenDenylist))
+
+		activationToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"org_id":  organizationID.Hex(),
+			"purpose": activationPurpose,
+			"exp":     time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte(configs.GlobalConfig.PluginBootstrapKey))
+		require.NoError(t, err)
+		expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"org_id":  organizationID.Hex(),
+			"purpose": bootstrapPurpose,
+			"jti":     "expired",
+			"exp":     time.Now().Add(-time.Minute).Unix(),
+		}).SignedString([]byte(configs.GlobalConfig.PluginBootstrapKey))
+		require.NoError(t, err)
+		withoutID, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"org_id":  organizationID.Hex(),
+			"purpose": bootstrapPurpose,
+			"exp":     time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte(configs.GlobalConfig.PluginBootstrapKey))
+		require.NoError(t, err)
+
+		for _, token := range []string{"", "not-a-token", activationToken, expired, withoutID} {
+			_, err = service.RegisterPlugin(context.Background(), token, req)
+			require.ErrorIs(t, err, ErrInvalidBootstrapToken)
+		}
+	})
+
+	t.Run("validates the registration", func(t *testing.T) {
+		service := NewPluginService(new(mocks.MockPluginRepo), new(mocks.MockTaskRepo), new(mocks.MockTokenDenylist))
+		bootstrap, err := service.IssueBootstrapToken(ctx)
+		require.NoError(t, err)
+
+		invalid := []models.RegisterPluginRequest{
+			{Address: req.Address, Protocol: entities.HTTPProtocol, Capabilities: capabilities},
+			{Name: "toxicity", Address: "toxicity:8080", Protocol: entities.HTTPProtocol, Capabilities: capabilities},
+			{Name: "toxicity", Address: req.Address, Protocol: entities.HTTPProtocol},
+			{Name: "toxicity", Address: req.Address, Protocol: entities.WEBSOCKETProtocol},
+			{Name: "toxicity", Address: "toxicity:9090", Protocol: entities.GRPCProtocol, Auth: entities.PluginAuthHMAC},
+		}
+		for _, registration := range invalid {
+			_, err = service.RegisterPlugin(context.Background(), bootstrap.Token, registration)
+			require.ErrorIs(t, err, ErrInvalidPlugin)
+		}
+	})
+}
+
+func TestPluginService_RegistrationDisabled(t *testing.T) {
+	ctx, _ := setupPluginRegistration(t)
+	configs.GlobalConfig.PluginBootstrapKey = ""
+
+	service := NewPluginService(new(mocks.MockPluginRepo), new(mocks.MockTaskRepo), new(mocks.MockTokenDenylist))
+	_, err := service.IssueBootstrapToken(ctx)
+	require.ErrorIs(t, err, ErrRegistrationDisabled)
+	_, err = service.RegisterPlugin(ctx, "token", models.RegisterPluginRequest{})
+	require.ErrorIs(t, err, ErrRegistrationDisabled)
+}
+
+func TestPluginService_SetTaskPlugins(t *testing.T) {
+	t.Parallel()
+
+	taskID := primitive.NewObjectID()
+	toxicity := entities.Plugin{ID: primitive.NewObjectID(), Name: "toxicity",
+		Capabilities: &entities.PluginCapabilities{TaskTypes: []string{"toxicity"}}}
+	pii := entities.Plugin{ID: primitive.NewObjectID(), Name: "pii",
+		Capabilities: &entities.PluginCapabilities{TaskTypes: []string{"pii"}}}
+	legacy := entities.Plugin{ID: primitive.NewObjectID(), Name: "legacy"}
+
+	tests := []struct {
+		name     string
+		plugins  []entities.Plugin
+		found    []entities.Plugin
+		expected error
+	}{
+		{name: "assigns supporting plugins", plugins: []entities.Plugin{toxicity, legacy},
+			found: []entities.Plugin{toxicity, legacy}},
+		{name: "rejects unsupported plugins", plugins: []entities.Plugin{toxicity, pii},
+			found: []entities.Plugin{toxicity, pii}, expected: ErrUnsupportedTask},
+		{name: "rejects unknown plugins", plugins: []entities.Plugin{toxicity, pii},
+			found: []entities.Plugin{toxicity}, expected: ErrPluginNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pluginIDs := make([]primitive.ObjectID, 0, len(tt.plugins))
+			for _, plugin := range tt.plugins {
+				pluginIDs = append(pluginIDs, plugin.ID)
+			}
+			pluginRepo := new(mocks.MockPluginRepo)
+			pluginRepo.On("GetPlugins", pluginIDs).Return(tt.found, nil)
+			taskRepo := new(mocks.MockTaskRepo)
+			taskRepo.On("GetTask", taskID).Return(entities.Task{Type: "toxicity"}, nil)
+			taskRepo.On("SetTaskPlugins", taskID, pluginIDs).Return(nil)
+
+			service := NewPluginService(pluginRepo, taskRepo, new(mocks.MockTokenDenylist))
+			err := service.SetTaskPlugins(context.Background(), taskID, pluginIDs)
+			if tt.expected != nil {
+				require.ErrorIs(t, err, tt.expected)
+				taskRepo.AssertNotCalled(t, "SetTaskPlugins", taskID, pluginIDs)
+				return
+			}
+			require.NoError(t, err)
+			taskRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPluginService_SetTaskPlugins_TaskNotFound(t *testing.T) {
+	t.Parallel()
+
+	taskID := primitive.NewObjectID()
+	taskRepo := new(mocks.MockTaskRepo)
+	taskRepo.On("GetTask", taskID).Return(entities.Task{}, mongo.ErrNoDocuments)
+
+	service := NewPluginService(new(mocks.MockPluginRepo), taskRepo, new(mocks.MockTokenDenylist))
+	err := service.SetTaskPlugins(context.Background(), taskID, nil)
+	require.ErrorIs(t, err, ErrTaskNotFound)
+}
+
+func TestPromptService_EnforcesMaxPromptLength(t *testing.T) {
+	plugin := entities.Plugin{ID: primitive.NewObjectID(), Name: "short",
+		Protocol:     entities.Protocol{Type: entities.HTTPProtocol},
+		Capabilities: &entities.PluginCapabilities{TaskTypes: []string{"toxicity"}, MaxPromptLength: 5}}
+
+	client := new(mocks.MockClient)
+	client.On("Forward", mock.Anything, mock.Anything, mock.Anything).Return(&models.PluginResponse{Status: true}, nil)
+	promptService := NewPromptService(new(mocks.MockUserService), client, new(mocks.MockPluginService),
+		new(mocks.MockChatHistoryRepo))
+
+	verdicts, err := promptService.forwardRequest(context.Background(), []entities.Plugin{plugin},
+		&models.PluginRequest{Prompt: "too long"})
+	require.ErrorIs(t, err, plugins.ErrPromptTooLong)
+	require.Empty(t, verdicts)
+	client.AssertNotCalled(t, "Forward", mock.Anything, mock.Anything, mock.Anything)
+
+	verdicts, err = promptService.forwardRequest(context.Background(), []entities.Plugin{plugin},
+		&models.PluginRequest{Prompt: "héllo"})
+	require.NoError(t, err)
+	require.Len(t, verdicts, 1)
+	require.True(t, verdicts[0].Status)
+}
+
+func TestPluginService_CreateBuiltinPlugin(t *testing.T) {
+	t.Parallel()
+
+	detectors := entities.Detectors{Blocklist: []string{"jailbreak"}, PII: []string{entities.PIIEmail}}
+
+	t.Run("creates the plugin", func(t *testing.T) {
+		t.Parallel()
+
+		pluginID := primitive.NewObjectID()
+		pluginRepo := new(mocks.MockPluginRepo)
+		pluginRepo.On("GetPluginByName", "local").Return(nil, mongo.ErrNoDocuments)
+		pluginRepo.On("CreatePlugin", mock.MatchedBy(func(plugin entities.Plugin) bool {
+			return plugin.Protocol.Type == entities.BuiltinProtocol && plugin.Status == entities.PluginActive &&
+				plugin.Supports("toxicity") && plugin.Detectors.Blocklist[0] == "jailbreak"
+		})).Return(pluginID, nil)
+
+		service := NewPluginService(pluginRepo, new(mocks.MockTaskRepo), new(mocks.MockTokenDenylist))
+		plugin, err := service.CreateBuiltinPlugin(context.Background(),
+			models.CreateBuiltinPluginRequest{Name: "local", TaskTypes: []string{"toxicity"}, Detectors: detectors})
+		require.NoError(t, err)
+		require.Equal(t, pluginID, plugin.ID)
+		pluginRepo.AssertExpectations(t)
+	})
+
+	t.Run("validates the detectors", func(t *testing.T) {
+		t.Parallel()
+
+		service := NewPluginService(new(mocks.MockPluginRepo), new(mocks.MockTaskRepo), new(mocks.MockTokenDenylist))
+		_, err := service.CreateBuiltinPlugin(context.Background(), models.CreateBuiltinPluginRequest{Name: "local",
+			TaskTypes: []string{"toxicity"}, Detectors: entities.Detectors{Patterns: []string{`(`}}})
+		require.ErrorIs(t, err, ErrInvalidPlugin)
+	})
+}
+
+func TestPluginService_CreateWasmPlugin(t *testing.T) {
+	plugins.DefaultWasm = plugins.NewWasmRuntime(t.TempDir(), 1, time.Second)
+	service := NewPluginService(new(mocks.MockPluginRepo), new(mocks.MockTaskRepo), new(mocks.MockTokenDenylist))
+
+	_, err := service.CreateWasmPlugin(context.Background(), models.CreateWasmPluginRequest{Name: "custom",
+		TaskTypes: []string{"toxicity"}, Code: []byte("not wasm")})
+	require.ErrorIs(t, err, ErrInvalidPlugin)
+
+	_, err = service.CreateWasmPlugin(context.Background(), models.CreateWasmPluginRequest{Name: "custom",
+		TaskTypes: []string{"toxicity"}, Code: []byte("\x00asm"), Path: "custom.wasm"})
+	require.ErrorIs(t, err, ErrInvalidPlugin)
+
+	_, err = service.CreateWasmPlugin(context.Background(), models.CreateWasmPluginRequest{Name: "custom",
+		TaskTypes: []string{"toxicity"}, Code: make([]byte, plugins.MaxModuleSize+1)})
+	require.ErrorIs(t, err, ErrInvalidPlugin)
+}
@@ -4,20 +4,29 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"sync"
+	"time"
 
 	"guardian/configs"
+	"guardian/internal/metrics"
 	"guardian/internal/models"
 	"guardian/internal/models/entities"
 	"guardian/internal/plugins"
+	"guardian/internal/repository"
+	"guardian/internal/tenant"
+	"guardian/internal/tracing"
 	"guardian/utlis/logger"
 
-	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PromptServiceInterface interface {
-	ProcessPrompt(ctx context.Context, reqBody *models.PluginRequest) (bool, error)
-	SendPrompt(ctx context.Context, newReq *http.Request) (*http.Response, error)
+	ProcessPrompt(ctx context.Context, reqBody *models.PluginRequest) (models.Verdict, error)
+	ModerateOutput(ctx context.Context, reqBody *models.PluginRequest) (*plugins.OutputModerator, error)
+	RecordReply(ctx context.Context, reqBody *models.PluginRequest, content string) error
 }
 
 func NewHTTPClientProvider() *http.Client {
@@ -28,59 +37,109 @@ type PromptService struct {
 	userService   UserServiceInterface
 	pluginService PluginServiceInterface
 	client plugins.HTTPClientInterface
+	chatHistoryRepo repository.ChatHistoryRepoInterface
 }
 
 var (
-	ErrForwardRequest = errors.New("failed to forward request")
+	ErrForwardRequest = plugins.ErrForwardRequest
 )
 
 func NewPromptService(userService UserServiceInterface, client plugins.HTTPClientInterface,
-	pluginService PluginServiceInterface) *PromptService {
+	pluginService PluginServiceInterface, chatHistoryRepo repository.ChatHistoryRepoInterface) *PromptService {
 	return &PromptService{
 		userService: userService,
 		client:  client,
 		pluginService: pluginService,
+		chatHistoryRepo: chatHistoryRepo,
 	}
 }
 
-func (p *PromptService) SendPrompt(ctx context.Context, newReq *http.Request) (*http.Response, error) {
-	return p.client.Do(newReq)
-}
-
-func (p *PromptService) ProcessPrompt(ctx context.Context, reqBody *models.PluginRequest) (bool, error) {
+// ProcessPrompt runs the prompt through the user's tasks. The verdict's risk score is the highest score the plugins
+// gave the prompt. The prompt of a chat becomes a turn of the chat, whatever the verdict.
+func (p *PromptService) ProcessPrompt(ctx context.Context, reqBody *models.PluginRequest) (models.Verdict, error) {
 	if reqBody.Prompt == "" {
-		return false, nil
+		return models.Verdict{}, nil
+	}
+	if organizationID, ok := tenant.FromContext(ctx); ok && reqBody.OrganizationID.IsZero() {
+		reqBody.OrganizationID = organizationID
 	}
-	result, err := p.pipeline(ctx, reqBody)
+	ctx = withLogFields(ctx, reqBody)
+	verdict, err := p.pipeline(ctx, reqBody)
+	if err != nil || reqBody.ChatID == nil {
+		return verdict, err
+	}
+
+	err = p.addTurn(ctx, reqBody, models.RoleUser, reqBody.Prompt, !verdict.Allowed)
 	if err != nil {
-		return false, err
+		logger.FromContext(ctx).WithError(err).Error("error in recording the chat turn")
 	}
-	if !result {
-		return false, nil
+	return verdict, nil
+}
+
+// RecordReply records the target model's answer to the prompt as a turn of the prompt's chat.
+func (p *PromptService) RecordReply(ctx context.Context, reqBody *models.PluginRequest, content string) error {
+	if reqBody.ChatID == nil {
+		return nil
 	}
+	return p.addTurn(ctx, reqBody, models.RoleAssistant, content, false)
+}
 
-	return true, nil
+func (p *PromptService) addTurn(ctx context.Context, reqBody *models.PluginRequest, role, content string,
+	blocked bool) error {
+	return p.chatHistoryRepo.AddTurn(ctx, entities.ChatTurn{
+		ChatID:    *reqBody.ChatID,
+		UserID:    reqBody.UserID,
+		Role:      role,
+		Content:   content,
+		Blocked:   blocked,
+		CreatedAt: time.Now(),
+		Tenant:    entities.Tenant{OrganizationID: reqBody.OrganizationID},
+	})
+}
+
+// tasksOf returns the tasks of the group the request was made for with a group API key, or else the user's tasks.
+func (p *PromptService) tasksOf(ctx context.Context, req *models.PluginRequest) ([]entities.Task, error) {
+	ctx, span := tracing.Start(ctx, "resolve tasks")
+	var tasks []entities.Task
+	var err error
+	if req.GroupID != nil {
+		tasks, err = p.userService.GetGroupTasksByID(ctx, *req.GroupID)
+	} else {
+		tasks, err = p.userService.GetUserTasksByID(ctx, req.UserID)
+	}
+	tracing.End(span, err)
+	return tasks, err
 }
 
-func (p *PromptService) pipeline(ctx context.Context, req *models.PluginRequest) (bool, error) {
-	tasks, err := p.userService.GetUserTasksByID(req.UserID)
+func (p *PromptService) pipeline(ctx context.Context, req *models.PluginRequest) (models.Verdict, error) {
+	tasks, err := p.tasksOf(ctx, req)
 	if err != nil {
-		logger.GetLogger().Errorf("err in pipeline: %v", err)
-		return false, err
+		logger.FromContext(ctx).WithError(err).Error("error in resolving the tasks")
+		return models.Verdict{}, err
+	}
+
+	multiTurnReq := req
+	if req.ChatID != nil && slices.ContainsFunc(tasks, func(task entities.Task) bool { return task.MultiTurn }) {
+		multiTurnReq, err = p.withChatHistory(ctx, req)
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).Error("error in fetching the chat history")
+			return models.Verdict{}, err
+		}
 	}
 
 	workerPoolSize := configs.GlobalConfig.PipelineWorkerPoolSize
 
 	taskChan := make(chan entities.Task, len(tasks))
-	resultsChan := make(chan entities.TaskResult, len(tasks))
+	resultsChan := make(chan models.TaskResult, len(tasks))
 	quit := make(chan struct{})
 	var closeQuitOnce sync.Once
 	var wg sync.WaitGroup
 	for i := 0; i < workerPoolSize; i++ {
 		wg.Add(1)
-		go p.worker(ctx, taskChan, resultsChan, quit, req, &wg, &closeQuitOnce)
+		go p.worker(ctx, taskChan, resultsChan, quit, req, multiTurnReq, &wg, &closeQuitOnce)
 	}
 
+	metrics.AddQueuedTasks(len(tasks))
 	for _, task := range tasks {
 		taskChan <- task
 	}
@@ -88,23 +147,62 @@ func (p *PromptService) pipeline(ctx context.Context, req *models.PluginRequest)
 
 	wg.Wait()
 	close(resultsChan)
+	// The tasks left once a task failed are never picked up.
+	metrics.AddQueuedTasks(-len(taskChan))
 
+	verdict := models.Verdict{Allowed: true}
 	for result := range resultsChan {
-		if result.Err != nil {
-			logger.GetLogger().Errorf("task %s faced error", result.Err)
+		taskLogger := logger.FromContext(ctx).WithField("task", result.TaskType)
+		switch {
+		case result.Err != nil:
+			taskLogger.WithError(result.Err).Error("task failed to judge the prompt")
+		case !result.Success:
+			taskLogger.WithField("score", result.Score).Info("task blocked the prompt")
 		}
 
+		verdict.RiskScore = max(verdict.RiskScore, result.Score)
 		if !result.Success {
-			logger.GetLogger().Infof("task %s failed:", result.TaskType)
-			return false, nil
+			verdict.Allowed = false
 		}
+		verdict.Tasks = append(verdict.Tasks, models.TaskVerdict{
+			TaskType: result.TaskType,
+			Allowed:  result.Success,
+			Score:    result.Score,
+			Failed:   result.Err != nil,
+			Plugins:  result.Plugins,
+		})
 	}
 
-	return true, nil
+	return verdict, nil
 }
 
-func (p *PromptService) worker(ctx context.Context, taskChan chan entities.Task, resultsChan chan entities.TaskResult,
-	quit chan struct{}, reqBody *models.PluginRequest, wg *sync.WaitGroup, closeQuitOnce *sync.Once,
+// withChatHistory returns the request for multi-turn tasks, whose history starts with the last turns of the chat.
+func (p *PromptService) withChatHistory(ctx context.Context, req *models.PluginRequest) (*models.PluginRequest,
+	error) {
+	if configs.GlobalConfig.ChatHistoryTurns <= 0 {
+		return req, nil
+	}
+	turns, err := p.chatHistoryRepo.GetLastTurns(ctx, req.UserID, *req.ChatID, configs.GlobalConfig.ChatHistoryTurns)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]models.ChatMessage, 0, len(turns)+len(req.History))
+	for _, turn := range turns {
+		history = append(history, models.ChatMessage{Role: turn.Role, Content: turn.Content})
+	}
+	history = append(history, req.History...)
+	if len(req.History) == 0 && req.Chat != "" {
+		history = append(history, models.ChatMessage{Role: models.RoleSystem, Content: req.Chat})
+	}
+
+	multiTurnReq := *req
+	multiTurnReq.SetHistory(history)
+	return &multiTurnReq, nil
+}
+
+func (p *PromptService) worker(ctx context.Context, taskChan chan entities.Task, resultsChan chan models.TaskResult,
+	quit chan struct{}, reqBody, multiTurnReq *models.PluginRequest, wg *sync.WaitGroup, closeQuitOnce *sync.Once,
 ) {
 	defer wg.Done()
 
@@ -114,69 +212,194 @@ func (p *PromptService) worker(ctx context.Context, taskChan chan entities.Task,
 			if !ok {
 				return
 			}
-			taskType := task.Type
-			pluginList, err := p.pluginService.GetPluginsByTask(ctx, task)
-			if err != nil {
-				resultsChan <- entities.TaskResult{TaskType: taskType, Success: false, Err: err}
-				closeQuitOnce.Do(func() {
-					close(quit)
-				})
-				return
+			metrics.AddQueuedTasks(-1)
+			req := reqBody
+			if task.MultiTurn {
+				req = multiTurnReq
 			}
-			result, err := p.forwardRequest(ctx, pluginList, reqBody)
-			if err != nil {
-				resultsChan <- entities.TaskResult{TaskType: taskType, Success: false, Err: err}
+			result := p.runTask(ctx, task, req)
+			resultsChan <- result
+			if !result.Success {
 				closeQuitOnce.Do(func() {
 					close(quit)
 				})
 				return
 			}
 
-			if !result {
-				resultsChan <- entities.TaskResult{TaskType: taskType, Success: false}
-				closeQuitOnce.Do(func() {
-					close(quit)
-				})
-				return
-			}
-
-			resultsChan <- entities.TaskResult{TaskType: taskType, Success: true}
-
 		case <-quit:
 			return
 		}
 	}
 }
 
+// runTask asks the plugins of the task about the prompt. The task fails at the first plugin that blocks the prompt or
+// fails to answer.
+func (p *PromptService) runTask(ctx context.Context, task entities.Task,
+	reqBody *models.PluginRequest) models.TaskResult {
+	result := models.TaskResult{TaskType: task.Type}
+	ctx, span := tracing.Start(ctx, "task", trace.WithAttributes(tracing.TaskTypeKey.String(task.Type)))
+	start := time.Now()
+	defer func() {
+		verdict := taskVerdict(result)
+		metrics.ObserveTask(task.Type, verdict, time.Since(start))
+		span.SetAttributes(tracing.VerdictKey.String(verdict), tracing.ScoreKey.Int64(int64(result.Score)))
+		tracing.End(span, result.Err)
+	}()
+
+	pluginList, err := p.pluginService.GetPluginsByTask(ctx, task)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	taskReq := *reqBody
+	taskReq.TaskType = task.Type
+	result.Plugins, result.Err = p.forwardRequest(ctx, pluginList, &taskReq)
+	result.Success = result.Err == nil
+	for _, plugin := range result.Plugins {
+		result.Score = max(result.Score, plugin.Score)
+		result.Success = result.Success && plugin.Status
+	}
+	return result
+}
+
+// forwardRequest asks the plugins about the prompt in turn, until one of them blocks it.
 func (p *PromptService) forwardRequest(ctx context.Context, pluginList []entities.Plugin,
-	reqBody *models.PluginRequest) (bool, error) {
+	reqBody *models.PluginRequest) ([]models.PluginVerdict, error) {
+	var verdicts []models.PluginVerdict
 	for _, plugin := range pluginList {
-		var client plugins.PluginClient
+		pluginCtx, span := tracing.Start(ctx, "plugin", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(tracing.PluginIDKey.String(plugin.ID.Hex()), tracing.PluginNameKey.String(plugin.Name),
+				tracing.PluginProtocolKey.String(plugin.Protocol.Type)))
+		result, err := p.askPlugin(pluginCtx, &plugin, reqBody)
+		if err == nil {
+			span.SetAttributes(tracing.VerdictKey.String(pluginVerdict(result)),
+				tracing.ScoreKey.Int64(int64(result.Score)))
+		}
+		tracing.End(span, err)
+		if err != nil {
+			metrics.ObservePluginError(plugin.ID.Hex(), plugin.Name, plugins.ErrorType(err))
+			return verdicts, err
+		}
+		verdicts = append(verdicts, models.PluginVerdict{PluginID: plugin.ID, Name: plugin.Name, PluginResponse: *result})
+		if !result.Status {
+			return verdicts, nil
+		}
+	}
 
-		switch plugin.Protocol.Type {
-		case entities.HTTPProtocol:
-			client = p.client
+	return verdicts, nil
+}
 
-		case entities.GRPCProtocol:
-			grpcConn, err := configs.GlobalConfig.GRPCManager.GetClient(plugin)
-			if err != nil {
-				return false, fmt.Errorf("%w: %w", ErrForwardRequest, err)
-			}
-			client = plugins.NewPluginGRPCClient(grpcConn)
+// askPlugin forwards the prompt to the plugin over its protocol, timing the plugins that answer.
+func (p *PromptService) askPlugin(ctx context.Context, plugin *entities.Plugin,
+	reqBody *models.PluginRequest) (*models.PluginResponse, error) {
+	if plugins.DefaultHealth.IsDegraded(plugin.ID) {
+		return nil, fmt.Errorf("%w: %s", plugins.ErrPluginDegraded, plugin.Name)
+	}
+	if !plugin.AcceptsPrompt(reqBody.Prompt) {
+		return nil, fmt.Errorf("%w: %s", plugins.ErrPromptTooLong, plugin.Name)
+	}
+
+	var client plugins.PluginClient
+
+	switch plugin.Protocol.Type {
+	case entities.HTTPProtocol:
+		client = p.client
 
-		default:
-			return false, fmt.Errorf("unsupported protocol type: %s", plugin.Protocol.Type)
+	case entities.GRPCProtocol:
+		grpcConn, err := configs.GlobalConfig.GRPCManager.GetClient(*plugin)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
 		}
+		client = plugins.NewPluginGRPCClient(grpcConn)
+
+	case entities.BuiltinProtocol:
+		client = plugins.DefaultBuiltin
 
-		reqBody.Address = plugin.Address
-		result, err := client.Forward(ctx, reqBody)
+	case entities.WasmProtocol:
+		if plugins.DefaultWasm == nil {
+			return nil, fmt.Errorf("%w: the wasm runtime is unavailable", ErrForwardRequest)
+		}
+		client = plugins.DefaultWasm
+
+	default:
+		return nil, fmt.Errorf("unsupported protocol type: %s", plugin.Protocol.Type)
+	}
+
+	start := time.Now()
+	result, err := client.Forward(ctx, plugin, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	metrics.ObservePlugin(plugin.ID.Hex(), plugin.Name, pluginVerdict(result), time.Since(start))
+	return result, nil
+}
+
+func pluginVerdict(result *models.PluginResponse) string {
+	if !result.Status {
+		return metrics.VerdictBlocked
+	}
+	return metrics.VerdictAllowed
+}
+
+// withLogFields has the logs of the request carry its user and target model.
+func withLogFields(ctx context.Context, reqBody *models.PluginRequest) context.Context {
+	fields := logrus.Fields{"user_id": reqBody.UserID.Hex()}
+	if !reqBody.TargetID.IsZero() {
+		fields["target_id"] = reqBody.TargetID.Hex()
+	}
+	return logger.WithFields(ctx, fields)
+}
+
+func taskVerdict(result models.TaskResult) string {
+	switch {
+	case result.Err != nil:
+		return metrics.VerdictError
+	case !result.Success:
+		return metrics.VerdictBlocked
+	default:
+		return metrics.VerdictAllowed
+	}
+}
+
+// ModerateOutput opens moderation streams to the plugins of the user's or group's tasks that moderate output, for the
+// completion answering the prompt to be streamed to.
+func (p *PromptService) ModerateOutput(ctx context.Context, reqBody *models.PluginRequest) (*plugins.OutputModerator,
+	error) {
+	if organizationID, ok := tenant.FromContext(ctx); ok && reqBody.OrganizationID.IsZero() {
+		reqBody.OrganizationID = organizationID
+	}
+	tasks, err := p.tasksOf(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribers []plugins.OutputSubscriber
+	subscribed := make(map[primitive.ObjectID]bool)
+	for _, task := range tasks {
+		pluginList, err := p.pluginService.GetPluginsByTask(ctx, task)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
-		if !result.Status {
-			return false, nil
+		for i := range pluginList {
+			plugin := &pluginList[i]
+			if !plugin.ModeratesOutput() || subscribed[plugin.ID] {
+				continue
+			}
+			if plugins.DefaultHealth.IsDegraded(plugin.ID) {
+				return nil, fmt.Errorf("%w: %s", plugins.ErrPluginDegraded, plugin.Name)
+			}
+			grpcConn, err := configs.GlobalConfig.GRPCManager.GetClient(*plugin)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrForwardRequest, err)
+			}
+			subscribed[plugin.ID] = true
+			subscribers = append(subscribers, plugins.OutputSubscriber{
+				Plugin: plugin,
+				Client: plugins.NewPluginGRPCClient(grpcConn),
+			})
 		}
 	}
 
-	return true, nil
+	return plugins.NewOutputModerator(ctx, subscribers, reqBody)
 }
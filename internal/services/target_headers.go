@@ -0,0 +1,100 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"guardian/configs"
+	"guardian/internal/models/entities"
+
+	"github.com/pkg/errors"
+)
+
+// hopByHopHeaders only concern a single connection and must not be proxied.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer",
+	"Transfer-Encoding", "Upgrade",
+}
+
+// guardianHeaders authenticate callers to Guardian and must never reach a target model, even when allowlisted.
+var guardianHeaders = []string{"Authorization", "Cookie", "X-Api-Key", "X-Organization-Id"}
+
+const guardianHeaderPrefix = "X-Guardian-"
+
+// outboundHeader keeps the allowlisted headers of a request to Guardian, without the ones that are hop-by-hop or
+// meant for Guardian.
+func outboundHeader(inbound http.Header) http.Header {
+	header := make(http.Header)
+	for _, name := range configs.GlobalConfig.PassthroughHeaders {
+		key := http.CanonicalHeaderKey(strings.TrimSpace(name))
+		values, ok := inbound[key]
+		if !ok || isGuardianHeader(key) || slices.Contains(hopByHopHeaders, key) {
+			continue
+		}
+		header[key] = slices.Clone(values)
+	}
+	for _, name := range connectionHeaders(inbound) {
+		header.Del(name)
+	}
+	return header
+}
+
+// RemoveHopByHopHeaders deletes the headers that only concern the connection they came over.
+func RemoveHopByHopHeaders(header http.Header) {
+	for _, name := range connectionHeaders(header) {
+		header.Del(name)
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// connectionHeaders returns the headers the Connection header declares hop-by-hop.
+func connectionHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func isGuardianHeader(key string) bool {
+	return slices.Contains(guardianHeaders, key) || strings.HasPrefix(key, guardianHeaderPrefix)
+}
+
+// injectCredentials authenticates the request to the target model with the model's token.
+func injectCredentials(req *http.Request, model *entities.TargetModel) {
+	if model.Token == "" {
+		return
+	}
+
+	token := model.Token.Reveal()
+	auth := model.GetAuth()
+	switch auth.Type {
+	case entities.AuthAPIKey:
+		req.Header.Set("Api-Key", token)
+	case entities.AuthHeader:
+		req.Header.Set(auth.Name, token)
+	case entities.AuthQuery:
+		query := req.URL.Query()
+		query.Set(auth.Name, token)
+		req.URL.RawQuery = query.Encode()
+	default:
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// redactURL keeps credentials passed as query parameters out of errors, which end up in the logs.
+func redactURL(err error, endpoint entities.Endpoint) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = endpoint.Address
+	}
+	return err
+}
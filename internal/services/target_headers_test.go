@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOutboundHeader(t *testing.T) {
+	configs.GlobalConfig.PassthroughHeaders = []string{"content-type", "Accept", "Authorization", "X-Trace", "Upgrade"}
+
+	inbound := http.Header{
+		"Authorization":      {"Bearer guardian-jwt"},
+		"X-Api-Key":          {"guardian-key"},
+		"X-Guardian-Session": {"session"},
+		"Content-Type":       {"application/json"},
+		"Accept":             {"application/json"},
+		"Upgrade":            {"websocket"},
+		"X-Trace":            {"abc"},
+		"Connection":         {"X-Trace"},
+		"X-Not-Allowlisted":  {"value"},
+	}
+
+	header := outboundHeader(inbound)
+	require.Equal(t, http.Header{
+		"Content-Type": {"application/json"},
+		"Accept":       {"application/json"},
+	}, header)
+}
+
+func TestInjectCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    entities.TargetModel
+		header   string
+		expected string
+		rawQuery string
+	}{
+		{
+			name:     "bearer by default",
+			model:    entities.TargetModel{Provider: "openai", Token: "sk"},
+			header:   "Authorization",
+			expected: "Bearer sk",
+		},
+		{
+			name:     "azure api-key",
+			model:    entities.TargetModel{Provider: "azure", Token: "sk"},
+			header:   "Api-Key",
+			expected: "sk",
+		},
+		{
+			name:     "anthropic x-api-key",
+			model:    entities.TargetModel{Provider: "anthropic", Token: "sk"},
+			header:   "X-Api-Key",
+			expected: "sk",
+		},
+		{
+			name: "custom header",
+			model: entities.TargetModel{Provider: "openai", Token: "sk",
+				Auth: entities.TargetAuth{Type: entities.AuthHeader, Name: "X-Upstream-Token"}},
+			header:   "X-Upstream-Token",
+			expected: "sk",
+		},
+		{
+			name: "query parameter",
+			model: entities.TargetModel{Token: "s k",
+				Auth: entities.TargetAuth{Type: entities.AuthQuery, Name: "api_key"}},
+			rawQuery: "api_key=s+k&v=1",
+		},
+		{
+			name:  "no token",
+			model: entities.TargetModel{Provider: "openai"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://target/v1?v=1", nil)
+			require.NoError(t, err)
+
+			injectCredentials(req, &tt.model)
+			if tt.header != "" {
+				require.Equal(t, tt.expected, req.Header.Get(tt.header))
+			}
+			if tt.rawQuery != "" {
+				require.Equal(t, tt.rawQuery, req.URL.RawQuery)
+			}
+			if tt.header == "" && tt.rawQuery == "" {
+				require.Empty(t, req.Header)
+				require.Equal(t, "v=1", req.URL.RawQuery)
+			}
+		})
+	}
+}
+
+func TestUpstreamService_Credentials(t *testing.T) {
+	configs.GlobalConfig.PassthroughHeaders = []string{"Content-Type"}
+
+	client := new(mocks.MockClient)
+	upstream := NewUpstreamService(client, new(mocks.MockTargetModelRepo), new(mocks.MockTargetRouter))
+	targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Address: "http://a", Token: "sk"}
+	client.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Header.Get("Authorization") == "Bearer sk" && req.Header.Get("Cookie") == "" &&
+			req.Header.Get("Content-Type") == "application/json"
+	})).Return(response(http.StatusOK), nil)
+
+	_, _, err := upstream.Send(context.Background(), primitive.NewObjectID(), targetModel, http.MethodPost, http.Header{
+		"Authorization": {"Bearer guardian-jwt"},
+		"Cookie":        {"session=1"},
+		"Content-Type":  {"application/json"},
+	}, &models.ChatRequest{})
+	require.NoError(t, err)
+	client.AssertExpectations(t)
+}
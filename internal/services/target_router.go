@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"unicode/utf8"
+
+	"guardian/configs"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/repository"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNoTargetAvailable = errors.New("no target model is available for the prompt")
+	ErrTargetNotAllowed  = errors.New("target model is not allowed")
+)
+
+// TargetRouterInterface chooses the target model for requests that let Guardian pick one.
+type TargetRouterInterface interface {
+	SelectTarget(ctx context.Context, userID primitive.ObjectID, prompt string, verdict models.Verdict) (
+		*entities.TargetModel, error)
+	CheckTarget(ctx context.Context, userID primitive.ObjectID, modelID primitive.ObjectID) error
+	FilterAllowed(ctx context.Context, userID primitive.ObjectID, modelIDs []primitive.ObjectID) (
+		[]primitive.ObjectID, error)
+}
+
+type TargetRouter struct {
+	userRepo        repository.UserRepoInterface
+	groupRepo       repository.GroupRepoInterface
+	targetModelRepo repository.TargetModelRepoInterface
+}
+
+func NewTargetRouter(userRepo repository.UserRepoInterface, groupRepo repository.GroupRepoInterface,
+	targetModelRepo repository.TargetModelRepoInterface,
+) *TargetRouter {
+	return &TargetRouter{
+		userRepo:        userRepo,
+		groupRepo:       groupRepo,
+		targetModelRepo: targetModelRepo,
+	}
+}
+
+// SelectTarget picks among the target models the user and their groups may use. Models that are down or can't take
+// the prompt are skipped. Prompts at or above the high-risk score go to the cheapest conservative model when there is
+// one, every other prompt goes to the cheapest model.
+func (t *TargetRouter) SelectTarget(ctx context.Context, userID primitive.ObjectID, prompt string,
+	verdict models.Verdict,
+) (*entities.TargetModel, error) {
+	modelIDs, err := t.allowedModels(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(modelIDs) == 0 {
+		return nil, ErrNoTargetAvailable
+	}
+
+	targetModels, err := t.targetModelRepo.GetModels(ctx, modelIDs)
+	if err != nil {
+		return nil, errors.Errorf("error in finding the target models: %v", err)
+	}
+
+	promptLength := utf8.RuneCountInString(prompt)
+	candidates := make([]entities.TargetModel, 0, len(targetModels))
+	for _, targetModel := range targetModels {
+		if !targetModel.IsHealthy() {
+			continue
+		}
+		if targetModel.MaxPromptLength > 0 && promptLength > targetModel.MaxPromptLength {
+			continue
+		}
+		candidates = append(candidates, targetModel)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoTargetAvailable
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].CostTier < candidates[j].CostTier
+	})
+	if verdict.RiskScore >= configs.GlobalConfig.HighRiskScore {
+		for i := range candidates {
+			if candidates[i].Conservative {
+				return &candidates[i], nil
+			}
+		}
+	}
+	return &candidates[0], nil
+}
+
+// CheckTarget verifies that the user may send to a target model named in the request.
+func (t *TargetRouter) CheckTarget(ctx context.Context, userID primitive.ObjectID, modelID primitive.ObjectID) error {
+	allowed, err := t.FilterAllowed(ctx, userID, []primitive.ObjectID{modelID})
+	if err != nil {
+		return err
+	}
+	if len(allowed) == 0 {
+		return ErrTargetNotAllowed
+	}
+	return nil
+}
+
+// FilterAllowed returns the target models among modelIDs the user may send to, keeping their order. Users that
+// neither have target models nor belong to a group that has any predate routing and may use any target model of
+// their organization.
+func (t *TargetRouter) FilterAllowed(ctx context.Context, userID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) ([]primitive.ObjectID, error) {
+	allowedIDs, err := t.allowedModels(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowedIDs) == 0 {
+		return modelIDs, nil
+	}
+
+	allowed := make(map[primitive.ObjectID]bool, len(allowedIDs))
+	for _, id := range allowedIDs {
+		allowed[id] = true
+	}
+	filtered := make([]primitive.ObjectID, 0, len(modelIDs))
+	for _, id := range modelIDs {
+		if allowed[id] {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
+
+func (t *TargetRouter) allowedModels(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	user, err := t.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, errors.Errorf("error in finding the user: %v", err)
+	}
+
+	modelIDs := user.TargetModels
+	if len(user.Groups) > 0 {
+		groupIDs := make([]primitive.ObjectID, 0, len(user.Groups))
+		for _, group := range user.Groups {
+			groupIDs = append(groupIDs, group.ID)
+		}
+		groups, err := t.groupRepo.GetGroupsByIDs(ctx, groupIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			modelIDs = append(modelIDs, group.TargetModels...)
+		}
+	}
+	return uniqueIDs(modelIDs), nil
+}
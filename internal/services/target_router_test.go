@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTargetRouter_SelectTarget(t *testing.T) {
+	configs.GlobalConfig.HighRiskScore = 50
+
+	cheap := entities.TargetModel{ID: primitive.NewObjectID(), Name: "cheap", Status: 1, CostTier: 1,
+		MaxPromptLength: 10}
+	premium := entities.TargetModel{ID: primitive.NewObjectID(), Name: "premium", Status: 1, CostTier: 3}
+	conservative := entities.TargetModel{ID: primitive.NewObjectID(), Name: "conservative", Status: 1, CostTier: 2,
+		Conservative: true}
+	down := entities.TargetModel{ID: primitive.NewObjectID(), Name: "down", Status: 0}
+
+	group := entities.Group{ID: primitive.NewObjectID(), TargetModels: []primitive.ObjectID{conservative.ID, down.ID}}
+	user := &entities.User{
+		ID:           primitive.NewObjectID(),
+		Groups:       []entities.Group{{ID: group.ID}},
+		TargetModels: []primitive.ObjectID{cheap.ID, premium.ID, conservative.ID},
+	}
+	allowed := []primitive.ObjectID{cheap.ID, premium.ID, conservative.ID, down.ID}
+
+	tests := []struct {
+		name     string
+		models   []entities.TargetModel
+		prompt   string
+		verdict  models.Verdict
+		expected string
+		err      error
+	}{
+		{
+			name:     "cheapest model",
+			models:   []entities.TargetModel{premium, conservative, cheap, down},
+			prompt:   "hello",
+			verdict:  models.Verdict{Allowed: true},
+			expected: "cheap",
+		},
+		{
+			name:     "prompt too long for the cheapest model",
+			models:   []entities.TargetModel{premium, conservative, cheap, down},
+			prompt:   strings.Repeat("a", 11),
+			verdict:  models.Verdict{Allowed: true},
+			expected: "conservative",
+		},
+		{
+			name:     "high risk prefers conservative models",
+			models:   []entities.TargetModel{premium, conservative, cheap, down},
+			prompt:   "hello",
+			verdict:  models.Verdict{Allowed: true, RiskScore: 50},
+			expected: "conservative",
+		},
+		{
+			name:     "high risk falls back without conservative models",
+			models:   []entities.TargetModel{premium, cheap},
+			prompt:   "hello",
+			verdict:  models.Verdict{Allowed: true, RiskScore: 90},
+			expected: "cheap",
+		},
+		{
+			name:    "no healthy model",
+			models:  []entities.TargetModel{down},
+			prompt:  "hello",
+			verdict: models.Verdict{Allowed: true},
+			err:     ErrNoTargetAvailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userRepo := new(mocks.MockUserRepo)
+			groupRepo := new(mocks.MockGroupRepo)
+			targetModelRepo := new(mocks.MockTargetModelRepo)
+			router := NewTargetRouter(userRepo, groupRepo, targetModelRepo)
+			userRepo.On("GetUser", user.ID).Return(user, nil)
+			groupRepo.On("GetGroupsByIDs", []primitive.ObjectID{group.ID}).Return([]entities.Group{group}, nil)
+			targetModelRepo.On("GetModels", allowed).Return(tt.models, nil)
+
+			targetModel, err := router.SelectTarget(context.Background(), user.ID, tt.prompt, tt.verdict)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, targetModel.Name)
+		})
+	}
+
+	t.Run("no allowed models", func(t *testing.T) {
+		userRepo := new(mocks.MockUserRepo)
+		router := NewTargetRouter(userRepo, new(mocks.MockGroupRepo), new(mocks.MockTargetModelRepo))
+		userID := primitive.NewObjectID()
+		userRepo.On("GetUser", userID).Return(&entities.User{ID: userID}, nil)
+
+		_, err := router.SelectTarget(context.Background(), userID, "hello", models.Verdict{Allowed: true})
+		require.ErrorIs(t, err, ErrNoTargetAvailable)
+	})
+}
+
+func TestTargetRouter_CheckTarget(t *testing.T) {
+	t.Parallel()
+
+	allowed, denied := primitive.NewObjectID(), primitive.NewObjectID()
+	group := entities.Group{ID: primitive.NewObjectID(), TargetModels: []primitive.ObjectID{allowed}}
+
+	tests := []struct {
+		name    string
+		user    *entities.User
+		modelID primitive.ObjectID
+		err     error
+	}{
+		{name: "allowed through a group", user: &entities.User{Groups: []entities.Group{{ID: group.ID}}},
+			modelID: allowed},
+		{name: "not allowed", user: &entities.User{Groups: []entities.Group{{ID: group.ID}}}, modelID: denied,
+			err: ErrTargetNotAllowed},
+		{name: "no target models configured", user: &entities.User{}, modelID: denied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			userRepo := new(mocks.MockUserRepo)
+			groupRepo := new(mocks.MockGroupRepo)
+			router := NewTargetRouter(userRepo, groupRepo, new(mocks.MockTargetModelRepo))
+			tt.user.ID = primitive.NewObjectID()
+			userRepo.On("GetUser", tt.user.ID).Return(tt.user, nil)
+			groupRepo.On("GetGroupsByIDs", []primitive.ObjectID{group.ID}).Return([]entities.Group{group}, nil)
+
+			err := router.CheckTarget(context.Background(), tt.user.ID, tt.modelID)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/repository"
+	"guardian/internal/tenant"
+	"guardian/utlis/logger"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const refreshTokenBytes = 32
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+type TokenServiceInterface interface {
+	IssueTokens(ctx context.Context, user *entities.User) (*models.TokenResponse, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (*models.TokenResponse, error)
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeRefreshToken(ctx context.Context, userID primitive.ObjectID, refreshToken string) error
+	RevokeAllRefreshTokens(ctx context.Context, userID primitive.ObjectID) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type TokenService struct {
+	refreshTokenRepo repository.RefreshTokenRepoInterface
+	denylist         repository.TokenDenylistInterface
+	userRepo         repository.UserRepoInterface
+}
+
+func NewTokenService(refreshTokenRepo repository.RefreshTokenRepoInterface,
+	denylist repository.TokenDenylistInterface, userRepo repository.UserRepoInterface,
+) *TokenService {
+	return &TokenService{
+		refreshTokenRepo: refreshTokenRepo,
+		denylist:         denylist,
+		userRepo:         userRepo,
+	}
+}
+
+// IssueTokens starts a new session for the user with a fresh refresh token family.
+func (t *TokenService) IssueTokens(ctx context.Context, user *entities.User) (*models.TokenResponse, error) {
+	return t.issueTokens(ctx, user, primitive.NewObjectID())
+}
+
+// RefreshTokens rotates a refresh token. Presenting an already used or revoked token revokes its whole family, as
+// it means the token has leaked.
+func (t *TokenService) RefreshTokens(ctx context.Context, refreshToken string) (*models.TokenResponse, error) {
+	stored, err := t.refreshTokenRepo.GetRefreshTokenByHash(tenant.Unscoped(ctx), hashSecret(refreshToken))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	ctx = tenant.WithOrganization(ctx, stored.OrganizationID)
+
+	now := time.Now().UTC()
+	if stored.UsedAt != nil || stored.RevokedAt != nil {
+		t.revokeFamily(ctx, stored.FamilyID, now)
+		return nil, ErrRefreshTokenReused
+	}
+	if now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	marked, err := t.refreshTokenRepo.MarkRefreshTokenUsed(ctx, stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if marked == 0 {
+		t.revokeFamily(ctx, stored.FamilyID, now)
+		return nil, ErrRefreshTokenReused
+	}
+
+	// The user is reloaded so that the new access token reflects role and status changes made since the last one.
+	user, err := t.userRepo.GetUser(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if user.Status != entities.UserStatusActive {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return t.issueTokens(ctx, user, stored.FamilyID)
+}
+
+func (t *TokenService) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+	return t.denylist.RevokeToken(ctx, jti, expiresAt)
+}
+
+// RevokeRefreshToken ends the session the refresh token belongs to.
+func (t *TokenService) RevokeRefreshToken(ctx context.Context, userID primitive.ObjectID, refreshToken string) error {
+	stored, err := t.refreshTokenRepo.GetRefreshTokenByHash(ctx, hashSecret(refreshToken))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+	if stored.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+	return t.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, stored.FamilyID, time.Now().UTC())
+}
+
+func (t *TokenService) RevokeAllRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	return t.refreshTokenRepo.RevokeUserRefreshTokens(ctx, userID, time.Now().UTC())
+}
+
+func (t *TokenService) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	return t.denylist.IsTokenRevoked(ctx, jti)
+}
+
+func (t *TokenService) issueTokens(ctx context.Context, user *entities.User,
+	familyID primitive.ObjectID,
+) (*models.TokenResponse, error) {
+	now := time.Now().UTC()
+	_, accessToken, err := configs.GlobalConfig.TokenAuth.Encode(map[string]interface{}{
+		"user_id": user.ID.Hex(),
+		"org_id":  user.OrganizationID.Hex(),
+		"role":    user.GetRole(),
+		"jti":     uuid.NewString(),
+		"iat":     now,
+		"exp":     now.Add(configs.GlobalConfig.TokenExpirationTime),
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("error generating token: %v", err)
+		return nil, err
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	err = t.refreshTokenRepo.CreateRefreshToken(ctx, entities.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		Hash:      hashSecret(refreshToken),
+		CreatedAt: now,
+		ExpiresAt: now.Add(configs.GlobalConfig.RefreshTokenExpTime),
+		Tenant:    entities.Tenant{OrganizationID: user.OrganizationID},
+	})
+	if err != nil {
+		return nil, errors.Errorf("error in storing the refresh token: %v", err)
+	}
+
+	return &models.TokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(configs.GlobalConfig.TokenExpirationTime.Seconds()),
+	}, nil
+}
+
+func (t *TokenService) revokeFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) {
+	if err := t.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, familyID, at); err != nil {
+		logger.GetLogger().Errorf("error in revoking refresh token family %s: %v", familyID.Hex(), err)
+	}
+}
+
+func generateRefreshToken() (string, error) {
+	tokenBytes := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models/entities"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func setupTokenConfig() {
+	configs.GlobalConfig.TokenAuth = jwtauth.New("HS256", []byte("secret"), nil)
+	configs.GlobalConfig.TokenExpirationTime = 15 * time.Minute
+	configs.GlobalConfig.RefreshTokenExpTime = 24 * time.Hour
+}
+
+func TestIssueTokens(t *testing.T) {
+	setupTokenConfig()
+	userID := primitive.NewObjectID()
+
+	refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+	userRepo := new(mocks.MockUserRepo)
+	service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+	var stored entities.RefreshToken
+	refreshTokenRepo.On("CreateRefreshToken", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(entities.RefreshToken)
+	}).Return(nil)
+
+	organizationID := primitive.NewObjectID()
+	resp, err := service.IssueTokens(context.Background(), &entities.User{
+		ID: userID, Role: entities.RoleGroupAdmin, Tenant: entities.Tenant{OrganizationID: organizationID},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(900), resp.ExpiresIn)
+	assert.Equal(t, hashSecret(resp.RefreshToken), stored.Hash)
+	assert.Equal(t, userID, stored.UserID)
+	assert.False(t, stored.FamilyID.IsZero())
+	assert.Equal(t, organizationID, stored.OrganizationID)
+
+	token, err := configs.GlobalConfig.TokenAuth.Decode(resp.Token)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.JwtID())
+	claims := token.PrivateClaims()
+	assert.Equal(t, userID.Hex(), claims["user_id"])
+	assert.Equal(t, entities.RoleGroupAdmin, claims["role"])
+	assert.Equal(t, organizationID.Hex(), claims["org_id"])
+}
+
+func TestRefreshTokens(t *testing.T) {
+	setupTokenConfig()
+	userID := primitive.NewObjectID()
+	familyID := primitive.NewObjectID()
+	now := time.Now().UTC()
+
+	t.Run("rotates within the same family", func(t *testing.T) {
+		refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+		userRepo := new(mocks.MockUserRepo)
+		service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+		stored := &entities.RefreshToken{
+			ID: primitive.NewObjectID(), UserID: userID, FamilyID: familyID, ExpiresAt: now.Add(time.Hour),
+		}
+		refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(stored, nil)
+		refreshTokenRepo.On("MarkRefreshTokenUsed", stored.ID).Return(int64(1), nil)
+		userRepo.On("GetUser", userID).Return(&entities.User{ID: userID, Status: entities.UserStatusActive}, nil)
+		refreshTokenRepo.On("CreateRefreshToken", mock.MatchedBy(func(token entities.RefreshToken) bool {
+			return token.FamilyID == familyID && token.UserID == userID
+		})).Return(nil)
+
+		resp, err := service.RefreshTokens(context.Background(), "current")
+		require.NoError(t, err)
+		assert.NotEqual(t, "current", resp.RefreshToken)
+		refreshTokenRepo.AssertExpectations(t)
+	})
+
+	t.Run("reuse revokes the family", func(t *testing.T) {
+		refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+		userRepo := new(mocks.MockUserRepo)
+		service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+		usedAt := now.Add(-time.Minute)
+		stored := &entities.RefreshToken{
+			ID: primitive.NewObjectID(), UserID: userID, FamilyID: familyID, ExpiresAt: now.Add(time.Hour),
+			UsedAt: &usedAt,
+		}
+		refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(stored, nil)
+		refreshTokenRepo.On("RevokeRefreshTokenFamily", familyID).Return(nil)
+
+		_, err := service.RefreshTokens(context.Background(), "current")
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+		refreshTokenRepo.AssertExpectations(t)
+	})
+
+	t.Run("concurrent rotation loses the race", func(t *testing.T) {
+		refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+		userRepo := new(mocks.MockUserRepo)
+		service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+		stored := &entities.RefreshToken{
+			ID: primitive.NewObjectID(), UserID: userID, FamilyID: familyID, ExpiresAt: now.Add(time.Hour),
+		}
+		refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(stored, nil)
+		refreshTokenRepo.On("MarkRefreshTokenUsed", stored.ID).Return(int64(0), nil)
+		refreshTokenRepo.On("RevokeRefreshTokenFamily", familyID).Return(nil)
+
+		_, err := service.RefreshTokens(context.Background(), "current")
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+		userRepo := new(mocks.MockUserRepo)
+		service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+		stored := &entities.RefreshToken{
+			ID: primitive.NewObjectID(), UserID: userID, FamilyID: familyID, ExpiresAt: now.Add(time.Hour),
+		}
+		refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(stored, nil)
+		refreshTokenRepo.On("MarkRefreshTokenUsed", stored.ID).Return(int64(1), nil)
+		userRepo.On("GetUser", userID).Return(&entities.User{ID: userID, Status: entities.UserStatusDeleted}, nil)
+
+		_, err := service.RefreshTokens(context.Background(), "current")
+		require.ErrorIs(t, err, ErrInvalidRefreshToken)
+		refreshTokenRepo.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+		userRepo := new(mocks.MockUserRepo)
+		service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+		stored := &entities.RefreshToken{
+			ID: primitive.NewObjectID(), UserID: userID, FamilyID: familyID, ExpiresAt: now.Add(-time.Hour),
+		}
+		refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(stored, nil)
+
+		_, err := service.RefreshTokens(context.Background(), "current")
+		require.ErrorIs(t, err, ErrInvalidRefreshToken)
+		refreshTokenRepo.AssertNotCalled(t, "MarkRefreshTokenUsed", mock.Anything)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+		userRepo := new(mocks.MockUserRepo)
+		service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+		refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(nil, mongo.ErrNoDocuments)
+
+		_, err := service.RefreshTokens(context.Background(), "current")
+		require.ErrorIs(t, err, ErrInvalidRefreshToken)
+	})
+}
+
+func TestRevokeRefreshToken_OtherUser(t *testing.T) {
+	t.Parallel()
+
+	refreshTokenRepo := new(mocks.MockRefreshTokenRepo)
+	userRepo := new(mocks.MockUserRepo)
+	service := NewTokenService(refreshTokenRepo, new(mocks.MockTokenDenylist), userRepo)
+	stored := &entities.RefreshToken{UserID: primitive.NewObjectID(), FamilyID: primitive.NewObjectID()}
+	refreshTokenRepo.On("GetRefreshTokenByHash", hashSecret("current")).Return(stored, nil)
+
+	err := service.RevokeRefreshToken(context.Background(), primitive.NewObjectID(), "current")
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+	refreshTokenRepo.AssertNotCalled(t, "RevokeRefreshTokenFamily", mock.Anything)
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/metrics"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+	"guardian/internal/plugins"
+	"guardian/internal/providers"
+	"guardian/internal/repository"
+	"guardian/internal/tracing"
+	"guardian/utlis/logger"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endpointStateTTL is how long the state of an endpoint that no request goes to anymore, e.g. because its target
+// model was deleted or its address changed, is kept.
+const endpointStateTTL = time.Hour
+
+var ErrTargetUnavailable = errors.New("target model is unavailable")
+
+// UpstreamServiceInterface sends prompts to the endpoints of target models.
+type UpstreamServiceInterface interface {
+	Send(ctx context.Context, userID primitive.ObjectID, targetModel *entities.TargetModel, method string,
+		header http.Header, chatReq *models.ChatRequest) (*http.Response, *entities.TargetModel, error)
+}
+
+// endpointState keeps what the passive health checks and the balancers know about an endpoint.
+type endpointState struct {
+	failures      int
+	downUntil     time.Time
+	latency       time.Duration
+	currentWeight int
+	lastUsed      time.Time
+}
+
+type UpstreamService struct {
+	client          plugins.HTTPClientInterface
+	targetModelRepo repository.TargetModelRepoInterface
+	targetRouter    TargetRouterInterface
+	mu              sync.Mutex
+	endpoints       map[string]*endpointState
+	lastSweep       time.Time
+}
+
+func NewUpstreamService(client plugins.HTTPClientInterface,
+	targetModelRepo repository.TargetModelRepoInterface, targetRouter TargetRouterInterface,
+) *UpstreamService {
+	return &UpstreamService{
+		client:          client,
+		targetModelRepo: targetModelRepo,
+		targetRouter:    targetRouter,
+		endpoints:       make(map[string]*endpointState),
+	}
+}
+
+// Send forwards the request to the target model, then to the fallback models the user may use in order, and returns
+// the response along with the model that answered it. Each model tries its endpoints in balancing order. Network
+// errors, timeouts and 5xx responses move on to the next endpoint and count against its health. Only allowlisted
+// headers are forwarded, and each model gets the request in its provider's format, authenticated with its own token.
+func (u *UpstreamService) Send(ctx context.Context, userID primitive.ObjectID, targetModel *entities.TargetModel,
+	method string, header http.Header, chatReq *models.ChatRequest,
+) (*http.Response, *entities.TargetModel, error) {
+	header = outboundHeader(header)
+	targetModels, err := u.withFallbacks(ctx, userID, targetModel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lastErr error
+	for i := range targetModels {
+		model := &targetModels[i]
+		for _, endpoint := range u.order(model) {
+			resp, err := u.try(ctx, model, endpoint, method, header, chatReq)
+			if err == nil {
+				return resp, model, nil
+			}
+			lastErr = err
+			logger.FromContext(ctx).WithError(err).Warnf("target model %s failed on %s", model.Name, endpoint.Address)
+			if ctx.Err() != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrTargetUnavailable, ctx.Err())
+			}
+		}
+	}
+
+	if lastErr == nil {
+		return nil, nil, ErrTargetUnavailable
+	}
+	return nil, nil, fmt.Errorf("%w: %w", ErrTargetUnavailable, lastErr)
+}
+
+// try sends the request to an endpoint of the model, tracing the request until the endpoint answered.
+func (u *UpstreamService) try(ctx context.Context, model *entities.TargetModel, endpoint entities.Endpoint,
+	method string, header http.Header, chatReq *models.ChatRequest,
+) (*http.Response, error) {
+	ctx, span := tracing.Start(ctx, "target model", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.TargetModelIDKey.String(model.ID.Hex()),
+			tracing.TargetModelNameKey.String(model.Name)))
+	resp, err := u.send(ctx, model, endpoint, method, header, chatReq)
+	if err == nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (u *UpstreamService) send(ctx context.Context, model *entities.TargetModel, endpoint entities.Endpoint,
+	method string, header http.Header, chatReq *models.ChatRequest,
+) (*http.Response, error) {
+	adapter := providers.ForProvider(model.Provider)
+	req, err := adapter.NewRequest(ctx, method, endpoint.Address, chatReq, model)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		if _, ok := req.Header[key]; !ok {
+			req.Header[key] = slices.Clone(values)
+		}
+	}
+	injectCredentials(req, model)
+
+	key := endpointKey(model, endpoint)
+	start := time.Now()
+	resp, err := u.client.Do(req)
+	if err != nil {
+		metrics.ObserveTargetModel(model.ID.Hex(), model.Name, 0, time.Since(start))
+		u.recordFailure(key)
+		return nil, redactURL(err, endpoint)
+	}
+	metrics.ObserveTargetModel(model.ID.Hex(), model.Name, resp.StatusCode, time.Since(start))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		resp.Body.Close()
+		u.recordFailure(key)
+		return nil, errors.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	u.recordSuccess(key, time.Since(start))
+
+	resp, err = adapter.ParseResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// withFallbacks returns the target model followed by its healthy fallback models, in the order they were listed.
+// Fallbacks outside the user's allowed target models are skipped.
+func (u *UpstreamService) withFallbacks(ctx context.Context, userID primitive.ObjectID,
+	targetModel *entities.TargetModel,
+) ([]entities.TargetModel, error) {
+	targetModels := []entities.TargetModel{*targetModel}
+	if len(targetModel.Fallbacks) == 0 {
+		return targetModels, nil
+	}
+
+	fallbackIDs, err := u.targetRouter.FilterAllowed(ctx, userID, uniqueIDs(targetModel.Fallbacks))
+	if err != nil {
+		return nil, errors.Errorf("error in checking the fallback models: %v", err)
+	}
+	if len(fallbackIDs) == 0 {
+		return targetModels, nil
+	}
+
+	fallbacks, err := u.targetModelRepo.GetModels(ctx, fallbackIDs)
+	if err != nil {
+		return nil, errors.Errorf("error in finding the fallback models: %v", err)
+	}
+	byID := make(map[primitive.ObjectID]entities.TargetModel, len(fallbacks))
+	for _, fallback := range fallbacks {
+		byID[fallback.ID] = fallback
+	}
+	for _, id := range fallbackIDs {
+		fallback, ok := byID[id]
+		if ok && id != targetModel.ID && fallback.IsHealthy() {
+			targetModels = append(targetModels, fallback)
+		}
+	}
+	return targetModels, nil
+}
+
+// order returns the endpoints that are not cooling down, the one to try first leading.
+func (u *UpstreamService) order(model *entities.TargetModel) []entities.Endpoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	endpoints := make([]entities.Endpoint, 0, len(model.GetEndpoints()))
+	for _, endpoint := range model.GetEndpoints() {
+		if u.state(endpointKey(model, endpoint)).downUntil.Before(now) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) < 2 {
+		return endpoints
+	}
+
+	if model.Balancing == entities.BalancingLeastLatency {
+		// Endpoints without a latency yet sort first so that they get measured.
+		sort.SliceStable(endpoints, func(i, j int) bool {
+			return u.state(endpointKey(model, endpoints[i])).latency <
+				u.state(endpointKey(model, endpoints[j])).latency
+		})
+		return endpoints
+	}
+
+	// Smooth weighted round-robin spreads the picks of heavier endpoints instead of sending them in bursts.
+	total, picked := 0, 0
+	for i, endpoint := range endpoints {
+		weight := max(endpoint.Weight, 1)
+		state := u.state(endpointKey(model, endpoint))
+		state.currentWeight += weight
+		total += weight
+		if state.currentWeight > u.state(endpointKey(model, endpoints[picked])).currentWeight {
+			picked = i
+		}
+	}
+	u.state(endpointKey(model, endpoints[picked])).currentWeight -= total
+	endpoints[0], endpoints[picked] = endpoints[picked], endpoints[0]
+	return endpoints
+}
+
+// recordFailure counts a failed request. An endpoint that failed too often in a row cools down, and fails again right
+// away after that unless it succeeds.
+func (u *UpstreamService) recordFailure(key string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	cfg := configs.GlobalConfig.TargetFailover
+	state := u.state(key)
+	state.failures++
+	if state.failures >= cfg.FailureThreshold {
+		state.downUntil = time.Now().Add(cfg.Cooldown)
+		logger.GetLogger().Warnf("endpoint %s is down for %s after %d failures", key, cfg.Cooldown, state.failures)
+	}
+}
+
+func (u *UpstreamService) recordSuccess(key string, latency time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	state := u.state(key)
+	state.failures = 0
+	state.downUntil = time.Time{}
+	if state.latency == 0 {
+		state.latency = latency
+	} else {
+		state.latency = (3*state.latency + latency) / 4
+	}
+}
+
+// state must be called with the lock held. It also forgets endpoints that haven't been used for endpointStateTTL.
+func (u *UpstreamService) state(key string) *endpointState {
+	now := time.Now()
+	if now.Sub(u.lastSweep) > endpointStateTTL {
+		for k, state := range u.endpoints {
+			if now.Sub(state.lastUsed) > endpointStateTTL && now.After(state.downUntil) {
+				delete(u.endpoints, k)
+			}
+		}
+		u.lastSweep = now
+	}
+
+	state, ok := u.endpoints[key]
+	if !ok {
+		state = &endpointState{}
+		u.endpoints[key] = state
+	}
+	state.lastUsed = now
+	return state
+}
+
+func endpointKey(model *entities.TargetModel, endpoint entities.Endpoint) string {
+	return model.ID.Hex() + "|" + endpoint.Address
+}
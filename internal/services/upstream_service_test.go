@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/mocks"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errConnectionRefused = errors.New("connection refused")
+
+func toAddress(address string) interface{} {
+	return mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.String() == address
+	})
+}
+
+func response(status int) *http.Response {
+	return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString("{}"))}
+}
+
+func TestUpstreamService_Send(t *testing.T) {
+	configs.GlobalConfig.TargetFailover = configs.TargetFailoverConfig{FailureThreshold: 1, Cooldown: time.Minute}
+	userID := primitive.NewObjectID()
+	chatReq := &models.ChatRequest{}
+
+	t.Run("weighted round-robin", func(t *testing.T) {
+		client := new(mocks.MockClient)
+		upstream := NewUpstreamService(client, new(mocks.MockTargetModelRepo), new(mocks.MockTargetRouter))
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Endpoints: []entities.Endpoint{
+			{Address: "http://a", Weight: 2},
+			{Address: "http://b", Weight: 1},
+		}}
+		client.On("Do", toAddress("http://a")).Return(response(http.StatusOK), nil)
+		client.On("Do", toAddress("http://b")).Return(response(http.StatusOK), nil)
+
+		for range 6 {
+			_, _, err := upstream.Send(context.Background(), userID, targetModel, http.MethodPost, http.Header{}, chatReq)
+			require.NoError(t, err)
+		}
+		client.AssertNumberOfCalls(t, "Do", 6)
+		require.Equal(t, 4, countCalls(client, "http://a"))
+		require.Equal(t, 2, countCalls(client, "http://b"))
+	})
+
+	t.Run("fails over and cools the endpoint down", func(t *testing.T) {
+		client := new(mocks.MockClient)
+		upstream := NewUpstreamService(client, new(mocks.MockTargetModelRepo), new(mocks.MockTargetRouter))
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Endpoints: []entities.Endpoint{
+			{Address: "http://a"},
+			{Address: "http://b"},
+		}}
+		client.On("Do", toAddress("http://a")).Return(response(http.StatusBadGateway), nil)
+		client.On("Do", toAddress("http://b")).Return(response(http.StatusOK), nil)
+
+		for range 3 {
+			resp, answeredBy, err := upstream.Send(context.Background(), userID, targetModel, http.MethodPost, http.Header{},
+				&models.ChatRequest{Raw: []byte(`{"prompt":"hi"}`)})
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, targetModel.ID, answeredBy.ID)
+		}
+		require.Equal(t, 1, countCalls(client, "http://a"))
+	})
+
+	t.Run("falls back to the next model", func(t *testing.T) {
+		client := new(mocks.MockClient)
+		targetModelRepo := new(mocks.MockTargetModelRepo)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstream := NewUpstreamService(client, targetModelRepo, targetRouter)
+		down := entities.TargetModel{ID: primitive.NewObjectID(), Status: 0, Address: "http://down"}
+		fallback := entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Address: "http://fallback"}
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Address: "http://primary",
+			Fallbacks: []primitive.ObjectID{down.ID, fallback.ID}}
+		targetRouter.On("FilterAllowed", userID, targetModel.Fallbacks).Return(targetModel.Fallbacks, nil)
+		targetModelRepo.On("GetModels", targetModel.Fallbacks).Return([]entities.TargetModel{fallback, down}, nil)
+		client.On("Do", toAddress("http://primary")).Return((*http.Response)(nil), errConnectionRefused)
+		client.On("Do", toAddress("http://fallback")).Return(response(http.StatusOK), nil)
+
+		_, answeredBy, err := upstream.Send(context.Background(), userID, targetModel, http.MethodPost, http.Header{},
+			chatReq)
+		require.NoError(t, err)
+		require.Equal(t, fallback.ID, answeredBy.ID)
+		require.Zero(t, countCalls(client, "http://down"))
+	})
+
+	t.Run("skips fallbacks the user may not use", func(t *testing.T) {
+		client := new(mocks.MockClient)
+		targetRouter := new(mocks.MockTargetRouter)
+		upstream := NewUpstreamService(client, new(mocks.MockTargetModelRepo), targetRouter)
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Address: "http://primary",
+			Fallbacks: []primitive.ObjectID{primitive.NewObjectID()}}
+		targetRouter.On("FilterAllowed", userID, targetModel.Fallbacks).Return([]primitive.ObjectID{}, nil)
+		client.On("Do", toAddress("http://primary")).Return((*http.Response)(nil), errConnectionRefused)
+
+		_, _, err := upstream.Send(context.Background(), userID, targetModel, http.MethodPost, http.Header{}, chatReq)
+		require.ErrorIs(t, err, ErrTargetUnavailable)
+		client.AssertNumberOfCalls(t, "Do", 1)
+	})
+
+	t.Run("every endpoint fails", func(t *testing.T) {
+		client := new(mocks.MockClient)
+		upstream := NewUpstreamService(client, new(mocks.MockTargetModelRepo), new(mocks.MockTargetRouter))
+		targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Status: 1, Address: "http://a"}
+		client.On("Do", toAddress("http://a")).Return(response(http.StatusServiceUnavailable), nil)
+
+		_, _, err := upstream.Send(context.Background(), userID, targetModel, http.MethodPost, http.Header{}, chatReq)
+		require.ErrorIs(t, err, ErrTargetUnavailable)
+
+		_, _, err = upstream.Send(context.Background(), userID, targetModel, http.MethodPost, http.Header{}, chatReq)
+		require.ErrorIs(t, err, ErrTargetUnavailable)
+		client.AssertNumberOfCalls(t, "Do", 1)
+	})
+}
+
+func TestUpstreamService_LeastLatency(t *testing.T) {
+	upstream := NewUpstreamService(new(mocks.MockClient), new(mocks.MockTargetModelRepo), new(mocks.MockTargetRouter))
+	targetModel := &entities.TargetModel{ID: primitive.NewObjectID(), Balancing: entities.BalancingLeastLatency,
+		Endpoints: []entities.Endpoint{{Address: "http://slow"}, {Address: "http://fast"}, {Address: "http://new"}}}
+	upstream.recordSuccess(endpointKey(targetModel, targetModel.Endpoints[0]), time.Second)
+	upstream.recordSuccess(endpointKey(targetModel, targetModel.Endpoints[1]), time.Millisecond)
+
+	endpoints := upstream.order(targetModel)
+	require.Equal(t, []string{"http://new", "http://fast", "http://slow"}, []string{
+		endpoints[0].Address, endpoints[1].Address, endpoints[2].Address,
+	})
+}
+
+func countCalls(client *mocks.MockClient, address string) int {
+	count := 0
+	for _, call := range client.Calls {
+		if req, ok := call.Arguments.Get(0).(*http.Request); ok && req.URL.String() == address {
+			count++
+		}
+	}
+	return count
+}
+
+func TestUpstreamService_ForgetsUnusedEndpoints(t *testing.T) {
+	upstream := NewUpstreamService(new(mocks.MockClient), new(mocks.MockTargetModelRepo), new(mocks.MockTargetRouter))
+	upstream.recordSuccess("deleted|http://a", time.Second)
+	upstream.endpoints["deleted|http://a"].lastUsed = time.Now().Add(-2 * endpointStateTTL)
+	upstream.lastSweep = time.Time{}
+
+	upstream.recordSuccess("current|http://b", time.Second)
+	require.NotContains(t, upstream.endpoints, "deleted|http://a")
+	require.Contains(t, upstream.endpoints, "current|http://b")
+}
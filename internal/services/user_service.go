@@ -2,108 +2,755 @@ package services
 
 import (
 	"context"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"strings"
 	"time"
 
 	"guardian/configs"
+	"guardian/internal/mail"
 	"guardian/internal/models"
 	"guardian/internal/models/entities"
 	"guardian/internal/repository"
-	"guardian/utlis/logger"
+	"guardian/internal/tenant"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	activationPurpose = "activation"
+	minPasswordLength = 8
+	// dummyPasswordHash is compared against when the email is unknown, so that the response time of a login doesn't
+	// reveal whether an account exists.
+	dummyPasswordHash = "$2a$10$HN/VfG/wnJNC7uqGYm7VpeJvgqW/0f6eIhuxIkmi3BeqfnMwADqhe"
+)
+
+var (
+	ErrMissingSubject          = errors.New("external identity has no subject")
+	ErrInvalidCredentials      = errors.New("invalid email or password")
+	ErrUserInactive            = errors.New("user is not active")
+	ErrEmailTaken              = errors.New("email is already in use")
+	ErrInvalidEmail            = errors.New("invalid email address")
+	ErrWeakPassword            = errors.Errorf("password must have at least %d characters", minPasswordLength)
+	ErrPasswordNotSet          = errors.New("user signs in through an external identity provider")
+	ErrInvalidActivationToken  = errors.New("invalid activation token")
+	ErrActivationTokenNotFound = errors.New("activation token user not found")
+	ErrInvalidRole             = errors.New("invalid role")
+	ErrRoleNotAllowed          = errors.New("not allowed to grant this role")
+	ErrAccountLinkRequired     = errors.New("an account with this email exists, sign in to it to link the identity")
+	ErrExternalIdentityTaken   = errors.New("external identity is linked to another account")
+)
+
 type UserServiceInterface interface {
-	GetUserTasksByID(userID primitive.ObjectID) ([]entities.Task, error)
-	GetUser(id primitive.ObjectID) (*entities.User, error)
-	Login(req models.LoginRequest) (string, error)
+	GetUserTasksByID(ctx context.Context, userID primitive.ObjectID) ([]entities.Task, error)
+	GetGroupTasksByID(ctx context.Context, groupID primitive.ObjectID) ([]entities.Task, error)
+	GetUser(ctx context.Context, id primitive.ObjectID) (*entities.User, error)
+	GetUsers(ctx context.Context) ([]entities.User, error)
+	CreateUser(ctx context.Context, actor models.Actor, req models.CreateUserRequest) (*entities.User, error)
+	Login(ctx context.Context, req models.LoginRequest, clientIP string) (*models.TokenResponse, error)
 	SignUp(req models.SignUpRequest) error
-	ActivateUser(req models.SignUpRequest) error
+	ActivateUser(ctx context.Context, token string) error
+	ResendActivation(ctx context.Context, email string) error
+	UpdateUser(ctx context.Context, userID primitive.ObjectID, req models.UpdateUserRequest) error
+	ChangePassword(ctx context.Context, userID primitive.ObjectID, req models.ChangePasswordRequest) error
+	DeleteUser(ctx context.Context, userID primitive.ObjectID) error
+	SetUserRole(ctx context.Context, actor models.Actor, userID primitive.ObjectID, role string) error
+	SetUserTargetModels(ctx context.Context, userID primitive.ObjectID, modelIDs []primitive.ObjectID) error
+	ProvisionExternalUser(ctx context.Context, identity models.ExternalIdentity) (*entities.User, error)
+	LinkExternalIdentity(ctx context.Context, userID primitive.ObjectID, identity models.ExternalIdentity) error
 }
 
 type UserService struct {
-	userRepo *repository.UserRepository
-	taskRepo *repository.TaskRepository
+	userRepo         *repository.UserRepository
+	taskRepo         *repository.TaskRepository
+	groupRepo        *repository.GroupRepository
+	organizationRepo repository.OrganizationRepoInterface
+	targetModelRepo  repository.TargetModelRepoInterface
+	tokenService     TokenServiceInterface
+	loginGuard       LoginGuardInterface
+	mailSender       mail.Sender
 }
 
-func NewUserService(userRepo *repository.UserRepository, taskRepo *repository.TaskRepository) *UserService {
+func NewUserService(userRepo *repository.UserRepository, taskRepo *repository.TaskRepository,
+	groupRepo *repository.GroupRepository, organizationRepo repository.OrganizationRepoInterface,
+	targetModelRepo repository.TargetModelRepoInterface, tokenService TokenServiceInterface,
+	loginGuard LoginGuardInterface, mailSender mail.Sender,
+) *UserService {
 	return &UserService{
-		userRepo: userRepo,
-		taskRepo: taskRepo,
+		userRepo:         userRepo,
+		taskRepo:         taskRepo,
+		groupRepo:        groupRepo,
+		organizationRepo: organizationRepo,
+		targetModelRepo:  targetModelRepo,
+		tokenService:     tokenService,
+		loginGuard:       loginGuard,
+		mailSender:       mailSender,
 	}
 }
 
-func (u *UserService) GetUser(id primitive.ObjectID) (*entities.User, error) {
-	user, err := u.userRepo.GetByFilter(context.Background(), bson.M{"_id": id})
+func (u *UserService) GetUser(ctx context.Context, id primitive.ObjectID) (*entities.User, error) {
+	user, err := u.userRepo.GetByFilter(ctx, bson.M{"_id": id})
 	if err != nil {
 		return nil, errors.Errorf("error in GetUser:%v", err)
 	}
 	return user, nil
 }
 
-func (u *UserService) GetUserTasksByID(userID primitive.ObjectID) ([]entities.Task, error) {
-	user, err := u.GetUser(userID)
+// GetUsers lists the users of the organization the context is scoped to.
+func (u *UserService) GetUsers(ctx context.Context) ([]entities.User, error) {
+	if _, ok := tenant.FromContext(ctx); !ok {
+		return nil, ErrNoOrganization
+	}
+
+	users, err := u.userRepo.GetAll(ctx, bson.M{"status": bson.M{"$ne": entities.UserStatusDeleted}})
+	if err != nil {
+		return nil, errors.Errorf("error in finding users: %v", err)
+	}
+	if users == nil {
+		users = []entities.User{}
+	}
+	return users, nil
+}
+
+// CreateUser adds a user to the organization the context is scoped to and sends it an activation email.
+func (u *UserService) CreateUser(ctx context.Context, actor models.Actor, req models.CreateUserRequest) (
+	*entities.User, error,
+) {
+	organizationID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoOrganization
+	}
+	if req.Role == "" {
+		req.Role = entities.RoleUser
+	}
+	err := checkRoleGrant(actor, req.Role)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+	err = u.ensureEmailAvailable(ctx, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := entities.User{
+		ID:       primitive.NewObjectID(),
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: hashedPassword,
+		Status:   entities.UserStatusInactive,
+		Role:     req.Role,
+		Tenant:   entities.Tenant{OrganizationID: organizationID},
+	}
+	err = u.userRepo.Create(ctx, &user)
+	if err != nil {
+		return nil, emailTaken(err, "error in creating the user")
+	}
+
+	return &user, u.sendActivationEmail(ctx, user.ID, user.Email)
+}
+
+// GetUserTasksByID returns the user's own tasks together with the tasks of every group the user belongs to.
+func (u *UserService) GetUserTasksByID(ctx context.Context, userID primitive.ObjectID) ([]entities.Task, error) {
+	user, err := u.GetUser(ctx, userID)
 	if err != nil {
 		return nil, errors.Errorf("user error:%v", userID)
 	}
-	if user.Tasks == nil {
+
+	taskIDs := user.Tasks
+	if len(user.Groups) > 0 {
+		groupIDs := make([]primitive.ObjectID, 0, len(user.Groups))
+		for _, group := range user.Groups {
+			groupIDs = append(groupIDs, group.ID)
+		}
+		groups, err := u.groupRepo.GetGroupsByIDs(ctx, groupIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			taskIDs = append(taskIDs, group.Tasks...)
+		}
+	}
+	if len(taskIDs) == 0 {
 		return []entities.Task{}, nil
 	}
-	tasks, err := u.taskRepo.GetTasks(context.Background(), user.Tasks)
+
+	tasks, err := u.taskRepo.GetTasks(ctx, uniqueIDs(taskIDs))
 	return tasks, err
 }
 
-func (u *UserService) Login(req models.LoginRequest) (string, error) {
-	user, err := u.userRepo.GetByFilter(context.Background(), bson.M{"email": req.Email})
+// GetGroupTasksByID returns the tasks of a group, which apply to requests made with the group's API keys.
+func (u *UserService) GetGroupTasksByID(ctx context.Context, groupID primitive.ObjectID) ([]entities.Task, error) {
+	group, err := u.groupRepo.GetGroup(ctx, groupID)
 	if err != nil {
-		return "", err
+		return nil, errors.Errorf("group error:%v", groupID)
+	}
+	if len(group.Tasks) == 0 {
+		return []entities.Task{}, nil
 	}
+	return u.taskRepo.GetTasks(ctx, uniqueIDs(group.Tasks))
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+// Login verifies the credentials and starts a session. Unknown emails, wrong passwords and deleted accounts fail
+// alike, and repeated failures lock the account and the client IP out.
+func (u *UserService) Login(ctx context.Context, req models.LoginRequest, clientIP string) (*models.TokenResponse,
+	error,
+) {
+	err := u.loginGuard.CheckLogin(ctx, req.Email, clientIP)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	_, tokenString, err := configs.GlobalConfig.TokenAuth.Encode(map[string]interface{}{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(configs.GlobalConfig.TokenExpirationTime),
-	})
-	if err != nil {
-		logger.GetLogger().Errorf("error generating token: %v", err)
-		return "", err
+	// Email addresses are unique across organizations, so the user determines the organization.
+	user, err := u.userRepo.GetByFilter(tenant.Unscoped(ctx), bson.M{"email": req.Email})
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Errorf("error in finding the user: %v", err)
+	}
+	passwordHash := dummyPasswordHash
+	if err == nil && user.Password != "" {
+		passwordHash = user.Password
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password))
+	if err != nil || passwordHash == dummyPasswordHash || user.Status == entities.UserStatusDeleted {
+		u.loginGuard.RecordFailure(ctx, req.Email, clientIP)
+		return nil, ErrInvalidCredentials
 	}
-	return tokenString, nil
+	u.loginGuard.RecordSuccess(ctx, req.Email)
+	if user.Status != entities.UserStatusActive {
+		return nil, ErrUserInactive
+	}
+
+	return u.tokenService.IssueTokens(ctx, user)
 }
 
 func (u *UserService) SignUp(req models.SignUpRequest) error {
+	ctx := context.Background()
+	if !isValidEmail(req.Email) {
+		return ErrInvalidEmail
+	}
+	if len(req.Password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	err := u.ensureEmailAvailable(ctx, req.Email)
+	if err != nil {
+		return err
+	}
+
 	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
 		return err
 	}
 
+	organization, err := u.organization(ctx, "")
+	if err != nil {
+		return err
+	}
+
 	user := entities.User{
+		ID:       primitive.NewObjectID(),
 		Name:     req.Name,
 		Email:    req.Email,
 		Password: hashedPassword,
-		Status:   0,
+		Status:   entities.UserStatusInactive,
+		Role:     initialRole(req.Email),
 		Groups:   nil,
+		Tenant:   entities.Tenant{OrganizationID: organization.ID},
+	}
+
+	ctx = tenant.WithOrganization(ctx, organization.ID)
+	err = u.userRepo.Create(ctx, &user)
+	if err != nil {
+		return emailTaken(err, "error in creating the user")
+	}
+
+	return u.sendActivationEmail(ctx, user.ID, user.Email)
+}
+
+// ActivateUser verifies an activation token. It activates a new account and applies the email address the token
+// was sent to, which also completes an email change. Only the last token sent to the user is accepted, and only once.
+func (u *UserService) ActivateUser(ctx context.Context, token string) error {
+	claims, err := parseActivationToken(token)
+	if err != nil {
+		return err
+	}
+	userID, email := claims.UserID, claims.Email
+
+	user, err := u.userRepo.GetByFilter(tenant.Unscoped(ctx), bson.M{"_id": userID})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrActivationTokenNotFound
+		}
+		return err
+	}
+	ctx = tenant.WithOrganization(ctx, user.OrganizationID)
+	if user.Status == entities.UserStatusDeleted {
+		return ErrActivationTokenNotFound
+	}
+	if user.ActivationNonce != claims.Nonce {
+		return ErrInvalidActivationToken
+	}
+	if user.Email != email {
+		err = u.ensureEmailAvailable(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	activated, err := u.userRepo.ActivateUser(ctx, userID, claims.Nonce, email)
+	if err != nil {
+		return emailTaken(err, "error in activating the user")
+	}
+	if activated == 0 {
+		// A concurrent activation consumed the token first.
+		return ErrInvalidActivationToken
+	}
+	return nil
+}
+
+// ResendActivation sends a new activation email to an account that has not been activated yet. Unknown addresses
+// are ignored so the endpoint cannot be used to probe for accounts.
+func (u *UserService) ResendActivation(ctx context.Context, email string) error {
+	user, err := u.userRepo.GetByFilter(tenant.Unscoped(ctx),
+		bson.M{"email": email, "status": entities.UserStatusInactive})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+	return u.sendActivationEmail(tenant.WithOrganization(ctx, user.OrganizationID), user.ID, user.Email)
+}
+
+func (u *UserService) UpdateUser(ctx context.Context, userID primitive.ObjectID, req models.UpdateUserRequest) error {
+	user, err := u.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if req.Name != nil && *req.Name != user.Name {
+		err = u.userRepo.Update(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"name": *req.Name}})
+		if err != nil {
+			return errors.Errorf("error in updating the user: %v", err)
+		}
+	}
+
+	if req.Email != nil && *req.Email != user.Email {
+		if !isValidEmail(*req.Email) {
+			return ErrInvalidEmail
+		}
+		err = u.ensureEmailAvailable(ctx, *req.Email)
+		if err != nil {
+			return err
+		}
+		return u.sendActivationEmail(ctx, userID, *req.Email)
+	}
+	return nil
+}
+
+// SetUserRole changes the role of a user of the actor's organization. Access tokens carry the role, so it takes
+// effect with the user's next token.
+func (u *UserService) SetUserRole(ctx context.Context, actor models.Actor, userID primitive.ObjectID,
+	role string,
+) error {
+	err := checkRoleGrant(actor, role)
+	if err != nil {
+		return err
+	}
+	if actor.Role != entities.RolePlatformAdmin {
+		user, err := u.userRepo.GetUser(ctx, userID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return errors.Errorf("error in finding the user: %v", err)
+		}
+		if user.GetRole() == entities.RolePlatformAdmin {
+			return ErrRoleNotAllowed
+		}
+	}
+
+	matched, err := u.userRepo.SetUserRole(ctx, userID, role)
+	if err != nil {
+		return errors.Errorf("error in setting the user role: %v", err)
+	}
+	if matched == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserTargetModels sets the target models the user may be routed to, in addition to those of their groups.
+func (u *UserService) SetUserTargetModels(ctx context.Context, userID primitive.ObjectID,
+	modelIDs []primitive.ObjectID,
+) error {
+	modelIDs = uniqueIDs(modelIDs)
+	if len(modelIDs) > 0 {
+		targetModels, err := u.targetModelRepo.GetModels(ctx, modelIDs)
+		if err != nil {
+			return errors.Errorf("error in finding the target models: %v", err)
+		}
+		if len(targetModels) != len(modelIDs) {
+			return ErrModelNotFound
+		}
+	}
+
+	matched, err := u.userRepo.SetUserTargetModels(ctx, userID, modelIDs)
+	if err != nil {
+		return errors.Errorf("error in setting the user target models: %v", err)
+	}
+	if matched == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ChangePassword replaces the user's password and ends all of its other sessions.
+func (u *UserService) ChangePassword(ctx context.Context, userID primitive.ObjectID,
+	req models.ChangePasswordRequest,
+) error {
+	user, err := u.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Password == "" {
+		return ErrPasswordNotSet
 	}
 
-	err = u.userRepo.Create(context.Background(), &user)
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword))
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		return ErrWeakPassword
+	}
+
+	hashedPassword, err := hashPassword(req.NewPassword)
 	if err != nil {
 		return err
 	}
+	err = u.userRepo.Update(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		return errors.Errorf("error in updating the password: %v", err)
+	}
+
+	return u.tokenService.RevokeAllRefreshTokens(ctx, userID)
+}
+
+// DeleteUser soft deletes the user: the account is kept but can no longer sign in or use Guardian.
+func (u *UserService) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	now := time.Now().UTC()
+	err := u.userRepo.Update(ctx, bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"status": entities.UserStatusDeleted, "deleted_at": now}})
+	if err != nil {
+		return errors.Errorf("error in deleting the user: %v", err)
+	}
+
+	return u.tokenService.RevokeAllRefreshTokens(ctx, userID)
+}
+
+// emailTaken maps the duplicate key errors of the unique email index to ErrEmailTaken, which ensureEmailAvailable
+// misses when the same email is registered concurrently or in another case.
+func emailTaken(err error, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+	return errors.Errorf("%s: %v", message, err)
+}
+
+// ensureEmailAvailable checks every organization as users sign in by email alone.
+func (u *UserService) ensureEmailAvailable(ctx context.Context, email string) error {
+	_, err := u.userRepo.GetByFilter(tenant.Unscoped(ctx), bson.M{"email": email})
+	switch {
+	case err == nil:
+		return ErrEmailTaken
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return nil
+	default:
+		return err
+	}
+}
+
+// sendActivationEmail sends a new activation token, which supersedes the ones sent before.
+func (u *UserService) sendActivationEmail(ctx context.Context, userID primitive.ObjectID, email string) error {
+	nonce := uuid.NewString()
+	err := u.userRepo.Update(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"activation_nonce": nonce}})
+	if err != nil {
+		return errors.Errorf("error in storing the activation nonce: %v", err)
+	}
+
+	token, err := generateActivationToken(activationClaims{UserID: userID, Email: email, Nonce: nonce})
+	if err != nil {
+		return errors.Errorf("error in generating the activation token: %v", err)
+	}
+
+	err = u.mailSender.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Activate your Guardian account",
+		Body: fmt.Sprintf("Confirm your email address by opening the link below:\n\n%s/user/activate?token=%s\n\n"+
+			"The link expires in %s.\n", strings.TrimSuffix(configs.GlobalConfig.PublicURL, "/"), url.QueryEscape(token),
+			configs.GlobalConfig.ActivationTokenExpTime),
+	})
+	if err != nil {
+		return errors.Errorf("error in sending the activation email: %v", err)
+	}
+	return nil
+}
+
+// ProvisionExternalUser finds the user behind an external identity, creating it on first sight (JIT provisioning)
+// and keeping its groups in sync with the IdP's groups claim.
+func (u *UserService) ProvisionExternalUser(ctx context.Context, identity models.ExternalIdentity) (*entities.User,
+	error,
+) {
+	if identity.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+
+	user, err := u.findExternalUser(ctx, identity)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	// Existing users stay in their organization, new ones join the one named by the organization claim.
+	organizationID := primitive.NilObjectID
+	if user != nil {
+		organizationID = user.OrganizationID
+	} else {
+		organization, err := u.organization(ctx, identity.Organization)
+		if err != nil {
+			return nil, err
+		}
+		organizationID = organization.ID
+
+		user, err = u.findLinkableUser(tenant.WithOrganization(ctx, organizationID), identity)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+	}
+	ctx = tenant.WithOrganization(ctx, organizationID)
+
+	var groups []entities.Group
+	if identity.Groups != nil {
+		groups, err = u.syncGroups(ctx, identity.Groups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		user = &entities.User{
+			Name:       identity.Name,
+			Email:      identity.Email,
+			Status:     entities.UserStatusActive,
+			Role:       externalRole(identity),
+			Groups:     groups,
+			ExternalID: identity.Subject,
+			Tenant:     entities.Tenant{OrganizationID: organizationID},
+		}
+		err = u.userRepo.Create(ctx, user)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, errors.Errorf("error in creating the external user: %v", err)
+		}
+		// A duplicate key means a concurrent login provisioned the same identity first, unless another user has the
+		// email.
+		user, err = u.userRepo.GetByFilter(ctx, bson.M{"external_id": identity.Subject})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrEmailTaken
+		}
+		return user, err
+	}
+
+	update := externalUserUpdate(user, identity, groups)
+	if len(update) == 0 {
+		return user, nil
+	}
+	err = u.userRepo.Update(ctx, bson.M{"_id": user.ID}, bson.M{"$set": update})
+	if err != nil {
+		return nil, errors.Errorf("error in updating the external user: %v", err)
+	}
+	user.ExternalID = identity.Subject
+	if identity.Groups != nil {
+		user.Groups = groups
+	}
+
+	return user, nil
+}
+
+// externalUserUpdate returns the fields of user that are out of sync with the external identity, so that logins
+// that change nothing don't write to the database.
+func externalUserUpdate(user *entities.User, identity models.ExternalIdentity, groups []entities.Group) bson.M {
+	update := bson.M{}
+	if user.ExternalID != identity.Subject {
+		update["external_id"] = identity.Subject
+	}
+	if identity.Groups != nil && !sameGroups(user.Groups, groups) {
+		update["groups"] = groups
+	}
+	return update
+}
+
+func sameGroups(a, b []entities.Group) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
 
+// findExternalUser looks the user linked to the external subject up.
+func (u *UserService) findExternalUser(ctx context.Context, identity models.ExternalIdentity) (*entities.User,
+	error,
+) {
+	// External identities are matched before their organization is known.
+	user, err := u.userRepo.GetByFilter(tenant.Unscoped(ctx), bson.M{"external_id": identity.Subject})
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Errorf("error in finding the external user: %v", err)
+	}
+	return user, err
+}
+
+// findLinkableUser looks up the account of the organization ctx is scoped to with the identity's verified email, so
+// that existing accounts get linked instead of duplicated. Accounts that canAutoLink refuses are only linked once
+// their owner signs in and links the identity with LinkExternalIdentity.
+func (u *UserService) findLinkableUser(ctx context.Context, identity models.ExternalIdentity) (*entities.User,
+	error,
+) {
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, mongo.ErrNoDocuments
+	}
+	user, err := u.userRepo.GetByFilter(ctx, bson.M{"email": identity.Email})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		return nil, errors.Errorf("error in finding the external user: %v", err)
+	}
+	if !canAutoLink(user) {
+		return nil, ErrAccountLinkRequired
+	}
+	return user, nil
+}
+
+// canAutoLink reports whether an account may be linked to an external identity on the IdP's word alone. Accounts
+// with a password, a privileged role or another external identity need the consent of their owner.
+func canAutoLink(user *entities.User) bool {
+	return user.Password == "" && user.GetRole() == entities.RoleUser && user.ExternalID == ""
+}
+
+// LinkExternalIdentity links the external identity to the account of the signed in user, who consents to signing in
+// through the IdP from now on.
+func (u *UserService) LinkExternalIdentity(ctx context.Context, userID primitive.ObjectID,
+	identity models.ExternalIdentity,
+) error {
+	if identity.Subject == "" {
+		return ErrMissingSubject
+	}
+
+	err := u.userRepo.Update(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"external_id": identity.Subject}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrExternalIdentityTaken
+	}
+	if err != nil {
+		return errors.Errorf("error in linking the external identity: %v", err)
+	}
 	return nil
 }
 
-func (u *UserService) ActivateUser(_ models.SignUpRequest) error {
-	_, _ = generateActivationToken("")
-	_ = sendActivationEmail("", "")
+// syncGroups resolves the given group names, creating the ones Guardian doesn't know yet.
+func (u *UserService) syncGroups(ctx context.Context, names []string) ([]entities.Group, error) {
+	existing, err := u.groupRepo.GetGroupsByNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]entities.Group, len(existing))
+	for _, group := range existing {
+		byName[group.Name] = group
+	}
+
+	groups := make([]entities.Group, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		group, ok := byName[name]
+		if !ok {
+			group, err = u.groupRepo.CreateGroup(ctx, entities.Group{Name: name, Status: 1})
+			if err != nil {
+				return nil, errors.Errorf("error in creating group %s: %v", name, err)
+			}
+			byName[name] = group
+		}
+		groups = append(groups, membership(group))
+	}
+	return groups, nil
+}
+
+// organization resolves an organization by its slug, falling back to the default organization.
+func (u *UserService) organization(ctx context.Context, slug string) (*entities.Organization, error) {
+	if slug == "" {
+		slug = configs.GlobalConfig.DefaultOrganization
+	}
+
+	organization, err := u.organizationRepo.GetOrganizationBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.Wrap(ErrOrganizationNotFound, slug)
+		}
+		return nil, errors.Errorf("error in finding the organization: %v", err)
+	}
+	return organization, nil
+}
+
+// initialRole makes the configured bootstrap admins platform admins. Sign ups only take effect once the email is
+// verified.
+func initialRole(email string) string {
+	for _, admin := range configs.GlobalConfig.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return entities.RolePlatformAdmin
+		}
+	}
+	return entities.RoleUser
+}
+
+// externalRole only grants the bootstrap admin role to external identities whose email the IdP has verified, as
+// findLinkableUser does for linking accounts.
+func externalRole(identity models.ExternalIdentity) string {
+	if !identity.EmailVerified {
+		return entities.RoleUser
+	}
+	return initialRole(identity.Email)
+}
+
+// checkRoleGrant validates a role an actor hands out. Only platform admins may create other platform admins.
+func checkRoleGrant(actor models.Actor, role string) error {
+	if !entities.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if role == entities.RolePlatformAdmin && actor.Role != entities.RolePlatformAdmin {
+		return ErrRoleNotAllowed
+	}
 	return nil
 }
 
@@ -115,23 +762,52 @@ func hashPassword(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
-// TODO: Infra layer code.
-func generateActivationToken(userID string) (string, error) {
-	expTime := time.Now().Add(configs.GlobalConfig.ActivationTokenExpTime)
+// activationClaims are the claims of an activation token. The nonce must match the user's, so that tokens are
+// single-use and superseded by newer ones.
+type activationClaims struct {
+	UserID primitive.ObjectID
+	Email  string
+	Nonce  string
+}
 
-	claims := jwt.MapClaims{}
-	claims["user_id"] = userID
-	claims["exp"] = expTime
+func generateActivationToken(claims activationClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": claims.UserID.Hex(),
+		"email":   claims.Email,
+		"nonce":   claims.Nonce,
+		"purpose": activationPurpose,
+		"exp":     time.Now().Add(configs.GlobalConfig.ActivationTokenExpTime).Unix(),
+	})
+	return token.SignedString([]byte(configs.GlobalConfig.ActivationTokenKey))
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+func parseActivationToken(tokenStr string) (activationClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(configs.GlobalConfig.ActivationTokenKey), nil
+	})
+	if err != nil || !token.Valid {
+		return activationClaims{}, ErrInvalidActivationToken
+	}
 
-	activationToken, err := token.SignedString([]byte(configs.GlobalConfig.ActivationTokenKey))
-	if err != nil {
-		return "", err
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != activationPurpose {
+		return activationClaims{}, ErrInvalidActivationToken
+	}
+	email, _ := claims["email"].(string)
+	nonce, _ := claims["nonce"].(string)
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil || email == "" || nonce == "" {
+		return activationClaims{}, ErrInvalidActivationToken
 	}
-	return activationToken, nil
+	return activationClaims{UserID: userID, Email: email, Nonce: nonce}, nil
 }
 
-func sendActivationEmail(_ string, _ string) error {
-	return nil
+// isValidEmail accepts bare addresses like jane@example.com, without display names or surrounding spaces.
+func isValidEmail(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email && strings.Contains(email[strings.LastIndex(email, "@"):], ".")
 }
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"guardian/configs"
+	"guardian/internal/models"
+	"guardian/internal/models/entities"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestActivationToken(t *testing.T) {
+	configs.GlobalConfig.ActivationTokenKey = "activation-secret"
+	configs.GlobalConfig.ActivationTokenExpTime = time.Hour
+	userID := primitive.NewObjectID()
+	claims := activationClaims{UserID: userID, Email: "jane@example.com", Nonce: "nonce"}
+
+	t.Run("round trip", func(t *testing.T) {
+		token, err := generateActivationToken(claims)
+		require.NoError(t, err)
+
+		got, err := parseActivationToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, claims, got)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		configs.GlobalConfig.ActivationTokenExpTime = -time.Minute
+		defer func() { configs.GlobalConfig.ActivationTokenExpTime = time.Hour }()
+
+		token, err := generateActivationToken(claims)
+		require.NoError(t, err)
+
+		_, err = parseActivationToken(token)
+		require.ErrorIs(t, err, ErrInvalidActivationToken)
+	})
+
+	t.Run("token signed with another key", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"user_id": userID.Hex(),
+			"email":   "jane@example.com",
+			"nonce":   "nonce",
+			"purpose": activationPurpose,
+		}).SignedString([]byte("other"))
+		require.NoError(t, err)
+
+		_, err = parseActivationToken(token)
+		require.ErrorIs(t, err, ErrInvalidActivationToken)
+	})
+
+	t.Run("token for another purpose", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"user_id": userID.Hex(),
+			"email":   "jane@example.com",
+			"nonce":   "nonce",
+		}).SignedString([]byte(configs.GlobalConfig.ActivationTokenKey))
+		require.NoError(t, err)
+
+		_, err = parseActivationToken(token)
+		require.ErrorIs(t, err, ErrInvalidActivationToken)
+	})
+
+	t.Run("token without nonce", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"user_id": userID.Hex(),
+			"email":   "jane@example.com",
+			"purpose": activationPurpose,
+		}).SignedString([]byte(configs.GlobalConfig.ActivationTokenKey))
+		require.NoError(t, err)
+
+		_, err = parseActivationToken(token)
+		require.ErrorIs(t, err, ErrInvalidActivationToken)
+	})
+}
+
+func TestIsValidEmail(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{email: "jane@example.com", valid: true},
+		{email: "jane.doe+guardian@mail.example.co.uk", valid: true},
+		{email: "", valid: false},
+		{email: "jane", valid: false},
+		{email: "jane@localhost", valid: false},
+		{email: "Jane <jane@example.com>", valid: false},
+		{email: " jane@example.com", valid: false},
+		{email: "jane@@example.com", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.valid, isValidEmail(tt.email))
+		})
+	}
+}
+
+func TestCheckRoleGrant(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		actor       string
+		role        string
+		expectedErr error
+	}{
+		{name: "admin grants group admin", actor: entities.RoleAdmin, role: entities.RoleGroupAdmin},
+		{name: "platform admin grants platform admin", actor: entities.RolePlatformAdmin,
+			role: entities.RolePlatformAdmin},
+		{name: "admin grants platform admin", actor: entities.RoleAdmin, role: entities.RolePlatformAdmin,
+			expectedErr: ErrRoleNotAllowed},
+		{name: "unknown role", actor: entities.RolePlatformAdmin, role: "root", expectedErr: ErrInvalidRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkRoleGrant(models.Actor{UserID: primitive.NewObjectID(), Role: tt.actor}, tt.role)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestExternalRole(t *testing.T) {
+	configs.GlobalConfig.AdminEmails = []string{"admin@example.com"}
+
+	tests := []struct {
+		name     string
+		identity models.ExternalIdentity
+		expected string
+	}{
+		{name: "verified admin email", identity: models.ExternalIdentity{Email: "Admin@example.com",
+			EmailVerified: true}, expected: entities.RolePlatformAdmin},
+		{name: "unverified admin email", identity: models.ExternalIdentity{Email: "admin@example.com"},
+			expected: entities.RoleUser},
+		{name: "verified user email", identity: models.ExternalIdentity{Email: "user@example.com",
+			EmailVerified: true}, expected: entities.RoleUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, externalRole(tt.identity))
+		})
+	}
+}
+
+func TestEmailTaken(t *testing.T) {
+	t.Parallel()
+
+	duplicate := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}
+	require.ErrorIs(t, emailTaken(duplicate, "error in creating the user"), ErrEmailTaken)
+
+	err := emailTaken(mongo.ErrClientDisconnected, "error in creating the user")
+	require.NotErrorIs(t, err, ErrEmailTaken)
+	assert.Contains(t, err.Error(), "error in creating the user")
+}
+
+func TestCanAutoLink(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		user     entities.User
+		expected bool
+	}{
+		{name: "account without a password", user: entities.User{Role: entities.RoleUser}, expected: true},
+		{name: "account with a password", user: entities.User{Password: "hash"}},
+		{name: "admin", user: entities.User{Role: entities.RoleAdmin}},
+		{name: "platform admin", user: entities.User{Role: entities.RolePlatformAdmin}},
+		{name: "account linked to another identity", user: entities.User{ExternalID: "idp|other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.expected, canAutoLink(&tt.user))
+		})
+	}
+}
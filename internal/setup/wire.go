@@ -6,22 +6,83 @@ package setup
 
 import (
 	"guardian/api"
+	"guardian/configs"
+	"guardian/internal/mail"
 	"guardian/internal/middleware"
+	"guardian/internal/plugins"
+	redisClient "guardian/internal/redis"
 	"guardian/internal/repository"
 	"guardian/internal/services"
-	"guardian/internal/plugins"
 
 	"github.com/google/wire"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func NewUserService(userRepo *repository.UserRepository, taskRepo *repository.TaskRepository) *services.UserService {
-	return services.NewUserService(userRepo, taskRepo)
+func NewUserService(userRepo *repository.UserRepository, taskRepo *repository.TaskRepository,
+	groupRepo *repository.GroupRepository, organizationRepo repository.OrganizationRepoInterface,
+	targetModelRepo repository.TargetModelRepoInterface, tokenService services.TokenServiceInterface,
+	loginGuard services.LoginGuardInterface, mailSender mail.Sender,
+) *services.UserService {
+	return services.NewUserService(userRepo, taskRepo, groupRepo, organizationRepo, targetModelRepo, tokenService,
+		loginGuard, mailSender)
+}
+
+// NewTokenDenylist selects where revoked access tokens are kept.
+func NewTokenDenylist(db *mongo.Database) repository.TokenDenylistInterface {
+	if configs.GlobalConfig.TokenDenylistStore == "redis" {
+		return redisClient.NewTokenDenylist(redisClient.Client)
+	}
+	return repository.NewRevokedTokenRepository(db)
 }
 
+var UserRepoSet = wire.NewSet(
+	repository.NewUserRepository,
+	wire.Bind(new(repository.UserRepoInterface), new(*repository.UserRepository)),
+)
+
+// NewLoginAttemptStore selects where failed logins are counted.
+func NewLoginAttemptStore(db *mongo.Database) repository.LoginAttemptStoreInterface {
+	if configs.GlobalConfig.LoginLockout.Store == "redis" {
+		return redisClient.NewLoginAttempts(redisClient.Client)
+	}
+	return repository.NewLoginAttemptRepository(db)
+}
+
+var LoginGuardSet = wire.NewSet(
+	NewLoginAttemptStore,
+	repository.NewAuditRepository,
+	wire.Bind(new(repository.AuditRepoInterface), new(*repository.AuditRepository)),
+	services.NewLoginGuard,
+	wire.Bind(new(services.LoginGuardInterface), new(*services.LoginGuard)),
+)
+
+var OrganizationRepoSet = wire.NewSet(
+	repository.NewOrganizationRepository,
+	wire.Bind(new(repository.OrganizationRepoInterface), new(*repository.OrganizationRepository)),
+)
+
+var TokenServiceSet = wire.NewSet(
+	repository.NewRefreshTokenRepository,
+	wire.Bind(new(repository.RefreshTokenRepoInterface), new(*repository.RefreshTokenRepository)),
+	NewTokenDenylist,
+	services.NewTokenService,
+	wire.Bind(new(services.TokenServiceInterface), new(*services.TokenService)),
+)
+
 var UserServiceSet = wire.NewSet(
 	NewUserService,
 	wire.Bind(new(services.UserServiceInterface), new(*services.UserService)),
+	TokenServiceSet,
+	OrganizationRepoSet,
+	LoginGuardSet,
+	mail.NewSender,
+)
+
+var APIKeyServiceSet = wire.NewSet(
+	repository.NewAPIKeyRepository,
+	wire.Bind(new(repository.APIKeyRepoInterface), new(*repository.APIKeyRepository)),
+	services.NewAPIKeyService,
+	wire.Bind(new(services.APIKeyServiceInterface), new(*services.APIKeyService)),
 )
 
 var SendHandlerSet = wire.NewSet(
@@ -34,13 +95,18 @@ var SendHandlerSet = wire.NewSet(
 	wire.Bind(new(services.PluginServiceInterface), new(*services.PluginService)),
 	services.NewPromptService,
 	wire.Bind(new(services.PromptServiceInterface), new(*services.PromptService)),
+	repository.NewChatHistoryRepository,
+	wire.Bind(new(repository.ChatHistoryRepoInterface), new(*repository.ChatHistoryRepository)),
 	UserServiceSet,
+	APIKeyServiceSet,
 	repository.NewTaskRepository,
+	wire.Bind(new(repository.TaskRepoInterface), new(*repository.TaskRepository)),
+	repository.NewGroupRepository,
 )
 
 func InitializeSendHandlerController(db *mongo.Database) *api.SendHandlerController {
 	wire.Build(
-		repository.NewUserRepository,
+		UserRepoSet,
 		repository.NewTargetModelRepository,
 		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
 		plugins.NewHTTPClient,
@@ -48,6 +114,46 @@ func InitializeSendHandlerController(db *mongo.Database) *api.SendHandlerControl
 		SendHandlerSet,
 		services.NewTargetModelService,
 		wire.Bind(new(services.TargetModelServiceInterface), new(*services.TargetModelService)),
+		wire.Bind(new(repository.GroupRepoInterface), new(*repository.GroupRepository)),
+		services.NewTargetRouter,
+		wire.Bind(new(services.TargetRouterInterface), new(*services.TargetRouter)),
+		services.NewUpstreamService,
+		wire.Bind(new(services.UpstreamServiceInterface), new(*services.UpstreamService)),
+		services.NewHTTPClientProvider,
+	)
+	return nil
+}
+
+func InitializeGuardianServer(db *mongo.Database) *api.GuardianServer {
+	wire.Build(
+		UserRepoSet,
+		repository.NewTargetModelRepository,
+		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
+		plugins.NewHTTPClient,
+		wire.Bind(new(plugins.HTTPClientInterface), new(*plugins.HTTPClient)),
+		SendHandlerSet,
+		api.NewGuardianServer,
+		services.NewTargetModelService,
+		wire.Bind(new(services.TargetModelServiceInterface), new(*services.TargetModelService)),
+		wire.Bind(new(repository.GroupRepoInterface), new(*repository.GroupRepository)),
+		services.NewTargetRouter,
+		wire.Bind(new(services.TargetRouterInterface), new(*services.TargetRouter)),
+		services.NewUpstreamService,
+		wire.Bind(new(services.UpstreamServiceInterface), new(*services.UpstreamService)),
+		services.NewHTTPClientProvider,
+	)
+	return nil
+}
+
+func InitializeCheckController(db *mongo.Database) *api.CheckController {
+	wire.Build(
+		UserRepoSet,
+		repository.NewTargetModelRepository,
+		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
+		plugins.NewHTTPClient,
+		wire.Bind(new(plugins.HTTPClientInterface), new(*plugins.HTTPClient)),
+		SendHandlerSet,
+		api.NewCheckController,
 		services.NewHTTPClientProvider,
 	)
 	return nil
@@ -55,11 +161,110 @@ func InitializeSendHandlerController(db *mongo.Database) *api.SendHandlerControl
 
 func InitializeAuthController(db *mongo.Database) *api.AuthController {
 	wire.Build(
-		repository.NewUserRepository,
+		UserRepoSet,
 		repository.NewTaskRepository,
+		repository.NewGroupRepository,
+		repository.NewTargetModelRepository,
+		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
 		services.NewUserService,
 		wire.Bind(new(services.UserServiceInterface), new(*services.UserService)),
+		TokenServiceSet,
+		OrganizationRepoSet,
+		LoginGuardSet,
+		mail.NewSender,
 		api.NewAuthController,
 	)
 	return &api.AuthController{}
 }
+
+func InitializeMiddleware(db *mongo.Database) *middleware.Middleware {
+	wire.Build(
+		UserRepoSet,
+		repository.NewTaskRepository,
+		repository.NewGroupRepository,
+		repository.NewTargetModelRepository,
+		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
+		UserServiceSet,
+		APIKeyServiceSet,
+		middleware.NewMiddleware,
+	)
+	return &middleware.Middleware{}
+}
+
+func InitializeAPIKeyController(db *mongo.Database) *api.APIKeyController {
+	wire.Build(
+		UserRepoSet,
+		repository.NewTaskRepository,
+		repository.NewGroupRepository,
+		repository.NewTargetModelRepository,
+		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
+		UserServiceSet,
+		APIKeyServiceSet,
+		middleware.NewMiddleware,
+		wire.Bind(new(middleware.Interface), new(*middleware.Middleware)),
+		api.NewAPIKeyController,
+	)
+	return &api.APIKeyController{}
+}
+
+func InitializeGroupController(db *mongo.Database) *api.GroupController {
+	wire.Build(
+		repository.NewGroupRepository,
+		wire.Bind(new(repository.GroupRepoInterface), new(*repository.GroupRepository)),
+		UserRepoSet,
+		repository.NewTaskRepository,
+		wire.Bind(new(repository.TaskRepoInterface), new(*repository.TaskRepository)),
+		repository.NewTargetModelRepository,
+		wire.Bind(new(repository.TargetModelRepoInterface), new(*repository.TargetModelRepository)),
+		services.NewGroupService,
+		wire.Bind(new(services.GroupServiceInterface), new(*services.GroupService)),
+		api.NewGroupController,
+	)
+	return &api.GroupController{}
+}
+
+func InitializeOrganizationService(db *mongo.Database) *services.OrganizationService {
+	wire.Build(
+		OrganizationRepoSet,
+		services.NewOrganizationService,
+	)
+	return &services.OrganizationService{}
+}
+
+func InitializeOrganizationController(db *mongo.Database) *api.OrganizationController {
+	wire.Build(
+		OrganizationRepoSet,
+		services.NewOrganizationService,
+		wire.Bind(new(services.OrganizationServiceInterface), new(*services.OrganizationService)),
+		api.NewOrganizationController,
+	)
+	return &api.OrganizationController{}
+}
+
+var PluginHealthServiceSet = wire.NewSet(
+	repository.NewPluginRepository,
+	wire.Bind(new(repository.PluginRepoInterface), new(*repository.PluginRepository)),
+	plugins.NewHTTPClient,
+	wire.Bind(new(plugins.HTTPClientInterface), new(*plugins.HTTPClient)),
+	services.NewHTTPClientProvider,
+	services.NewPluginHealthService,
+)
+
+func InitializePluginHealthService(db *mongo.Database) *services.PluginHealthService {
+	wire.Build(PluginHealthServiceSet)
+	return &services.PluginHealthService{}
+}
+
+func InitializePluginController(db *mongo.Database) *api.PluginController {
+	wire.Build(
+		PluginHealthServiceSet,
+		wire.Bind(new(services.PluginHealthServiceInterface), new(*services.PluginHealthService)),
+		repository.NewTaskRepository,
+		wire.Bind(new(repository.TaskRepoInterface), new(*repository.TaskRepository)),
+		NewTokenDenylist,
+		services.NewPluginService,
+		wire.Bind(new(services.PluginServiceInterface), new(*services.PluginService)),
+		api.NewPluginController,
+	)
+	return &api.PluginController{}
+}
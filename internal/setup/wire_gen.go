@@ -10,8 +10,11 @@ import (
 	"github.com/google/wire"
 	"go.mongodb.org/mongo-driver/mongo"
 	"guardian/api"
+	"guardian/configs"
+	"guardian/internal/mail"
 	"guardian/internal/middleware"
 	"guardian/internal/plugins"
+	"guardian/internal/redis"
 	"guardian/internal/repository"
 	"guardian/internal/services"
 )
@@ -21,35 +24,236 @@ import (
 func InitializeSendHandlerController(db *mongo.Database) *api.SendHandlerController {
 	userRepository := repository.NewUserRepository(db)
 	taskRepository := repository.NewTaskRepository(db)
-	userService := NewUserService(userRepository, taskRepository)
+	groupRepository := repository.NewGroupRepository(db)
+	organizationRepository := repository.NewOrganizationRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	tokenService := services.NewTokenService(refreshTokenRepository, tokenDenylistInterface, userRepository)
+	loginAttemptStoreInterface := NewLoginAttemptStore(db)
+	auditRepository := repository.NewAuditRepository(db)
+	loginGuard := services.NewLoginGuard(loginAttemptStoreInterface, auditRepository)
+	sender := mail.NewSender()
+	userService := NewUserService(userRepository, taskRepository, groupRepository, organizationRepository, targetModelRepository, tokenService, loginGuard, sender)
 	client := services.NewHTTPClientProvider()
 	httpClient := plugins.NewHTTPClient(client)
 	pluginRepository := repository.NewPluginRepository(db)
-	pluginService := services.NewPluginService(pluginRepository)
-	promptService := services.NewPromptService(userService, httpClient, pluginService)
-	targetModelRepository := repository.NewTargetModelRepository(db)
+	pluginService := services.NewPluginService(pluginRepository, taskRepository, tokenDenylistInterface)
+	chatHistoryRepository := repository.NewChatHistoryRepository(db)
+	promptService := services.NewPromptService(userService, httpClient, pluginService, chatHistoryRepository)
 	targetModelService := services.NewTargetModelService(targetModelRepository)
-	middlewareMiddleware := middleware.NewMiddleware()
-	sendHandlerController := api.NewSendHandlerController(promptService, targetModelService, middlewareMiddleware)
+	targetRouter := services.NewTargetRouter(userRepository, groupRepository, targetModelRepository)
+	upstreamService := services.NewUpstreamService(httpClient, targetModelRepository, targetRouter)
+	apiKeyRepository := repository.NewAPIKeyRepository(db)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepository, userService)
+	middlewareMiddleware := middleware.NewMiddleware(userService, apiKeyService, tokenService)
+	sendHandlerController := api.NewSendHandlerController(promptService, targetModelService, targetRouter, upstreamService, middlewareMiddleware)
 	return sendHandlerController
 }
 
+func InitializeGuardianServer(db *mongo.Database) *api.GuardianServer {
+	userRepository := repository.NewUserRepository(db)
+	taskRepository := repository.NewTaskRepository(db)
+	groupRepository := repository.NewGroupRepository(db)
+	organizationRepository := repository.NewOrganizationRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	tokenService := services.NewTokenService(refreshTokenRepository, tokenDenylistInterface, userRepository)
+	loginAttemptStoreInterface := NewLoginAttemptStore(db)
+	auditRepository := repository.NewAuditRepository(db)
+	loginGuard := services.NewLoginGuard(loginAttemptStoreInterface, auditRepository)
+	sender := mail.NewSender()
+	userService := NewUserService(userRepository, taskRepository, groupRepository, organizationRepository, targetModelRepository, tokenService, loginGuard, sender)
+	client := services.NewHTTPClientProvider()
+	httpClient := plugins.NewHTTPClient(client)
+	pluginRepository := repository.NewPluginRepository(db)
+	pluginService := services.NewPluginService(pluginRepository, taskRepository, tokenDenylistInterface)
+	chatHistoryRepository := repository.NewChatHistoryRepository(db)
+	promptService := services.NewPromptService(userService, httpClient, pluginService, chatHistoryRepository)
+	targetModelService := services.NewTargetModelService(targetModelRepository)
+	targetRouter := services.NewTargetRouter(userRepository, groupRepository, targetModelRepository)
+	upstreamService := services.NewUpstreamService(httpClient, targetModelRepository, targetRouter)
+	guardianServer := api.NewGuardianServer(promptService, targetModelService, targetRouter, upstreamService)
+	return guardianServer
+}
+
+func InitializeCheckController(db *mongo.Database) *api.CheckController {
+	userRepository := repository.NewUserRepository(db)
+	taskRepository := repository.NewTaskRepository(db)
+	groupRepository := repository.NewGroupRepository(db)
+	organizationRepository := repository.NewOrganizationRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	tokenService := services.NewTokenService(refreshTokenRepository, tokenDenylistInterface, userRepository)
+	loginAttemptStoreInterface := NewLoginAttemptStore(db)
+	auditRepository := repository.NewAuditRepository(db)
+	loginGuard := services.NewLoginGuard(loginAttemptStoreInterface, auditRepository)
+	sender := mail.NewSender()
+	userService := NewUserService(userRepository, taskRepository, groupRepository, organizationRepository, targetModelRepository, tokenService, loginGuard, sender)
+	client := services.NewHTTPClientProvider()
+	httpClient := plugins.NewHTTPClient(client)
+	pluginRepository := repository.NewPluginRepository(db)
+	pluginService := services.NewPluginService(pluginRepository, taskRepository, tokenDenylistInterface)
+	chatHistoryRepository := repository.NewChatHistoryRepository(db)
+	promptService := services.NewPromptService(userService, httpClient, pluginService, chatHistoryRepository)
+	apiKeyRepository := repository.NewAPIKeyRepository(db)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepository, userService)
+	middlewareMiddleware := middleware.NewMiddleware(userService, apiKeyService, tokenService)
+	checkController := api.NewCheckController(promptService, middlewareMiddleware)
+	return checkController
+}
+
 func InitializeAuthController(db *mongo.Database) *api.AuthController {
 	userRepository := repository.NewUserRepository(db)
 	taskRepository := repository.NewTaskRepository(db)
-	userService := services.NewUserService(userRepository, taskRepository)
-	authController := api.NewAuthController(userService)
+	groupRepository := repository.NewGroupRepository(db)
+	organizationRepository := repository.NewOrganizationRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	tokenService := services.NewTokenService(refreshTokenRepository, tokenDenylistInterface, userRepository)
+	loginAttemptStoreInterface := NewLoginAttemptStore(db)
+	auditRepository := repository.NewAuditRepository(db)
+	loginGuard := services.NewLoginGuard(loginAttemptStoreInterface, auditRepository)
+	sender := mail.NewSender()
+	userService := services.NewUserService(userRepository, taskRepository, groupRepository, organizationRepository, targetModelRepository, tokenService, loginGuard, sender)
+	authController := api.NewAuthController(userService, tokenService)
 	return authController
 }
 
+func InitializeMiddleware(db *mongo.Database) *middleware.Middleware {
+	userRepository := repository.NewUserRepository(db)
+	taskRepository := repository.NewTaskRepository(db)
+	groupRepository := repository.NewGroupRepository(db)
+	organizationRepository := repository.NewOrganizationRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	tokenService := services.NewTokenService(refreshTokenRepository, tokenDenylistInterface, userRepository)
+	loginAttemptStoreInterface := NewLoginAttemptStore(db)
+	auditRepository := repository.NewAuditRepository(db)
+	loginGuard := services.NewLoginGuard(loginAttemptStoreInterface, auditRepository)
+	sender := mail.NewSender()
+	userService := NewUserService(userRepository, taskRepository, groupRepository, organizationRepository, targetModelRepository, tokenService, loginGuard, sender)
+	apiKeyRepository := repository.NewAPIKeyRepository(db)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepository, userService)
+	middlewareMiddleware := middleware.NewMiddleware(userService, apiKeyService, tokenService)
+	return middlewareMiddleware
+}
+
+func InitializeAPIKeyController(db *mongo.Database) *api.APIKeyController {
+	apiKeyRepository := repository.NewAPIKeyRepository(db)
+	userRepository := repository.NewUserRepository(db)
+	taskRepository := repository.NewTaskRepository(db)
+	groupRepository := repository.NewGroupRepository(db)
+	organizationRepository := repository.NewOrganizationRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	tokenService := services.NewTokenService(refreshTokenRepository, tokenDenylistInterface, userRepository)
+	loginAttemptStoreInterface := NewLoginAttemptStore(db)
+	auditRepository := repository.NewAuditRepository(db)
+	loginGuard := services.NewLoginGuard(loginAttemptStoreInterface, auditRepository)
+	sender := mail.NewSender()
+	userService := NewUserService(userRepository, taskRepository, groupRepository, organizationRepository, targetModelRepository, tokenService, loginGuard, sender)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepository, userService)
+	middlewareMiddleware := middleware.NewMiddleware(userService, apiKeyService, tokenService)
+	apiKeyController := api.NewAPIKeyController(apiKeyService, middlewareMiddleware)
+	return apiKeyController
+}
+
+func InitializeGroupController(db *mongo.Database) *api.GroupController {
+	groupRepository := repository.NewGroupRepository(db)
+	userRepository := repository.NewUserRepository(db)
+	taskRepository := repository.NewTaskRepository(db)
+	targetModelRepository := repository.NewTargetModelRepository(db)
+	groupService := services.NewGroupService(groupRepository, userRepository, taskRepository, targetModelRepository)
+	groupController := api.NewGroupController(groupService)
+	return groupController
+}
+
+func InitializeOrganizationService(db *mongo.Database) *services.OrganizationService {
+	organizationRepository := repository.NewOrganizationRepository(db)
+	organizationService := services.NewOrganizationService(organizationRepository)
+	return organizationService
+}
+
+func InitializeOrganizationController(db *mongo.Database) *api.OrganizationController {
+	organizationRepository := repository.NewOrganizationRepository(db)
+	organizationService := services.NewOrganizationService(organizationRepository)
+	organizationController := api.NewOrganizationController(organizationService)
+	return organizationController
+}
+
+func InitializePluginHealthService(db *mongo.Database) *services.PluginHealthService {
+	pluginRepository := repository.NewPluginRepository(db)
+	client := services.NewHTTPClientProvider()
+	httpClient := plugins.NewHTTPClient(client)
+	pluginHealthService := services.NewPluginHealthService(pluginRepository, httpClient)
+	return pluginHealthService
+}
+
+func InitializePluginController(db *mongo.Database) *api.PluginController {
+	pluginRepository := repository.NewPluginRepository(db)
+	taskRepository := repository.NewTaskRepository(db)
+	tokenDenylistInterface := NewTokenDenylist(db)
+	pluginService := services.NewPluginService(pluginRepository, taskRepository, tokenDenylistInterface)
+	client := services.NewHTTPClientProvider()
+	httpClient := plugins.NewHTTPClient(client)
+	pluginHealthService := services.NewPluginHealthService(pluginRepository, httpClient)
+	pluginController := api.NewPluginController(pluginService, pluginHealthService)
+	return pluginController
+}
+
 // wire.go:
 
-func NewUserService(userRepo *repository.UserRepository, taskRepo *repository.TaskRepository) *services.UserService {
-	return services.NewUserService(userRepo, taskRepo)
+func NewUserService(userRepo *repository.UserRepository, taskRepo *repository.TaskRepository,
+	groupRepo *repository.GroupRepository, organizationRepo repository.OrganizationRepoInterface,
+	targetModelRepo repository.TargetModelRepoInterface, tokenService services.TokenServiceInterface,
+	loginGuard services.LoginGuardInterface, mailSender mail.Sender,
+) *services.UserService {
+	return services.NewUserService(userRepo, taskRepo, groupRepo, organizationRepo, targetModelRepo, tokenService,
+		loginGuard, mailSender)
+}
+
+// NewTokenDenylist selects where revoked access tokens are kept.
+func NewTokenDenylist(db *mongo.Database) repository.TokenDenylistInterface {
+	if configs.GlobalConfig.TokenDenylistStore == "redis" {
+		return redis.NewTokenDenylist(redis.Client)
+	}
+	return repository.NewRevokedTokenRepository(db)
+}
+
+var UserRepoSet = wire.NewSet(repository.NewUserRepository, wire.Bind(new(repository.UserRepoInterface), new(*repository.UserRepository)))
+
+// NewLoginAttemptStore selects where failed logins are counted.
+func NewLoginAttemptStore(db *mongo.Database) repository.LoginAttemptStoreInterface {
+	if configs.GlobalConfig.LoginLockout.Store == "redis" {
+		return redis.NewLoginAttempts(redis.Client)
+	}
+	return repository.NewLoginAttemptRepository(db)
 }
 
+var LoginGuardSet = wire.NewSet(
+	NewLoginAttemptStore, repository.NewAuditRepository, wire.Bind(new(repository.AuditRepoInterface), new(*repository.AuditRepository)), services.NewLoginGuard, wire.Bind(new(services.LoginGuardInterface), new(*services.LoginGuard)),
+)
+
+var OrganizationRepoSet = wire.NewSet(repository.NewOrganizationRepository, wire.Bind(new(repository.OrganizationRepoInterface), new(*repository.OrganizationRepository)))
+
+var TokenServiceSet = wire.NewSet(repository.NewRefreshTokenRepository, wire.Bind(new(repository.RefreshTokenRepoInterface), new(*repository.RefreshTokenRepository)), NewTokenDenylist, services.NewTokenService, wire.Bind(new(services.TokenServiceInterface), new(*services.TokenService)))
+
 var UserServiceSet = wire.NewSet(
-	NewUserService, wire.Bind(new(services.UserServiceInterface), new(*services.UserService)),
+	NewUserService, wire.Bind(new(services.UserServiceInterface), new(*services.UserService)), TokenServiceSet,
+	OrganizationRepoSet,
+	LoginGuardSet, mail.NewSender,
+)
+
+var APIKeyServiceSet = wire.NewSet(repository.NewAPIKeyRepository, wire.Bind(new(repository.APIKeyRepoInterface), new(*repository.APIKeyRepository)), services.NewAPIKeyService, wire.Bind(new(services.APIKeyServiceInterface), new(*services.APIKeyService)))
+
+var SendHandlerSet = wire.NewSet(api.NewSendHandlerController, middleware.NewMiddleware, wire.Bind(new(middleware.Interface), new(*middleware.Middleware)), repository.NewPluginRepository, wire.Bind(new(repository.PluginRepoInterface), new(*repository.PluginRepository)), services.NewPluginService, wire.Bind(new(services.PluginServiceInterface), new(*services.PluginService)), services.NewPromptService, wire.Bind(new(services.PromptServiceInterface), new(*services.PromptService)), repository.NewChatHistoryRepository, wire.Bind(new(repository.ChatHistoryRepoInterface), new(*repository.ChatHistoryRepository)), UserServiceSet,
+	APIKeyServiceSet, repository.NewTaskRepository, wire.Bind(new(repository.TaskRepoInterface), new(*repository.TaskRepository)), repository.NewGroupRepository,
 )
 
-var SendHandlerSet = wire.NewSet(api.NewSendHandlerController, middleware.NewMiddleware, wire.Bind(new(middleware.Interface), new(*middleware.Middleware)), repository.NewPluginRepository, wire.Bind(new(repository.PluginRepoInterface), new(*repository.PluginRepository)), services.NewPluginService, wire.Bind(new(services.PluginServiceInterface), new(*services.PluginService)), services.NewPromptService, wire.Bind(new(services.PromptServiceInterface), new(*services.PromptService)), UserServiceSet, repository.NewTaskRepository)
+var PluginHealthServiceSet = wire.NewSet(repository.NewPluginRepository, wire.Bind(new(repository.PluginRepoInterface), new(*repository.PluginRepository)), plugins.NewHTTPClient, wire.Bind(new(plugins.HTTPClientInterface), new(*plugins.HTTPClient)), services.NewHTTPClientProvider, services.NewPluginHealthService)
@@ -0,0 +1,41 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNoOrganization is returned for contexts that are neither scoped to an organization nor explicitly unscoped.
+var ErrNoOrganization = errors.New("context is not scoped to an organization")
+
+type contextKey struct{}
+
+type scope struct {
+	organizationID primitive.ObjectID
+	unscoped       bool
+}
+
+// WithOrganization scopes the context, and every repository query made with it, to the organization.
+func WithOrganization(ctx context.Context, organizationID primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, contextKey{}, scope{organizationID: organizationID})
+}
+
+// Unscoped lifts the organization scope for lookups that must span organizations, e.g. email uniqueness or resolving
+// a credential whose organization isn't known yet.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, scope{unscoped: true})
+}
+
+// FromContext returns the organization the context is scoped to.
+func FromContext(ctx context.Context) (primitive.ObjectID, bool) {
+	s, _ := ctx.Value(contextKey{}).(scope)
+	return s.organizationID, !s.unscoped && !s.organizationID.IsZero()
+}
+
+// IsUnscoped reports whether the context was explicitly unscoped with Unscoped.
+func IsUnscoped(ctx context.Context) bool {
+	s, _ := ctx.Value(contextKey{}).(scope)
+	return s.unscoped
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor starts the server span of every gRPC call, continuing the trace of the caller.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, span := startServer(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	End(span, err)
+	return resp, err
+}
+
+// StreamServerInterceptor starts the server span of every gRPC stream, continuing the trace of the caller.
+func StreamServerInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, span := startServer(stream.Context(), info.FullMethod)
+	err := handler(srv, &tracedStream{ServerStream: stream, ctx: ctx})
+	End(span, err)
+	return err
+}
+
+// UnaryClientInterceptor sends the trace context along with gRPC calls, in their metadata.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+) error {
+	return invoker(injectMetadata(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor sends the trace context along with gRPC streams, in their metadata.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return streamer(injectMetadata(ctx), desc, cc, method, opts...)
+}
+
+func startServer(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return Start(ctx, strings.TrimPrefix(fullMethod, "/"), trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(method)))
+}
+
+func injectMetadata(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+// metadataCarrier lets the propagator read and write the trace context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
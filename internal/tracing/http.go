@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey string
+
+const wrappedKey contextKey = "wrapped_span"
+
+// Middleware starts the server span of every request, continuing the trace of the caller. The span is named after the
+// route the request matched, so the middleware must wrap the router.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			span.SetName(r.Method + " " + routeContext.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(routeContext.RoutePattern()))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// wrapped is what Wrap keeps about a request going through a middleware.
+type wrapped struct {
+	w      http.ResponseWriter
+	parent trace.Span
+	span   trace.Span
+	passed bool
+}
+
+// Wrap traces the middleware in a span of its own, which ends once the middleware lets the request through, so that the
+// span only covers the work of the middleware. Requests the middleware rejects end the span with their status.
+func Wrap(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state, _ := r.Context().Value(wrappedKey).(*wrapped)
+			if state == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			state.passed = true
+			state.span.End()
+			next.ServeHTTP(state.w, r.WithContext(trace.ContextWithSpan(r.Context(), state.parent)))
+		}))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := &wrapped{w: w, parent: trace.SpanFromContext(r.Context())}
+			var ctx context.Context
+			ctx, state.span = Start(r.Context(), name)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			inner.ServeHTTP(ww, r.WithContext(context.WithValue(ctx, wrappedKey, state)))
+
+			if !state.passed {
+				state.span.SetAttributes(semconv.HTTPResponseStatusCode(ww.Status()))
+				state.span.SetStatus(codes.Error, fmt.Sprintf("rejected with %d", ww.Status()))
+				state.span.End()
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+
+	serviceName = "guardian"
+)
+
+// The attributes Guardian's spans are annotated with.
+const (
+	TaskTypeKey        = attribute.Key("guardian.task.type")
+	PluginIDKey        = attribute.Key("guardian.plugin.id")
+	PluginNameKey      = attribute.Key("guardian.plugin.name")
+	PluginProtocolKey  = attribute.Key("guardian.plugin.protocol")
+	TargetModelIDKey   = attribute.Key("guardian.target_model.id")
+	TargetModelNameKey = attribute.Key("guardian.target_model.name")
+	VerdictKey         = attribute.Key("guardian.verdict")
+	ScoreKey           = attribute.Key("guardian.score")
+)
+
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// propagator carries the W3C trace context and baggage of a prompt across the services it passes through.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Init installs the tracer provider exporting Guardian's spans with the exporter: "otlp" sends them over OTLP/HTTP to
+// endpointURL, or to the endpoint of the OTEL_EXPORTER_OTLP_* variables when it is empty, "stdout" prints them and
+// "none" drops them. sampleRatio of the traces are kept: the traces of callers join the caller's trace, but callers
+// can't make Guardian keep or drop them, as the sampled flag comes from untrusted requests. The returned function
+// flushes the spans that weren't exported yet.
+func Init(ctx context.Context, exporter, endpointURL string, sampleRatio float64) (func(context.Context) error,
+	error,
+) {
+	otel.SetTextMapPropagator(propagator)
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if endpointURL != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpointURL))
+		}
+		spanExporter, err = otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExporter, exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(newSampler(sampleRatio)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newSampler keeps sampleRatio of the traces by their ID, whatever remote parents decided. The spans of a trace kept
+// within Guardian keep their local parent's decision.
+func newSampler(sampleRatio float64) sdktrace.Sampler {
+	ratio := sdktrace.TraceIDRatioBased(sampleRatio)
+	return sdktrace.ParentBased(ratio,
+		sdktrace.WithRemoteParentSampled(ratio),
+		sdktrace.WithRemoteParentNotSampled(ratio),
+	)
+}
+
+// NewInMemory installs a tracer provider keeping the spans in memory, for tests to look at them.
+func NewInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagator)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+// Start starts a span of Guardian's, as a child of the span of ctx if it has one.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(serviceName).Start(ctx, name, opts...)
+}
+
+// End ends the span, marking it failed if err isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject adds the trace context of ctx to the headers of an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not found", "no span is named %q", name)
+	return tracetest.SpanStub{}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := NewInMemory()
+
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Reject") != "" {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Use(Wrap("rate limit", reject))
+	router.Post("/plugins/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "handler")
+		span.End()
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/plugins/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	server := spanNamed(t, spans, "POST /plugins/{id}")
+	rateLimit := spanNamed(t, spans, "rate limit")
+	handler := spanNamed(t, spans, "handler")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, server.SpanContext.SpanID(), rateLimit.Parent.SpanID())
+	assert.Equal(t, server.SpanContext.SpanID(), handler.Parent.SpanID(),
+		"the handler must not run within the span of the middleware")
+	assert.Equal(t, codes.Unset, rateLimit.Status.Code)
+
+	exporter.Reset()
+	req = httptest.NewRequest(http.MethodPost, "/plugins/1", nil)
+	req.Header.Set("X-Reject", "1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans = exporter.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Error, spanNamed(t, spans, "rate limit").Status.Code)
+}
+
+func TestGRPCPropagation(t *testing.T) {
+	exporter := NewInMemory()
+
+	ctx, span := Start(context.Background(), "client")
+	var md metadata.MD
+	err := UnaryClientInterceptor(ctx, "/guardian.Guardian/CheckPrompt", nil, nil, nil,
+		func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	require.NoError(t, err)
+	span.End()
+	require.NotEmpty(t, md.Get("traceparent"))
+
+	serverCtx := metadata.NewIncomingContext(context.Background(), md)
+	_, err = UnaryServerInterceptor(serverCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/guardian.Guardian/CheckPrompt"},
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	client := spanNamed(t, spans, "client")
+	server := spanNamed(t, spans, "guardian.Guardian/CheckPrompt")
+	assert.Equal(t, client.SpanContext.TraceID(), server.SpanContext.TraceID())
+	assert.Equal(t, client.SpanContext.SpanID(), server.Parent.SpanID())
+}
+
+func TestInit(t *testing.T) {
+	shutdown, err := Init(context.Background(), ExporterNone, "", 1)
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	_, err = Init(context.Background(), "jaeger", "", 1)
+	require.ErrorIs(t, err, ErrUnknownExporter)
+}
+
+func TestSampler(t *testing.T) {
+	remoteParent := func(flags trace.TraceFlags) context.Context {
+		return trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{0x4b, 0xf9}, SpanID: trace.SpanID{0x01}, TraceFlags: flags, Remote: true}))
+	}
+	sample := func(sampleRatio float64, ctx context.Context) sdktrace.SamplingDecision {
+		return newSampler(sampleRatio).ShouldSample(sdktrace.SamplingParameters{ParentContext: ctx,
+			TraceID: trace.SpanContextFromContext(ctx).TraceID(), Name: "send"}).Decision
+	}
+
+	assert.Equal(t, sdktrace.Drop, sample(0, remoteParent(trace.FlagsSampled)))
+	assert.Equal(t, sdktrace.RecordAndSample, sample(1, remoteParent(0)))
+	assert.Equal(t, sdktrace.RecordAndSample, sample(1, context.Background()))
+}
@@ -5,19 +5,31 @@ import (
 	"sync"
 
 	"guardian/internal/models/entities"
+	"guardian/internal/tracing"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type ClientManager struct {
-	mu      *sync.Mutex
-	clients map[primitive.ObjectID]*grpc.ClientConn
+	mu      sync.Mutex
+	certDir string
+	clients map[primitive.ObjectID]*managedClient
 }
 
-func NewClientManager() *ClientManager {
+// managedClient is a connection along with the fingerprint of the TLS configuration it was dialled with.
+type managedClient struct {
+	conn        *grpc.ClientConn
+	fingerprint string
+}
+
+// NewClientManager returns a manager whose plugins load their certificates from certDir.
+func NewClientManager(certDir string) *ClientManager {
 	return &ClientManager{
-		clients: make(map[primitive.ObjectID]*grpc.ClientConn),
+		certDir: certDir,
+		clients: make(map[primitive.ObjectID]*managedClient),
 	}
 }
 
@@ -25,26 +37,69 @@ func (m *ClientManager) GetClient(llm entities.Plugin) (*grpc.ClientConn, error)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if conn, exists := m.clients[llm.ID]; exists {
-		return conn, nil
+	var fingerprint string
+	if llm.Auth.UsesTLS() {
+		var err error
+		fingerprint, err = TLSFingerprint(llm.Auth, m.certDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if client, exists := m.clients[llm.ID]; exists {
+		if client.fingerprint == fingerprint {
+			return client.conn, nil
+		}
+		// The certificates were rotated: dial again with the new ones.
+		if err := client.conn.Close(); err != nil {
+			log.Printf("Failed to close connection for plugin %s: %v", llm.ID, err)
+		}
+		delete(m.clients, llm.ID)
 	}
 
-	client, err := grpc.NewClient(llm.Address)
+	client, err := m.Dial(llm)
 	if err != nil {
 		return nil, err
 	}
-	defer client.Close()
 	client.Connect()
-	m.clients[llm.ID] = client
+	m.clients[llm.ID] = &managedClient{conn: client, fingerprint: fingerprint}
 	return client, nil
 }
 
+// Dial creates a connection to the plugin, secured with its TLS configuration if it has one.
+func (m *ClientManager) Dial(llm entities.Plugin) (*grpc.ClientConn, error) {
+	transportCredentials := insecure.NewCredentials()
+	if llm.Auth.UsesTLS() {
+		tlsConfig, err := LoadTLSConfig(llm.Auth, m.certDir)
+		if err != nil {
+			return nil, err
+		}
+		transportCredentials = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(llm.Address, grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor))
+}
+
+// Remove closes the connection to a plugin, e.g. when its address changed, so that the next call reconnects.
+func (m *ClientManager) Remove(id primitive.ObjectID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if client, exists := m.clients[id]; exists {
+		if err := client.conn.Close(); err != nil {
+			log.Printf("Failed to close connection for plugin %s: %v", id, err)
+		}
+		delete(m.clients, id)
+	}
+}
+
 func (m *ClientManager) CloseAll() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for id, conn := range m.clients {
-		if err := conn.Close(); err != nil {
+	for id, client := range m.clients {
+		if err := client.conn.Close(); err != nil {
 			log.Printf("Failed to close connection for TargetLLM %s: %v", id, err)
 		}
 		delete(m.clients, id)
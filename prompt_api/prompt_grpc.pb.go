@@ -22,6 +22,7 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	PromptService_SendPrompt_FullMethodName = "/prompt.PromptService/SendPrompt"
+	PromptService_Describe_FullMethodName   = "/prompt.PromptService/Describe"
 )
 
 // PromptServiceClient is the client API for PromptService service.
@@ -29,6 +30,8 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type PromptServiceClient interface {
 	SendPrompt(ctx context.Context, in *SendPromptRequest, opts ...grpc.CallOption) (*SendPromptResponse, error)
+	// Describe returns the capabilities of the plugin, which Guardian checks task assignments against.
+	Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error)
 }
 
 type promptServiceClient struct {
@@ -49,11 +52,23 @@ func (c *promptServiceClient) SendPrompt(ctx context.Context, in *SendPromptRequ
 	return out, nil
 }
 
+func (c *promptServiceClient) Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeResponse)
+	err := c.cc.Invoke(ctx, PromptService_Describe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PromptServiceServer is the server API for PromptService service.
 // All implementations must embed UnimplementedPromptServiceServer
 // for forward compatibility.
 type PromptServiceServer interface {
 	SendPrompt(context.Context, *SendPromptRequest) (*SendPromptResponse, error)
+	// Describe returns the capabilities of the plugin, which Guardian checks task assignments against.
+	Describe(context.Context, *DescribeRequest) (*DescribeResponse, error)
 	mustEmbedUnimplementedPromptServiceServer()
 }
 
@@ -67,6 +82,9 @@ type UnimplementedPromptServiceServer struct{}
 func (UnimplementedPromptServiceServer) SendPrompt(context.Context, *SendPromptRequest) (*SendPromptResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendPrompt not implemented")
 }
+func (UnimplementedPromptServiceServer) Describe(context.Context, *DescribeRequest) (*DescribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Describe not implemented")
+}
 func (UnimplementedPromptServiceServer) mustEmbedUnimplementedPromptServiceServer() {}
 func (UnimplementedPromptServiceServer) testEmbeddedByValue()                       {}
 
@@ -106,6 +124,24 @@ func _PromptService_SendPrompt_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PromptService_Describe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PromptServiceServer).Describe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PromptService_Describe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PromptServiceServer).Describe(ctx, req.(*DescribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PromptService_ServiceDesc is the grpc.ServiceDesc for PromptService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -117,7 +153,194 @@ var PromptService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendPrompt",
 			Handler:    _PromptService_SendPrompt_Handler,
 		},
+		{
+			MethodName: "Describe",
+			Handler:    _PromptService_Describe_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "protoc/prompt.proto",
 }
+
+const (
+	PromptServiceV2_Evaluate_FullMethodName       = "/prompt.PromptServiceV2/Evaluate"
+	PromptServiceV2_EvaluateBatch_FullMethodName  = "/prompt.PromptServiceV2/EvaluateBatch"
+	PromptServiceV2_ModerateOutput_FullMethodName = "/prompt.PromptServiceV2/ModerateOutput"
+)
+
+// PromptServiceV2Client is the client API for PromptServiceV2 service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PromptServiceV2 is implemented by plugins that declare api_version 2 in Describe. It carries the request's context
+// and explains verdicts. Plugins implementing only PromptService keep working.
+type PromptServiceV2Client interface {
+	Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*EvaluateResponse, error)
+	EvaluateBatch(ctx context.Context, in *EvaluateBatchRequest, opts ...grpc.CallOption) (*EvaluateBatchResponse, error)
+	// ModerateOutput receives the completion chunk by chunk as the target model generates it. The plugin may answer
+	// with a verdict at any time, and a verdict that isn't allowed aborts the completion.
+	ModerateOutput(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[OutputChunk, OutputVerdict], error)
+}
+
+type promptServiceV2Client struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPromptServiceV2Client(cc grpc.ClientConnInterface) PromptServiceV2Client {
+	return &promptServiceV2Client{cc}
+}
+
+func (c *promptServiceV2Client) Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*EvaluateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EvaluateResponse)
+	err := c.cc.Invoke(ctx, PromptServiceV2_Evaluate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *promptServiceV2Client) EvaluateBatch(ctx context.Context, in *EvaluateBatchRequest, opts ...grpc.CallOption) (*EvaluateBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EvaluateBatchResponse)
+	err := c.cc.Invoke(ctx, PromptServiceV2_EvaluateBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *promptServiceV2Client) ModerateOutput(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[OutputChunk, OutputVerdict], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PromptServiceV2_ServiceDesc.Streams[0], PromptServiceV2_ModerateOutput_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[OutputChunk, OutputVerdict]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PromptServiceV2_ModerateOutputClient = grpc.BidiStreamingClient[OutputChunk, OutputVerdict]
+
+// PromptServiceV2Server is the server API for PromptServiceV2 service.
+// All implementations must embed UnimplementedPromptServiceV2Server
+// for forward compatibility.
+//
+// PromptServiceV2 is implemented by plugins that declare api_version 2 in Describe. It carries the request's context
+// and explains verdicts. Plugins implementing only PromptService keep working.
+type PromptServiceV2Server interface {
+	Evaluate(context.Context, *EvaluateRequest) (*EvaluateResponse, error)
+	EvaluateBatch(context.Context, *EvaluateBatchRequest) (*EvaluateBatchResponse, error)
+	// ModerateOutput receives the completion chunk by chunk as the target model generates it. The plugin may answer
+	// with a verdict at any time, and a verdict that isn't allowed aborts the completion.
+	ModerateOutput(grpc.BidiStreamingServer[OutputChunk, OutputVerdict]) error
+	mustEmbedUnimplementedPromptServiceV2Server()
+}
+
+// UnimplementedPromptServiceV2Server must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPromptServiceV2Server struct{}
+
+func (UnimplementedPromptServiceV2Server) Evaluate(context.Context, *EvaluateRequest) (*EvaluateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Evaluate not implemented")
+}
+func (UnimplementedPromptServiceV2Server) EvaluateBatch(context.Context, *EvaluateBatchRequest) (*EvaluateBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EvaluateBatch not implemented")
+}
+func (UnimplementedPromptServiceV2Server) ModerateOutput(grpc.BidiStreamingServer[OutputChunk, OutputVerdict]) error {
+	return status.Errorf(codes.Unimplemented, "method ModerateOutput not implemented")
+}
+func (UnimplementedPromptServiceV2Server) mustEmbedUnimplementedPromptServiceV2Server() {}
+func (UnimplementedPromptServiceV2Server) testEmbeddedByValue()                         {}
+
+// UnsafePromptServiceV2Server may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PromptServiceV2Server will
+// result in compilation errors.
+type UnsafePromptServiceV2Server interface {
+	mustEmbedUnimplementedPromptServiceV2Server()
+}
+
+func RegisterPromptServiceV2Server(s grpc.ServiceRegistrar, srv PromptServiceV2Server) {
+	// If the following call pancis, it indicates UnimplementedPromptServiceV2Server was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PromptServiceV2_ServiceDesc, srv)
+}
+
+func _PromptServiceV2_Evaluate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EvaluateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PromptServiceV2Server).Evaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PromptServiceV2_Evaluate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PromptServiceV2Server).Evaluate(ctx, req.(*EvaluateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PromptServiceV2_EvaluateBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EvaluateBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PromptServiceV2Server).EvaluateBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PromptServiceV2_EvaluateBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PromptServiceV2Server).EvaluateBatch(ctx, req.(*EvaluateBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PromptServiceV2_ModerateOutput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PromptServiceV2Server).ModerateOutput(&grpc.GenericServerStream[OutputChunk, OutputVerdict]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PromptServiceV2_ModerateOutputServer = grpc.BidiStreamingServer[OutputChunk, OutputVerdict]
+
+// PromptServiceV2_ServiceDesc is the grpc.ServiceDesc for PromptServiceV2 service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PromptServiceV2_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "prompt.PromptServiceV2",
+	HandlerType: (*PromptServiceV2Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Evaluate",
+			Handler:    _PromptServiceV2_Evaluate_Handler,
+		},
+		{
+			MethodName: "EvaluateBatch",
+			Handler:    _PromptServiceV2_EvaluateBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ModerateOutput",
+			Handler:       _PromptServiceV2_ModerateOutput_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "protoc/prompt.proto",
+}
@@ -0,0 +1,119 @@
+package prompt_api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"guardian/internal/models/entities"
+)
+
+var ErrInvalidCertificate = errors.New("invalid plugin certificate")
+
+// maxPEMSize bounds the certificate and key files read for a plugin.
+const maxPEMSize = 1 << 20
+
+// LoadTLSConfig returns the TLS configuration to call a plugin with: its client certificate for mTLS and the CA to
+// verify the plugin against. The certificate, key and CA files are resolved inside certDir, which the plugin cannot
+// escape.
+func LoadTLSConfig(auth entities.PluginAuth, certDir string) (*tls.Config, error) {
+	if auth.Type == entities.PluginAuthMTLS && auth.ClientCert == "" {
+		return nil, fmt.Errorf("%w: mtls needs a client certificate", ErrInvalidCertificate)
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: auth.ServerName,
+	}
+
+	if auth.ClientCert != "" || auth.ClientKey != "" {
+		certPEM, err := readPEM(certDir, auth.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := readPEM(certDir, auth.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if auth.CACert != "" {
+		caPEM, err := readPEM(certDir, auth.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrInvalidCertificate, auth.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// TLSFingerprint identifies the TLS configuration of a plugin: its settings along with the size and modification time
+// of its files. It changes when the certificates are rotated, so that clients built from the old ones are replaced.
+func TLSFingerprint(auth entities.PluginAuth, certDir string) (string, error) {
+	hash := sha256.New()
+	for _, value := range []string{auth.Type, auth.ServerName, auth.ClientCert, auth.ClientKey, auth.CACert} {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	for _, name := range []string{auth.ClientCert, auth.ClientKey, auth.CACert} {
+		if name == "" {
+			continue
+		}
+		path, info, err := certFile(certDir, name)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(path))
+		hash.Write([]byte(strconv.FormatInt(info.Size(), 10)))
+		hash.Write([]byte(strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// certFile resolves name, a path relative to certDir, and checks that it is a regular file.
+func certFile(certDir, name string) (string, os.FileInfo, error) {
+	if certDir == "" {
+		return "", nil, fmt.Errorf("%w: no certificate directory is configured", ErrInvalidCertificate)
+	}
+	name = filepath.Clean(name)
+	if !filepath.IsLocal(name) {
+		return "", nil, fmt.Errorf("%w: %s is outside the certificate directory", ErrInvalidCertificate, name)
+	}
+	path := filepath.Join(certDir, name)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("%w: %s is not a readable file", ErrInvalidCertificate, name)
+	}
+	if info.Size() > maxPEMSize {
+		return "", nil, fmt.Errorf("%w: %s is too large", ErrInvalidCertificate, name)
+	}
+	return path, info, nil
+}
+
+func readPEM(certDir, name string) ([]byte, error) {
+	path, _, err := certFile(certDir, name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a readable file", ErrInvalidCertificate, name)
+	}
+	return content, nil
+}
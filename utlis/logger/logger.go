@@ -1,13 +1,60 @@
 package logger
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"regexp"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var log *logrus.Logger
 
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	redacted = "[REDACTED]"
+)
+
+var ErrInvalidConfig = errors.New("invalid logging configuration")
+
+type contextKey string
+
+const (
+	fieldsKey     contextKey = "log_fields"
+	unredactedKey contextKey = "log_unredacted"
+)
+
+// sensitiveFields are the fields whose values never make it into the logs unless sensitive data may be logged.
+var sensitiveFields = map[string]bool{
+	"prompt":        true,
+	"chat":          true,
+	"messages":      true,
+	"content":       true,
+	"token":         true,
+	"authorization": true,
+	"api_key":       true,
+	"password":      true,
+	"secret":        true,
+}
+
+// secretPatterns find the credentials that slip into log messages, e.g. within the URLs of requests.
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(bearer)\s+[A-Za-z0-9._~+/=-]+`), "$1 " + redacted},
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), redacted},
+	{regexp.MustCompile(`\bgdn_[A-Za-z0-9_]+`), redacted},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`), redacted},
+	{regexp.MustCompile(`(?i)\b((?:access_|refresh_)?token|api_key|key|secret|password)=[^&\s"]+`), "$1=" + redacted},
+}
+
 func init() {
 	InitLogger()
 }
@@ -25,8 +72,106 @@ func InitLogger() {
 
 	// Optional: Set log level
 	log.SetLevel(logrus.InfoLevel)
+
+	log.AddHook(redactHook{})
+}
+
+// Configure sets the format, "json" or "text", and the level of the logs. Prompts, tokens and other secrets are
+// redacted from the logs unless logSensitiveData is set.
+func Configure(format, level string, logSensitiveData bool) error {
+	logLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	switch format {
+	case FormatJSON:
+		log.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidConfig, format)
+	}
+	log.SetLevel(logLevel)
+
+	hooks := make(logrus.LevelHooks)
+	if !logSensitiveData {
+		hooks.Add(redactHook{})
+	}
+	log.ReplaceHooks(hooks)
+	return nil
 }
 
 func GetLogger() *logrus.Logger {
 	return log
 }
+
+// WithFields returns a context whose logger adds the fields to every entry, along with the fields ctx already carries.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	parent, _ := ctx.Value(fieldsKey).(logrus.Fields)
+	merged := make(logrus.Fields, len(parent)+len(fields))
+	for key, value := range parent {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, fieldsKey, merged)
+}
+
+// FromContext returns the logger of the request ctx belongs to. Its entries carry the ID of the request, the ID of
+// its trace and the fields added with WithFields.
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := logrus.NewEntry(log).WithContext(ctx)
+	if requestID := middleware.GetReqID(ctx); requestID != "" {
+		entry = entry.WithField("request_id", requestID)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		entry = entry.WithField("trace_id", spanContext.TraceID().String())
+	}
+	if fields, ok := ctx.Value(fieldsKey).(logrus.Fields); ok {
+		entry = entry.WithFields(fields)
+	}
+	return entry
+}
+
+// Unredacted returns a logger whose entries are written as they are, for output that is meant to be read in full, like
+// the mails printed by the log sender, activation links included.
+func Unredacted(ctx context.Context) *logrus.Entry {
+	return logrus.NewEntry(log).WithContext(context.WithValue(ctx, unredactedKey, true))
+}
+
+// redactHook strips prompts and credentials from entries before they are written.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	if entry.Context != nil && entry.Context.Value(unredactedKey) == true {
+		return nil
+	}
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		if sensitiveFields[key] {
+			entry.Data[key] = redacted
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = Redact(value)
+		case error:
+			entry.Data[key] = Redact(value.Error())
+		}
+	}
+	return nil
+}
+
+// Redact masks the credentials found in s.
+func Redact(s string) string {
+	for _, secret := range secretPatterns {
+		s = secret.pattern.ReplaceAllString(s, secret.replacement)
+	}
+	return s
+}